@@ -0,0 +1,946 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// cliUsage describes the subcommands that run without the TUI.
+const cliUsage = `Usage: pf-tui [flags] [command]
+
+Without a command, pf-tui starts the TUI. Commands:
+  status                 show the state of pf and of the pf-tui rules
+  list [-json] <what>    list the rules, rdr, nat, tables or macros of the configuration
+  apply [-y] [-f <file>|-]
+                         save and apply the configuration, like Save & Apply;
+                         -y applies even if the rules may lock out the SSH session;
+                         -f imports the file, or stdin, first like import does
+  rule add [flags]       add a firewall rule, see pf-tui rule add -h
+  rule delete <id>       move the rule with the ID (see list) to the archive
+  export [-format f] -o <file>
+                         write the configuration as json, yaml, toml or pfconf
+                         (default by the extension of the file)
+  import [-y] [-format f] <file>|-
+                         replace the configuration with a json, yaml, toml or
+                         pfconf file, or stdin; -y imports even if it has problems
+  serve [-socket path]   serve the rules, apply, status and states as a JSON API
+                         over HTTP on a unix socket
+  profile list           list the profiles, named configurations to switch between
+  profile save <name>    save the configuration as the profile
+  profile switch [-y] <name>
+                         replace the configuration with the profile, like import
+  profile apply [-y] <name>
+                         switch to the profile and apply it, like apply -f
+
+With -json, commands print one JSON document to stdout, errors included.
+
+Flags:
+`
+
+// jsonFlag makes the commands, -backup and -panic print JSON.
+var jsonFlag bool
+
+// commandOutput writes the result of a command: as text to stdout with errors
+// to stderr, or with -json as one JSON document to stdout, errors as
+// {"error": "..."}.
+type commandOutput struct {
+	out  io.Writer
+	json bool
+}
+
+func newCommandOutput() commandOutput {
+	return commandOutput{out: os.Stdout, json: jsonFlag}
+}
+
+// result writes v as JSON, or calls text to write it as text, and returns 0.
+func (o commandOutput) result(v interface{}, text func(w io.Writer)) int {
+	if !o.json {
+		text(o.out)
+		return 0
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return o.fail(config.KindFailure, "Failed to encode the result: %v", err)
+	}
+	fmt.Fprintln(o.out, string(data))
+	return 0
+}
+
+// errorBody is the JSON of an error of a command or of the API.
+type errorBody struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"` // see ErrorKind.String
+}
+
+// fail reports an error of the given kind and returns its exit code.
+func (o commandOutput) fail(kind config.ErrorKind, format string, args ...interface{}) int {
+	message := fmt.Sprintf(format, args...)
+	if !o.json {
+		fmt.Fprintln(os.Stderr, message)
+		return kind.ExitCode()
+	}
+	data, _ := json.Marshal(errorBody{message, kind.String()})
+	fmt.Fprintln(o.out, string(data))
+	return kind.ExitCode()
+}
+
+// refuse reports a result that was refused because of its warnings, e.g. an
+// import of a file with problems without -y, and returns the exit code of
+// KindValidation. With -json, the result is written, with the status and the
+// warnings; otherwise the status is reported like fail does.
+func (o commandOutput) refuse(v interface{}, status string) int {
+	if !o.json {
+		return o.fail(config.KindValidation, "%s", status)
+	}
+	if code := o.result(v, nil); code != 0 {
+		return code
+	}
+	return config.KindValidation.ExitCode()
+}
+
+// failErr reports err after what failed, with the kind of err.
+func (o commandOutput) failErr(err error, what string) int {
+	return o.fail(config.KindOf(err), "%s: %v", what, err)
+}
+
+// flagSet returns the flag set of a subcommand. With -json, parse errors are
+// left to fail instead of printed with the usage.
+func (o commandOutput) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	if o.json {
+		flags.SetOutput(io.Discard)
+	}
+	return flags
+}
+
+// runCommand runs a subcommand without the TUI and returns the exit code.
+// The commands load the configuration themselves, so that import can replace
+// a rules file that cannot be loaded.
+func runCommand(args []string, runner pfcli.CommandRunner) int {
+	o := newCommandOutput()
+	fm := config.NewFirewallManager()
+
+	switch args[0] {
+	case "status":
+		return runStatus(fm, runner, o)
+	case "list":
+		return runList(fm, args[1:], o)
+	case "apply":
+		return runApply(fm, runner, args[1:], o)
+	case "rule":
+		return runRule(fm, args[1:], o)
+	case "export":
+		return runExport(fm, args[1:], o)
+	case "import":
+		return runImport(fm, args[1:], o)
+	case "serve":
+		return runServe(fm, runner, args[1:], o)
+	case "profile":
+		return runProfile(fm, runner, args[1:], o)
+	}
+	if o.json {
+		return o.fail(config.KindUsage, "Unknown command %q", args[0])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	flag.Usage()
+	return 2
+}
+
+// loadCommandConfig loads the configuration for a command. It returns the
+// exit code of the failure, 0 if it was loaded.
+func loadCommandConfig(fm *config.FirewallManager, o commandOutput) int {
+	if err := fm.LoadConfig(); err != nil {
+		return o.failErr(err, "Failed to load the configuration")
+	}
+	return 0
+}
+
+// loadConfigToReplace loads the configuration an import replaces. A rules
+// file that cannot be loaded is only warned about, so that e.g. a backup can
+// still be imported over it; a pf.conf import then keeps the default options,
+// scrub settings and Settings. The file itself is backed up by the import.
+func loadConfigToReplace(fm *config.FirewallManager, o commandOutput) {
+	if err := fm.LoadConfig(); err != nil {
+		LogWarn(fmt.Sprintf("Importing over a configuration that cannot be loaded: %v", err))
+		if !o.json {
+			fmt.Fprintf(os.Stderr, "Warning: the configuration cannot be loaded, the import replaces it: %v\n", err)
+		}
+		fm.Config = config.NewFirewallManager().Config
+	}
+}
+
+// requireSudo checks the sudo credentials of runner for the commands that run
+// pfctl.
+func requireSudo(o commandOutput, runner pfcli.CommandRunner) bool {
+	if err := checkSudo(runner); err != nil {
+		LogError(fmt.Sprintf("Error with sudo: %v", err))
+		o.fail(config.KindPermission, "Error with sudo: %v", err)
+		return false
+	}
+	return true
+}
+
+// commandStatus is the result of the status command.
+type commandStatus struct {
+	Pf                  string `json:"pf"`         // "Enabled" or "Disabled"
+	PfOnStartup         string `json:"pf_startup"` // "Enabled", "Disabled" or "Unknown"
+	RulesFile           string `json:"rules_file"`
+	FirewallRules       int    `json:"filter_rules"`
+	DisabledRules       int    `json:"disabled_filter_rules"`
+	PortForwardingRules int    `json:"rdr_rules"`
+	NatRules            int    `json:"nat_rules"`
+	Tables              int    `json:"tables"`
+	Macros              int    `json:"macros"`
+	Applied             string `json:"applied"` // "yes", "no" if the rules file changed since, or "never"
+}
+
+// getCommandStatus returns the state of pf and of the configuration of fm.
+func getCommandStatus(fm *config.FirewallManager, runner pfcli.CommandRunner) (commandStatus, error) {
+	pfStatus, err := pfcli.GetPfStatus(runner)
+	if err != nil {
+		return commandStatus{}, fmt.Errorf("pf status: %w", err)
+	}
+	startup, err := pfcli.CheckPfStartupStatus(runner)
+	if err != nil {
+		LogWarn(fmt.Sprintf("Failed to check the startup status: %v", err))
+	}
+	applied, err := pfcli.GetAppliedAnchor(runner)
+	if err != nil {
+		return commandStatus{}, fmt.Errorf("applied rules: %w", err)
+	}
+	path, _ := config.GetDefaultConfigPath()
+
+	status := commandStatus{
+		Pf:                  pfStatus,
+		PfOnStartup:         startup,
+		RulesFile:           path,
+		FirewallRules:       len(fm.Config.FirewallRules),
+		PortForwardingRules: len(fm.Config.PortForwardingRules),
+		NatRules:            len(fm.Config.NatRules),
+		Tables:              len(fm.Config.Tables),
+		Macros:              len(fm.Config.Macros),
+		Applied:             "yes",
+	}
+	for _, rule := range fm.Config.FirewallRules {
+		if !rule.Enabled {
+			status.DisabledRules++
+		}
+	}
+	if applied == "" {
+		status.Applied = "never"
+	} else if applied != fm.GeneratePfConf() {
+		status.Applied = "no"
+	}
+	return status, nil
+}
+
+func runStatus(fm *config.FirewallManager, runner pfcli.CommandRunner, o commandOutput) int {
+	if code := loadCommandConfig(fm, o); code != 0 {
+		return code
+	}
+	if !requireSudo(o, runner) {
+		return config.KindPermission.ExitCode()
+	}
+	status, err := getCommandStatus(fm, runner)
+	if err != nil {
+		return o.failErr(err, "Failed to get the status")
+	}
+	return o.result(status, func(out io.Writer) {
+		appliedStatus := status.Applied
+		if appliedStatus == "no" {
+			appliedStatus = "no, the rules file changed since the last apply"
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "pf:\t%s\n", status.Pf)
+		fmt.Fprintf(w, "pf on startup:\t%s\n", status.PfOnStartup)
+		fmt.Fprintf(w, "Rules file:\t%s\n", status.RulesFile)
+		fmt.Fprintf(w, "Rules:\t%d filter (%d disabled), %d port forwarding, %d NAT, %d tables, %d macros\n",
+			status.FirewallRules, status.DisabledRules, status.PortForwardingRules, status.NatRules,
+			status.Tables, status.Macros)
+		fmt.Fprintf(w, "Applied:\t%s\n", appliedStatus)
+		w.Flush()
+	})
+}
+
+func runList(fm *config.FirewallManager, args []string, o commandOutput) int {
+	flags := o.flagSet("list")
+	asJSON := flags.Bool("json", false, "print the entries as JSON, as in the rules file")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if *asJSON {
+		o.json = true
+	}
+	if flags.NArg() != 1 {
+		return o.fail(config.KindUsage, "Usage: pf-tui list [-json] rules|rdr|nat|tables|macros")
+	}
+	if code := loadCommandConfig(fm, o); code != 0 {
+		return code
+	}
+
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	var header string
+	var rows [][]string
+	switch flags.Arg(0) {
+	case "rules":
+		header = "#\tID\tENABLED\tACTION\tDIR\tQUICK\tPROTO\tSOURCE\tDESTINATION\tPORT\tGROUP\tDESCRIPTION"
+		for i, rule := range fm.Config.FirewallRules {
+			port := rule.DestinationPort
+			if rule.SourcePort != "any" && rule.SourcePort != "" {
+				port = rule.SourcePort + ">" + port
+			}
+			rows = append(rows, []string{strconv.Itoa(i + 1), rule.ID, yesNo[rule.Enabled], rule.Action, rule.Direction, yesNo[rule.Quick],
+				rule.Protocol, config.FormatHost(rule.Source, rule.SourceNot), config.FormatHost(rule.Destination, rule.DestinationNot),
+				port, rule.Group, rule.Description})
+		}
+	case "rdr":
+		header = "#\tID\tENABLED\tINTERFACE\tPROTO\tEXTERNAL\tINTERNAL\tDESCRIPTION"
+		for i, rule := range fm.Config.PortForwardingRules {
+			rows = append(rows, []string{strconv.Itoa(i + 1), rule.ID, yesNo[rule.Enabled], rule.Interface, rule.Protocol,
+				rule.ExternalIP + ":" + rule.ExternalPort, rule.InternalIP + ":" + rule.InternalPort, rule.Description})
+		}
+	case "nat":
+		header = "#\tID\tINTERFACE\tPROTO\tSOURCE\tDESTINATION\tTRANSLATION\tDESCRIPTION"
+		for i, rule := range fm.Config.NatRules {
+			translation := rule.Translation
+			if translation == "" {
+				translation = fmt.Sprintf("(%s)", rule.Interface)
+			}
+			rows = append(rows, []string{strconv.Itoa(i + 1), rule.ID, rule.Interface, rule.Protocol, rule.Source, rule.Destination,
+				translation, rule.Description})
+		}
+	case "tables":
+		header = "NAME\tPERSIST\tADDRESSES\tFEED\tDESCRIPTION"
+		for _, table := range fm.Config.Tables {
+			rows = append(rows, []string{"<" + table.Name + ">", yesNo[table.Persist], strconv.Itoa(len(table.Addresses)),
+				table.FeedURL, table.Description})
+		}
+	case "macros":
+		header = "NAME\tVALUE\tDESCRIPTION"
+		for _, macro := range fm.Config.Macros {
+			rows = append(rows, []string{"$" + macro.Name, macro.Value, macro.Description})
+		}
+	default:
+		return o.fail(config.KindUsage, "Unknown list %q, use rules, rdr, nat, tables or macros", flags.Arg(0))
+	}
+
+	return o.result(listEntries(fm.Config, flags.Arg(0)), func(out io.Writer) {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, header)
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	})
+}
+
+// listEntries returns the entries of the configuration named by the list
+// command, or nil for an unknown name.
+func listEntries(cfg *config.Config, what string) interface{} {
+	switch what {
+	case "rules":
+		return cfg.FirewallRules
+	case "rdr":
+		return cfg.PortForwardingRules
+	case "nat":
+		return cfg.NatRules
+	case "tables":
+		return cfg.Tables
+	case "macros":
+		return cfg.Macros
+	}
+	return nil
+}
+
+func runRule(fm *config.FirewallManager, args []string, o commandOutput) int {
+	if code := loadCommandConfig(fm, o); code != 0 {
+		return code
+	}
+	if len(args) > 0 && args[0] == "add" {
+		return runRuleAdd(fm, args[1:], o)
+	}
+	if len(args) == 2 && args[0] == "delete" {
+		return runRuleDelete(fm, args[1], o)
+	}
+	return o.fail(config.KindUsage, "Usage: pf-tui rule add [flags] | pf-tui rule delete <id>")
+}
+
+// runRuleAdd adds a firewall rule from flags named after the fields of the
+// rule form, with the same validation. With -json, the added rule is printed.
+func runRuleAdd(fm *config.FirewallManager, args []string, o commandOutput) int {
+	flags := o.flagSet("rule add")
+	var rule config.FirewallRule
+	flags.StringVar(&rule.Action, "action", "block", "block or pass")
+	flags.StringVar(&rule.Direction, "direction", "in", "in or out")
+	flags.BoolVar(&rule.Quick, "quick", false, "stop evaluating the rules at this one")
+	logPackets := flags.Bool("log", false, "log the matching packets to pflog0")
+	logAll := flags.Bool("log-all", false, "log all packets of the matching connections")
+	flags.StringVar(&rule.Interface, "interface", "any", "interface or list of interfaces")
+	flags.StringVar(&rule.Protocol, "proto", "any", "tcp, udp, tcp,udp, icmp or any")
+	flags.StringVar(&rule.Source, "from", "any", "source address, list, table or macro; a leading ! negates it")
+	flags.StringVar(&rule.Destination, "to", "any", "destination address, list, table or macro; a leading ! negates it")
+	flags.StringVar(&rule.SourcePort, "sport", "any", "source port, range or list")
+	flags.StringVar(&rule.DestinationPort, "port", "any", "destination port, range or list")
+	flags.StringVar(&rule.IcmpType, "icmp-type", "", "ICMP type for protocol icmp, e.g. echoreq")
+	flags.StringVar(&rule.IcmpCode, "icmp-code", "", "ICMP code of the ICMP type")
+	state := flags.String("state", "", "no, keep, modulate or synproxy (default pf's keep state)")
+	flags.IntVar(&rule.StateMax, "max-states", 0, "max states created by the rule")
+	flags.StringVar(&rule.SourceTrack, "source-track", "", "rule or global")
+	flags.IntVar(&rule.MaxSrcConn, "max-src-conn", 0, "max simultaneous connections per source")
+	flags.StringVar(&rule.MaxSrcConnRate, "max-src-conn-rate", "", "max new connections per source as number/seconds")
+	flags.StringVar(&rule.OverloadTable, "overload", "", "table that sources exceeding the limits are added to")
+	flags.StringVar(&rule.OverloadFlush, "overload-flush", "", "flush or \"flush global\"")
+	flags.StringVar(&rule.Route, "route", "", "route-to or reply-to")
+	flags.StringVar(&rule.RouteInterface, "route-interface", "", "interface of route-to or reply-to")
+	flags.StringVar(&rule.RouteGateway, "route-gateway", "", "next hop on the route interface")
+	flags.IntVar(&rule.Pipe, "pipe", 0, "dummynet pipe to send the matching traffic to")
+	flags.IntVar(&rule.Probability, "probability", 0, "percentage of matching packets the rule applies to")
+	flags.StringVar(&rule.Group, "group", "", "rule group")
+	flags.StringVar(&rule.Description, "description", "", "description")
+	disabled := flags.Bool("disabled", false, "add the rule disabled")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if flags.NArg() > 0 {
+		return o.fail(config.KindUsage, "Unexpected argument %q", flags.Arg(0))
+	}
+
+	rule.Enabled = !*disabled
+	if *logAll {
+		rule.Log = "log (all)"
+	} else if *logPackets {
+		rule.Log = "log"
+	}
+	if *state != "" && !strings.HasSuffix(*state, " state") {
+		rule.State = *state + " state"
+	} else {
+		rule.State = *state
+	}
+	rule.Group = strings.TrimSpace(rule.Group)
+
+	rule, err := fm.ValidateFirewallRule(rule, pfcli.SystemInterfaces())
+	if err != nil {
+		return o.failErr(err, "Invalid rule")
+	}
+	rule.ID = config.NewRuleID()
+	if err := fm.AddFirewallRule(rule); err != nil {
+		return o.failErr(err, "Failed to add the rule")
+	}
+	return o.result(rule, func(out io.Writer) {
+		fmt.Fprintf(out, "Added rule %s. Apply the configuration to load it.\n", rule.ID)
+	})
+}
+
+// runRuleDelete moves the firewall, port forwarding or NAT rule with the
+// given ID to the archive.
+func runRuleDelete(fm *config.FirewallManager, id string, o commandOutput) int {
+	kind, err := deleteRuleByID(fm, id)
+	if err != nil {
+		return o.failErr(err, "Failed to delete the rule")
+	}
+	if kind == "" {
+		return o.fail(config.KindValidation, "No rule with ID %q, see pf-tui list", id)
+	}
+	deleted := struct {
+		ID   string `json:"id"`
+		Kind string `json:"kind"`
+	}{id, kind}
+	return o.result(deleted, func(out io.Writer) {
+		fmt.Fprintf(out, "%s rule %s moved to the archive. Apply the configuration to unload it.\n", kind, id)
+	})
+}
+
+// deleteRuleByID moves the firewall, port forwarding or NAT rule with the
+// given ID to the archive and returns its kind, or "" if there is none.
+func deleteRuleByID(fm *config.FirewallManager, id string) (string, error) {
+	for i, rule := range fm.Config.FirewallRules {
+		if rule.ID == id {
+			return "Firewall", fm.DeleteFirewallRule(i)
+		}
+	}
+	for i, rule := range fm.Config.PortForwardingRules {
+		if rule.ID == id {
+			return "Port forwarding", fm.DeletePortForwardingRule(i)
+		}
+	}
+	for i, rule := range fm.Config.NatRules {
+		if rule.ID == id {
+			return "NAT", fm.DeleteNatRule(i)
+		}
+	}
+	return "", nil
+}
+
+// runExport writes the configuration to a file, like the Export
+// Configuration screen. An existing file is replaced.
+func runExport(fm *config.FirewallManager, args []string, o commandOutput) int {
+	flags := o.flagSet("export")
+	format := flags.String("format", "", "json, yaml, toml or pfconf (default by the extension of the file, .conf for pfconf)")
+	path := flags.String("o", "", "file to write")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if *path == "" || flags.NArg() > 0 {
+		return o.fail(config.KindUsage, "Usage: pf-tui export [-format json|yaml|toml|pfconf] -o <file>")
+	}
+	if code := loadCommandConfig(fm, o); code != 0 {
+		return code
+	}
+
+	var err error
+	switch strings.ToLower(*format) {
+	case "":
+		if strings.EqualFold(filepath.Ext(*path), ".conf") {
+			*format = "pfconf"
+			err = fm.ExportPfConf(*path)
+		} else {
+			*format = strings.ToLower(string(config.ConfigFormatOf(*path)))
+			err = fm.SaveConfigAs(*path, config.ConfigFormatOf(*path))
+		}
+	case "json", "yaml", "toml":
+		err = fm.SaveConfigAs(*path, config.ConfigFormat(strings.ToUpper(*format)))
+	case "pfconf", "pf.conf":
+		*format = "pfconf"
+		err = fm.ExportPfConf(*path)
+	default:
+		return o.fail(config.KindUsage, "Unknown format %q, use json, yaml, toml or pfconf", *format)
+	}
+	if err != nil {
+		return o.failErr(err, "Failed to export the configuration")
+	}
+	exported := struct {
+		Path   string `json:"path"`
+		Format string `json:"format"`
+	}{*path, strings.ToLower(*format)}
+	return o.result(exported, func(out io.Writer) {
+		fmt.Fprintf(out, "Configuration exported to %s\n", *path)
+	})
+}
+
+// runImport replaces the configuration with a configuration file or a
+// pf.conf, like the Import Configuration screen. See importConfig.
+func runImport(fm *config.FirewallManager, args []string, o commandOutput) int {
+	flags := o.flagSet("import")
+	force := flags.Bool("y", false, "import even if the file has problems")
+	format := flags.String("format", "", "json, yaml, toml or pfconf (default by the extension of the file; for stdin, json if it starts with { and otherwise pfconf)")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if flags.NArg() != 1 {
+		return o.fail(config.KindUsage, "Usage: pf-tui import [-y] [-format json|yaml|toml|pfconf] <file>|-")
+	}
+	loadConfigToReplace(fm, o)
+
+	imported, err := importConfigFile(fm, flags.Arg(0), *format, *force)
+	if err != nil {
+		return o.failErr(err, "Import failed")
+	}
+	if !o.json {
+		for _, warning := range imported.Warnings {
+			fmt.Fprintln(os.Stderr, "Warning: "+warning)
+		}
+	}
+	if !imported.Imported {
+		imported.Status = "Not imported. Run with -y to import anyway."
+		return o.refuse(imported, imported.Status)
+	}
+	imported.Status += " Apply the configuration to load them."
+	return o.result(imported, func(out io.Writer) { fmt.Fprintln(out, imported.Status) })
+}
+
+const profileUsage = "Usage: pf-tui profile list | save <name> | switch [-y] <name> | apply [-y] <name>"
+
+// runProfile lists, saves and switches to the profiles in the profiles
+// directory. Switching imports the profile like pf-tui import, applying it
+// does the same as pf-tui apply -f.
+func runProfile(fm *config.FirewallManager, runner pfcli.CommandRunner, args []string, o commandOutput) int {
+	if len(args) == 0 {
+		return o.fail(config.KindUsage, profileUsage)
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			return o.fail(config.KindUsage, profileUsage)
+		}
+		if code := loadCommandConfig(fm, o); code != 0 {
+			return code
+		}
+		profiles, err := fm.ListProfiles()
+		if err != nil {
+			return o.failErr(err, "Failed to list the profiles")
+		}
+		return o.result(profiles, func(out io.Writer) {
+			if len(profiles) == 0 {
+				fmt.Fprintln(out, "No profiles. Save the configuration as one with pf-tui profile save <name>.")
+			}
+			for _, profile := range profiles {
+				current := ""
+				if profile.Current {
+					current = "  (current)"
+				}
+				fmt.Fprintf(out, "%-20s %-4s %s%s\n", profile.Name, profile.Format, profile.Modified.Format("2006-01-02 15:04"), current)
+			}
+		})
+	case "save":
+		if len(args) != 2 {
+			return o.fail(config.KindUsage, profileUsage)
+		}
+		if code := loadCommandConfig(fm, o); code != 0 {
+			return code
+		}
+		profile, err := fm.SaveProfile(args[1])
+		if err != nil {
+			return o.failErr(err, "Failed to save the profile")
+		}
+		return o.result(profile, func(out io.Writer) {
+			fmt.Fprintf(out, "Configuration saved as profile %s (%s)\n", profile.Name, profile.Path)
+		})
+	case "switch", "apply":
+		flags := o.flagSet("profile " + args[0])
+		force := flags.Bool("y", false, "switch even if the profile has problems; for apply, also apply if the rules may lock out the SSH session")
+		if err := flags.Parse(args[1:]); err != nil {
+			return o.fail(config.KindUsage, "%v", err)
+		}
+		if flags.NArg() != 1 {
+			return o.fail(config.KindUsage, profileUsage)
+		}
+		profile, err := fm.FindProfile(flags.Arg(0))
+		if err != nil {
+			return o.failErr(err, "Failed to find the profile")
+		}
+		LogInfo(fmt.Sprintf("Switching to profile %s", profile.Name))
+		var forceArgs []string
+		if *force {
+			forceArgs = []string{"-y"}
+		}
+		if args[0] == "apply" {
+			return runApply(fm, runner, append(forceArgs, "-f", profile.Path), o)
+		}
+		return runImport(fm, append(forceArgs, profile.Path), o)
+	}
+	return o.fail(config.KindUsage, profileUsage)
+}
+
+// commandImportResult is the result of the import command.
+type commandImportResult struct {
+	Imported bool     `json:"imported"`
+	Path     string   `json:"path"` // "-" for stdin
+	Format   string   `json:"format"`
+	Warnings []string `json:"warnings,omitempty"` // dropped fields and statements, undefined references
+	Status   string   `json:"status"`
+}
+
+// importConfigFile replaces the configuration of fm with the file at path, or
+// stdin for "-", in the given format: json, yaml, toml or pfconf. Without a
+// format, the extension of the file decides, .conf for pfconf; stdin is JSON
+// if it starts with "{" and a pf.conf otherwise. A pf.conf replaces the
+// rules, macros and tables, see ReplaceWithImport. Nothing is imported if
+// there are warnings, unless force is set.
+func importConfigFile(fm *config.FirewallManager, path, format string, force bool) (commandImportResult, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return commandImportResult{}, err
+	}
+
+	format = strings.ToLower(format)
+	switch {
+	case format == "pf.conf":
+		format = "pfconf"
+	case format != "":
+	case path == "-" && strings.HasPrefix(strings.TrimSpace(string(data)), "{"):
+		format = "json"
+	case path == "-" || strings.EqualFold(filepath.Ext(path), ".conf"):
+		format = "pfconf"
+	default:
+		format = strings.ToLower(string(config.ConfigFormatOf(path)))
+	}
+	result := commandImportResult{Path: path, Format: format}
+
+	switch format {
+	case "pfconf":
+		imp := config.ParsePfConf(string(data))
+		for _, statement := range imp.Recognized {
+			if statement.Note != "" {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("Line %d: %s", statement.Line, statement.Note))
+			}
+		}
+		for _, statement := range imp.Skipped {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Line %d skipped: %s (%s)", statement.Line, statement.Text, statement.Note))
+		}
+		if len(result.Warnings) > 0 && !force {
+			result.Status = "Not imported."
+			return result, nil
+		}
+		err = fm.ReplaceWithImport(imp, path)
+	case "json", "yaml", "toml":
+		preview := config.PreviewConfigData(path, data, config.ConfigFormat(strings.ToUpper(format)))
+		if preview.Err != nil {
+			return result, config.WithKind(config.KindConfig, fmt.Errorf("cannot import this %s: %w", preview.Format, preview.Err))
+		}
+		if len(preview.Unknown) > 0 {
+			result.Warnings = append(result.Warnings, "Unknown fields, dropped on import: "+strings.Join(preview.Unknown, ", "))
+		}
+		result.Warnings = append(result.Warnings, preview.Problems...)
+		if len(result.Warnings) > 0 && !force {
+			result.Status = "Not imported."
+			return result, nil
+		}
+		err = fm.ImportConfigData(data, preview.Format, path)
+	default:
+		return result, config.WithKind(config.KindUsage, fmt.Errorf("unknown format %q, use json, yaml, toml or pfconf", format))
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Imported = true
+	result.Status = fmt.Sprintf("Imported %d filter, %d port forwarding and %d NAT rules.",
+		len(fm.Config.FirewallRules), len(fm.Config.PortForwardingRules), len(fm.Config.NatRules))
+	return result, nil
+}
+
+// commandApplyResult is the result of the apply command.
+type commandApplyResult struct {
+	Applied  bool                 `json:"applied"` // false if nothing was applied or the rules were reverted
+	Status   string               `json:"status"`
+	Warnings []string             `json:"warnings,omitempty"` // ways the rules may lock out the SSH session
+	Rejected []commandRuleError   `json:"rejected,omitempty"`
+	Rollback *commandRollback     `json:"rollback,omitempty"` // without a Rollback time, nil
+	Import   *commandImportResult `json:"import,omitempty"`   // with -f, the import before the apply
+}
+
+// commandRuleError is an error of pfctl on a generated line.
+type commandRuleError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+	Text    string `json:"text"`
+	RuleID  string `json:"rule_id,omitempty"`
+}
+
+// commandRollback is the confirmation of an apply with a Rollback time.
+type commandRollback struct {
+	Deadline  time.Time `json:"deadline"`
+	Confirmed bool      `json:"confirmed"`
+}
+
+func runApply(fm *config.FirewallManager, runner pfcli.CommandRunner, args []string, o commandOutput) int {
+	flags := o.flagSet("apply")
+	force := flags.Bool("y", false, "apply even if the rules may lock out the SSH session, or the file of -f has problems")
+	file := flags.String("f", "", "import this file, or stdin for -, before applying, like pf-tui import")
+	format := flags.String("format", "", "format of the file of -f, as for pf-tui import")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if flags.NArg() > 0 {
+		return o.fail(config.KindUsage, "Unexpected argument %q", flags.Arg(0))
+	}
+	if *file != "" {
+		loadConfigToReplace(fm, o)
+	} else if code := loadCommandConfig(fm, o); code != 0 {
+		return code
+	}
+	if !requireSudo(o, runner) {
+		return config.KindPermission.ExitCode()
+	}
+
+	var imported *commandImportResult
+	if *file != "" {
+		result, err := importConfigFile(fm, *file, *format, *force)
+		if err != nil {
+			return o.failErr(err, "Import failed")
+		}
+		if !o.json {
+			for _, warning := range result.Warnings {
+				fmt.Fprintln(os.Stderr, "Warning: "+warning)
+			}
+		}
+		if !result.Imported {
+			status := "Not imported or applied. Run with -y to import anyway."
+			return o.refuse(commandApplyResult{Status: status, Import: &result}, status)
+		}
+		if !o.json {
+			fmt.Fprintln(o.out, result.Status)
+		}
+		imported = &result
+	}
+
+	result, rollback, err := applyConfig(fm, runner, *force)
+	result.Import = imported
+	if err != nil {
+		return o.failErr(err, "Apply failed")
+	}
+	if !o.json {
+		for _, warning := range result.Warnings {
+			fmt.Fprintln(os.Stderr, "Warning: "+warning)
+		}
+	}
+	if !result.Applied {
+		if len(result.Rejected) == 0 {
+			result.Status = "Not applied. Run with -y to apply anyway."
+			return o.refuse(result, result.Status)
+		}
+		o.result(result, func(io.Writer) {
+			fmt.Fprintln(os.Stderr, result.Status)
+			for _, e := range result.Rejected {
+				fmt.Fprintf(os.Stderr, "  line %d: %s (%s)\n", e.Line, e.Message, e.Text)
+			}
+		})
+		return config.KindValidation.ExitCode()
+	}
+	if rollback == nil {
+		return o.result(result, func(out io.Writer) { fmt.Fprintln(out, result.Status) })
+	}
+	if !o.json {
+		fmt.Fprintln(o.out, result.Status)
+	}
+	// The rules came from stdin, so the answer has to come from the terminal
+	input := io.Reader(os.Stdin)
+	if *file == "-" {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			LogWarn(fmt.Sprintf("No terminal to confirm the rules on, reverting them: %v", err))
+			input = strings.NewReader("")
+		} else {
+			defer tty.Close()
+			input = tty
+		}
+	}
+	return confirmApply(rollback, result, input, o)
+}
+
+// applyConfig saves and applies the configuration of fm, unless the rules
+// may lock out the SSH session and force is not set. The rollback is nil
+// without a Rollback time.
+func applyConfig(fm *config.FirewallManager, runner pfcli.CommandRunner, force bool) (commandApplyResult, *config.PendingRollback, error) {
+	var result commandApplyResult
+	result.Warnings = fm.LockoutWarnings(config.CurrentSSHSession())
+	if len(result.Warnings) > 0 && !force {
+		result.Status = "Not applied, the rules may lock out the SSH session."
+		return result, nil, nil
+	}
+
+	applied, err := fm.SaveAndApply(runner, nil)
+	if err != nil {
+		return result, nil, err
+	}
+	if len(applied.Rejected) > 0 {
+		result.Status = "pfctl rejected the rules, nothing was applied."
+		for _, e := range applied.Rejected {
+			result.Rejected = append(result.Rejected, commandRuleError{
+				Line: e.Line, Message: e.Message, Text: strings.TrimSpace(e.Text), RuleID: e.RuleID,
+			})
+		}
+		return result, nil, nil
+	}
+	result.Applied = true
+	result.Status = applied.Status
+	if applied.Rollback != nil {
+		result.Rollback = &commandRollback{Deadline: applied.Rollback.Deadline}
+	}
+	return result, applied.Rollback, nil
+}
+
+// keepRollback confirms the rules of an apply with a rollback time and
+// records their snapshot in the history.
+func keepRollback(rollback *config.PendingRollback) error {
+	if err := rollback.Confirm(); err != nil {
+		return err
+	}
+	if rollback.Snapshot != nil {
+		if err := config.RecordSnapshot(*rollback.Snapshot); err != nil {
+			LogError(fmt.Sprintf("Failed to record the snapshot: %v", err))
+		}
+	}
+	return nil
+}
+
+// confirmApply asks on input whether to keep the rules of an apply with a
+// rollback time, and reverts them unless "y" is entered before the deadline.
+// With -json, the question goes to stderr.
+func confirmApply(rollback *config.PendingRollback, result commandApplyResult, input io.Reader, o commandOutput) int {
+	prompt := o.out
+	if o.json {
+		prompt = os.Stderr
+	}
+	fmt.Fprintf(prompt, "Keep the new rules? They are reverted at %s unless confirmed. [y/N] ", rollback.Deadline.Format("15:04:05"))
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(input).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case line := <-answer:
+		if strings.EqualFold(line, "y") {
+			if err := keepRollback(rollback); err != nil {
+				return o.failErr(err, "Failed to keep the new rules")
+			}
+			result.Rollback.Confirmed = true
+			result.Status = "New rules confirmed and kept."
+			return o.result(result, func(out io.Writer) { fmt.Fprintln(out, result.Status) })
+		}
+		if output, err := rollback.Revert(); err != nil {
+			return o.fail(config.KindOf(err), "Failed to revert the rules: %v, output: %s", err, output)
+		}
+		result.Status = "Reverted to the previous rules."
+	case <-time.After(time.Until(rollback.Deadline)):
+		// The scheduled revert restores the previous rules on its own
+		fmt.Fprintln(prompt)
+		result.Status = "The new rules were not confirmed in time and have been reverted."
+	}
+	result.Applied = false
+	o.result(result, func(out io.Writer) { fmt.Fprintln(out, result.Status) })
+	return 1
+}
+
+// runBackup makes a scheduled backup for the -backup flag. It needs no sudo,
+// the anchor file is readable by everyone.
+func runBackup() int {
+	o := newCommandOutput()
+	fm := config.NewFirewallManager()
+	if err := fm.LoadConfig(); err != nil {
+		// The rules file is backed up as it is, with the default retention
+		LogWarn(fmt.Sprintf("Backing up a configuration that cannot be loaded: %v", err))
+		fm.Config = config.NewFirewallManager().Config
+	}
+	dir, err := config.RunScheduledBackup(fm.Config.Settings)
+	if err != nil {
+		return o.failErr(err, "Backup failed")
+	}
+	backup := struct {
+		Dir string `json:"dir"`
+	}{dir}
+	return o.result(backup, func(out io.Writer) { fmt.Fprintf(out, "Backed up to %s\n", dir) })
+}
+
+// runPanic loads the pass-all rule of PanicAllowAll for the -panic flag.
+func runPanic(runner pfcli.CommandRunner) int {
+	o := newCommandOutput()
+	if !requireSudo(o, runner) {
+		return config.KindPermission.ExitCode()
+	}
+	if output, err := pfcli.PanicAllowAll(runner); err != nil {
+		return o.fail(config.KindOf(err), "Failed to load the pass-all rule: %v\n%s", err, output)
+	}
+	panicked := struct {
+		Status string `json:"status"`
+	}{"All traffic is passed by the pf-tui anchor. Save & Apply the configuration to restore the rules."}
+	return o.result(panicked, func(out io.Writer) { fmt.Fprintln(out, panicked.Status) })
+}
@@ -0,0 +1,37 @@
+package tui
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/muesli/termenv"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+)
+
+// CopyToClipboard puts text on the clipboard with pbcopy, or in an SSH
+// session with the OSC 52 escape sequence, which asks the terminal to put it
+// on the clipboard of the machine in front of it. Terminals without OSC 52
+// support ignore it.
+func CopyToClipboard(text string) error {
+	if config.CurrentSSHSession() != nil {
+		termenv.Copy(text)
+		return nil
+	}
+	cmd := exec.Command("pbcopy")
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to copy to the clipboard: %w, output: %s", err, out)
+	}
+	return nil
+}
+
+// ReadClipboard returns the text on the clipboard, with pbpaste.
+func ReadClipboard() (string, error) {
+	out, err := exec.Command("pbpaste").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the clipboard: %w", err)
+	}
+	return string(out), nil
+}
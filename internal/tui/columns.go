@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// ruleColumn is an optional column of the rule list, after the number,
+// action and direction that are always shown.
+type ruleColumn struct {
+	name   string // in the rule_list_columns setting
+	header string
+	width  int // 0 for the last column, which is not padded
+	value  func(i ruleListItem) string
+}
+
+// ruleColumns are the optional columns of the rule list, in their order.
+var ruleColumns = []ruleColumn{
+	{"quick", "Q", 3, func(i ruleListItem) string {
+		if i.rule.Quick {
+			return "Y"
+		}
+		return ""
+	}},
+	{"proto", "Proto", 7, func(i ruleListItem) string { return i.rule.Protocol }},
+	{"source", "Source", 15, func(i ruleListItem) string { return config.FormatHost(i.rule.Source, i.rule.SourceNot) }},
+	{"dest", "Dest", 15, func(i ruleListItem) string { return config.FormatHost(i.rule.Destination, i.rule.DestinationNot) }},
+	{"port", "Port", 10, func(i ruleListItem) string {
+		port := config.FormatPortServices(i.rule.DestinationPort)
+		if i.rule.SourcePort != "any" && i.rule.SourcePort != "" {
+			port = config.FormatPortServices(i.rule.SourcePort) + ">" + port
+		}
+		return port
+	}},
+	{"state", "S", 3, func(i ruleListItem) string {
+		// One letter per state mode: No/Keep/Modulate/Synproxy
+		if i.rule.State != "" {
+			return strings.ToUpper(i.rule.State[:1])
+		}
+		return ""
+	}},
+	{"hits", "Hits", 7, func(i ruleListItem) string { return i.counter(func(c pfcli.RuleCounters) uint64 { return c.Packets }) }},
+	{"bytes", "Bytes", 7, func(i ruleListItem) string { return i.counter(func(c pfcli.RuleCounters) uint64 { return c.Bytes }) }},
+	{"states", "States", 7, func(i ruleListItem) string { return i.counter(func(c pfcli.RuleCounters) uint64 { return c.States }) }},
+	{"evals", "Evals", 7, func(i ruleListItem) string {
+		return i.counter(func(c pfcli.RuleCounters) uint64 { return c.Evaluations })
+	}},
+	{"group", "Group", 12, func(i ruleListItem) string { return i.rule.Group }},
+	{"description", "Description", 0, func(i ruleListItem) string { return i.rule.Description }},
+}
+
+// defaultRuleColumns are the columns shown if the settings do not set them.
+var defaultRuleColumns = []string{"quick", "proto", "source", "dest", "port", "state", "hits", "description"}
+
+// Rule list layouts: one line per rule, or a second line with the fields
+// the columns do not show.
+const (
+	layoutCompact  = "compact"
+	layoutDetailed = "detailed"
+)
+
+// shownRuleColumns returns the names of the columns the settings show, the
+// defaultRuleColumns if they do not set them.
+func shownRuleColumns(settings config.Settings) []string {
+	if len(settings.RuleListColumns) == 0 {
+		return defaultRuleColumns
+	}
+	return settings.RuleListColumns
+}
+
+// counter renders a pf counter of the rule, "-" if the rule has none.
+func (i ruleListItem) counter(value func(pfcli.RuleCounters) uint64) string {
+	if i.counters == nil {
+		return "-"
+	}
+	return formatCount(value(*i.counters))
+}
+
+// ruleListHeader renders the header of the rule list for the shown columns.
+func ruleListHeader(columns []string) string {
+	header := "   #   Action  Dir   "
+	for _, column := range ruleColumns {
+		if slices.Contains(columns, column.name) {
+			header += fmt.Sprintf("%-*s ", column.width, column.header)
+		}
+	}
+	return strings.TrimRight(header, " ")
+}
+
+// details renders the second line of a rule in the detailed layout: the
+// fields of the rule that are not in the shown columns, e.g. "on en0  log
+// pipe 1  group LAN".
+func (i ruleListItem) details() string {
+	var parts []string
+	if i.rule.Interface != "any" && i.rule.Interface != "" {
+		parts = append(parts, "on "+i.rule.Interface)
+	}
+	if i.rule.Log != "" {
+		parts = append(parts, i.rule.Log)
+	}
+	if route := config.FormatRoute(i.rule); route != "" {
+		parts = append(parts, route)
+	}
+	if opts := config.StateOptions(i.rule); len(opts) > 0 {
+		parts = append(parts, strings.Join(opts, ", "))
+	}
+	if i.rule.Pipe > 0 {
+		parts = append(parts, fmt.Sprintf("pipe %d", i.rule.Pipe))
+	}
+	if i.rule.Probability > 0 && i.rule.Probability < 100 {
+		parts = append(parts, fmt.Sprintf("probability %d%%", i.rule.Probability))
+	}
+	if i.rule.Group != "" && !slices.Contains(i.columns, "group") {
+		parts = append(parts, "group "+i.rule.Group)
+	}
+	if i.counters != nil {
+		var counters []string
+		for _, counter := range []struct {
+			column string
+			value  uint64
+		}{
+			{"hits", i.counters.Packets},
+			{"bytes", i.counters.Bytes},
+			{"states", i.counters.States},
+			{"evals", i.counters.Evaluations},
+		} {
+			if !slices.Contains(i.columns, counter.column) {
+				counters = append(counters, formatCount(counter.value)+" "+counter.column)
+			}
+		}
+		if len(counters) > 0 {
+			parts = append(parts, strings.Join(counters, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "      " + strings.Join(parts, "  ")
+}
+
+// newRuleListDelegate returns the delegate of the rule list for a layout.
+func newRuleListDelegate(layout string) list.DefaultDelegate {
+	delegate := newListDelegate()
+	delegate.ShowDescription = layout == layoutDetailed
+	delegate.SetHeight(1)
+	if delegate.ShowDescription {
+		delegate.SetHeight(2)
+	}
+	selected := lipgloss.NewStyle().
+		Border(selectionBorder(), false, false, false, true).
+		BorderForeground(lipgloss.AdaptiveColor{Light: "#F793FF", Dark: "#AD58B4"}).
+		Foreground(lipgloss.AdaptiveColor{Light: "#EE6FF8", Dark: "#EE6FF8"}).
+		Padding(0, 0, 0, 1)
+	delegate.Styles.NormalTitle = lipgloss.NewStyle().Padding(0, 0, 0, 2)
+	delegate.Styles.SelectedTitle = selected
+	delegate.Styles.NormalDesc = disabledStyle.Padding(0, 0, 0, 2)
+	delegate.Styles.SelectedDesc = selected
+	delegate.SetSpacing(0)
+	return delegate
+}
+
+// openRuleColumns opens ruleColumnsView with the columns and layout of the
+// settings.
+func (m *model) openRuleColumns() {
+	m.columnsDraft = append([]string(nil), shownRuleColumns(m.firewallManager.Config.Settings)...)
+	m.layoutDraft = m.firewallManager.Config.Settings.RuleListLayout
+	if m.layoutDraft == "" {
+		m.layoutDraft = layoutCompact
+	}
+	m.columnsCursor = 0
+	m.clearToast()
+	m.pushView(ruleColumnsView)
+}
+
+// toggleRuleColumn shows or hides the column at the cursor of ruleColumnsView.
+func (m *model) toggleRuleColumn() {
+	name := ruleColumns[m.columnsCursor].name
+	var columns []string
+	for _, column := range ruleColumns {
+		shown := slices.Contains(m.columnsDraft, column.name)
+		if (column.name == name) != shown {
+			columns = append(columns, column.name)
+		}
+	}
+	m.columnsDraft = columns
+}
+
+func (m *model) ruleColumnsView() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Rule List Columns"))
+	b.WriteString("\n\n")
+	for i, column := range ruleColumns {
+		check := "[ ]"
+		if slices.Contains(m.columnsDraft, column.name) {
+			check = "[x]"
+		}
+		label := column.header
+		if !strings.EqualFold(column.header, column.name) {
+			label = fmt.Sprintf("%s (%s)", column.header, column.name)
+		}
+		b.WriteString(renderSelectedLine(check+" "+label, i == m.columnsCursor) + "\n")
+	}
+	b.WriteString("\n" + renderOptions("Layout", []string{layoutCompact, layoutDetailed}, m.layoutDraft, false))
+	b.WriteString("\n" + ruleListHeader(m.columnsDraft) + "\n")
+	b.WriteString("\n" + m.helpView("  "))
+	if m.notifications.toast != nil {
+		b.WriteString("\n  " + m.toastView())
+	}
+	return appStyle.Render(b.String())
+}
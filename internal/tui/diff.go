@@ -0,0 +1,135 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffOp is one line of a line diff: ' ' for a common line, '-' for a line
+// only in the old text and '+' for a line only in the new text.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the line diff of a and b, from a longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// splitDiffLines splits text into lines for diffLines, without a trailing empty line.
+func splitDiffLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// UnifiedDiff returns the differences between oldText and newText in unified
+// diff format with context lines around each change, or "" if they are equal.
+func UnifiedDiff(oldName, newName, oldText, newText string, context int) string {
+	ops := diffLines(splitDiffLines(oldText), splitDiffLines(newText))
+
+	var b strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk until more than 2*context common lines follow a change
+		end := start
+		for common := 0; end < len(ops) && common <= 2*context; end++ {
+			if ops[end].kind == ' ' {
+				common++
+			} else {
+				common = 0
+			}
+		}
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		// Drop the common lines after the last change beyond the context
+		hunkEnd := end
+		for hunkEnd > start && ops[hunkEnd-1].kind == ' ' {
+			hunkEnd--
+		}
+		hunkEnd += context
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		// Line numbers of the hunk in the old and new text
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		if b.Len() == 0 {
+			b.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName))
+		}
+		b.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			b.WriteString(string(op.kind) + op.line + "\n")
+		}
+		start = hunkEnd
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package tui
+
+import "github.com/charmbracelet/lipgloss"
+
+var fieldHintStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("245")).Italic(true)
+
+// ruleFieldHints explain what the fields of the rule form mean to pf. They are
+// shown below the form for the focused field.
+var ruleFieldHints = [ruleFieldCount]string{
+	ruleFieldAction:          "pass lets matching packets through, block drops them (or returns them, see Block Policy).",
+	ruleFieldDirection:       "in matches packets arriving on the interface, out those leaving it.",
+	ruleFieldQuick:           "quick stops at this rule when it matches; otherwise the last matching rule decides.",
+	ruleFieldLog:             "log sends the packet that creates a state to pflog0; log (all) logs every packet.",
+	ruleFieldInterface:       "The interface the packets pass, e.g. en0 or utun0, or any for all of them.",
+	ruleFieldRoute:           "route-to sends matching packets out another interface, reply-to routes the replies.",
+	ruleFieldRouteInterface:  "The interface route-to or reply-to sends the packets out of.",
+	ruleFieldRouteGateway:    "The next hop on the route interface, e.g. the router of a second uplink.",
+	ruleFieldProtocol:        "Ports only apply to tcp and udp; tcp,udp generates one rule for each.",
+	ruleFieldIcmpType:        "The ICMP message type, e.g. echoreq for ping requests.",
+	ruleFieldIcmpCode:        "The code of the ICMP type, e.g. port-unr for a closed UDP port.",
+	ruleFieldSource:          "any, an address, a network (10.0.0.0/8), a <table>, $macro or (en0) for its address.",
+	ruleFieldSourceNot:       "Yes matches every source except the one given (! in pf.conf).",
+	ruleFieldSourcePort:      "A port (22), service (ssh), range (8000-8080), list (80, 443) or any.",
+	ruleFieldDestination:     "any, an address, a network (10.0.0.0/8), a <table>, $macro or (en0) for its address.",
+	ruleFieldDestinationNot:  "Yes matches every destination except the one given (! in pf.conf).",
+	ruleFieldDestinationPort: "A port (22), service (ssh), range (8000-8080), list (80, 443) or any.",
+	ruleFieldState:           "keep state lets the replies of a connection through without their own rule.",
+	ruleFieldStateMax:        "max: the most states, i.e. connections, this rule may create at once.",
+	ruleFieldSourceTrack:     "Counts states per source address, for the per-source limits below.",
+	ruleFieldMaxSrcConn:      "max-src-conn: the most connections one source address may have open.",
+	ruleFieldMaxSrcConnRate:  "max-src-conn-rate: new connections per seconds one source may open, e.g. 15/5.",
+	ruleFieldOverloadTable:   "Sources over the limits are added to this <table>, e.g. to block them.",
+	ruleFieldOverloadFlush:   "flush kills the states of an overloading source, flush global those of all rules.",
+	ruleFieldPipe:            "dummynet pipe that shapes the matching traffic, see Pipes.",
+	ruleFieldProbability:     "The rule only matches this percentage of the packets, e.g. to simulate loss.",
+	ruleFieldGroup:           "Groups the rule in the rule list and the generated pf.conf; pf ignores it.",
+	ruleFieldDescription:     "Written as a comment above the rule in the generated pf.conf.",
+}
+
+// ruleOptionHints explain the choices of option fields of the rule form whose
+// choices mean more than their field hint says.
+var ruleOptionHints = map[int]map[string]string{
+	ruleFieldState: {
+		"default":        "pf's default: keep state for pass rules, no state for block rules.",
+		"no state":       "no state: every packet, replies included, is matched against the rules.",
+		"keep state":     "keep state lets the replies of a connection through without their own rule.",
+		"modulate state": "modulate state keeps state and randomizes TCP sequence numbers (tcp only).",
+		"synproxy state": "synproxy completes the TCP handshake itself first, against SYN floods (tcp only).",
+	},
+	ruleFieldSourceTrack: {
+		"rule":   "source-track rule: the per-source limits count the states of this rule only.",
+		"global": "source-track global: the per-source limits count the states of all rules.",
+	},
+}
+
+// ruleFieldHint returns the hint of the focused field of the rule form.
+func (f *ruleForm) ruleFieldHint() string {
+	if _, selected := f.optionField(f.focused); selected != nil {
+		if hint, ok := ruleOptionHints[f.focused][*selected]; ok {
+			return hint
+		}
+	}
+	return ruleFieldHints[f.focused]
+}
+
+// The hints of the other forms, by field index in display order.
+var (
+	portForwardingFieldHints = []string{
+		"The interface the forwarded connections arrive on, e.g. en0.",
+		"rdr rules redirect one protocol; add a second rule for the other.",
+		"The address the connections are made to, any for all of the interface.",
+		"The port or range (8000-8080) the connections are made to.",
+		"The address the connections are redirected to, here or on the network.",
+		"The port they are redirected to; a range must be as long as the external one.",
+		"Written as a comment above the rdr rule in the generated pf.conf.",
+	}
+	natFieldHints = []string{
+		"The interface the translated packets leave on, usually the one to the internet.",
+		"The protocol to translate, any for all.",
+		"The addresses to translate, e.g. the network behind this machine.",
+		"Only packets to these addresses are translated, any for all.",
+		"The address the source is rewritten to; (en0) follows the address of en0.",
+		"Written as a comment above the nat rule in the generated pf.conf.",
+	}
+	tableFieldHints = []string{
+		"Rules reference the table as <name>.",
+		"persist keeps the table in pf even while no rule references it.",
+		"Addresses and networks, e.g. 192.0.2.1, 198.51.100.0/24, separated by commas.",
+		"Written as a comment above the table in the generated pf.conf.",
+		"A list of networks downloaded into the table, one per line.",
+		"How often the feed is downloaded again while pf-tui runs.",
+	}
+	macroFieldHints = []string{
+		"Rule fields reference the macro as $name.",
+		"Replaced for $name when pf reads the rules, e.g. en0 or {80, 443}.",
+		"Written as a comment above the macro in the generated pf.conf.",
+	}
+	pipeFieldHints = []string{
+		"Rules attach to the pipe with this number in their Pipe field.",
+		"The most the pipe lets through, e.g. 10Mbit/s; empty for unlimited.",
+		"The delay added to every packet, e.g. to simulate a slow link.",
+		"How many packets wait in the pipe before it drops them; empty for the default.",
+		"Shown in the pipe list only; pipes are set up with dnctl, not in pf.conf.",
+	}
+	optionsFieldHints = [optionsFieldCount]string{
+		optionsFieldSkip:              "set skip on: pf does not filter these interfaces at all, e.g. lo0.",
+		optionsFieldStateLimit:        "set limit states: the most states, i.e. connections, pf keeps at once.",
+		optionsFieldTableEntriesLimit: "set limit table-entries: the most addresses all tables may hold.",
+		optionsFieldBlockPolicy:       "set block-policy: drop silently discards, return sends a TCP RST or ICMP error.",
+		optionsFieldOptimization:      "set optimization: how long idle states are kept, see Edit Timeouts.",
+	}
+	scrubFieldHints = [scrubFieldCount]string{
+		scrubFieldEnabled:       "scrub normalizes packets before they are translated and filtered.",
+		scrubFieldDirection:     "Scrub incoming packets, outgoing packets, or both.",
+		scrubFieldInterface:     "The interface to scrub on, any for all.",
+		scrubFieldReassembleTCP: "reassemble tcp normalizes TCP connections, e.g. their timestamps and TTLs.",
+		scrubFieldNoDF:          "no-df clears the don't-fragment bit, for hosts that set it on fragments.",
+		scrubFieldRandomID:      "random-id replaces the IP IDs of outgoing packets with random ones.",
+		scrubFieldFragment:      "reassemble joins fragments before the rules see them; crop and drop-ovl only trim overlaps.",
+	}
+)
+
+// fieldHint returns the hint of the focused field, "" if it has none.
+func fieldHint(hints []string, focused int) string {
+	if focused < 0 || focused >= len(hints) {
+		return ""
+	}
+	return hints[focused]
+}
+
+// renderFieldHint renders the hint of the focused form field as the line
+// below the fields, empty if there is no hint.
+func renderFieldHint(hint string) string {
+	if hint == "" {
+		return "\n"
+	}
+	return "    " + fieldHintStyle.Render(hint) + "\n"
+}
@@ -0,0 +1,116 @@
+package tui
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// GeoIP annotates addresses with their country and autonomous system, using
+// local MaxMind GeoLite2/GeoIP2 databases. Either database may be missing.
+type GeoIP struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+type geoCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type geoASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenGeoIP opens the country and ASN databases at the given paths. Empty
+// paths are skipped; it returns nil without error if both are empty.
+func OpenGeoIP(countryPath, asnPath string) (*GeoIP, error) {
+	if countryPath == "" && asnPath == "" {
+		return nil, nil
+	}
+	g := &GeoIP{cache: make(map[string]string)}
+	if countryPath != "" {
+		reader, err := maxminddb.Open(countryPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP country database %s: %w", countryPath, err)
+		}
+		g.country = reader
+	}
+	if asnPath != "" {
+		reader, err := maxminddb.Open(asnPath)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("failed to open GeoIP ASN database %s: %w", asnPath, err)
+		}
+		g.asn = reader
+	}
+	return g, nil
+}
+
+// Close closes the databases.
+func (g *GeoIP) Close() {
+	if g == nil {
+		return
+	}
+	if g.country != nil {
+		g.country.Close()
+	}
+	if g.asn != nil {
+		g.asn.Close()
+	}
+}
+
+// Annotate returns the country code and autonomous system of addr, such as
+// "US AS15169 Google LLC", or "" if addr is not an address or not found.
+// A nil GeoIP annotates nothing.
+func (g *GeoIP) Annotate(addr string) string {
+	if g == nil {
+		return ""
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if annotation, ok := g.cache[addr]; ok {
+		return annotation
+	}
+
+	var parts []string
+	if g.country != nil {
+		var record geoCountryRecord
+		if err := g.country.Lookup(ip, &record); err != nil {
+			logging.Warn(fmt.Sprintf("GeoIP country lookup of %s failed: %v", addr, err))
+		} else if record.Country.ISOCode != "" {
+			parts = append(parts, record.Country.ISOCode)
+		} else if record.RegisteredCountry.ISOCode != "" {
+			parts = append(parts, record.RegisteredCountry.ISOCode)
+		}
+	}
+	if g.asn != nil {
+		var record geoASNRecord
+		if err := g.asn.Lookup(ip, &record); err != nil {
+			logging.Warn(fmt.Sprintf("GeoIP ASN lookup of %s failed: %v", addr, err))
+		} else if record.Number != 0 {
+			parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", record.Number, record.Organization)))
+		}
+	}
+	annotation := strings.Join(parts, " ")
+	g.cache[addr] = annotation
+	return annotation
+}
@@ -0,0 +1,356 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+)
+
+// keyMap is the keys of a view for the help: columns of key bindings, the
+// first of which is shown in the help line at the bottom of the view, and
+// all of them in the help overlay "?" opens.
+type keyMap [][]key.Binding
+
+// ShortHelp returns the keys of the help line.
+func (k keyMap) ShortHelp() []key.Binding {
+	if len(k) == 0 {
+		return nil
+	}
+	return k[0]
+}
+
+// FullHelp returns the keys of the help overlay.
+func (k keyMap) FullHelp() [][]key.Binding {
+	return k
+}
+
+// keyDisplayNames are the names the help shows for keys other than single
+// characters, by the name bubbletea gives them. Plain mode shows the names.
+var keyDisplayNames = map[string]string{
+	"up":    "↑",
+	"down":  "↓",
+	"left":  "←",
+	"right": "→",
+}
+
+// displayKey returns the name of a key as the help shows it.
+func displayKey(name string) string {
+	if name == " " {
+		return "space"
+	}
+	if display, ok := keyDisplayNames[name]; ok && !plainMode {
+		return display
+	}
+	return name
+}
+
+// bind returns the binding of keys, by the names bubbletea gives them, shown
+// in the help as e.g. "↑/↓ navigate".
+func bind(desc string, keys ...string) key.Binding {
+	shown := make([]string, len(keys))
+	for i, k := range keys {
+		shown[i] = displayKey(k)
+	}
+	return key.NewBinding(key.WithKeys(keys...), key.WithHelp(strings.Join(shown, "/"), desc))
+}
+
+// bindIf returns the binding of bind if enabled, or else a disabled binding
+// the help leaves out, for keys that do nothing in the current state.
+func bindIf(enabled bool, desc string, keys ...string) key.Binding {
+	binding := bind(desc, keys...)
+	binding.SetEnabled(enabled)
+	return binding
+}
+
+// withKeybindings returns a keyMap with the keys of the keybindings of the
+// settings: the keys bound to a key are shown after it, e.g. "d/ctrl+d
+// delete", and keys that are rebound or turned off are left out. Bindings
+// without any key left are left out of the help.
+func withKeybindings(bindings map[string]string, keys keyMap) keyMap {
+	if len(bindings) == 0 {
+		return keys
+	}
+	aliases := make(map[string][]string)
+	for from, to := range bindings {
+		if to != "" {
+			aliases[keyName(to)] = append(aliases[keyName(to)], keyName(from))
+		}
+	}
+	for _, names := range aliases {
+		sort.Strings(names)
+	}
+	// A key bound to another key, or turned off, no longer does what it did
+	rebound := func(name string) bool {
+		_, ok := bindings[name]
+		if !ok && name == " " {
+			_, ok = bindings["space"]
+		}
+		return ok
+	}
+
+	remapped := make(keyMap, len(keys))
+	for i, column := range keys {
+		remapped[i] = make([]key.Binding, len(column))
+		for j, binding := range column {
+			var names []string
+			for _, name := range binding.Keys() {
+				if !rebound(name) {
+					names = append(names, name)
+				}
+				names = append(names, aliases[name]...)
+			}
+			remapped[i][j] = bindIf(binding.Enabled() && len(names) > 0, binding.Help().Desc, names...)
+		}
+	}
+	return remapped
+}
+
+// keyMap returns the keys of the current view, with the keybindings of the
+// settings.
+func (m *model) keyMap() keyMap {
+	var (
+		navigate = bind("navigate", "up", "down")
+		scroll   = bind("scroll", "up", "down")
+		back     = bind("back", "esc")
+		cancel   = bind("cancel", "esc")
+		keys     keyMap
+	)
+	switch m.currentView {
+	case mainView:
+		keys = keyMap{{navigate, bind("open", "enter"), bindIf(m.rulesFileChanged, "reload rules", "r"), bind("quit", "esc")}}
+	case confirmationView:
+		keys = keyMap{{bind("yes", "y"), bind("no", "n")}}
+	case ruleListView:
+		edit := bind("edit", "enter")
+		if _, ok := m.ruleList.SelectedItem().(ruleGroupListItem); ok {
+			edit = bind("collapse/expand", "enter")
+		}
+		keys = keyMap{
+			{navigate, bind("add", "a"), edit, bind("delete", "d"), bind("enable/disable", "e"), back},
+			{bind("copy", "c"), bind("inspect", "i"), bind("move up/down", "k", "j"), bind("save order", "s"), bind("yank as pf", "y"), bind("yank as JSON", "Y"), bind("paste pf rule", "P")},
+			{bind("select", " "), bind("move to group", "g"), bind("export", "x"), bind("columns", "v"), bind("detail panel", "p"), bind("save & apply", "A"), bindIf(m.canUndoDelete("Firewall"), "undo delete", "u")},
+		}
+		if len(m.markedRules) > 0 {
+			keys[0][3] = bind("delete selected", "d")
+			keys[0][4] = bind("enable/disable selected", "e")
+			keys[2][1] = bind("move selected to group", "g")
+			keys[2][2] = bind("export selected", "x")
+			keys[1][4] = bind("yank selected as pf", "y")
+			keys[1][5] = bind("yank selected as JSON", "Y")
+		}
+	case ruleFormView:
+		text := m.form.textInput(m.form.focused) != nil
+		port := m.form.focused == ruleFieldSourcePort || m.form.focused == ruleFieldDestinationPort
+		keys = m.formKeys(m.form.activeTextInput != -1, !text, text, "save rule", bindIf(port, "pick a service", "/"))
+	case portForwardingFormView:
+		keys = m.formKeys(m.portForwardingForm.activeTextInput != -1, true, true, "save rule")
+	case natFormView:
+		keys = m.formKeys(m.natForm.activeTextInput != -1, true, true, "save rule")
+	case tableFormView:
+		keys = m.formKeys(m.tableForm.activeTextInput != -1, true, true, "save table")
+	case macroFormView:
+		keys = m.formKeys(m.macroForm.activeTextInput != -1, false, true, "save macro")
+	case pipeFormView:
+		keys = m.formKeys(m.pipeForm.activeTextInput != -1, false, true, "save pipe")
+	case timeoutsFormView:
+		keys = m.formKeys(m.timeoutsForm.activeTextInput != -1, false, true, "save and apply")
+	case optionsFormView:
+		text := m.optionsForm.textInput(m.optionsForm.focused) != nil
+		keys = m.formKeys(m.optionsForm.activeTextInput != -1, !text, text, "save options")
+	case sharingFormView:
+		text := m.sharingForm.textInput(m.sharingForm.focused) != nil
+		keys = m.formKeys(m.sharingForm.activeTextInput != -1, !text, text, "add the rules")
+	case settingsFormView:
+		text := m.settingsForm.textInput(m.settingsForm.focused) != nil
+		keys = m.formKeys(m.settingsForm.activeTextInput != -1, !text, text, "save", bind("back up now", "b"))
+	case scrubFormView:
+		keys = m.formKeys(m.scrubForm.activeTextInput != -1, true, true, "save scrub options")
+	case portForwardingListView:
+		keys = keyMap{
+			{navigate, bind("add", "a"), bind("edit", "enter"), bind("delete", "d"), bind("enable/disable", "e"), back},
+			{bind("copy", "c"), bind("details", "i"), bind("move up/down", "k", "j"), bind("save order", "s"), bind("save & apply", "A"), bindIf(m.canUndoDelete("Port Forwarding"), "undo delete", "u")},
+		}
+	case natListView:
+		keys = keyMap{
+			{navigate, bind("add", "a"), bind("edit", "enter"), bind("delete", "d"), back},
+			{bind("details", "i"), bind("move up/down", "k", "j"), bind("save order", "s")},
+		}
+	case tableListView:
+		keys = keyMap{
+			{navigate, bind("add", "a"), bind("edit", "enter"), bind("delete", "d"), back},
+			{bind("loaded entries", "v"), bind("update feed", "u")},
+		}
+	case tableEntriesView:
+		keys = keyMap{{navigate, bind("add", "a"), bind("delete", "d"), bind("flush", "f"), bind("refresh", "r"), back}}
+		if m.tableEntryAdding {
+			keys = keyMap{{bind("add", "enter"), back}}
+		}
+	case macroListView, pipeListView:
+		keys = keyMap{{navigate, bind("add", "a"), bind("edit", "enter"), bind("delete", "d"), back}}
+	case pflogView:
+		keys = keyMap{
+			{bind("select", "up", "down"), bind("block source", "b"), bind("whois", "w"), bind("filter", "/"), bind("back", "esc", "q")},
+			{bind("host names", "n"), bind("pause/resume", "p"), bind("follow", "f"), bind("clear", "c")},
+		}
+		switch {
+		case m.pflogFiltering:
+			keys = keyMap{{bind("apply filter", "enter"), back}}
+		case m.pflogBlockAddr != "":
+			keys = keyMap{{bind("quick rule", "r"), bind("add to <"+config.BlocklistTable+">", "t")}}
+		}
+	case applyPreviewView:
+		keys = keyMap{{bind("save & apply", "y", "enter"), bind("cancel", "n", "esc"), scroll}}
+	case unsavedChangesView:
+		keys = keyMap{{bind("save & apply", "a"), bind("save", "s"), bind("discard", "d"), cancel}}
+	case rollbackView:
+		keys = keyMap{{bind("keep the new rules", "y", "enter"), bind("revert now", "r")}}
+	case whoisView, ruleDetailView:
+		keys = keyMap{{scroll, bind("back", "esc", "q")}}
+	case infoView:
+		keys = keyMap{{scroll, bind("back", "esc", "q")}}
+		pause := bind("pause", " ")
+		if m.refreshPaused {
+			pause = bind("resume", " ")
+		}
+		switch m.infoViewTitle {
+		case "Current Live PF Rules":
+			keys[0] = append(keys[0], bind("pf-tui anchor only", "a"), bind("reload", "r"))
+		case "pf-tui Anchor Rules":
+			keys[0] = append(keys[0], bind("all rules", "a"), bind("reload", "r"))
+		case "Top Talkers":
+			names := bind("host names", "n")
+			if m.resolveNames {
+				names = bind("addresses", "n")
+			}
+			keys[0] = append([]key.Binding{bind("select", "up", "down"), bind("whois", "w"), names}, keys[0][1:]...)
+		case "Live PF Info":
+			keys[0] = append(keys[0], pause, bind("refresh now", "r"), bind("refresh slower", "+", "="), bind("refresh faster", "-"))
+		case "PF States":
+			keys[0] = append(keys[0], pause, bind("refresh now", "r"))
+		case "Generated pf.conf":
+			keys[0] = append(keys[0], bind("copy", "c"), bind("save to file", "s"))
+		}
+		if m.searchable() {
+			switch {
+			case m.search.editing:
+				keys = keyMap{{bind("done", "enter"), bind("cancel", "esc")}}
+			case m.search.input.Value() != "":
+				// esc clears the search before it leaves the view
+				keys[0][1] = bind("back", "q")
+				keys[0] = append([]key.Binding{bind("next/previous match", "n", "N"), bind("clear search", "esc")}, keys[0]...)
+			default:
+				keys[0] = append(keys[0], bind("search", "/"))
+			}
+		}
+	case saveConfigView:
+		keys = keyMap{
+			{bind("save", "enter"), bind("complete directory", "tab"), bind("switch format", "shift+tab"), cancel},
+			{bindIf(len(m.recentExports) > 0, "recent destinations", "up", "down")},
+		}
+	case importConfigView:
+		hidden := "show hidden"
+		if m.browseHidden {
+			hidden = "hide hidden"
+		}
+		keys = keyMap{
+			{navigate, bind("open/import", "enter"), bind("parent", "backspace"), bind("type a path", "/"), cancel},
+			{bind("home", "~"), bind(hidden, ".")},
+		}
+		if m.browseTyping {
+			keys = keyMap{{bind("open", "enter"), cancel}}
+		}
+	case ruleGroupView:
+		keys = keyMap{{bind("move to the end of the group", "enter"), cancel}}
+	case servicePickerView:
+		keys = keyMap{{navigate, bind("add to "+strings.TrimSpace(ruleFieldLabels[m.serviceField]), "enter"), bind("search", "/"), back}}
+		if m.serviceList.FilterState() == list.Filtering {
+			keys = keyMap{{bind("apply the search", "enter"), bind("cancel the search", "esc")}}
+		}
+	case ruleColumnsView:
+		keys = keyMap{{navigate, bind("show/hide", " "), bind("layout", "left", "right"), bind("save", "enter"), cancel}}
+	case pfConfPathView:
+		keys = keyMap{{bind("read the file", "enter"), cancel}}
+	case pfConfImportView:
+		recognized := m.pfConfImport != nil && len(m.pfConfImport.Recognized) > 0
+		keys = keyMap{{bindIf(recognized, "add to the configuration", "enter"), scroll, cancel}}
+	case importPreviewView:
+		ok := m.importPreview != nil && m.importPreview.Err == nil
+		keys = keyMap{{bindIf(ok, "replace the configuration", "enter"), scroll, back}}
+	case historyView:
+		keys = keyMap{
+			{navigate, bind("mark/unmark as base", " ", "m"), bind("roll back", "r"), back},
+			{bind("rules/configuration", "c"), bind("scroll diff", "pgup", "pgdown")},
+		}
+	case backupsView:
+		keys = keyMap{{navigate, bind("restore", "enter", "r"), back}}
+	case archiveView:
+		keys = keyMap{
+			{navigate, bind("restore", "enter", "r"), bind("details", "i"), back},
+			{bind("delete", "d"), bind("delete all", "D")},
+		}
+	}
+	return withKeybindings(m.firewallManager.Config.Settings.Keybindings, keys)
+}
+
+// formKeys returns the keys of a form: while a text field is edited only
+// Enter and Esc, or else the navigation, the keys of the focused field (of
+// option and text fields if the form cannot tell which it is), the save key
+// and the extra keys of the form.
+func (m *model) formKeys(editing, options, text bool, save string, extra ...key.Binding) keyMap {
+	if editing {
+		return keyMap{{bind("done editing", "enter"), bind("cancel", "esc")}}
+	}
+	keys := keyMap{{
+		bind("fields", "up", "down"),
+		bindIf(options, "change value", "left", "right"),
+		bindIf(text, "edit field", "enter"),
+		bind(save, "s"),
+		bind("cancel", "esc"),
+	}}
+	if len(extra) > 0 {
+		keys = append(keys, extra)
+	}
+	return keys
+}
+
+// helpKey opens the help overlay with all the keys of the view.
+var helpKey = bind("all keys", "?")
+
+// helpView renders the help line of the current view, indented: its main
+// keys, as many as fit the width, and "?" for all of them.
+func (m *model) helpView(indent string) string {
+	more := m.help.ShortHelpView([]key.Binding{helpKey})
+	h := m.help
+	if h.Width > 0 {
+		h.Width -= len(indent) + lipgloss.Width(h.ShortSeparator+more)
+	}
+	short := h.ShortHelpView(m.keyMap().ShortHelp())
+	if short == "" {
+		return indent + more
+	}
+	return indent + short + h.Styles.ShortSeparator.Render(h.ShortSeparator) + more
+}
+
+// helpOverlay renders the help overlay: all the keys of the current view in
+// columns, on top of it until a key is pressed.
+func (m *model) helpOverlay() string {
+	border := lipgloss.RoundedBorder()
+	if plainMode {
+		border = lipgloss.ASCIIBorder()
+	}
+	h := m.help
+	h.Width = m.width - 10
+	box := lipgloss.NewStyle().Border(border).Padding(1, 2).Render(lipgloss.JoinVertical(lipgloss.Left,
+		titleStyle.Render("Keys"),
+		"",
+		h.FullHelpView(m.keyMap().FullHelp()),
+		"",
+		disabledStyle.Render("Press any key to close"),
+	))
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, box)
+}
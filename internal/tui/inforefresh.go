@@ -0,0 +1,135 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultInfoRefreshSeconds is how often Show Info refreshes unless the
+// settings say otherwise.
+const defaultInfoRefreshSeconds = 1
+
+// statesRefreshInterval is how often Show States refreshes.
+const statesRefreshInterval = 2 * time.Second
+
+// infoRefreshSteps are the intervals, in seconds, that + and - step through
+// in Show Info.
+var infoRefreshSteps = []int{1, 2, 5, 10, 30, 60}
+
+// infoRefreshInterval returns how often Show Info refreshes: the interval
+// set with + and - in this session, else the one of the settings.
+func (m *model) infoRefreshInterval() time.Duration {
+	seconds := m.infoRefreshSeconds
+	if seconds <= 0 {
+		seconds = m.firewallManager.Config.Settings.InfoRefreshSeconds
+	}
+	if seconds <= 0 {
+		seconds = defaultInfoRefreshSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// stepInfoRefresh sets the interval of Show Info to the next longer
+// (direction > 0) or shorter step, for this session.
+func (m *model) stepInfoRefresh(direction int) {
+	current := int(m.infoRefreshInterval() / time.Second)
+	next := current
+	if direction > 0 {
+		for _, step := range infoRefreshSteps {
+			if step > current {
+				next = step
+				break
+			}
+		}
+	} else {
+		for i := len(infoRefreshSteps) - 1; i >= 0; i-- {
+			if infoRefreshSteps[i] < current {
+				next = infoRefreshSteps[i]
+				break
+			}
+		}
+	}
+	m.infoRefreshSeconds = next
+	m.notify(levelInfo, fmt.Sprintf("Refreshing every %ds.", next))
+}
+
+// scheduleInfoRefresh returns the timer of the next refresh of Show Info.
+func (m *model) scheduleInfoRefresh() tea.Cmd {
+	m.infoTimerRunning = true
+	return tea.Tick(m.infoRefreshInterval(), func(t time.Time) tea.Msg {
+		return infoRefreshMsg{}
+	})
+}
+
+// scheduleStatesRefresh returns the timer of the next refresh of Show States.
+func (m *model) scheduleStatesRefresh() tea.Cmd {
+	m.statesTimerRunning = true
+	return tea.Tick(statesRefreshInterval, func(t time.Time) tea.Msg {
+		return statesRefreshMsg{}
+	})
+}
+
+// resumeRefresh starts the refresh of Show Info or Show States when it is
+// opened, resumed or the terminal gets the focus back, unless a timer of it
+// is still running.
+func (m *model) resumeRefresh() tea.Cmd {
+	if m.currentView != infoView || m.refreshPaused {
+		return nil
+	}
+	switch {
+	case m.infoViewTitle == "Live PF Info" && !m.infoTimerRunning:
+		return func() tea.Msg { return infoRefreshMsg{} }
+	case m.infoViewTitle == "PF States" && !m.statesTimerRunning:
+		return func() tea.Msg { return statesRefreshMsg{} }
+	}
+	return nil
+}
+
+// refreshesLive reports whether the info view refreshes by itself, so that
+// space pauses it.
+func (m *model) refreshesLive() bool {
+	return m.infoViewTitle == "Live PF Info" || m.infoViewTitle == "PF States"
+}
+
+// togglePause pauses or resumes the refresh of Show Info or Show States.
+func (m *model) togglePause() tea.Cmd {
+	m.refreshPaused = !m.refreshPaused
+	return m.resumeRefresh()
+}
+
+// refreshNow reloads the info view once, paused or not, nil for the views
+// that have nothing to reload.
+func (m *model) refreshNow() tea.Cmd {
+	switch m.infoViewTitle {
+	case "Live PF Info":
+		return getPfInfo(m.runner)
+	case "PF States":
+		return getStates(m.runner)
+	case "Current Live PF Rules":
+		return getCurrentRules(m.runner)
+	case "pf-tui Anchor Rules":
+		return getAnchorRules(m.runner)
+	}
+	return nil
+}
+
+// refreshStatus describes the refresh of Show Info or Show States for its
+// title.
+func (m *model) refreshStatus() string {
+	interval := statesRefreshInterval
+	if m.infoViewTitle == "Live PF Info" {
+		if m.pfStatus != "Enabled" {
+			return "not refreshing, pf is disabled"
+		}
+		interval = m.infoRefreshInterval()
+	}
+	switch {
+	case m.refreshPaused:
+		return "paused, space to resume"
+	case m.unfocused:
+		return "paused while the terminal is unfocused"
+	}
+	return fmt.Sprintf("every %ds", int(interval/time.Second))
+}
@@ -0,0 +1,134 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// namedKeys are the keys other than single characters that keybindings can
+// stand for, by the name bubbletea gives them.
+var namedKeys = map[string]tea.KeyType{
+	"up":        tea.KeyUp,
+	"down":      tea.KeyDown,
+	"left":      tea.KeyLeft,
+	"right":     tea.KeyRight,
+	"enter":     tea.KeyEnter,
+	"esc":       tea.KeyEsc,
+	"tab":       tea.KeyTab,
+	"shift+tab": tea.KeyShiftTab,
+	"backspace": tea.KeyBackspace,
+	"pgup":      tea.KeyPgUp,
+	"pgdown":    tea.KeyPgDown,
+	" ":         tea.KeySpace,
+}
+
+// keyName returns the name of a key as bubbletea gives it, from its name in
+// the keybindings: "space" is " ", other names are the same.
+func keyName(name string) string {
+	if name == "space" {
+		return " "
+	}
+	return name
+}
+
+// keyMsgOf returns the key press of the key with the given name, or false if
+// keybindings cannot stand for it.
+func keyMsgOf(name string) (tea.KeyMsg, bool) {
+	if keyType, ok := namedKeys[name]; ok {
+		msg := tea.KeyMsg{Type: keyType}
+		if keyType == tea.KeySpace {
+			msg.Runes = []rune{' '}
+		}
+		return msg, true
+	}
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) && r > ' ' && r != utf8.RuneError {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}, true
+	}
+	return tea.KeyMsg{}, false
+}
+
+// CheckKeybindings returns the problems of the keybindings of the settings,
+// which map a key to the key it stands for, e.g. "ctrl+n" to "down", or to ""
+// to turn the key off.
+func CheckKeybindings(bindings map[string]string) []string {
+	var problems []string
+	for from, to := range bindings {
+		if from == "" || from == "ctrl+c" {
+			problems = append(problems, fmt.Sprintf("key %q cannot be rebound", from))
+		} else if _, ok := keyMsgOf(keyName(to)); to != "" && !ok {
+			problems = append(problems, fmt.Sprintf("%q cannot stand for %q: use a character or one of up, down, left, right, enter, esc, tab, shift+tab, backspace, pgup, pgdown, space", from, to))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// remapKey returns the key press the keybindings make of msg, and false if
+// the key is turned off. Keys bound to keys that cannot be pressed this way
+// are left as they are, see CheckKeybindings.
+func remapKey(bindings map[string]string, msg tea.KeyMsg) (tea.KeyMsg, bool) {
+	pressed := msg.String()
+	if pressed == " " {
+		pressed = "space"
+	}
+	to, ok := bindings[pressed]
+	if !ok && pressed == "space" {
+		to, ok = bindings[" "]
+	}
+	if !ok {
+		return msg, true
+	}
+	if to == "" {
+		return msg, false
+	}
+	if remapped, ok := keyMsgOf(keyName(to)); ok {
+		return remapped, true
+	}
+	return msg, true
+}
+
+// typing reports whether key presses go to a text input of the current view.
+// Keybindings do not apply there, so that every key can be typed.
+func (m *model) typing() bool {
+	switch m.currentView {
+	case ruleFormView:
+		return m.form.activeTextInput != -1
+	case portForwardingFormView:
+		return m.portForwardingForm.activeTextInput != -1
+	case natFormView:
+		return m.natForm.activeTextInput != -1
+	case tableFormView:
+		return m.tableForm.activeTextInput != -1
+	case macroFormView:
+		return m.macroForm.activeTextInput != -1
+	case scrubFormView:
+		return m.scrubForm.activeTextInput != -1
+	case pipeFormView:
+		return m.pipeForm.activeTextInput != -1
+	case optionsFormView:
+		return m.optionsForm.activeTextInput != -1
+	case timeoutsFormView:
+		return m.timeoutsForm.activeTextInput != -1
+	case sharingFormView:
+		return m.sharingForm.activeTextInput != -1
+	case settingsFormView:
+		return m.settingsForm.activeTextInput != -1
+	case tableEntriesView:
+		return m.tableEntryAdding
+	case pflogView:
+		return m.pflogFiltering
+	case infoView:
+		return m.search.editing
+	case importConfigView:
+		return m.browseTyping
+	case servicePickerView:
+		return m.serviceList.FilterState() == list.Filtering
+	case saveConfigView, pfConfPathView, ruleGroupView:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package tui
+
+// The views form a stack: a view opened from another is pushed on top of it,
+// and Esc, cancelling or finishing it pops it, which returns to the view it
+// was opened from. The main menu is at the bottom and never on the stack.
+
+// pushView opens v on top of the current view. If v is open already further
+// down, the views above it are closed instead, so that the stack has no
+// loops, e.g. from a rule form back to the rule list it was opened from.
+func (m *model) pushView(v view) {
+	if m.currentView == v {
+		return
+	}
+	if v == mainView || containsView(m.viewStack, v) {
+		m.backTo(v)
+		return
+	}
+	m.viewStack = append(m.viewStack, m.currentView)
+	m.currentView = v
+}
+
+// popView returns to the view the current one was opened from, the main
+// menu if none.
+func (m *model) popView() {
+	n := len(m.viewStack)
+	if n == 0 {
+		m.currentView = mainView
+		return
+	}
+	m.currentView = m.viewStack[n-1]
+	m.viewStack = m.viewStack[:n-1]
+}
+
+// parentView returns the view the current one was opened from, the main
+// menu if none.
+func (m *model) parentView() view {
+	if n := len(m.viewStack); n > 0 {
+		return m.viewStack[n-1]
+	}
+	return mainView
+}
+
+// backTo returns to v, closing the views opened from it. If v is not open,
+// it takes the place of the current views on top of the main menu, e.g. the
+// rule list after a rule added from the main menu is saved.
+func (m *model) backTo(v view) {
+	for m.currentView != v && len(m.viewStack) > 0 {
+		m.popView()
+	}
+	if m.currentView != v {
+		m.currentView = v
+		m.viewStack = nil
+		if v != mainView {
+			m.viewStack = []view{mainView}
+		}
+	}
+}
+
+// closeView returns to the view v was opened from, closing the views opened
+// from v too. It does nothing if v is not open, e.g. when a save finishes
+// after its form was left.
+func (m *model) closeView(v view) {
+	if m.currentView != v && !containsView(m.viewStack, v) {
+		return
+	}
+	for m.currentView != v {
+		m.popView()
+	}
+	m.popView()
+}
+
+func containsView(views []view, v view) bool {
+	for _, candidate := range views {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
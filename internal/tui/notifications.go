@@ -0,0 +1,150 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// notificationLevel is how important a notification is, which sets its
+// style and how long its toast is shown.
+type notificationLevel int
+
+const (
+	levelInfo notificationLevel = iota
+	levelWarn
+	levelError
+)
+
+func (l notificationLevel) String() string {
+	switch l {
+	case levelWarn:
+		return "warn"
+	case levelError:
+		return "error"
+	}
+	return "info"
+}
+
+// toastDurations are how long the toast of a notification is shown, by level.
+var toastDurations = map[notificationLevel]time.Duration{
+	levelInfo:  4 * time.Second,
+	levelWarn:  8 * time.Second,
+	levelError: 12 * time.Second,
+}
+
+var toastStyles = map[notificationLevel]lipgloss.Style{
+	levelInfo:  statusStyle,
+	levelWarn:  lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	levelError: fieldErrorStyle.Bold(true),
+}
+
+// maxNotifications is the number of notifications kept in the history.
+const maxNotifications = 200
+
+// notification is a message to the user, e.g. that a rule was saved or that
+// pfctl failed.
+type notification struct {
+	id    int
+	Level notificationLevel
+	Text  string
+	Time  time.Time
+}
+
+// notifications are the notifications of the session: the history, and the
+// toast shown at the bottom of the views until it expires.
+type notifications struct {
+	history   []notification
+	toast     *notification
+	progress  bool // the toast is a progress message, kept until replaced
+	nextID    int
+	scheduled int // the id of the last toast with an expiry timer
+}
+
+// toastExpiredMsg dismisses the toast with the id, unless another toast has
+// replaced it since.
+type toastExpiredMsg struct{ id int }
+
+// notify shows a toast and adds it to the history.
+func (m *model) notify(level notificationLevel, text string) {
+	n := &m.notifications
+	n.nextID++
+	toast := notification{id: n.nextID, Level: level, Text: text, Time: time.Now()}
+	n.history = append(n.history, toast)
+	if len(n.history) > maxNotifications {
+		n.history = n.history[len(n.history)-maxNotifications:]
+	}
+	n.toast = &toast
+	n.progress = false
+	if m.currentView == infoView && m.infoViewTitle == "Messages" {
+		m.viewport.SetContent(formatNotifications(n.history))
+	}
+}
+
+// notifyProgress shows a toast of something under way, e.g. "Loading...", or
+// of a question for the next key. It is not added to the history, and shown
+// until the next notification or clearToast.
+func (m *model) notifyProgress(text string) {
+	n := &m.notifications
+	n.nextID++
+	n.toast = &notification{id: n.nextID, Level: levelInfo, Text: text, Time: time.Now()}
+	n.progress = true
+}
+
+// clearToast dismisses the toast, e.g. when a view is opened.
+func (m *model) clearToast() {
+	m.notifications.toast = nil
+}
+
+// scheduleToast returns the expiry timer of a new toast, nil if there is
+// none or it is a progress message.
+func (n *notifications) scheduleToast() tea.Cmd {
+	if n.toast == nil || n.progress || n.toast.id == n.scheduled {
+		return nil
+	}
+	n.scheduled = n.toast.id
+	id := n.toast.id
+	return tea.Tick(toastDurations[n.toast.Level], func(time.Time) tea.Msg {
+		return toastExpiredMsg{id: id}
+	})
+}
+
+// toastView renders the status line: the operations under way, then the
+// toast in the style of its level; "" if there are neither. Plain mode shows
+// the level in words.
+func (m *model) toastView() string {
+	operations := m.operationsView()
+	toast := m.notifications.toast
+	if toast == nil {
+		return operations
+	}
+	text := toastStyles[toast.Level].Render(toast.Text)
+	if plainMode && toast.Level != levelInfo {
+		text = strings.ToUpper(toast.Level.String()) + ": " + toast.Text
+	}
+	if operations != "" {
+		return operations + "  " + text
+	}
+	return text
+}
+
+// formatNotifications renders the history for the info view, the latest
+// first.
+func formatNotifications(history []notification) string {
+	if len(history) == 0 {
+		return "No messages yet."
+	}
+	var b strings.Builder
+	for i := len(history) - 1; i >= 0; i-- {
+		n := history[i]
+		level := fmt.Sprintf("%-5s", strings.ToUpper(n.Level.String()))
+		if !plainMode {
+			level = toastStyles[n.Level].Render(level)
+		}
+		b.WriteString(fmt.Sprintf("%s  %s  %s\n", n.Time.Format("15:04:05"), level, n.Text))
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// operation is a pfctl operation that can take a while, e.g. Save & Apply
+// or reading the rules for Show Current Rules. While it runs, the status line
+// shows it with a spinner and the step it is at, and esc cancels its sudo
+// commands, e.g. a pfctl that hangs.
+type operation struct {
+	label      string // what it does, e.g. "Applying the rules"
+	step       string // what it is doing now, "" if it does not tell
+	started    time.Time
+	cancelling bool // esc was pressed, its sudo commands are being stopped
+	steps      chan string
+}
+
+// operationStartMsg starts an operation, see runOperation.
+type operationStartMsg struct {
+	label string
+	work  func(step func(string)) tea.Msg
+}
+
+// operationStepMsg is the step an operation got to.
+type operationStepMsg struct {
+	op   *operation
+	step string
+}
+
+// operationDoneMsg is the result of an operation, which is handled as if the
+// operation had not been one.
+type operationDoneMsg struct {
+	op  *operation
+	msg tea.Msg
+}
+
+func newOperationSpinner() spinner.Model {
+	s := spinner.New()
+	s.Spinner = spinner.MiniDot
+	if plainMode {
+		s.Spinner = spinner.Line
+	}
+	s.Style = statusStyle
+	return s
+}
+
+// runOperation returns a command that runs work as an operation with the
+// given label. work reports its steps with step, e.g. "loading the rules",
+// and returns its message like a command.
+func runOperation(label string, work func(step func(string)) tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return operationStartMsg{label: label, work: work}
+	}
+}
+
+// asOperation returns cmd run as an operation that does not report steps.
+func asOperation(label string, cmd tea.Cmd) tea.Cmd {
+	return runOperation(label, func(func(string)) tea.Msg { return cmd() })
+}
+
+// startOperation runs the operation of msg.
+func (m *model) startOperation(msg operationStartMsg) tea.Cmd {
+	op := &operation{label: msg.label, started: time.Now(), steps: make(chan string, 16)}
+	logging.Info(fmt.Sprintf("Operation started: %s", op.label))
+	m.operations = append(m.operations, op)
+	run := func() tea.Msg {
+		result := msg.work(func(step string) {
+			// Steps are only shown, the operation does not wait for them
+			select {
+			case op.steps <- step:
+			default:
+			}
+		})
+		close(op.steps)
+		return operationDoneMsg{op: op, msg: result}
+	}
+	cmds := []tea.Cmd{run, waitForStep(op)}
+	if len(m.operations) == 1 {
+		cmds = append(cmds, m.spinner.Tick)
+	}
+	return tea.Batch(cmds...)
+}
+
+// waitForStep waits for the next step of op.
+func waitForStep(op *operation) tea.Cmd {
+	return func() tea.Msg {
+		step, ok := <-op.steps
+		if !ok {
+			return nil
+		}
+		return operationStepMsg{op: op, step: step}
+	}
+}
+
+// finishOperation removes op from the operations under way.
+func (m *model) finishOperation(op *operation) {
+	for i, running := range m.operations {
+		if running == op {
+			m.operations = append(m.operations[:i], m.operations[i+1:]...)
+			break
+		}
+	}
+	logging.Info(fmt.Sprintf("Operation finished: %s, after %s", op.label, time.Since(op.started).Round(time.Millisecond)))
+}
+
+// cancelOperations stops the sudo commands of the operations under way, which
+// then finish with an error. It reports whether there were any that were not
+// being cancelled already, so that esc does what it usually does otherwise.
+func (m *model) cancelOperations() bool {
+	cancelled := false
+	for _, op := range m.operations {
+		if !op.cancelling {
+			op.cancelling = true
+			cancelled = true
+		}
+	}
+	if cancelled {
+		pfcli.CancelSudoCommands()
+	}
+	return cancelled
+}
+
+// operationsView renders the operations under way for the status line, ""
+// if there are none.
+func (m *model) operationsView() string {
+	var parts []string
+	for _, op := range m.operations {
+		text := op.label
+		if op.step != "" {
+			text += ": " + op.step
+		}
+		text += "..."
+		if elapsed := time.Since(op.started); elapsed >= time.Second {
+			text += fmt.Sprintf(" %ds", int(elapsed/time.Second))
+		}
+		if op.cancelling {
+			text += " (cancelling)"
+		} else {
+			text += " (esc cancel)"
+		}
+		parts = append(parts, m.spinner.View()+" "+statusStyle.Render(text))
+	}
+	return strings.Join(parts, "  ")
+}
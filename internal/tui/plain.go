@@ -0,0 +1,99 @@
+package tui
+
+import (
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/paginator"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// plainMode renders the TUI without colors or other styling and with ASCII
+// characters only, for screen readers, dumb terminals and logged output.
+// What styling showed, e.g. the selected item or the focused field, is shown
+// with characters instead. It is set by -plain and by NO_COLOR.
+var plainMode bool
+
+// SetupPlainMode turns on plain mode if plain is set, by -plain, or NO_COLOR
+// is set (see no-color.org), and sets up the rendering for it. It is called
+// before the model is created.
+func SetupPlainMode(plain bool) {
+	plainMode = plain || os.Getenv("NO_COLOR") != ""
+	if !plainMode {
+		return
+	}
+	lipgloss.SetColorProfile(termenv.Ascii)
+	sparkBlocks = []rune("_.-:=+*#")
+	fieldErrorMarker = "! "
+	logging.Info("Plain mode: no colors, ASCII only")
+}
+
+// fieldErrorMarker is in front of the validation errors of form fields.
+var fieldErrorMarker = "✗ "
+
+// selectionBorder returns the border to the left of the selected list item:
+// a line, or ">" in plain mode.
+func selectionBorder() lipgloss.Border {
+	if plainMode {
+		return lipgloss.Border{Left: ">"}
+	}
+	return lipgloss.NormalBorder()
+}
+
+// newListDelegate returns the default list delegate, with the selectionBorder.
+func newListDelegate() list.DefaultDelegate {
+	delegate := list.NewDefaultDelegate()
+	delegate.Styles.SelectedTitle = delegate.Styles.SelectedTitle.BorderStyle(selectionBorder())
+	delegate.Styles.SelectedDesc = delegate.Styles.SelectedDesc.BorderStyle(selectionBorder())
+	return delegate
+}
+
+// usePlainPagination shows the pages of the lists as "1/3" instead of dots.
+func usePlainPagination(lists ...*list.Model) {
+	for _, l := range lists {
+		l.Paginator.Type = paginator.Arabic
+	}
+}
+
+// renderFocused renders the label of the focused form field, "    Name:",
+// underlined, or in plain mode with "> " in its indent.
+func renderFocused(label string) string {
+	if plainMode {
+		return "  > " + strings.TrimPrefix(label, "    ")
+	}
+	return focusedStyle.Render(label)
+}
+
+// renderSelectedLine renders a line of a view with a cursor, e.g. the top
+// talkers: highlighted if it is selected, or in plain mode after "> " if it is
+// selected and "  " if not.
+func renderSelectedLine(line string, selected bool) string {
+	if plainMode {
+		if selected {
+			return "> " + line
+		}
+		return "  " + line
+	}
+	if selected {
+		return selectedItemStyle.Render(line)
+	}
+	return line
+}
+
+// groupMarker returns the marker of a rule group header: a triangle pointing
+// down or right, or "-" and "+" in plain mode.
+func groupMarker(collapsed bool) string {
+	switch {
+	case plainMode && collapsed:
+		return "+"
+	case plainMode:
+		return "-"
+	case collapsed:
+		return "▸"
+	}
+	return "▾"
+}
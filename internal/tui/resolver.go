@@ -0,0 +1,101 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// whoisTimeout bounds a whois query, which may be referred to several servers.
+const whoisTimeout = 15 * time.Second
+
+// reverseLookupTimeout bounds each PTR lookup, so that an unresponsive DNS
+// server does not leave addresses pending.
+const reverseLookupTimeout = 3 * time.Second
+
+// Resolver resolves addresses to host names with PTR lookups and caches the
+// results, including failed lookups, for as long as pf-tui runs.
+type Resolver struct {
+	mu      sync.Mutex
+	names   map[string]string // "" if the address has no name
+	pending map[string]bool
+}
+
+// NewResolver creates an empty Resolver.
+func NewResolver() *Resolver {
+	return &Resolver{
+		names:   make(map[string]string),
+		pending: make(map[string]bool),
+	}
+}
+
+// Name returns the cached host name of addr, or "" if it has none or has not
+// been resolved yet. A nil Resolver resolves nothing.
+func (r *Resolver) Name(addr string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.names[addr]
+}
+
+// Unresolved returns the addresses that are neither cached nor being
+// resolved, and marks them as being resolved. The caller must Resolve them.
+func (r *Resolver) Unresolved(addrs ...string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unresolved []string
+	for _, addr := range addrs {
+		if addr == "" || r.pending[addr] {
+			continue
+		}
+		if _, ok := r.names[addr]; ok {
+			continue
+		}
+		r.pending[addr] = true
+		unresolved = append(unresolved, addr)
+	}
+	return unresolved
+}
+
+// Resolve looks up the host name of addr and caches it.
+func (r *Resolver) Resolve(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), reverseLookupTimeout)
+	defer cancel()
+	name := ""
+	if net.ParseIP(addr) != nil {
+		if names, err := net.DefaultResolver.LookupAddr(ctx, addr); err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, addr)
+	r.names[addr] = name
+}
+
+// Whois runs the whois command for addr and returns its output.
+func Whois(addr string) (string, error) {
+	if net.ParseIP(addr) == nil {
+		return "", fmt.Errorf("invalid address %q", addr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), whoisTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "whois", addr).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("whois %s timed out after %s", addr, whoisTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("whois %s failed: %w, output: %s", addr, err, out)
+	}
+	logging.Info(fmt.Sprintf("Ran whois for %s", addr))
+	return string(out), nil
+}
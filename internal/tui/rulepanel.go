@@ -0,0 +1,166 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// ruleDetailPanelMinWidth is the terminal width from which the rule list
+// has room for the detail panel. Narrower terminals show the list only.
+const ruleDetailPanelMinWidth = 110
+
+// ruleDetailPanelShown reports whether the rule list is shown with the
+// detail panel on its right.
+func (m *model) ruleDetailPanelShown() bool {
+	return m.showRulePanel && m.width >= ruleDetailPanelMinWidth
+}
+
+// ruleListWidth returns the width of the rule list: the whole view, or a
+// bit more than half of it next to the detail panel.
+func (m *model) ruleListWidth() int {
+	h, _ := appStyle.GetFrameSize()
+	if m.ruleDetailPanelShown() {
+		return (m.width - h) * 11 / 20
+	}
+	return m.width - h
+}
+
+// sizeRuleList sizes the rule list for the terminal and the detail panel.
+func (m *model) sizeRuleList() {
+	_, v := appStyle.GetFrameSize()
+	m.ruleList.SetSize(m.ruleListWidth(), m.height-v-4)
+}
+
+// toggleRuleDetailPanel shows or hides the detail panel of the rule list.
+func (m *model) toggleRuleDetailPanel() {
+	m.showRulePanel = !m.showRulePanel
+	if m.showRulePanel && !m.ruleDetailPanelShown() {
+		m.notify(levelWarn, fmt.Sprintf("The detail panel needs a terminal at least %d columns wide.", ruleDetailPanelMinWidth))
+	}
+	m.sizeRuleList()
+}
+
+// ruleDetailPanel renders the detail panel of the selected item of the rule
+// list, cut to width and height: all the fields of a rule, the pf lines it
+// generates, its counters and why pfctl rejected it or it never matches.
+func (m *model) ruleDetailPanel(width, height int) string {
+	var b strings.Builder
+	switch selected := m.ruleList.SelectedItem().(type) {
+	case ruleListItem:
+		b.WriteString(titleStyle.Render(fmt.Sprintf("Rule %d", selected.index+1)) + "\n\n")
+		b.WriteString(m.formatRuleInspection(selected))
+	case ruleGroupListItem:
+		b.WriteString(titleStyle.Render("Group "+selected.name) + "\n\n")
+		enabled := 0
+		for _, rule := range m.firewallManager.Config.FirewallRules {
+			if rule.Group == selected.name && rule.Enabled {
+				enabled++
+			}
+		}
+		b.WriteString(formatRuleFields(
+			"Rules", strconv.Itoa(selected.size),
+			"Enabled", strconv.Itoa(enabled),
+			"Collapsed", map[bool]string{true: "yes", false: "no"}[selected.collapsed],
+		))
+	default:
+		b.WriteString(disabledStyle.Render("No rule selected."))
+	}
+
+	border := lipgloss.NormalBorder()
+	if plainMode {
+		border = lipgloss.Border{Left: "|"}
+	}
+	return lipgloss.NewStyle().
+		Border(border, false, false, false, true).
+		PaddingLeft(1).
+		MaxWidth(width).
+		MaxHeight(height).
+		Render(strings.TrimRight(b.String(), "\n"))
+}
+
+// formatRuleInspection formats what there is to know about a rule of the rule
+// list: why pfctl rejected it or it never matches, all its fields, the pf
+// lines it generates and its counters.
+func (m *model) formatRuleInspection(item ruleListItem) string {
+	var b strings.Builder
+	if item.err != "" {
+		b.WriteString(warningStyle.Render("pfctl: "+item.err) + "\n\n")
+	}
+	if item.shadow != "" {
+		b.WriteString(warningStyle.Render(item.shadow) + "\n\n")
+	}
+	b.WriteString(formatFirewallRuleDetails(item.rule))
+	b.WriteString("\npf.conf:\n")
+	filterRules, dummynetRules := m.firewallManager.GenerateFirewallRule(item.rule)
+	for _, line := range append(filterRules, dummynetRules...) {
+		b.WriteString("  " + line + "\n")
+	}
+	b.WriteString("\nCounters:\n")
+	if item.counters == nil {
+		b.WriteString("  " + disabledStyle.Render("none, the rule is not loaded in pf") + "\n")
+	} else {
+		counters := *item.counters
+		b.WriteString(formatRuleFields(
+			"Evaluations", strconv.FormatUint(counters.Evaluations, 10),
+			"Packets", strconv.FormatUint(counters.Packets, 10),
+			"Bytes", fmt.Sprintf("%d (%s)", counters.Bytes, formatCount(counters.Bytes)),
+			"States", strconv.FormatUint(counters.States, 10),
+		))
+	}
+	return b.String()
+}
+
+// openRuleInspector shows the selected rule of the rule list read-only and
+// full width: its formatRuleInspection, and where it is in the rule order,
+// its group and the profiles that have it.
+func (m *model) openRuleInspector(item ruleListItem) {
+	rules := m.firewallManager.Config.FirewallRules
+	var b strings.Builder
+	b.WriteString(m.formatRuleInspection(item))
+
+	b.WriteString("\nMembership:\n")
+	position := fmt.Sprintf("%d of %d rules", item.index+1, len(rules))
+	group := "none"
+	if item.rule.Group != "" {
+		inGroup, at := 0, 0
+		for i, rule := range rules {
+			if rule.Group == item.rule.Group {
+				inGroup++
+				if i == item.index {
+					at = inGroup
+				}
+			}
+		}
+		group = fmt.Sprintf("%s, rule %d of %d in the group", item.rule.Group, at, inGroup)
+	}
+	profiles := "none"
+	if found, err := m.firewallManager.ProfilesWithRule(item.rule); err != nil {
+		logging.Error(fmt.Sprintf("Failed to read the profiles: %v", err))
+		profiles = "unknown, the profiles could not be read"
+	} else if len(found) > 0 {
+		var names []string
+		for _, p := range found {
+			name := p.Profile.Name
+			switch {
+			case p.Profile.Current:
+				name += " (current)"
+			case p.Changed:
+				name += " (changed there)"
+			}
+			names = append(names, name)
+		}
+		profiles = strings.Join(names, ", ")
+	}
+	b.WriteString(formatRuleFields(
+		"Position", position,
+		"Group", group,
+		"Profiles", profiles,
+	))
+
+	m.openRuleDetails(fmt.Sprintf("Firewall Rule %d", item.index+1), b.String())
+}
@@ -0,0 +1,143 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// The TUI starts without waiting for sudo, pf or the rules file: the main
+// header shows "Checking..." until the checks of Init report, and the menu
+// waits for the configuration, which is loaded in the background too.
+
+// The prompts of promptSudo, at start and when the credentials expired.
+const (
+	sudoRequiredPrompt = "pf-tui: sudo credentials required. Password for %u: "
+	sudoExpiredPrompt  = "pf-tui: the sudo credentials expired. Password for %u: "
+)
+
+// sudoCheckedMsg reports whether sudo runs without a password at start.
+type sudoCheckedMsg struct{ err error }
+
+// startupConfigMsg is the configuration loaded at start, with the GeoIP
+// databases of its settings.
+type startupConfigMsg struct {
+	fm    *config.FirewallManager
+	geoip *GeoIP
+	err   error
+}
+
+// portForwardingItemsMsg is the port forwarding list of the loaded
+// configuration, built in the background.
+type portForwardingItemsMsg []list.Item
+
+// startupCheckDeferredMsg is a status check of the start that needs the sudo
+// password, which it is run again after.
+type startupCheckDeferredMsg struct{ check tea.Cmd }
+
+// checkSudoCredentials checks that runner runs without a password, so that
+// it is asked for once at start rather than by the first command that fails.
+func checkSudoCredentials(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		if err := pfcli.CheckCredentials(runner); err != nil {
+			logging.Warn(fmt.Sprintf("Sudo needs the password: %v", err))
+			return sudoCheckedMsg{err}
+		}
+		return sudoCheckedMsg{}
+	}
+}
+
+// loadStartupConfig loads the configuration into a new FirewallManager, which
+// replaces the empty one the model starts with.
+func loadStartupConfig() tea.Msg {
+	fm := config.NewFirewallManager()
+	if err := fm.LoadConfig(); err != nil {
+		logging.Warn(fmt.Sprintf("Error loading initial config: %v", err))
+		return startupConfigMsg{fm: fm, err: err}
+	}
+	geoip, err := OpenGeoIP(fm.Config.Settings.GeoIPCountryDB, fm.Config.Settings.GeoIPASNDB)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to open GeoIP databases: %v", err))
+	}
+	return startupConfigMsg{fm: fm, geoip: geoip}
+}
+
+// startupCheck returns check, a status check of the start, deferred while
+// sudo needs the password instead of failing with ErrSudoExpired.
+func startupCheck(check tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		msg := check()
+		if err, ok := msg.(errMsg); ok && errors.Is(err.err, pfcli.ErrSudoExpired) {
+			return startupCheckDeferredMsg{check}
+		}
+		return msg
+	}
+}
+
+// runDeferredChecks runs the status checks that waited for the sudo password.
+func (m *model) runDeferredChecks() tea.Cmd {
+	checks := m.deferredChecks
+	m.deferredChecks = nil
+	for i, check := range checks {
+		checks[i] = startupCheck(check)
+	}
+	return tea.Batch(checks...)
+}
+
+// handleStartupMsg handles the results of the checks and the loading of Init.
+func (m *model) handleStartupMsg(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case sudoCheckedMsg:
+		if msg.err != nil {
+			return m, m.promptSudo(sudoRequiredPrompt)
+		}
+
+	case startupCheckDeferredMsg:
+		m.deferredChecks = append(m.deferredChecks, msg.check)
+		return m, m.promptSudo(sudoRequiredPrompt)
+
+	case startupConfigMsg:
+		m.configLoading = false
+		m.firewallManager = msg.fm
+		m.geoip.Close()
+		m.geoip = msg.geoip
+		settings := msg.fm.Config.Settings
+		m.ruleList.SetDelegate(newRuleListDelegate(settings.RuleListLayout))
+		if msg.err != nil {
+			m.notify(levelError, fmt.Sprintf("Failed to load the configuration: %v", msg.err))
+		} else if len(msg.fm.UnknownFields) > 0 {
+			m.notify(levelWarn, fmt.Sprintf("The rules file has fields this version does not know, which the next save drops (a backup is kept): %s",
+				strings.Join(msg.fm.UnknownFields, ", ")))
+		} else if problems := CheckKeybindings(settings.Keybindings); len(problems) > 0 {
+			logging.Warn(fmt.Sprintf("Keybinding problems: %s", strings.Join(problems, "; ")))
+			m.notify(levelWarn, "Some keybindings do not work: "+strings.Join(problems, "; "))
+		}
+		logging.Info(fmt.Sprintf("Configuration loaded: %d firewall rules", len(msg.fm.Config.FirewallRules)))
+
+		rules := append([]config.PortForwardingRule(nil), msg.fm.Config.PortForwardingRules...)
+		var openStartView tea.Cmd
+		if m.startView != "" {
+			startView := m.startView
+			openStartView = func() tea.Msg { return openMenuItemMsg(startView) }
+		}
+		return m, tea.Batch(
+			m.restartAutoBan(settings),
+			m.updateRuleList(),
+			func() tea.Msg { return portForwardingItemsMsg(portForwardingListItems(rules)) },
+			func() tea.Msg { return feedTickMsg{} },
+			func() tea.Msg { return backupTickMsg{} },
+			openStartView,
+		)
+
+	case portForwardingItemsMsg:
+		m.portForwardingList.SetItems(msg)
+	}
+	return m, nil
+}
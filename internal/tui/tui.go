@@ -0,0 +1,8005 @@
+// Package tui is the terminal UI of pf-tui, a Bubble Tea model on top of
+// packages config and pfcli.
+package tui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// Styles
+var (
+	appStyle          = lipgloss.NewStyle().Padding(1, 2)
+	titleStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFDF5")).Background(lipgloss.Color("#25A065")).Padding(0, 1)
+	statusStyle       = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#04B575", Dark: "#04B575"})
+	selectedStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("212"))
+	focusedStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Underline(true)
+	selectedItemStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Bold(true)
+	disabledStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Faint(true)
+	warningStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFDF5")).Background(lipgloss.Color("#D9534F")).Padding(0, 1)
+	fieldErrorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+)
+
+// Views
+type view int
+
+const (
+	mainView view = iota
+	ruleListView
+	ruleFormView
+	portForwardingListView
+	portForwardingFormView
+	natListView
+	natFormView
+	tableListView
+	tableFormView
+	macroListView
+	macroFormView
+	scrubFormView
+	pipeListView
+	pipeFormView
+	optionsFormView
+	timeoutsFormView
+	sharingFormView
+	settingsFormView
+	pflogView
+	tableEntriesView
+	whoisView
+	ruleDetailView
+	applyPreviewView
+	rollbackView
+	unsavedChangesView
+	infoView
+	saveConfigView
+	importConfigView
+	backupsView
+	archiveView
+	historyView
+	pfConfPathView
+	pfConfImportView
+	importPreviewView
+	ruleGroupView
+	servicePickerView
+	ruleColumnsView
+	confirmationView
+)
+
+// Model
+type model struct {
+	list                list.Model
+	ruleList            list.Model
+	portForwardingList  list.Model
+	natList             list.Model
+	tableList           list.Model
+	tableEntryList      list.Model // entries of the loaded table in tableEntriesView
+	macroList           list.Model
+	pipeList            list.Model
+	fileList            list.Model
+	backupList          list.Model
+	archiveList         list.Model
+	historyList         list.Model
+	serviceList         list.Model // services servicePickerView offers, see PickableServices
+	viewport            viewport.Model
+	textinput           textinput.Model
+	confirmationMessage string
+	confirming          bool
+	confirmCmd          tea.Cmd // run when the confirmation is accepted, nil for the per-view actions
+	firewallManager     *config.FirewallManager
+	runner              pfcli.CommandRunner // runs the privileged commands of the pf functions
+	notifications       notifications
+	showRulePanel       bool // the rule list shows the detail panel of the selected rule
+	unfocused           bool // the terminal lost the focus, so Show Info and Show States don't refresh
+	refreshPaused       bool // space paused the refresh of Show Info or Show States
+	infoRefreshSeconds  int  // interval of Show Info set with + and -, 0 for the settings', see inforefresh.go
+	infoTimerRunning    bool // a timer of the refresh of Show Info is running
+	statesTimerRunning  bool // a timer of the refresh of Show States is running
+	pfStatus            string
+	startupStatus       string
+	currentView         view
+	viewStack           []view // views the current one was opened from, see nav.go
+	form                ruleForm
+	portForwardingForm  portForwardingForm
+	natForm             natForm
+	tableForm           tableForm
+	macroForm           macroForm
+	scrubForm           scrubForm
+	pipeForm            pipeForm
+	optionsForm         optionsForm
+	timeoutsForm        timeoutsForm
+	sharingForm         sharingForm
+	settingsForm        settingsForm
+	geoip               *GeoIP                // country/ASN annotation of addresses, nil if not configured
+	autoBan             *config.AutoBanner    // running auto-ban watcher, nil if disabled
+	sudoKeepAlive       *pfcli.SudoKeepAlive  // refreshes the sudo credentials, nil in test mode
+	configWatcher       *config.ConfigWatcher // reports changes of the rules file, nil if it cannot be watched
+	startView           string                // title of the menu item opened at start, "" for the main menu
+	testMode            bool                  // whois is not run, see Options
+	configLoading       bool                  // until loadStartupConfig reports, the menu waits
+	deferredChecks      []tea.Cmd             // status checks of the start waiting for the sudo password
+	rulesFileChanged    bool                  // the rules file changed on disk and has not been reloaded
+	sudoPrompting       bool                  // sudo is asking for the password in place of the TUI
+	exportFormat        string                // what the export view writes: one of exportFormats
+	resolver            *Resolver
+	resolveNames        bool               // show host names instead of addresses in the monitoring views
+	pflog               *pfcli.PflogStream // running tcpdump of the pflog view, nil if none
+	pflogLines          []string
+	pflogFollow         bool // keep the pflog view scrolled to the newest line
+	pflogPaused         bool
+	pflogFilterInput    textinput.Model
+	pflogFiltering      bool
+	pflogVisible        []string // pflog lines matching the filter
+	pflogCursor         int      // selected line in pflogVisible
+	pflogBlockAddr      string   // address picked with "b", waiting for the kind of block
+	tableEntriesName    string   // table shown in tableEntriesView
+	tableEntryInput     textinput.Model
+	tableEntryAdding    bool
+	ruleCounters        map[string]pfcli.RuleCounters // pf counters by rule label, from `pfctl -vsr`
+	stateUsage          pfcli.PfUsage                 // state table usage, for the warning in the main header
+	stats               []config.StatsSample          // recent activity samples, oldest first
+	topTalkers          []pfcli.HostTraffic           // shown in the top talkers view
+	feedStatus          map[string]config.FeedStatus  // blocklist feed updates by table name
+	feedsUpdating       map[string]bool               // tables whose feed is being downloaded
+	topTalkerCursor     int                           // selected host in the top talkers view
+	whoisTitle          string
+	detailTitle         string
+	applyPreviewReady   bool               // the diff of the apply preview has been loaded
+	sshSession          *config.SSHSession // SSH session pf-tui runs in, nil if local
+	interfaces          []string           // network interfaces of this host, for validating interface fields
+	unsavedNext         func() tea.Cmd     // what the unsaved changes view was opened for, run once they are dealt with
+	backupPreviewPath   string             // backup the cached backupPreview belongs to
+	backupPreview       string             // diff of the selected backup in the backups view
+	historyBase         time.Time          // snapshot marked to diff against in the history view, zero for the previous one
+	historyShowConfig   bool               // the history view diffs the configurations instead of the rules
+	historyDiffKey      string             // snapshots and mode the cached historyDiff belongs to
+	historyDiff         string
+	historyDiffOffset   int                      // first line of historyDiff shown
+	pfConfImport        *config.PfConfImport     // parsed pf.conf the import view previews
+	pfConfImportSource  string                   // file the import was parsed from
+	panicMode           bool                     // the pf-tui anchor passes all traffic, see PanicAllowAll
+	competing           []pfcli.CompetingRuleset // rules of other tools found in pf, see DetectCompetingRules
+	pfTuiLoaded         bool                     // the main ruleset evaluates the pf-tui rules
+	applied             *appliedAnchorMsg        // anchor file of the last apply, nil until read, see checkAppliedAnchor
+	lockoutWarnings     []string                 // lockout warnings of the apply preview
+	collapsedGroups     map[string]bool          // rule groups collapsed in the rule list
+	markedRules         map[string]bool          // IDs of the rules selected in the rule list for bulk actions
+	groupRuleIDs        map[string]bool          // rules ruleGroupView moves to a group
+	serviceField        int                      // port field of the rule form servicePickerView adds to
+	columnsDraft        []string                 // rule list columns shown in ruleColumnsView
+	layoutDraft         string                   // rule list layout chosen in ruleColumnsView
+	columnsCursor       int                      // index in ruleColumns of the column selected in ruleColumnsView
+	browseDir           string                   // directory shown in the import file browser
+	browseHidden        bool                     // show hidden files and directories in the file browser
+	browseTyping        bool                     // the path of the file browser is being typed
+	importPreview       *config.ConfigPreview    // file shown in importPreviewView
+	exportRuleIDs       map[string]bool          // rules the export view writes, nil for the whole configuration
+	exportMatches       []string                 // directories the export path completes to, when there are several
+	recentExports       []string                 // recent export directories, most recent first
+	recentExportIndex   int                      // recent export directory in the export path, -1 for a typed one
+	ruleErrors          map[string]string        // pfctl errors of the last Save & Apply by rule ID
+	rollback            *config.PendingRollback  // apply waiting for confirmation in the rollback view
+	operations          []*operation             // long pfctl operations under way, see operations.go
+	lastDeleted         []config.DeletedRule     // rules of the last delete from the rule lists, which u puts back
+	spinner             spinner.Model            // spinner of the operations in the status line
+	infoContent         string
+	search              viewportSearch // "/" search of infoContent, see viewsearch.go
+	infoViewTitle       string         // New field for dynamic title
+	showConfirm         bool
+	help                help.Model
+	width, height       int
+}
+
+// Messages
+type pfStatusMsg string
+type pfStartupStatusMsg string
+type pfInfoMsg string
+type currentRulesMsg string
+type firewallRuleSavedMsg string
+type portForwardingRuleSavedMsg string
+type natRuleSavedMsg string
+type ruleCountersMsg map[string]pfcli.RuleCounters
+type tableSavedMsg string
+type macroSavedMsg string
+type scrubSavedMsg string
+type pipeSavedMsg string
+type optionsSavedMsg string
+type timeoutsMsg []pfcli.PfTimeout
+type ipForwardingMsg bool
+type sharingSavedMsg string
+type backupTickMsg struct{}
+type scheduledBackupMsg struct {
+	dir string
+	err error
+}
+type settingsSavedMsg struct {
+	geoip    *GeoIP
+	settings config.Settings
+	status   string
+}
+type autoBanMsg struct {
+	banner *config.AutoBanner
+	event  config.AutoBanEvent
+}
+type autoBanClosedMsg struct {
+	banner *config.AutoBanner
+}
+type statesFlushedMsg string
+type pfReleasedMsg struct {
+	status  string
+	message string
+}
+type competingRulesMsg struct {
+	competing   []pfcli.CompetingRuleset
+	pfTuiLoaded bool
+}
+
+// appliedAnchorMsg is the anchor file of the last apply, see
+// checkAppliedAnchor.
+type appliedAnchorMsg struct {
+	time    time.Time // zero if the rules were never applied
+	content string
+}
+type panicModeMsg struct {
+	active bool
+	status string
+}
+type pflogLineMsg struct {
+	stream *pfcli.PflogStream
+	line   string
+}
+type pflogClosedMsg struct{ stream *pfcli.PflogStream }
+type quickBlockedMsg string
+type tableEntriesMsg []string
+type tableEntriesChangedMsg string
+type pfUsageMsg []pfcli.PfUsage
+type topTalkersMsg []pfcli.HostTraffic
+type topTalkersRefreshMsg struct{}
+type statesMsg []pfcli.PfState
+type statesRefreshMsg struct{}
+type hostnameResolvedMsg struct{}
+type feedTickMsg struct{}
+type feedUpdatedMsg config.FeedStatus
+type whoisMsg struct {
+	addr   string
+	result string
+}
+type statsMsg []config.StatsSample
+type statsTickMsg struct{}
+type usageTickMsg struct{}
+type quickBlockAppliedMsg string
+type configLoadedMsg string
+type configSavedAndBackToMainMsg string
+type rulesAppliedMsg string
+type rulesRejectedMsg []config.RuleError
+type rollbackPendingMsg struct {
+	rollback *config.PendingRollback
+	status   string
+}
+type rollbackTickMsg struct{}
+type rollbackDoneMsg string
+type unsavedResolvedMsg string
+type applyPreviewMsg struct {
+	diff    string
+	lockout []string // LockoutWarnings of the rules to apply
+}
+type configExportedMsg string
+type copiedMsg string
+type pastedMsg string
+type ruleColumnsSavedMsg struct{}
+type importPreviewMsg config.ConfigPreview
+type fileListMsg struct {
+	dir   string
+	items []list.Item
+}
+type backupListMsg []list.Item
+type archiveChangedMsg string
+type historyListMsg []list.Item
+type snapshotRestoredMsg string
+type pfConfParsedMsg struct {
+	source string
+	imp    config.PfConfImport
+}
+type errMsg struct{ err error }
+type sudoExpiredMsg struct{}
+type configChangedMsg struct{}
+type reloadConfigMsg struct{}
+type sudoRenewedMsg struct{ err error }
+type infoRefreshMsg struct{}
+type openMenuItemMsg string
+
+func (e errMsg) Error() string { return e.err.Error() }
+
+// Helper function to render options
+func renderOptions(label string, options []string, selected string, isFocused bool) string {
+	var parts []string
+	for _, opt := range options {
+		if opt == selected && plainMode {
+			parts = append(parts, fmt.Sprintf("[%s]", opt))
+		} else if opt == selected {
+			style := selectedStyle
+			if isFocused {
+				style = focusedStyle
+			}
+			parts = append(parts, style.Render(fmt.Sprintf(" %s ", opt)))
+		} else {
+			parts = append(parts, fmt.Sprintf(" %s ", opt))
+		}
+	}
+	labelPart := fmt.Sprintf("    %-16s:", label)
+	if isFocused {
+		labelPart = renderFocused(labelPart)
+	}
+	return fmt.Sprintf("%s %s\n", labelPart, strings.Join(parts, " "))
+}
+
+// Helper function to render text input
+func renderInput(label string, input textinput.Model, isFocused bool, activeTextInputIndex int, currentFieldIndex int, fieldLabel string) string {
+	if isFocused && activeTextInputIndex == currentFieldIndex {
+		input.Focus()
+	} else {
+		input.Blur()
+	}
+	labelPart := fmt.Sprintf("    %-16s:", label)
+	if isFocused {
+		labelPart = renderFocused(labelPart)
+	}
+
+	hint := ""
+	if isFocused && activeTextInputIndex == -1 { // Only show hint if focused and not actively editing
+		if (fieldLabel == "Source" || fieldLabel == "Destination") && input.Value() == "any" {
+			hint = "  <-- Press Enter to specify, Left/Right for self or an interface address"
+		} else if (fieldLabel == "Interface" || fieldLabel == "Source Port" || fieldLabel == "Destination Port") && input.Value() == "any" {
+			hint = "  <-- Press Enter to specify"
+		} else if fieldLabel == "Description" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify"
+		} else if fieldLabel == "External Port" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify"
+		} else if fieldLabel == "Internal Port" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify"
+		} else if fieldLabel == "External IP" && input.Value() == "any" {
+			hint = "  <-- Press Enter to specify"
+		} else if fieldLabel == "Internal IP" && input.Value() == "127.0.0.1" {
+			hint = "  <-- Press Enter to specify"
+		} else if (fieldLabel == "Max States" || fieldLabel == "Max Src Conn") && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (default: unlimited)"
+		} else if fieldLabel == "Max Conn Rate" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (e.g. 15/5 = 15 connections per 5 seconds)"
+		} else if fieldLabel == "Overload Table" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (table name)"
+		} else if fieldLabel == "Route Interface" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (e.g. en1)"
+		} else if fieldLabel == "Route Gateway" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (optional next hop, e.g. 192.168.2.1)"
+		} else if fieldLabel == "Skip Interfaces" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (e.g. lo0, utun*)"
+		} else if (fieldLabel == "State Limit" || fieldLabel == "Table Entries") && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (default: pf's default)"
+		} else if fieldLabel == "Pipe" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (optional pipe number for traffic shaping)"
+		} else if fieldLabel == "Probability" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (optional percentage of packets, e.g. 20)"
+		} else if fieldLabel == "Bandwidth" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (e.g. 10Mbit/s, default: unlimited)"
+		} else if fieldLabel == "Group" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (optional, e.g. LAN)"
+		} else if fieldLabel == "Addresses" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (comma-separated)"
+		} else if fieldLabel == "Translation" && input.Value() == "" {
+			hint = "  <-- Press Enter to specify (default: interface address)"
+		}
+	}
+
+	return fmt.Sprintf("%s  %s%s\n", labelPart, input.View(), hint)
+}
+
+// renderFieldError renders the validation error of a text input below it, or
+// nothing if it is valid. Empty inputs are only reported on save.
+func renderFieldError(input textinput.Model, err error) string {
+	if err == nil || input.Value() == "" {
+		return ""
+	}
+	return fmt.Sprintf("    %-16s   %s\n", "", fieldErrorStyle.Render(fieldErrorMarker+err.Error()))
+}
+
+// Commands
+
+func checkPfStatus(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		status, err := pfcli.GetPfStatus(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return pfStatusMsg(status)
+	}
+}
+
+func checkPfUsage(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		usages, err := pfcli.GetPfUsage(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return pfUsageMsg(usages)
+	}
+}
+
+func checkCompetingRules(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		competing, pfTuiLoaded, err := pfcli.DetectCompetingRules(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return competingRulesMsg{competing, pfTuiLoaded}
+	}
+}
+
+// checkAppliedAnchor reads the anchor file of the last apply for the main
+// header, unless it has not been written since the time known. Errors are
+// only logged, the check runs in the background.
+func checkAppliedAnchor(runner pfcli.CommandRunner, known *appliedAnchorMsg) tea.Cmd {
+	return func() tea.Msg {
+		modTime, err := pfcli.AppliedAnchorTime(runner)
+		if err != nil {
+			logging.Error(fmt.Sprintf("Failed to check the applied rules: %v", err))
+			return nil
+		}
+		if known != nil && modTime.Equal(known.time) {
+			return nil
+		}
+		content, err := pfcli.GetAppliedAnchor(runner)
+		if err != nil {
+			logging.Error(fmt.Sprintf("Failed to read the applied rules: %v", err))
+			return nil
+		}
+		return appliedAnchorMsg{time: modTime, content: content}
+	}
+}
+
+func checkPfStartupStatus(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		status, err := pfcli.CheckPfStartupStatus(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return pfStartupStatusMsg(status)
+	}
+}
+
+func getPfInfo(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		info, err := pfcli.GetPfInfo(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return pfInfoMsg(info)
+	}
+}
+
+func getCurrentRules(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		rules, err := pfcli.GetCurrentRules(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return currentRulesMsg(rules)
+	}
+}
+
+func loadStats() tea.Msg {
+	samples, err := config.LoadStats()
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to load statistics history: %v", err))
+	}
+	return statsMsg(samples)
+}
+
+func getTopTalkers(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		talkers, err := pfcli.GetTopTalkers(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return topTalkersMsg(talkers)
+	}
+}
+
+func getStates(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		states, err := pfcli.GetStates(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return statesMsg(states)
+	}
+}
+
+func getAnchorRules(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		rules, err := pfcli.GetAnchorRules(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return currentRulesMsg(rules)
+	}
+}
+
+func getTimeouts(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		timeouts, err := pfcli.GetTimeouts(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return timeoutsMsg(timeouts)
+	}
+}
+
+func getIPForwarding(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		enabled, err := pfcli.GetIPForwarding(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return ipForwardingMsg(enabled)
+	}
+}
+
+func getRuleCounters(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		counters, err := pfcli.GetRuleCounters(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return ruleCountersMsg(counters)
+	}
+}
+
+func enablePf(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		tokensPath, err := config.PfTokensPath()
+		if err != nil {
+			return errMsg{err}
+		}
+		if _, err := pfcli.EnablePf(runner, tokensPath); err != nil {
+			return errMsg{err}
+		}
+		return checkPfStatus(runner)()
+	}
+}
+
+func flushStates(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		if output, err := pfcli.FlushStates(runner); err != nil {
+			return errMsg{fmt.Errorf("failed to flush states: %w, output: %s", err, output)}
+		}
+		return statesFlushedMsg("All states flushed. Existing connections now have to pass the current rules.")
+	}
+}
+
+func panicAllowAll(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		if output, err := pfcli.PanicAllowAll(runner); err != nil {
+			return errMsg{fmt.Errorf("failed to load the pass-all rule: %w, output: %s", err, output)}
+		}
+		return panicModeMsg{active: true, status: "Panic mode: the pf-tui rules are unloaded and all traffic is passed. Save & Apply the configuration to restore them."}
+	}
+}
+
+func checkPanicMode(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		active, err := pfcli.PanicActive(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return panicModeMsg{active: active}
+	}
+}
+
+// waitForPflog returns the next line of the pflog stream.
+func waitForPflog(stream *pfcli.PflogStream) tea.Cmd {
+	return func() tea.Msg {
+		line, ok := <-stream.Lines
+		if !ok {
+			return pflogClosedMsg{stream}
+		}
+		return pflogLineMsg{stream, line}
+	}
+}
+
+// waitForAutoBan waits for the next ban of the auto-ban watcher b, if any.
+func waitForAutoBan(b *config.AutoBanner) tea.Cmd {
+	if b == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		event, ok := <-b.Events
+		if !ok {
+			return autoBanClosedMsg{b}
+		}
+		return autoBanMsg{b, event}
+	}
+}
+
+// waitForSudoExpired waits until the keep-alive fails to refresh the sudo
+// credentials.
+func waitForSudoExpired(k *pfcli.SudoKeepAlive) tea.Cmd {
+	if k == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		<-k.Expired
+		return sudoExpiredMsg{}
+	}
+}
+
+// waitForConfigChange waits until the rules file changes on disk.
+func waitForConfigChange(w *config.ConfigWatcher) tea.Cmd {
+	if w == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		if _, ok := <-w.Changed; !ok {
+			return nil
+		}
+		return configChangedMsg{}
+	}
+}
+
+// offerReload asks whether to reload the rules file that changed on disk.
+func (m *model) offerReload() {
+	m.pushView(confirmationView)
+	m.confirming = true
+	m.confirmCmd = func() tea.Msg { return reloadConfigMsg{} }
+	m.confirmationMessage = "The rules file changed on disk, e.g. in an editor or synced from another machine. Reload it?"
+}
+
+// reloadConfig loads the rules file again after it changed on disk.
+func reloadConfig(fm *config.FirewallManager) tea.Cmd {
+	return func() tea.Msg {
+		if err := fm.LoadConfig(); err != nil {
+			return errMsg{err}
+		}
+		return configLoadedMsg("Configuration reloaded from disk.")
+	}
+}
+
+// promptSudo suspends the TUI while sudo asks for the password in the
+// terminal, unless it already does or the runner never asks, e.g. in test
+// mode.
+func (m *model) promptSudo(prompt string) tea.Cmd {
+	auth, ok := m.runner.(pfcli.Authenticator)
+	if m.sudoPrompting || !ok {
+		return nil
+	}
+	m.sudoPrompting = true
+	return tea.ExecProcess(auth.AuthCommand(prompt), func(err error) tea.Msg {
+		return sudoRenewedMsg{err}
+	})
+}
+
+func getTableEntries(runner pfcli.CommandRunner, name string) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := pfcli.GetTableEntries(runner, name)
+		if err != nil {
+			return errMsg{err}
+		}
+		return tableEntriesMsg(entries)
+	}
+}
+
+// modifyTable runs a pfctl table command on the loaded table and reports status.
+func modifyTable(runner pfcli.CommandRunner, name, command, status string, addresses ...string) tea.Cmd {
+	return func() tea.Msg {
+		if _, err := pfcli.ModifyTable(runner, name, command, addresses...); err != nil {
+			return errMsg{err}
+		}
+		return tableEntriesChangedMsg(status)
+	}
+}
+
+func disablePf(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		tokensPath, err := config.PfTokensPath()
+		if err != nil {
+			return errMsg{err}
+		}
+		if _, err := pfcli.DisablePf(runner, tokensPath); err != nil {
+			return errMsg{err}
+		}
+		status, err := pfcli.GetPfStatus(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		if status == "Enabled" {
+			return pfReleasedMsg{status: status, message: "Released pf-tui's reference on pf. pf stays enabled while other services (e.g. AirDrop, Internet Sharing) use it."}
+		}
+		return pfStatusMsg(status)
+	}
+}
+
+func enablePfOnStartup(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		_, err := pfcli.EnablePfOnStartup(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return checkPfStartupStatus(runner)()
+	}
+}
+
+func disablePfOnStartup(runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		_, err := pfcli.DisablePfOnStartup(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return checkPfStartupStatus(runner)()
+	}
+}
+
+func saveConfigAs(fm *config.FirewallManager, path string, format string) tea.Cmd {
+	return func() tea.Msg {
+		if format == "pf.conf" {
+			if err := fm.ExportPfConf(path); err != nil {
+				return errMsg{err}
+			}
+			recordExport(path)
+			return configExportedMsg(fmt.Sprintf("pf.conf exported to %s", path))
+		}
+		if err := fm.SaveConfigAs(path, config.ConfigFormat(format)); err != nil {
+			return errMsg{err}
+		}
+		recordExport(path)
+		return configExportedMsg(fmt.Sprintf("Configuration exported to %s", path))
+	}
+}
+
+// exportConfig writes the configuration, or the rules selected for the export
+// view, to path in the export format.
+func (m *model) exportConfig(path string) tea.Cmd {
+	if m.exportRuleIDs == nil {
+		return saveConfigAs(m.firewallManager, path, m.exportFormat)
+	}
+	fm, format, ids := m.firewallManager, m.exportFormat, m.exportRuleIDs
+	return func() tea.Msg {
+		if err := fm.ExportSelectedRules(path, format, ids); err != nil {
+			return errMsg{err}
+		}
+		recordExport(path)
+		rules := "rules"
+		if len(ids) == 1 {
+			rules = "rule"
+		}
+		return configExportedMsg(fmt.Sprintf("%d selected %s exported to %s", len(ids), rules, path))
+	}
+}
+
+// recordExport adds the destination of an export to the recent ones the
+// export view offers.
+func recordExport(path string) {
+	if err := config.RecordExport(path); err != nil {
+		logging.Warn(fmt.Sprintf("Failed to record the export destination: %v", err))
+	}
+}
+
+// openExport opens the export view with a file name in the directory of the
+// last export, or the config directory, for the whole configuration or only
+// the rules with the given IDs.
+func (m *model) openExport(filename string, ids map[string]bool) {
+	m.pushView(saveConfigView)
+	m.exportFormat = string(config.FormatJSON)
+	m.exportRuleIDs = ids
+	m.exportMatches = nil
+	m.recentExportIndex = -1
+	recent, err := config.LoadRecentExports()
+	if err != nil {
+		logging.Warn(fmt.Sprintf("Failed to load the recent export destinations: %v", err))
+	}
+	m.recentExports = recent
+	dir, _ := config.GetConfigPath()
+	if len(recent) > 0 {
+		dir = recent[0]
+		m.recentExportIndex = 0
+	}
+	m.textinput.SetValue(filepath.Join(dir, filename))
+	m.textinput.Focus()
+	m.textinput.CursorEnd()
+}
+
+// parsePfConfFile reads a pf.conf or anchor file, with sudo if it is not
+// readable, and parses it for the import view.
+func parsePfConfFile(runner pfcli.CommandRunner, path string) tea.Cmd {
+	return func() tea.Msg {
+		data, err := os.ReadFile(path)
+		if os.IsPermission(err) {
+			var out string
+			out, err = pfcli.RunSudoCmd(runner, "cat", path)
+			data = []byte(out)
+		}
+		if err != nil {
+			logging.Error(fmt.Sprintf("Failed to read %s: %v", path, err))
+			return errMsg{err}
+		}
+		imp := config.ParsePfConf(string(data))
+		logging.Info(fmt.Sprintf("Parsed %s: %d statements recognized, %d skipped", path, len(imp.Recognized), len(imp.Skipped)))
+		return pfConfParsedMsg{path, imp}
+	}
+}
+
+func importLiveRules(fm *config.FirewallManager, runner pfcli.CommandRunner) tea.Cmd {
+	return func() tea.Msg {
+		imp, err := fm.ImportLiveRules(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return pfConfParsedMsg{"Live Rules", imp}
+	}
+}
+
+func mergeImport(fm *config.FirewallManager, imp config.PfConfImport) tea.Cmd {
+	return func() tea.Msg {
+		summary, err := fm.MergeImport(imp)
+		if err != nil {
+			return errMsg{err}
+		}
+		return configLoadedMsg(summary)
+	}
+}
+
+func importConfig(fm *config.FirewallManager, path string) tea.Cmd {
+	return func() tea.Msg {
+		logging.Info(fmt.Sprintf("Importing config from: %s", path))
+		if err := fm.ImportConfigFile(path); err != nil {
+			logging.Error(fmt.Sprintf("Error loading config: %v", err))
+			return errMsg{err}
+		}
+		logging.Info("Config imported successfully")
+		return configLoadedMsg("Configuration imported successfully.")
+	}
+}
+
+func saveAndApplyRules(fm *config.FirewallManager, runner pfcli.CommandRunner) tea.Cmd {
+	return runOperation("Applying the rules", func(step func(string)) tea.Msg {
+		return saveAndApply(fm, runner, step)
+	})
+}
+
+// saveAndApply runs fm.SaveAndApply and returns its outcome as a message.
+func saveAndApply(fm *config.FirewallManager, runner pfcli.CommandRunner, step func(string)) tea.Msg {
+	result, err := fm.SaveAndApply(runner, step)
+	if err != nil {
+		return errMsg{err}
+	}
+	if len(result.Rejected) > 0 {
+		return rulesRejectedMsg(result.Rejected)
+	}
+	if result.Rollback != nil {
+		return rollbackPendingMsg{rollback: result.Rollback, status: result.Status}
+	}
+	return rulesAppliedMsg(result.Status)
+}
+
+// getApplyPreview diffs the anchor file of the last apply against the rules
+// Save & Apply would write now, and checks them for a lockout of session.
+func getApplyPreview(fm *config.FirewallManager, runner pfcli.CommandRunner, session *config.SSHSession) tea.Cmd {
+	return func() tea.Msg {
+		applied, err := pfcli.GetAppliedAnchor(runner)
+		if err != nil {
+			return errMsg{err}
+		}
+		return applyPreviewMsg{
+			diff:    UnifiedDiff("/etc/pf.anchors/pf-tui (applied)", "pf-tui (to apply)", applied, fm.GeneratePfConf(), 3),
+			lockout: fm.LockoutWarnings(session),
+		}
+	}
+}
+
+// item represents a list item.
+type item struct {
+	title, desc string
+}
+
+func (i item) Title() string       { return i.title }
+func (i item) Description() string { return i.desc }
+func (i item) FilterValue() string { return i.title }
+
+// Rule form fields, in display order.
+const (
+	ruleFieldAction = iota
+	ruleFieldDirection
+	ruleFieldQuick
+	ruleFieldLog
+	ruleFieldInterface
+	ruleFieldRoute
+	ruleFieldRouteInterface
+	ruleFieldRouteGateway
+	ruleFieldProtocol
+	ruleFieldIcmpType
+	ruleFieldIcmpCode
+	ruleFieldSource
+	ruleFieldSourceNot
+	ruleFieldSourcePort
+	ruleFieldDestination
+	ruleFieldDestinationNot
+	ruleFieldDestinationPort
+	ruleFieldState
+	ruleFieldStateMax
+	ruleFieldSourceTrack
+	ruleFieldMaxSrcConn
+	ruleFieldMaxSrcConnRate
+	ruleFieldOverloadTable
+	ruleFieldOverloadFlush
+	ruleFieldPipe
+	ruleFieldProbability
+	ruleFieldGroup
+	ruleFieldDescription
+	ruleFieldCount
+)
+
+var ruleFieldLabels = [ruleFieldCount]string{
+	ruleFieldAction:          "Action",
+	ruleFieldDirection:       "Direction",
+	ruleFieldQuick:           "Quick",
+	ruleFieldLog:             "Log",
+	ruleFieldInterface:       "Interface",
+	ruleFieldRoute:           "Route",
+	ruleFieldRouteInterface:  "Route Interface",
+	ruleFieldRouteGateway:    "Route Gateway",
+	ruleFieldProtocol:        "Protocol",
+	ruleFieldIcmpType:        "ICMP Type",
+	ruleFieldIcmpCode:        "ICMP Code",
+	ruleFieldSource:          "Source",
+	ruleFieldSourceNot:       "Negate Source",
+	ruleFieldSourcePort:      "Source Port",
+	ruleFieldDestination:     "Destination",
+	ruleFieldDestinationNot:  "Negate Dest",
+	ruleFieldDestinationPort: "Destination Port",
+	ruleFieldState:           "State",
+	ruleFieldStateMax:        "Max States",
+	ruleFieldSourceTrack:     "Source Track",
+	ruleFieldMaxSrcConn:      "Max Src Conn",
+	ruleFieldMaxSrcConnRate:  "Max Conn Rate",
+	ruleFieldOverloadTable:   "Overload Table",
+	ruleFieldOverloadFlush:   "Overload Flush",
+	ruleFieldPipe:            "Pipe",
+	ruleFieldProbability:     "Probability",
+	ruleFieldGroup:           "Group",
+	ruleFieldDescription:     "Description",
+}
+
+// ruleForm represents the form for adding/editing a rule.
+
+type ruleForm struct {
+	focused              int // one of the ruleField* constants
+	activeTextInput      int // -1 if no text input is active, otherwise the ruleField* constant of the active text input
+	isNew                bool
+	ruleIndex            int
+	enabled              bool // kept as-is when editing, toggled from the rule list
+	action               string
+	direction            string
+	quick                string
+	sourceNot            string
+	destinationNot       string
+	log                  string
+	protocol             string
+	icmpType             string
+	icmpCode             string
+	state                string
+	sourceTrack          string
+	stateMaxInput        textinput.Model
+	overloadFlush        string
+	maxSrcConnInput      textinput.Model
+	maxSrcConnRateInput  textinput.Model
+	overloadTableInput   textinput.Model
+	interfaceInput       textinput.Model
+	route                string
+	routeInterfaceInput  textinput.Model
+	routeGatewayInput    textinput.Model
+	sourceInput          textinput.Model
+	destinationInput     textinput.Model
+	sourcePortInput      textinput.Model
+	destinationPortInput textinput.Model
+	pipeInput            textinput.Model
+	probabilityInput     textinput.Model
+	groupInput           textinput.Model
+	descriptionInput     textinput.Model
+	addressSuggestions   []string // picked with left/right on the Source and Destination fields
+}
+
+// addressSuggestions returns the addresses offered on the Source and
+// Destination fields: "any", "self" and the address and network of each
+// interface that is up, in parentheses so pf follows DHCP address changes.
+func addressSuggestions() []string {
+	suggestions := []string{"any", "self"}
+	for _, name := range shareableInterfaces() {
+		suggestions = append(suggestions, fmt.Sprintf("(%s)", name), fmt.Sprintf("(%s:network)", name))
+	}
+	return suggestions
+}
+
+func newRuleForm() ruleForm {
+	interfaceInput := textinput.New()
+	interfaceInput.SetValue("any")
+	interfaceInput.Prompt = ""
+	interfaceInput.Blur()
+	routeInterfaceInput := textinput.New()
+	routeInterfaceInput.Prompt = ""
+	routeInterfaceInput.Blur()
+	routeGatewayInput := textinput.New()
+	routeGatewayInput.Prompt = ""
+	routeGatewayInput.Blur()
+	sourceInput := textinput.New()
+	sourceInput.SetValue("any")
+	sourceInput.Prompt = ""
+	sourceInput.Blur()
+	destinationInput := textinput.New()
+	destinationInput.SetValue("any")
+	destinationInput.Prompt = ""
+	destinationInput.Blur()
+	sourcePortInput := textinput.New()
+	sourcePortInput.SetValue("any")
+	sourcePortInput.Prompt = ""
+	sourcePortInput.Blur()
+	destinationPortInput := textinput.New()
+	destinationPortInput.SetValue("any")
+	destinationPortInput.Prompt = ""
+	destinationPortInput.Blur()
+	stateMaxInput := textinput.New()
+	stateMaxInput.Prompt = ""
+	stateMaxInput.Blur()
+	maxSrcConnInput := textinput.New()
+	maxSrcConnInput.Prompt = ""
+	maxSrcConnInput.Blur()
+	maxSrcConnRateInput := textinput.New()
+	maxSrcConnRateInput.Prompt = ""
+	maxSrcConnRateInput.Blur()
+	overloadTableInput := textinput.New()
+	overloadTableInput.Prompt = ""
+	overloadTableInput.Blur()
+	pipeInput := textinput.New()
+	pipeInput.Prompt = ""
+	pipeInput.Blur()
+	probabilityInput := textinput.New()
+	probabilityInput.Prompt = ""
+	probabilityInput.Blur()
+	groupInput := textinput.New()
+	groupInput.Prompt = ""
+	groupInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+
+	return ruleForm{
+		focused:              0,
+		activeTextInput:      -1,
+		addressSuggestions:   addressSuggestions(),
+		enabled:              true,
+		action:               "block",
+		direction:            "in",
+		quick:                "No",
+		log:                  "none",
+		protocol:             "any",
+		icmpType:             "any",
+		icmpCode:             "any",
+		state:                "default",
+		sourceTrack:          "none",
+		stateMaxInput:        stateMaxInput,
+		overloadFlush:        "none",
+		maxSrcConnInput:      maxSrcConnInput,
+		maxSrcConnRateInput:  maxSrcConnRateInput,
+		overloadTableInput:   overloadTableInput,
+		interfaceInput:       interfaceInput,
+		route:                "none",
+		routeInterfaceInput:  routeInterfaceInput,
+		routeGatewayInput:    routeGatewayInput,
+		sourceNot:            "No",
+		destinationNot:       "No",
+		sourceInput:          sourceInput,
+		destinationInput:     destinationInput,
+		sourcePortInput:      sourcePortInput,
+		destinationPortInput: destinationPortInput,
+		pipeInput:            pipeInput,
+		probabilityInput:     probabilityInput,
+		groupInput:           groupInput,
+		descriptionInput:     descriptionInput,
+	}
+}
+
+// visibleFields returns the fields that apply to the current protocol, in display order.
+func (f *ruleForm) visibleFields() []int {
+	var fields []int
+	for field := 0; field < ruleFieldCount; field++ {
+		switch field {
+		case ruleFieldRouteInterface, ruleFieldRouteGateway:
+			if f.route == "none" {
+				continue
+			}
+		case ruleFieldIcmpType:
+			if f.protocol != "icmp" {
+				continue
+			}
+		case ruleFieldIcmpCode:
+			if f.protocol != "icmp" || config.IcmpCodes[f.icmpType] == nil {
+				continue
+			}
+		case ruleFieldStateMax, ruleFieldSourceTrack, ruleFieldMaxSrcConn, ruleFieldMaxSrcConnRate, ruleFieldOverloadTable:
+			// State options only apply when the rule creates state
+			if f.state == "default" || f.state == "no state" {
+				continue
+			}
+		case ruleFieldOverloadFlush:
+			if f.state == "default" || f.state == "no state" || f.overloadTableInput.Value() == "" {
+				continue
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// textInput returns the text input backing the given field, or nil for option fields.
+func (f *ruleForm) textInput(field int) *textinput.Model {
+	switch field {
+	case ruleFieldInterface:
+		return &f.interfaceInput
+	case ruleFieldRouteInterface:
+		return &f.routeInterfaceInput
+	case ruleFieldRouteGateway:
+		return &f.routeGatewayInput
+	case ruleFieldSource:
+		return &f.sourceInput
+	case ruleFieldDestination:
+		return &f.destinationInput
+	case ruleFieldSourcePort:
+		return &f.sourcePortInput
+	case ruleFieldDestinationPort:
+		return &f.destinationPortInput
+	case ruleFieldStateMax:
+		return &f.stateMaxInput
+	case ruleFieldMaxSrcConn:
+		return &f.maxSrcConnInput
+	case ruleFieldMaxSrcConnRate:
+		return &f.maxSrcConnRateInput
+	case ruleFieldOverloadTable:
+		return &f.overloadTableInput
+	case ruleFieldPipe:
+		return &f.pipeInput
+	case ruleFieldProbability:
+		return &f.probabilityInput
+	case ruleFieldGroup:
+		return &f.groupInput
+	case ruleFieldDescription:
+		return &f.descriptionInput
+	}
+	return nil
+}
+
+// optionField returns the options and the selected value of the given field, or nil for text fields.
+func (f *ruleForm) optionField(field int) ([]string, *string) {
+	switch field {
+	case ruleFieldAction:
+		return []string{"block", "pass"}, &f.action
+	case ruleFieldDirection:
+		return []string{"in", "out"}, &f.direction
+	case ruleFieldQuick:
+		return []string{"Yes", "No"}, &f.quick
+	case ruleFieldSourceNot:
+		return []string{"No", "Yes"}, &f.sourceNot
+	case ruleFieldDestinationNot:
+		return []string{"No", "Yes"}, &f.destinationNot
+	case ruleFieldLog:
+		return []string{"none", "log", "log (all)"}, &f.log
+	case ruleFieldRoute:
+		return []string{"none", "route-to", "reply-to"}, &f.route
+	case ruleFieldProtocol:
+		return []string{"tcp", "udp", "tcp,udp", "icmp", "any"}, &f.protocol
+	case ruleFieldIcmpType:
+		return config.IcmpTypes, &f.icmpType
+	case ruleFieldIcmpCode:
+		return config.IcmpCodes[f.icmpType], &f.icmpCode
+	case ruleFieldState:
+		return []string{"default", "no state", "keep state", "modulate state", "synproxy state"}, &f.state
+	case ruleFieldSourceTrack:
+		return []string{"none", "rule", "global"}, &f.sourceTrack
+	case ruleFieldOverloadFlush:
+		return []string{"none", "flush", "flush global"}, &f.overloadFlush
+	}
+	return nil, nil
+}
+
+// moveFocus moves the focus delta fields up or down, wrapping around.
+func (f *ruleForm) moveFocus(delta int) {
+	fields := f.visibleFields()
+	for i, field := range fields {
+		if field == f.focused {
+			f.focused = fields[(i+delta+len(fields))%len(fields)]
+			return
+		}
+	}
+	f.focused = fields[0]
+}
+
+// cycleOption selects the previous (-1) or next (+1) option of the focused field.
+func (f *ruleForm) cycleOption(delta int) {
+	if f.focused == ruleFieldSource || f.focused == ruleFieldDestination {
+		f.cycleAddress(delta)
+		return
+	}
+	options, selected := f.optionField(f.focused)
+	if selected == nil {
+		return
+	}
+	for i, opt := range options {
+		if opt == *selected {
+			*selected = options[(i+delta+len(options))%len(options)]
+			break
+		}
+	}
+	if f.focused == ruleFieldIcmpType {
+		f.icmpCode = "any"
+	}
+}
+
+// cycleAddress replaces the focused Source or Destination with the next address suggestion.
+func (f *ruleForm) cycleAddress(delta int) {
+	input := f.textInput(f.focused)
+	suggestions := f.addressSuggestions
+	if input == nil || len(suggestions) == 0 {
+		return
+	}
+	next := 0
+	if delta < 0 {
+		next = len(suggestions) - 1
+	}
+	for i, suggestion := range suggestions {
+		if suggestion == input.Value() {
+			next = (i + delta + len(suggestions)) % len(suggestions)
+			break
+		}
+	}
+	input.SetValue(suggestions[next])
+}
+
+func newPortForwardingForm() portForwardingForm {
+	interfaceInput := textinput.New()
+	interfaceInput.SetValue("any")
+	interfaceInput.Prompt = ""
+	interfaceInput.Blur()
+	externalIPInput := textinput.New()
+	externalIPInput.SetValue("any")
+	externalIPInput.Prompt = ""
+	externalIPInput.Blur()
+	externalPortInput := textinput.New()
+	externalPortInput.Prompt = ""
+	externalPortInput.Blur()
+	internalIPInput := textinput.New()
+	internalIPInput.SetValue("127.0.0.1")
+	internalIPInput.Prompt = ""
+	internalIPInput.Blur()
+	internalPortInput := textinput.New()
+	internalPortInput.Prompt = ""
+	internalPortInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+
+	return portForwardingForm{
+		focused:           0,
+		activeTextInput:   -1,
+		enabled:           true,
+		protocol:          "tcp",
+		interfaceInput:    interfaceInput,
+		externalIPInput:   externalIPInput,
+		externalPortInput: externalPortInput,
+		internalIPInput:   internalIPInput,
+		internalPortInput: internalPortInput,
+		descriptionInput:  descriptionInput,
+	}
+}
+
+func newNatForm() natForm {
+	interfaceInput := textinput.New()
+	interfaceInput.SetValue("en0")
+	interfaceInput.Prompt = ""
+	interfaceInput.Blur()
+	sourceInput := textinput.New()
+	sourceInput.SetValue("any")
+	sourceInput.Prompt = ""
+	sourceInput.Blur()
+	destinationInput := textinput.New()
+	destinationInput.SetValue("any")
+	destinationInput.Prompt = ""
+	destinationInput.Blur()
+	translationInput := textinput.New()
+	translationInput.Prompt = ""
+	translationInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+
+	return natForm{
+		focused:          0,
+		activeTextInput:  -1,
+		protocol:         "any",
+		interfaceInput:   interfaceInput,
+		sourceInput:      sourceInput,
+		destinationInput: destinationInput,
+		translationInput: translationInput,
+		descriptionInput: descriptionInput,
+	}
+}
+
+func newTableForm() tableForm {
+	nameInput := textinput.New()
+	nameInput.Prompt = ""
+	nameInput.Blur()
+	addressesInput := textinput.New()
+	addressesInput.Prompt = ""
+	addressesInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+	feedURLInput := textinput.New()
+	feedURLInput.Prompt = ""
+	feedURLInput.Placeholder = "https://www.spamhaus.org/drop/drop.txt"
+	feedURLInput.Blur()
+	feedRefreshInput := textinput.New()
+	feedRefreshInput.Prompt = ""
+	feedRefreshInput.Placeholder = strconv.Itoa(config.DefaultFeedRefreshHours)
+	feedRefreshInput.Blur()
+
+	return tableForm{
+		focused:          0,
+		activeTextInput:  -1,
+		persist:          "Yes",
+		nameInput:        nameInput,
+		addressesInput:   addressesInput,
+		descriptionInput: descriptionInput,
+		feedURLInput:     feedURLInput,
+		feedRefreshInput: feedRefreshInput,
+	}
+}
+
+func newMacroForm() macroForm {
+	nameInput := textinput.New()
+	nameInput.Prompt = ""
+	nameInput.Blur()
+	valueInput := textinput.New()
+	valueInput.Prompt = ""
+	valueInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+
+	return macroForm{
+		focused:          0,
+		activeTextInput:  -1,
+		nameInput:        nameInput,
+		valueInput:       valueInput,
+		descriptionInput: descriptionInput,
+	}
+}
+
+// Scrub form fields, in display order.
+const (
+	scrubFieldEnabled = iota
+	scrubFieldDirection
+	scrubFieldInterface
+	scrubFieldReassembleTCP
+	scrubFieldNoDF
+	scrubFieldRandomID
+	scrubFieldFragment
+	scrubFieldCount
+)
+
+var scrubFieldLabels = [scrubFieldCount]string{
+	scrubFieldEnabled:       "Enabled",
+	scrubFieldDirection:     "Direction",
+	scrubFieldInterface:     "Interface",
+	scrubFieldReassembleTCP: "Reassemble TCP",
+	scrubFieldNoDF:          "No DF",
+	scrubFieldRandomID:      "Random ID",
+	scrubFieldFragment:      "Fragment",
+}
+
+func newScrubForm(scrub config.ScrubOptions) scrubForm {
+	yesNo := map[bool]string{true: "Yes", false: "No"}
+	interfaceInput := textinput.New()
+	interfaceInput.SetValue("any")
+	if scrub.Interface != "" {
+		interfaceInput.SetValue(scrub.Interface)
+	}
+	interfaceInput.Prompt = ""
+	interfaceInput.Blur()
+
+	form := scrubForm{
+		focused:         0,
+		activeTextInput: -1,
+		enabled:         yesNo[scrub.Enabled],
+		direction:       "in",
+		reassembleTCP:   yesNo[scrub.ReassembleTCP],
+		noDF:            yesNo[scrub.NoDF],
+		randomID:        yesNo[scrub.RandomID],
+		fragment:        "reassemble",
+		interfaceInput:  interfaceInput,
+	}
+	if !scrub.Enabled && scrub.Direction == "" && scrub.Fragment == "" {
+		return form // never configured, keep the defaults
+	}
+	form.direction = "both"
+	if scrub.Direction != "" {
+		form.direction = scrub.Direction
+	}
+	if scrub.Fragment != "" {
+		form.fragment = scrub.Fragment
+	}
+	return form
+}
+
+// Global options form fields, in display order.
+const (
+	optionsFieldSkip = iota
+	optionsFieldStateLimit
+	optionsFieldTableEntriesLimit
+	optionsFieldBlockPolicy
+	optionsFieldOptimization
+	optionsFieldCount
+)
+
+var optionsFieldLabels = [optionsFieldCount]string{
+	optionsFieldSkip:              "Skip Interfaces",
+	optionsFieldStateLimit:        "State Limit",
+	optionsFieldTableEntriesLimit: "Table Entries",
+	optionsFieldBlockPolicy:       "Block Policy",
+	optionsFieldOptimization:      "Optimization",
+}
+
+func newOptionsForm(options config.PfOptions) optionsForm {
+	skipInput := textinput.New()
+	skipInput.SetValue(strings.Join(options.SkipInterfaces, ", "))
+	skipInput.Prompt = ""
+	skipInput.Blur()
+	stateLimitInput := textinput.New()
+	if options.StateLimit > 0 {
+		stateLimitInput.SetValue(strconv.Itoa(options.StateLimit))
+	}
+	stateLimitInput.Prompt = ""
+	stateLimitInput.Blur()
+	tableEntriesLimitInput := textinput.New()
+	if options.TableEntriesLimit > 0 {
+		tableEntriesLimitInput.SetValue(strconv.Itoa(options.TableEntriesLimit))
+	}
+	tableEntriesLimitInput.Prompt = ""
+	tableEntriesLimitInput.Blur()
+
+	form := optionsForm{
+		focused:                0,
+		activeTextInput:        -1,
+		skipInput:              skipInput,
+		stateLimitInput:        stateLimitInput,
+		tableEntriesLimitInput: tableEntriesLimitInput,
+		blockPolicy:            "default",
+		optimization:           "default",
+	}
+	if options.BlockPolicy != "" {
+		form.blockPolicy = options.BlockPolicy
+	}
+	if options.Optimization != "" {
+		form.optimization = options.Optimization
+	}
+	return form
+}
+
+func newPipeForm() pipeForm {
+	numberInput := textinput.New()
+	numberInput.Prompt = ""
+	numberInput.Blur()
+	bandwidthInput := textinput.New()
+	bandwidthInput.Prompt = ""
+	bandwidthInput.Blur()
+	delayInput := textinput.New()
+	delayInput.Prompt = ""
+	delayInput.Blur()
+	queueInput := textinput.New()
+	queueInput.Prompt = ""
+	queueInput.Blur()
+	descriptionInput := textinput.New()
+	descriptionInput.Prompt = ""
+	descriptionInput.Blur()
+
+	return pipeForm{
+		focused:          0,
+		activeTextInput:  -1,
+		numberInput:      numberInput,
+		bandwidthInput:   bandwidthInput,
+		delayInput:       delayInput,
+		queueInput:       queueInput,
+		descriptionInput: descriptionInput,
+	}
+}
+
+// StartViews are the menu items that -view opens at start, by name.
+var StartViews = map[string]string{
+	"rules":     "Edit Firewall Rule",
+	"rdr":       "Edit Port Forwarding Rule",
+	"nat":       "Edit NAT Rule",
+	"tables":    "Edit Tables",
+	"macros":    "Edit Macros",
+	"pipes":     "Edit Pipes",
+	"settings":  "Settings",
+	"pfconf":    "Preview Generated pf.conf",
+	"backups":   "Restore Backup",
+	"archive":   "Archived Rules",
+	"history":   "Apply History",
+	"current":   "Show Current Rules",
+	"info":      "Show Info",
+	"memory":    "Show Memory & Limits",
+	"competing": "Show Competing Rules",
+	"talkers":   "Show Top Talkers",
+	"states":    "Show States",
+	"log":       "Live Pflog",
+	"messages":  "Show Messages",
+}
+
+// StartViewNames returns the names of StartViews, sorted.
+func StartViewNames() []string {
+	names := make([]string, 0, len(StartViews))
+	for name := range StartViews {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options are what main sets up for the TUI.
+type Options struct {
+	SudoKeepAlive *pfcli.SudoKeepAlive  // refreshes the sudo credentials, nil in test mode
+	ConfigWatcher *config.ConfigWatcher // reports changes of the rules file, nil if it cannot be watched
+	StartView     string                // title of the menu item opened at start, "" for the main menu
+	TestMode      bool                  // -test: whois is answered with made-up data; the fake runner answers the privileged commands
+}
+
+// NewModel returns the TUI for fm, which runs the privileged commands with
+// runner.
+func NewModel(fm *config.FirewallManager, runner pfcli.CommandRunner, opts Options) tea.Model {
+	m := model{
+		firewallManager:    fm,
+		runner:             runner,
+		sudoKeepAlive:      opts.SudoKeepAlive,
+		configWatcher:      opts.ConfigWatcher,
+		startView:          opts.StartView,
+		testMode:           opts.TestMode,
+		pfStatus:           "Checking...",
+		startupStatus:      "Checking...",
+		configLoading:      true,
+		currentView:        mainView,
+		form:               newRuleForm(),
+		portForwardingForm: newPortForwardingForm(),
+		natForm:            newNatForm(),
+		tableForm:          newTableForm(),
+		macroForm:          newMacroForm(),
+		scrubForm:          newScrubForm(config.ScrubOptions{}),
+		pipeForm:           newPipeForm(),
+		optionsForm:        newOptionsForm(config.PfOptions{}),
+		viewport:           viewport.New(80, 24),
+		textinput:          textinput.New(),
+		pflogFilterInput:   newPflogFilterInput(),
+		search:             viewportSearch{input: newSearchInput()},
+		spinner:            newOperationSpinner(),
+		tableEntryInput:    newTableEntryInput(),
+		resolver:           NewResolver(),
+		sshSession:         config.CurrentSSHSession(),
+		interfaces:         pfcli.SystemInterfaces(),
+		pfTuiLoaded:        true, // until checked
+		feedStatus:         make(map[string]config.FeedStatus),
+		feedsUpdating:      make(map[string]bool),
+		help:               help.New(),
+	}
+	geoip, err := OpenGeoIP(fm.Config.Settings.GeoIPCountryDB, fm.Config.Settings.GeoIPASNDB)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to open GeoIP databases: %v", err))
+	}
+	m.geoip = geoip
+	if fm.Config.Settings.AutoBan {
+		banner, err := config.StartAutoBan(m.runner, fm.Config.Settings)
+		if err != nil {
+			logging.Error(fmt.Sprintf("Failed to start auto-ban: %v", err))
+		}
+		m.autoBan = banner
+	}
+
+	// Main menu list
+	items := []list.Item{
+		//item{title: ""},
+		item{title: "Edit Firewall Rule"},
+		item{title: "Add New Firewall Rule"},
+		item{title: "Edit Port Forwarding Rule"},
+		item{title: "Add Port Forwarding Rule"},
+		item{title: "Edit NAT Rule"},
+		item{title: "Add NAT Rule"},
+		item{title: "Internet Sharing Wizard"},
+		item{title: "Edit Tables"},
+		item{title: "Edit Macros"},
+		item{title: "Edit Scrub Options"},
+		item{title: "Edit Pipes"},
+		item{title: "Edit Global Options"},
+		item{title: "Edit Timeouts"},
+		item{title: "Settings"},
+		item{title: "---"},
+		item{title: "Save & Apply Configuration"},
+		item{title: "Preview Generated pf.conf"},
+		item{title: "Export Configuration"},
+		item{title: "Import Configuration"},
+		item{title: "Import pf.conf"},
+		item{title: "Import from Live Rules"},
+		item{title: "Restore Backup"},
+		item{title: "Archived Rules"},
+		item{title: "Apply History"},
+		item{title: "---"},
+		item{title: "Show Current Rules"},
+		item{title: "Show Info"},
+		item{title: "Show Memory & Limits"},
+		item{title: "Show Competing Rules"},
+		item{title: "Show Top Talkers"},
+		item{title: "Show States"},
+		item{title: "Live Pflog"},
+		item{title: "Show Messages"},
+		item{title: "---"},
+		item{title: "Enable PF"},
+		item{title: "Disable PF"},
+		item{title: "Flush All States"},
+		item{title: "Panic: Allow All Traffic"},
+		item{title: "Enable PF on Startup"},
+		item{title: "Disable PF on Startup"},
+		item{title: "---"},
+		item{title: "Exit"},
+	}
+
+	delegate := newListDelegate()
+	delegate.ShowDescription = false
+	delegate.SetSpacing(0)
+	delegate.Styles.NormalTitle = delegate.Styles.NormalTitle.Padding(0, 0, 0, 2)
+	delegate.Styles.SelectedTitle = delegate.Styles.SelectedTitle.Padding(0, 0, 0, 1)
+	l := list.New(items, delegate, 0, 0)
+	l.Title = "Main menu"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.SetShowTitle(true)
+	l.SetShowHelp(false)
+	l.SetShowPagination(true)
+	l.SetShowStatusBar(false)
+	m.list = l
+
+	// Rule list
+	ruleListDelegate := newRuleListDelegate(fm.Config.Settings.RuleListLayout)
+	m.ruleList = list.New([]list.Item{}, ruleListDelegate, 0, 0)
+	m.ruleList.Title = "Firewall Rules"
+	m.ruleList.SetShowStatusBar(false)
+	m.ruleList.SetFilteringEnabled(false)
+	m.ruleList.SetShowHelp(false)
+	m.ruleList.SetShowTitle(false)
+
+	// Port forwarding list
+	portForwardingListDelegate := newListDelegate()
+	portForwardingListDelegate.ShowDescription = false
+	portForwardingListDelegate.SetHeight(1)
+	portForwardingListDelegate.SetSpacing(0)
+	m.portForwardingList = list.New([]list.Item{}, portForwardingListDelegate, 0, 0)
+	m.portForwardingList.Title = "Port Forwarding Rules"
+	m.portForwardingList.SetShowStatusBar(false)
+	m.portForwardingList.SetFilteringEnabled(false)
+	m.portForwardingList.SetShowTitle(false)
+	m.portForwardingList.SetShowHelp(false)
+
+	// NAT list
+	natListDelegate := newListDelegate()
+	natListDelegate.ShowDescription = false
+	natListDelegate.SetHeight(1)
+	natListDelegate.SetSpacing(0)
+	m.natList = list.New([]list.Item{}, natListDelegate, 0, 0)
+	m.natList.Title = "NAT Rules"
+	m.natList.SetShowStatusBar(false)
+	m.natList.SetFilteringEnabled(false)
+	m.natList.SetShowTitle(false)
+	m.natList.SetShowHelp(false)
+
+	// Table list
+	tableListDelegate := newListDelegate()
+	tableListDelegate.ShowDescription = false
+	tableListDelegate.SetHeight(1)
+	tableListDelegate.SetSpacing(0)
+	m.tableList = list.New([]list.Item{}, tableListDelegate, 0, 0)
+	m.tableList.Title = "Tables"
+	m.tableList.SetShowStatusBar(false)
+	m.tableList.SetFilteringEnabled(false)
+	m.tableList.SetShowTitle(false)
+	m.tableList.SetShowHelp(false)
+
+	// Table entry list
+	tableEntryListDelegate := newListDelegate()
+	tableEntryListDelegate.ShowDescription = false
+	tableEntryListDelegate.SetHeight(1)
+	tableEntryListDelegate.SetSpacing(0)
+	m.tableEntryList = list.New([]list.Item{}, tableEntryListDelegate, 0, 0)
+	m.tableEntryList.Title = "Table Entries"
+	m.tableEntryList.SetShowStatusBar(false)
+	m.tableEntryList.SetFilteringEnabled(false)
+	m.tableEntryList.SetShowTitle(false)
+	m.tableEntryList.SetShowHelp(false)
+
+	// Macro list
+	macroListDelegate := newListDelegate()
+	macroListDelegate.ShowDescription = false
+	macroListDelegate.SetHeight(1)
+	macroListDelegate.SetSpacing(0)
+	m.macroList = list.New([]list.Item{}, macroListDelegate, 0, 0)
+	m.macroList.Title = "Macros"
+	m.macroList.SetShowStatusBar(false)
+	m.macroList.SetFilteringEnabled(false)
+	m.macroList.SetShowTitle(false)
+	m.macroList.SetShowHelp(false)
+
+	// Pipe list
+	pipeListDelegate := newListDelegate()
+	pipeListDelegate.ShowDescription = false
+	pipeListDelegate.SetHeight(1)
+	pipeListDelegate.SetSpacing(0)
+	m.pipeList = list.New([]list.Item{}, pipeListDelegate, 0, 0)
+	m.pipeList.Title = "Pipes"
+	m.pipeList.SetShowStatusBar(false)
+	m.pipeList.SetFilteringEnabled(false)
+	m.pipeList.SetShowTitle(false)
+	m.pipeList.SetShowHelp(false)
+
+	// File list
+	fileListDelegate := newListDelegate()
+	fileListDelegate.ShowDescription = true
+	fileListDelegate.SetHeight(2)
+	fileListDelegate.SetSpacing(0)
+	fileListDelegate.Styles.NormalTitle = lipgloss.NewStyle().Padding(0, 0, 0, 2)
+	fileListDelegate.Styles.SelectedTitle = lipgloss.NewStyle().
+		Border(selectionBorder(), false, false, false, true).
+		BorderForeground(lipgloss.AdaptiveColor{Light: "#F793FF", Dark: "#AD58B4"}).
+		Foreground(lipgloss.AdaptiveColor{Light: "#EE6FF8", Dark: "#EE6FF8"}).
+		Padding(0, 0, 0, 1)
+
+	m.fileList = list.New([]list.Item{}, fileListDelegate, 0, 0)
+	m.fileList.Title = "Select a file to import"
+	m.fileList.SetShowStatusBar(false)
+	m.fileList.SetFilteringEnabled(false)
+	m.fileList.SetShowTitle(true)
+	m.fileList.SetShowHelp(false)
+
+	m.backupList = list.New([]list.Item{}, fileListDelegate, 0, 0)
+	m.backupList.Title = "Select a backup to restore"
+	m.backupList.SetShowStatusBar(false)
+	m.backupList.SetFilteringEnabled(false)
+	m.backupList.SetShowTitle(true)
+	m.backupList.SetShowHelp(false)
+
+	m.archiveList = list.New([]list.Item{}, fileListDelegate, 0, 0)
+	m.archiveList.Title = "Archived Rules"
+	m.archiveList.SetShowStatusBar(false)
+	m.archiveList.SetFilteringEnabled(false)
+	m.archiveList.SetShowTitle(true)
+	m.archiveList.SetShowHelp(false)
+
+	m.historyList = list.New([]list.Item{}, fileListDelegate, 0, 0)
+	m.historyList.Title = "Apply History"
+	m.historyList.SetShowStatusBar(false)
+	m.historyList.SetFilteringEnabled(false)
+	m.historyList.SetShowTitle(true)
+	m.historyList.SetShowHelp(false)
+
+	m.serviceList = list.New([]list.Item{}, fileListDelegate, 0, 0)
+	m.serviceList.Title = "Services"
+	m.serviceList.SetShowStatusBar(false)
+	m.serviceList.SetShowTitle(true)
+	m.serviceList.SetShowHelp(false)
+
+	if plainMode {
+		usePlainPagination(&m.list, &m.ruleList, &m.portForwardingList, &m.natList, &m.tableList, &m.tableEntryList,
+			&m.macroList, &m.pipeList, &m.fileList, &m.backupList, &m.archiveList, &m.historyList, &m.serviceList)
+	}
+
+	return &m
+}
+
+func (m model) Init() tea.Cmd {
+	// The start view, the feeds and the backups wait for the configuration
+	return tea.Batch(
+		checkSudoCredentials(m.runner),
+		loadStartupConfig,
+		startupCheck(checkPfStatus(m.runner)),
+		startupCheck(checkPfStartupStatus(m.runner)),
+		startupCheck(checkPanicMode(m.runner)),
+		func() tea.Msg { return usageTickMsg{} },
+		loadStats,
+		waitForAutoBan(m.autoBan),
+		waitForSudoExpired(m.sudoKeepAlive),
+		waitForConfigChange(m.configWatcher),
+	)
+}
+
+// selectMenuItem opens the main menu item with the given title.
+func (m *model) selectMenuItem(title string) tea.Cmd {
+	if m.configLoading && title != "Exit" {
+		m.notify(levelWarn, "The configuration is still loading, try again in a moment.")
+		return nil
+	}
+	switch title {
+	case " ", "---":
+		// Do nothing for separators and empty space
+
+	case "Add New Firewall Rule":
+		m.pushView(ruleFormView)
+		m.form = newRuleForm()
+		m.form.isNew = true
+		m.focusRuleForm()
+	case "Edit Firewall Rule":
+		m.pushView(ruleListView)
+		return tea.Batch(m.updateRuleList(), getRuleCounters(m.runner))
+
+	case "Add Port Forwarding Rule":
+		m.pushView(portForwardingFormView)
+		m.portForwardingForm = newPortForwardingForm()
+		m.portForwardingForm.isNew = true
+		m.focusPortForwardingForm()
+	case "Edit Port Forwarding Rule":
+		m.pushView(portForwardingListView)
+		m.updatePortForwardingList()
+	case "Add NAT Rule":
+		m.pushView(natFormView)
+		m.natForm = newNatForm()
+		m.natForm.isNew = true
+		m.focusNatForm()
+	case "Edit NAT Rule":
+		m.pushView(natListView)
+		m.updateNatList()
+	case "Internet Sharing Wizard":
+		m.pushView(sharingFormView)
+		m.sharingForm = newSharingForm()
+		m.focusSharingForm()
+		return getIPForwarding(m.runner)
+	case "Settings":
+		if err := m.firewallManager.LoadConfig(); err != nil {
+			m.notify(levelError, fmt.Sprintf("Error loading config: %v", err))
+			return nil
+		}
+		m.pushView(settingsFormView)
+		m.settingsForm = newSettingsForm(m.firewallManager.Config.Settings)
+		if backups, err := config.ListScheduledBackups(); err == nil && len(backups) > 0 {
+			m.settingsForm.lastBackup = backups[0].Time
+		}
+		m.focusSettingsForm()
+	case "Edit Tables":
+		m.pushView(tableListView)
+		m.updateTableList()
+	case "Edit Macros":
+		m.pushView(macroListView)
+		m.updateMacroList()
+	case "Edit Scrub Options":
+		m.pushView(scrubFormView)
+		m.scrubForm = newScrubForm(m.firewallManager.Config.Scrub)
+		m.focusScrubForm()
+	case "Edit Pipes":
+		m.pushView(pipeListView)
+		m.updatePipeList()
+	case "Edit Global Options":
+		m.pushView(optionsFormView)
+		m.optionsForm = newOptionsForm(m.firewallManager.Config.Options)
+		m.focusOptionsForm()
+	case "Edit Timeouts":
+		m.pushView(timeoutsFormView)
+		m.timeoutsForm = timeoutsForm{activeTextInput: -1, loading: true}
+		return getTimeouts(m.runner)
+	case "Show Memory & Limits":
+		m.pushView(infoView)
+		m.infoViewTitle = "PF Memory & Limits"
+		m.viewport.SetContent("Loading...")
+		return checkPfUsage(m.runner)
+	case "Show Competing Rules":
+		m.pushView(infoView)
+		m.infoViewTitle = "Competing Rules"
+		m.viewport.SetContent("Loading...")
+		return checkCompetingRules(m.runner)
+	case "Show Top Talkers":
+		m.pushView(infoView)
+		m.infoViewTitle = "Top Talkers"
+		m.topTalkers = nil
+		m.topTalkerCursor = 0
+		m.viewport.SetContent("Loading...")
+		return func() tea.Msg { return topTalkersRefreshMsg{} }
+	case "Show States":
+		m.pushView(infoView)
+		m.infoViewTitle = "PF States"
+		m.refreshPaused = false
+		m.viewport.SetContent("Loading...")
+		m.viewport.GotoTop()
+		return m.resumeRefresh()
+	case "Show Info":
+		m.pushView(infoView)
+		m.infoViewTitle = "Live PF Info"
+		m.refreshPaused = false
+		m.clearSearch()
+		m.infoContent = "Loading..."
+		m.viewport.SetContent(m.infoContent)
+		return tea.Batch(asOperation("Reading pf info", getPfInfo(m.runner)), m.resumeRefresh())
+	case "Show Messages":
+		m.pushView(infoView)
+		m.infoViewTitle = "Messages"
+		m.viewport.SetContent(formatNotifications(m.notifications.history))
+		m.viewport.GotoTop()
+	case "Live Pflog":
+		stream, err := pfcli.StartPflog(m.runner)
+		if err != nil {
+			m.notify(levelError, err.Error())
+			return nil
+		}
+		m.pushView(pflogView)
+		m.pflog = stream
+		m.pflogLines = nil
+		m.pflogFollow = true
+		m.pflogPaused = false
+		m.pflogFiltering = false
+		m.pflogFilterInput.SetValue("")
+		m.pflogCursor = 0
+		m.pflogBlockAddr = ""
+		m.clearToast()
+		m.refreshPflogView()
+		return waitForPflog(stream)
+	case "Preview Generated pf.conf":
+		m.pushView(infoView)
+		m.infoViewTitle = "Generated pf.conf"
+		m.clearToast()
+		m.viewport.SetContent(highlightPfConf(m.firewallManager.GeneratePfConf()))
+		m.viewport.GotoTop()
+		return nil
+	case "Show Current Rules":
+		m.pushView(infoView)
+		m.infoViewTitle = "Current Live PF Rules"
+		m.clearSearch()
+		m.infoContent = "Loading..."
+		m.viewport.SetContent(m.infoContent)
+		return asOperation("Reading the rules", getCurrentRules(m.runner))
+	case "Enable PF":
+		return asOperation("Enabling pf", enablePf(m.runner))
+	case "Disable PF":
+		return asOperation("Disabling pf", disablePf(m.runner))
+	case "Flush All States":
+		m.pushView(confirmationView)
+		m.confirming = true
+		m.confirmCmd = flushStates(m.runner)
+		m.confirmationMessage = "Flush all states? Existing connections will be dropped unless the rules pass them."
+		return nil
+	case "Panic: Allow All Traffic":
+		m.pushView(confirmationView)
+		m.confirming = true
+		m.confirmCmd = panicAllowAll(m.runner)
+		m.confirmationMessage = "Unload all pf-tui rules and pass all traffic until the next Save & Apply?"
+		return nil
+	case "Enable PF on Startup":
+		return asOperation("Enabling pf on startup", enablePfOnStartup(m.runner))
+	case "Disable PF on Startup":
+		return asOperation("Disabling pf on startup", disablePfOnStartup(m.runner))
+	case "Save & Apply Configuration":
+		return m.openApplyPreview()
+	case "Export Configuration":
+		timestamp := time.Now().Format("20060102-150405")
+		m.openExport(fmt.Sprintf("rules-export-%s.json", timestamp), nil)
+	case "Import Configuration":
+		m.pushView(importConfigView)
+		m.browseTyping = false
+		m.clearToast()
+		configPath, _ := config.GetConfigPath()
+		return m.updateFileList(configPath)
+	case "Import pf.conf":
+		m.pushView(pfConfPathView)
+		m.textinput.SetValue("/etc/pf.conf")
+		m.textinput.CursorEnd()
+		m.textinput.Focus()
+	case "Import from Live Rules":
+		return importLiveRules(m.firewallManager, m.runner)
+	case "Restore Backup":
+		m.pushView(backupsView)
+		m.backupPreviewPath = ""
+		return getBackupList
+	case "Archived Rules":
+		if err := m.firewallManager.LoadConfig(); err != nil {
+			m.notify(levelError, fmt.Sprintf("Error loading config: %v", err))
+			return nil
+		}
+		m.pushView(archiveView)
+		m.updateArchiveList()
+		m.archiveList.Select(0)
+	case "Apply History":
+		m.pushView(historyView)
+		m.historyBase = time.Time{}
+		m.historyDiffKey = ""
+		return getHistoryList
+	case "Exit":
+		m.requestExit()
+		return nil
+	}
+	return nil
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(toastExpiredMsg); ok {
+		if m.notifications.toast != nil && m.notifications.toast.id == msg.id {
+			m.clearToast()
+		}
+		return m, nil
+	}
+	model, cmd := m.update(msg)
+	// Toasts posted by the update expire on their own
+	return model, tea.Batch(cmd, m.notifications.scheduleToast())
+}
+
+// update handles the messages other than toastExpiredMsg.
+func (m *model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if !m.typing() {
+			var ok bool
+			if msg, ok = remapKey(m.firewallManager.Config.Settings.Keybindings, msg); !ok {
+				return m, nil
+			}
+		}
+		// Any key closes the help overlay, "?" opens it outside of text inputs
+		if m.help.ShowAll {
+			m.help.ShowAll = false
+			return m, nil
+		}
+		if msg.String() == "?" && !m.typing() {
+			m.help.ShowAll = true
+			return m, nil
+		}
+		switch msg.String() {
+		case "esc":
+			if !m.typing() && m.cancelOperations() {
+				return m, nil
+			} else if m.currentView == mainView {
+				m.requestExit()
+				return m, nil
+			} else if m.currentView == whoisView {
+				return m, m.closeWhois()
+			} else if m.currentView == importConfigView && m.browseTyping {
+				m.browseTyping = false
+				m.textinput.Blur()
+				return m, nil
+			} else if m.currentView == importPreviewView {
+				m.popView()
+				return m, nil
+			} else if m.currentView == infoView && m.searchable() && (m.search.editing || m.search.input.Value() != "") {
+				m.clearSearch()
+				m.showInfoContent()
+				return m, nil
+			} else if m.currentView == ruleGroupView {
+				m.textinput.Blur()
+				m.popView()
+				return m, nil
+			} else if m.currentView == rollbackView && m.rollback != nil {
+				return m, nil // only confirming or reverting leaves it
+			} else if m.currentView != confirmationView {
+				if m.currentView == pflogView {
+					m.stopPflog()
+				}
+				m.unsavedNext = nil
+				m.popView()
+				return m, nil
+			}
+		}
+		if m.currentView == confirmationView {
+			switch msg.String() {
+			case "y":
+				if m.confirming {
+					m.confirming = false
+					if m.confirmCmd != nil {
+						cmd := m.confirmCmd
+						m.confirmCmd = nil
+						m.popView()
+						return m, cmd
+					}
+					if m.parentView() == mainView {
+						m.stopAutoBan()
+						return m, tea.Quit
+					} else if m.parentView() == saveConfigView {
+						m.popView()
+						return m, m.exportConfig(m.textinput.Value())
+					}
+				}
+			case "n":
+				if m.confirming {
+					m.confirming = false
+					m.confirmCmd = nil
+					m.popView()
+				}
+			}
+		}
+
+		switch m.currentView {
+		case mainView:
+			switch msg.String() {
+			case "up", "k":
+				if m.list.Index() == 0 {
+					m.list.Select(len(m.list.Items()) - 1)
+				} else {
+					m.list.Select(m.list.Index() - 1)
+				}
+				return m, nil
+			case "down", "j":
+				if m.list.Index() == len(m.list.Items())-1 {
+					m.list.Select(0)
+				} else {
+					m.list.Select(m.list.Index() + 1)
+				}
+				return m, nil
+			case "r":
+				if m.rulesFileChanged {
+					if m.rulesFileChanged = m.firewallManager.ChangedOnDisk(); m.rulesFileChanged {
+						m.offerReload()
+					}
+				}
+				return m, nil
+
+			case "enter":
+				selectedItem, ok := m.list.SelectedItem().(item)
+				if !ok {
+					return m, nil
+				}
+				return m, m.selectMenuItem(selectedItem.title)
+			}
+		case ruleListView:
+			// Handle key presses for reordering
+			switch msg.String() {
+			case "k", "j":
+				delta := 1
+				if msg.String() == "k" {
+					delta = -1
+				}
+				switch selectedItem := m.ruleList.SelectedItem().(type) {
+				case ruleListItem:
+					m.firewallManager.MoveFirewallRule(selectedItem.index, selectedItem.index+delta)
+					m.ruleList.SetItems(m.getRuleListItems())
+					m.selectRuleListItem(func(item list.Item) bool {
+						ruleItem, ok := item.(ruleListItem)
+						return ok && ruleItem.rule.ID == selectedItem.rule.ID
+					}) // Select the moved item
+				case ruleGroupListItem:
+					m.firewallManager.MoveRuleGroup(selectedItem.name, delta)
+					m.ruleList.SetItems(m.getRuleListItems())
+					m.selectRuleListItem(func(item list.Item) bool {
+						groupItem, ok := item.(ruleGroupListItem)
+						return ok && groupItem.name == selectedItem.name
+					}) // Select the moved group
+				}
+				return m, nil
+			case "g":
+				// Before the list, which would go to the first item
+				if ids := m.selectedRuleIDs(); len(ids) > 0 {
+					m.groupRuleIDs = ids
+					m.textinput.SetValue("")
+					if selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem); ok && len(m.markedRules) == 0 {
+						m.textinput.SetValue(selectedItem.rule.Group)
+					}
+					m.textinput.CursorEnd()
+					m.textinput.Focus()
+					m.clearToast()
+					m.pushView(ruleGroupView)
+				}
+				return m, nil
+			case "u":
+				// Before the list, which would go to the previous page
+				return m, m.undoDelete("Firewall")
+			}
+
+			// Let the list model handle its own updates for other keys
+			m.ruleList, cmd = m.ruleList.Update(msg)
+
+			// Handle other specific key presses for this view
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "a": // Add new rule
+				m.pushView(ruleFormView)
+				m.form = newRuleForm()
+				m.form.isNew = true
+				// Adding from a group header puts the new rule in that group
+				if groupItem, ok := m.ruleList.SelectedItem().(ruleGroupListItem); ok {
+					m.form.groupInput.SetValue(groupItem.name)
+				}
+				m.focusRuleForm()
+			case "enter":
+				if groupItem, ok := m.ruleList.SelectedItem().(ruleGroupListItem); ok {
+					if m.collapsedGroups == nil {
+						m.collapsedGroups = make(map[string]bool)
+					}
+					m.collapsedGroups[groupItem.name] = !m.collapsedGroups[groupItem.name]
+					m.ruleList.SetItems(m.getRuleListItems())
+					return m, nil
+				}
+				selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem)
+				if ok {
+					m.openFirewallRuleForm(selectedItem.index, false)
+				}
+			case "d":
+				if len(m.markedRules) > 0 {
+					ids := m.selectedRuleIDs()
+					m.pushView(confirmationView)
+					m.confirming = true
+					var deleted []config.DeletedRule
+					for i, rule := range m.firewallManager.Config.FirewallRules {
+						if ids[rule.ID] {
+							deleted = append(deleted, config.DeletedRule{Kind: "Firewall", ID: rule.ID, Index: i})
+						}
+					}
+					m.confirmCmd = deleteRules(deleted, func() (int, error) {
+						return m.firewallManager.DeleteFirewallRules(ids)
+					})
+					m.confirmationMessage = fmt.Sprintf("Move the %d selected rules to the archive?", len(ids))
+					return m, nil
+				}
+				selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem)
+				if ok {
+					deleted := []config.DeletedRule{{Kind: "Firewall", ID: selectedItem.rule.ID, Index: selectedItem.index}}
+					return m, deleteRules(deleted, func() (int, error) {
+						return 1, m.firewallManager.DeleteFirewallRule(selectedItem.index)
+					})
+				}
+			case " ":
+				switch selectedItem := m.ruleList.SelectedItem().(type) {
+				case ruleListItem:
+					m.markRules(selectedItem.rule.Group, selectedItem.rule.ID)
+				case ruleGroupListItem:
+					// Selects the whole group, or clears it if all of it is selected
+					m.markRules(selectedItem.name, "")
+				}
+				m.ruleList.SetItems(m.getRuleListItems())
+				m.ruleList.CursorDown()
+			case "x":
+				if ids := m.selectedRuleIDs(); len(ids) > 0 {
+					m.openExport(fmt.Sprintf("rules-selected-%s.json", time.Now().Format("20060102-150405")), ids)
+				}
+			case "c":
+				if selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem); ok {
+					m.openFirewallRuleForm(selectedItem.index, true)
+				}
+			case "i":
+				if selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem); ok {
+					m.openRuleInspector(selectedItem)
+				}
+			case "e":
+				if len(m.markedRules) > 0 {
+					// Enables all of them, unless they all are already
+					ids := m.selectedRuleIDs()
+					enabled := false
+					for _, rule := range m.firewallManager.Config.FirewallRules {
+						enabled = enabled || (ids[rule.ID] && !rule.Enabled)
+					}
+					cmd = func() tea.Msg {
+						changed, err := m.firewallManager.SetFirewallRulesEnabled(ids, enabled)
+						if err != nil {
+							return errMsg{err}
+						}
+						if enabled {
+							return firewallRuleSavedMsg(fmt.Sprintf("%d rules enabled.", changed))
+						}
+						return firewallRuleSavedMsg(fmt.Sprintf("%d rules disabled.", changed))
+					}
+					return m, tea.Sequence(cmd, m.updateRuleList())
+				}
+				selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem)
+				if ok {
+					enabled := !selectedItem.rule.Enabled
+					cmd = func() tea.Msg {
+						if err := m.firewallManager.SetFirewallRuleEnabled(selectedItem.index, enabled); err != nil {
+							return errMsg{err}
+						}
+						if enabled {
+							return firewallRuleSavedMsg("Rule enabled.")
+						}
+						return firewallRuleSavedMsg("Rule disabled.")
+					}
+					return m, tea.Sequence(cmd, m.updateRuleList())
+				}
+			case "s":
+				return m, func() tea.Msg {
+					if err := m.firewallManager.SaveConfig(); err != nil {
+						return errMsg{err}
+					}
+					return configSavedAndBackToMainMsg("Rule order saved.")
+				}
+			case "v":
+				m.openRuleColumns()
+			case "p":
+				m.toggleRuleDetailPanel()
+			case "y", "Y":
+				// pf syntax, or JSON with "Y"
+				return m, m.yankRules(m.selectedRuleIDs(), msg.String() == "Y")
+			case "A":
+				return m, m.openApplyPreview()
+			case "P":
+				return m, func() tea.Msg {
+					text, err := ReadClipboard()
+					if err != nil {
+						return errMsg{err}
+					}
+					return pastedMsg(text)
+				}
+			}
+			// Pasting into the terminal, e.g. with Cmd-V, pastes the rule too
+			if msg.Paste {
+				m.pasteRule(string(msg.Runes))
+				return m, nil
+			}
+		case ruleFormView:
+			// If a text input is active, let it handle the key presses
+			if m.form.activeTextInput != -1 {
+				var cmd tea.Cmd
+				if input := m.form.textInput(m.form.activeTextInput); input != nil {
+					*input, cmd = input.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.form.activeTextInput = -1
+					m.focusRuleForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			// Handle navigation and option changes when no text input is active
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "s":
+				// Only save if no text input is active
+				if m.form.activeTextInput == -1 {
+					return m, m.saveRule()
+				}
+			case "enter":
+				// If the current field is a text input, enter editing mode
+				if m.form.textInput(m.form.focused) != nil {
+					m.form.activeTextInput = m.form.focused
+					m.focusRuleForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.form.moveFocus(-1)
+				m.focusRuleForm()
+			case "down":
+				m.form.moveFocus(1)
+				m.focusRuleForm()
+			case "left":
+				m.form.cycleOption(-1)
+			case "right":
+				m.form.cycleOption(1)
+			case "/":
+				if m.form.focused == ruleFieldSourcePort || m.form.focused == ruleFieldDestinationPort {
+					m.openServicePicker(m.form.focused)
+				}
+			}
+			return m, nil
+		case ruleColumnsView:
+			switch msg.String() {
+			case "up":
+				m.columnsCursor = (m.columnsCursor - 1 + len(ruleColumns)) % len(ruleColumns)
+			case "down":
+				m.columnsCursor = (m.columnsCursor + 1) % len(ruleColumns)
+			case " ":
+				m.toggleRuleColumn()
+			case "left", "right":
+				if m.layoutDraft == layoutCompact {
+					m.layoutDraft = layoutDetailed
+				} else {
+					m.layoutDraft = layoutCompact
+				}
+			case "enter":
+				columns, layout := m.columnsDraft, m.layoutDraft
+				if strings.Join(columns, ",") == strings.Join(defaultRuleColumns, ",") {
+					columns = nil
+				}
+				if layout == layoutCompact {
+					layout = ""
+				}
+				return m, func() tea.Msg {
+					if err := m.firewallManager.SetRuleListView(columns, layout); err != nil {
+						return errMsg{err}
+					}
+					return ruleColumnsSavedMsg{}
+				}
+			case "esc":
+				m.popView()
+			}
+			return m, nil
+		case servicePickerView:
+			// While the search is typed, the list gets all keys
+			if m.serviceList.FilterState() != list.Filtering {
+				switch msg.String() {
+				case "enter":
+					if selectedItem, ok := m.serviceList.SelectedItem().(serviceListItem); ok {
+						m.pickService(selectedItem.service.Name)
+					}
+					return m, nil
+				case "esc":
+					if m.serviceList.FilterState() == list.Unfiltered {
+						m.popView()
+						return m, nil
+					}
+				}
+			}
+			m.serviceList, cmd = m.serviceList.Update(msg)
+			return m, cmd
+		case portForwardingListView:
+			if msg.String() == "u" {
+				// Before the list, which would go to the previous page
+				return m, m.undoDelete("Port Forwarding")
+			}
+			m.portForwardingList, cmd = m.portForwardingList.Update(msg)
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "A":
+				return m, m.openApplyPreview()
+			case "a": // Add new port forwarding rule
+				m.pushView(portForwardingFormView)
+				m.portForwardingForm = newPortForwardingForm()
+				m.portForwardingForm.isNew = true
+				m.focusPortForwardingForm()
+			case "enter":
+				selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem)
+				if ok {
+					m.openPortForwardingRuleForm(selectedItem.index, false)
+				}
+			case "d":
+				selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem)
+				if ok {
+					deleted := []config.DeletedRule{{Kind: "Port Forwarding", ID: selectedItem.rule.ID, Index: selectedItem.index}}
+					return m, deleteRules(deleted, func() (int, error) {
+						return 1, m.firewallManager.DeletePortForwardingRule(selectedItem.index)
+					})
+				}
+			case "c":
+				if selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem); ok {
+					m.openPortForwardingRuleForm(selectedItem.index, true)
+				}
+			case "i":
+				if selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem); ok {
+					m.openRuleDetails(fmt.Sprintf("Port Forwarding Rule %d", selectedItem.index+1), formatPortForwardingRuleDetails(selectedItem.rule))
+				}
+			case "e":
+				selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem)
+				if ok {
+					enabled := !selectedItem.rule.Enabled
+					cmd = func() tea.Msg {
+						if err := m.firewallManager.SetPortForwardingRuleEnabled(selectedItem.index, enabled); err != nil {
+							return errMsg{err}
+						}
+						if enabled {
+							return firewallRuleSavedMsg("Port forwarding rule enabled.")
+						}
+						return firewallRuleSavedMsg("Port forwarding rule disabled.")
+					}
+					return m, tea.Sequence(cmd, func() tea.Msg {
+						m.updatePortForwardingList()
+						return nil
+					})
+				}
+			case "k":
+				selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem)
+				if ok {
+					m.firewallManager.MovePortForwardingRule(selectedItem.index, selectedItem.index-1)
+					m.updatePortForwardingList()
+				}
+			case "j":
+				selectedItem, ok := m.portForwardingList.SelectedItem().(portForwardingListItem)
+				if ok {
+					m.firewallManager.MovePortForwardingRule(selectedItem.index, selectedItem.index+1)
+					m.updatePortForwardingList()
+				}
+			case "s":
+				return m, func() tea.Msg {
+					if err := m.firewallManager.SaveConfig(); err != nil {
+						return errMsg{err}
+					}
+					return configSavedAndBackToMainMsg("Rule order saved.")
+				}
+			}
+		case portForwardingFormView:
+			// If a text input is active, let it handle the key presses
+			if m.portForwardingForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				switch m.portForwardingForm.activeTextInput {
+				case 0:
+					m.portForwardingForm.interfaceInput, cmd = m.portForwardingForm.interfaceInput.Update(msg)
+				case 2:
+					m.portForwardingForm.externalIPInput, cmd = m.portForwardingForm.externalIPInput.Update(msg)
+				case 3:
+					m.portForwardingForm.externalPortInput, cmd = m.portForwardingForm.externalPortInput.Update(msg)
+				case 4:
+					m.portForwardingForm.internalIPInput, cmd = m.portForwardingForm.internalIPInput.Update(msg)
+				case 5:
+					m.portForwardingForm.internalPortInput, cmd = m.portForwardingForm.internalPortInput.Update(msg)
+				case 6:
+					m.portForwardingForm.descriptionInput, cmd = m.portForwardingForm.descriptionInput.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.portForwardingForm.activeTextInput = -1
+					m.focusPortForwardingForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "s":
+				// Only save if no text input is active
+				if m.portForwardingForm.activeTextInput == -1 {
+					return m, m.savePortForwardingRule()
+				}
+			case "enter":
+				// If the current field is a text input, enter editing mode
+				if m.portForwardingForm.focused == 0 || m.portForwardingForm.focused == 2 || m.portForwardingForm.focused == 3 || m.portForwardingForm.focused == 4 || m.portForwardingForm.focused == 5 || m.portForwardingForm.focused == 6 {
+					m.portForwardingForm.activeTextInput = m.portForwardingForm.focused
+					m.focusPortForwardingForm() // Focus the active text input
+					return m, nil
+				}
+				// Otherwise, move to the next field (for option fields)
+				m.portForwardingForm.focused = (m.portForwardingForm.focused + 1) % 7
+				m.focusPortForwardingForm()
+			case "up":
+				m.portForwardingForm.focused = (m.portForwardingForm.focused - 1 + 7) % 7
+				m.focusPortForwardingForm()
+			case "down":
+				m.portForwardingForm.focused = (m.portForwardingForm.focused + 1) % 7
+				m.focusPortForwardingForm()
+			case "left", "right":
+				if m.portForwardingForm.focused == 1 { // Protocol
+					if m.portForwardingForm.protocol == "tcp" {
+						m.portForwardingForm.protocol = "udp"
+					} else {
+						m.portForwardingForm.protocol = "tcp"
+					}
+				}
+			}
+			return m, nil
+		case natListView:
+			m.natList, cmd = m.natList.Update(msg)
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "a": // Add new NAT rule
+				m.pushView(natFormView)
+				m.natForm = newNatForm()
+				m.natForm.isNew = true
+				m.focusNatForm()
+			case "enter":
+				selectedItem, ok := m.natList.SelectedItem().(natListItem)
+				if ok {
+					m.pushView(natFormView)
+					m.natForm = newNatForm()
+					m.natForm.isNew = false
+					m.natForm.ruleIndex = selectedItem.index
+					rule := m.firewallManager.Config.NatRules[selectedItem.index]
+					m.natForm.interfaceInput.SetValue(rule.Interface)
+					m.natForm.protocol = rule.Protocol
+					m.natForm.sourceInput.SetValue(rule.Source)
+					m.natForm.destinationInput.SetValue(rule.Destination)
+					m.natForm.translationInput.SetValue(rule.Translation)
+					m.natForm.descriptionInput.SetValue(rule.Description)
+					m.focusNatForm()
+				}
+			case "d":
+				selectedItem, ok := m.natList.SelectedItem().(natListItem)
+				if ok {
+					cmd = func() tea.Msg {
+						if err := m.firewallManager.DeleteNatRule(selectedItem.index); err != nil {
+							return errMsg{err}
+						}
+						return natRuleSavedMsg("NAT rule moved to the archive, see Archived Rules.")
+					}
+					return m, cmd
+				}
+			case "i":
+				if selectedItem, ok := m.natList.SelectedItem().(natListItem); ok {
+					m.openRuleDetails(fmt.Sprintf("NAT Rule %d", selectedItem.index+1), formatNatRuleDetails(selectedItem.rule))
+				}
+			case "k":
+				selectedItem, ok := m.natList.SelectedItem().(natListItem)
+				if ok {
+					m.firewallManager.MoveNatRule(selectedItem.index, selectedItem.index-1)
+					m.updateNatList()
+				}
+			case "j":
+				selectedItem, ok := m.natList.SelectedItem().(natListItem)
+				if ok {
+					m.firewallManager.MoveNatRule(selectedItem.index, selectedItem.index+1)
+					m.updateNatList()
+				}
+			case "s":
+				return m, func() tea.Msg {
+					if err := m.firewallManager.SaveConfig(); err != nil {
+						return errMsg{err}
+					}
+					return configSavedAndBackToMainMsg("Rule order saved.")
+				}
+			}
+		case natFormView:
+			// If a text input is active, let it handle the key presses
+			if m.natForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				switch m.natForm.activeTextInput {
+				case 0:
+					m.natForm.interfaceInput, cmd = m.natForm.interfaceInput.Update(msg)
+				case 2:
+					m.natForm.sourceInput, cmd = m.natForm.sourceInput.Update(msg)
+				case 3:
+					m.natForm.destinationInput, cmd = m.natForm.destinationInput.Update(msg)
+				case 4:
+					m.natForm.translationInput, cmd = m.natForm.translationInput.Update(msg)
+				case 5:
+					m.natForm.descriptionInput, cmd = m.natForm.descriptionInput.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.natForm.activeTextInput = -1
+					m.focusNatForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveNatRule()
+			case "enter":
+				// If the current field is a text input, enter editing mode
+				if m.natForm.focused != 1 {
+					m.natForm.activeTextInput = m.natForm.focused
+					m.focusNatForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.natForm.focused = (m.natForm.focused - 1 + 6) % 6
+				m.focusNatForm()
+			case "down":
+				m.natForm.focused = (m.natForm.focused + 1) % 6
+				m.focusNatForm()
+			case "left", "right":
+				if m.natForm.focused == 1 { // Protocol
+					options := []string{"any", "tcp", "udp", "icmp"}
+					step := 1
+					if msg.String() == "left" {
+						step = len(options) - 1
+					}
+					for i, opt := range options {
+						if opt == m.natForm.protocol {
+							m.natForm.protocol = options[(i+step)%len(options)]
+							break
+						}
+					}
+				}
+			}
+			return m, nil
+		case tableListView:
+			m.tableList, cmd = m.tableList.Update(msg)
+			switch msg.String() {
+			case "a": // Add new table
+				m.pushView(tableFormView)
+				m.tableForm = newTableForm()
+				m.tableForm.isNew = true
+				m.focusTableForm()
+			case "enter":
+				selectedItem, ok := m.tableList.SelectedItem().(tableListItem)
+				if ok {
+					m.pushView(tableFormView)
+					m.tableForm = newTableForm()
+					m.tableForm.isNew = false
+					m.tableForm.tableIndex = selectedItem.index
+					table := m.firewallManager.Config.Tables[selectedItem.index]
+					m.tableForm.nameInput.SetValue(table.Name)
+					m.tableForm.persist = map[bool]string{true: "Yes", false: "No"}[table.Persist]
+					m.tableForm.addressesInput.SetValue(strings.Join(table.Addresses, ", "))
+					m.tableForm.descriptionInput.SetValue(table.Description)
+					m.tableForm.feedURLInput.SetValue(table.FeedURL)
+					if table.FeedRefreshHours > 0 {
+						m.tableForm.feedRefreshInput.SetValue(strconv.Itoa(table.FeedRefreshHours))
+					}
+					m.focusTableForm()
+				}
+			case "d":
+				selectedItem, ok := m.tableList.SelectedItem().(tableListItem)
+				if ok {
+					return m, func() tea.Msg {
+						if err := m.firewallManager.DeleteTable(selectedItem.index); err != nil {
+							return errMsg{err}
+						}
+						return tableSavedMsg("Table deleted successfully.")
+					}
+				}
+			case "u": // Update the feed of the table now
+				selectedItem, ok := m.tableList.SelectedItem().(tableListItem)
+				if ok {
+					if selectedItem.table.FeedURL == "" {
+						m.notify(levelWarn, fmt.Sprintf("<%s> has no feed URL.", selectedItem.table.Name))
+						return m, nil
+					}
+					return m, m.updateFeed(selectedItem.table)
+				}
+			case "v": // View the entries of the loaded table
+				selectedItem, ok := m.tableList.SelectedItem().(tableListItem)
+				if ok {
+					m.pushView(tableEntriesView)
+					m.tableEntriesName = selectedItem.table.Name
+					m.tableEntryList.SetItems([]list.Item{})
+					m.tableEntryAdding = false
+					m.notifyProgress("Loading...")
+					return m, getTableEntries(m.runner, selectedItem.table.Name)
+				}
+			}
+		case tableEntriesView:
+			// While an address is typed, let the input handle the key presses
+			if m.tableEntryAdding {
+				m.tableEntryInput, cmd = m.tableEntryInput.Update(msg)
+				if msg.String() == "enter" {
+					m.tableEntryAdding = false
+					m.tableEntryInput.Blur()
+					addr := strings.TrimSpace(m.tableEntryInput.Value())
+					if err := validateTableEntry(addr); err != nil {
+						m.notify(levelError, err.Error())
+						return m, nil
+					}
+					return m, modifyTable(m.runner, m.tableEntriesName, "add", fmt.Sprintf("Added %s to <%s>.", addr, m.tableEntriesName), addr)
+				}
+				return m, cmd
+			}
+
+			m.tableEntryList, cmd = m.tableEntryList.Update(msg)
+			switch msg.String() {
+			case "a":
+				m.tableEntryAdding = true
+				m.tableEntryInput.SetValue("")
+				m.tableEntryInput.Focus()
+				return m, nil
+			case "d":
+				selectedItem, ok := m.tableEntryList.SelectedItem().(tableEntryListItem)
+				if ok {
+					return m, modifyTable(m.runner, m.tableEntriesName, "delete", fmt.Sprintf("Deleted %s from <%s>.", selectedItem.addr, m.tableEntriesName), selectedItem.addr)
+				}
+			case "f":
+				m.pushView(confirmationView)
+				m.confirming = true
+				m.confirmCmd = modifyTable(m.runner, m.tableEntriesName, "flush", fmt.Sprintf("Flushed <%s>.", m.tableEntriesName))
+				m.confirmationMessage = fmt.Sprintf("Remove all entries from the loaded table <%s>?", m.tableEntriesName)
+				return m, nil
+			case "r":
+				return m, getTableEntries(m.runner, m.tableEntriesName)
+			}
+		case tableFormView:
+			// If a text input is active, let it handle the key presses
+			if m.tableForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				switch m.tableForm.activeTextInput {
+				case 0:
+					m.tableForm.nameInput, cmd = m.tableForm.nameInput.Update(msg)
+				case 2:
+					m.tableForm.addressesInput, cmd = m.tableForm.addressesInput.Update(msg)
+				case 3:
+					m.tableForm.descriptionInput, cmd = m.tableForm.descriptionInput.Update(msg)
+				case 4:
+					m.tableForm.feedURLInput, cmd = m.tableForm.feedURLInput.Update(msg)
+				case 5:
+					m.tableForm.feedRefreshInput, cmd = m.tableForm.feedRefreshInput.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.tableForm.activeTextInput = -1
+					m.focusTableForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveTable()
+			case "enter":
+				// If the current field is a text input, enter editing mode
+				if m.tableForm.focused != 1 {
+					m.tableForm.activeTextInput = m.tableForm.focused
+					m.focusTableForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.tableForm.focused = (m.tableForm.focused - 1 + 6) % 6
+				m.focusTableForm()
+			case "down":
+				m.tableForm.focused = (m.tableForm.focused + 1) % 6
+				m.focusTableForm()
+			case "left", "right":
+				if m.tableForm.focused == 1 { // Persist
+					if m.tableForm.persist == "Yes" {
+						m.tableForm.persist = "No"
+					} else {
+						m.tableForm.persist = "Yes"
+					}
+				}
+			}
+			return m, nil
+		case macroListView:
+			m.macroList, cmd = m.macroList.Update(msg)
+			switch msg.String() {
+			case "a": // Add new macro
+				m.pushView(macroFormView)
+				m.macroForm = newMacroForm()
+				m.macroForm.isNew = true
+				m.focusMacroForm()
+			case "enter":
+				selectedItem, ok := m.macroList.SelectedItem().(macroListItem)
+				if ok {
+					m.pushView(macroFormView)
+					m.macroForm = newMacroForm()
+					m.macroForm.isNew = false
+					m.macroForm.macroIndex = selectedItem.index
+					macro := m.firewallManager.Config.Macros[selectedItem.index]
+					m.macroForm.nameInput.SetValue(macro.Name)
+					m.macroForm.valueInput.SetValue(macro.Value)
+					m.macroForm.descriptionInput.SetValue(macro.Description)
+					m.focusMacroForm()
+				}
+			case "d":
+				selectedItem, ok := m.macroList.SelectedItem().(macroListItem)
+				if ok {
+					return m, func() tea.Msg {
+						if err := m.firewallManager.DeleteMacro(selectedItem.index); err != nil {
+							return errMsg{err}
+						}
+						return macroSavedMsg("Macro deleted successfully.")
+					}
+				}
+			}
+		case pipeListView:
+			m.pipeList, cmd = m.pipeList.Update(msg)
+			switch msg.String() {
+			case "a": // Add new pipe
+				m.pushView(pipeFormView)
+				m.pipeForm = newPipeForm()
+				m.pipeForm.isNew = true
+				m.focusPipeForm()
+			case "enter":
+				selectedItem, ok := m.pipeList.SelectedItem().(pipeListItem)
+				if ok {
+					m.pushView(pipeFormView)
+					m.pipeForm = newPipeForm()
+					m.pipeForm.isNew = false
+					m.pipeForm.pipeIndex = selectedItem.index
+					pipe := m.firewallManager.Config.Pipes[selectedItem.index]
+					m.pipeForm.numberInput.SetValue(strconv.Itoa(pipe.Number))
+					m.pipeForm.bandwidthInput.SetValue(pipe.Bandwidth)
+					if pipe.Delay > 0 {
+						m.pipeForm.delayInput.SetValue(strconv.Itoa(pipe.Delay))
+					}
+					if pipe.QueueSize > 0 {
+						m.pipeForm.queueInput.SetValue(strconv.Itoa(pipe.QueueSize))
+					}
+					m.pipeForm.descriptionInput.SetValue(pipe.Description)
+					m.focusPipeForm()
+				}
+			case "d":
+				selectedItem, ok := m.pipeList.SelectedItem().(pipeListItem)
+				if ok {
+					return m, func() tea.Msg {
+						if err := m.firewallManager.DeletePipe(selectedItem.index); err != nil {
+							return errMsg{err}
+						}
+						return pipeSavedMsg("Pipe deleted successfully.")
+					}
+				}
+			}
+		case pipeFormView:
+			// If a text input is active, let it handle the key presses
+			if m.pipeForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				if input := m.pipeForm.input(m.pipeForm.activeTextInput); input != nil {
+					*input, cmd = input.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.pipeForm.activeTextInput = -1
+					m.focusPipeForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.savePipe()
+			case "enter":
+				// All pipe fields are text inputs
+				m.pipeForm.activeTextInput = m.pipeForm.focused
+				m.focusPipeForm() // Focus the active text input
+				return m, nil
+			case "up":
+				m.pipeForm.focused = (m.pipeForm.focused - 1 + len(pipeFieldLabels)) % len(pipeFieldLabels)
+			case "down":
+				m.pipeForm.focused = (m.pipeForm.focused + 1) % len(pipeFieldLabels)
+			}
+			return m, nil
+		case timeoutsFormView:
+			if m.timeoutsForm.loading || len(m.timeoutsForm.inputs) == 0 {
+				return m, nil
+			}
+			// If a text input is active, let it handle the key presses
+			if m.timeoutsForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				input := &m.timeoutsForm.inputs[m.timeoutsForm.activeTextInput]
+				*input, cmd = input.Update(msg)
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.timeoutsForm.activeTextInput = -1
+					input.Blur()
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveTimeouts()
+			case "enter":
+				// All timeout fields are text inputs
+				m.timeoutsForm.activeTextInput = m.timeoutsForm.focused
+				m.timeoutsForm.inputs[m.timeoutsForm.focused].Focus()
+				return m, nil
+			case "up":
+				m.timeoutsForm.focused = (m.timeoutsForm.focused - 1 + len(m.timeoutsForm.inputs)) % len(m.timeoutsForm.inputs)
+			case "down":
+				m.timeoutsForm.focused = (m.timeoutsForm.focused + 1) % len(m.timeoutsForm.inputs)
+			}
+			return m, nil
+		case optionsFormView:
+			// If a text input is active, let it handle the key presses
+			if m.optionsForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				if input := m.optionsForm.textInput(m.optionsForm.activeTextInput); input != nil {
+					*input, cmd = input.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.optionsForm.activeTextInput = -1
+					m.focusOptionsForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveOptions()
+			case "enter":
+				if m.optionsForm.textInput(m.optionsForm.focused) != nil {
+					m.optionsForm.activeTextInput = m.optionsForm.focused
+					m.focusOptionsForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.optionsForm.focused = (m.optionsForm.focused - 1 + optionsFieldCount) % optionsFieldCount
+			case "down":
+				m.optionsForm.focused = (m.optionsForm.focused + 1) % optionsFieldCount
+			case "left", "right":
+				if options, selected := m.optionsForm.optionField(m.optionsForm.focused); options != nil {
+					delta := 1
+					if msg.String() == "left" {
+						delta = -1
+					}
+					for i, opt := range options {
+						if opt == *selected {
+							*selected = options[(i+delta+len(options))%len(options)]
+							break
+						}
+					}
+				}
+			}
+			return m, nil
+		case sharingFormView:
+			// If a text input is active, let it handle the key presses
+			if m.sharingForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				if input := m.sharingForm.textInput(m.sharingForm.activeTextInput); input != nil {
+					*input, cmd = input.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.sharingForm.activeTextInput = -1
+					m.focusSharingForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveInternetSharing()
+			case "enter":
+				m.sharingForm.activeTextInput = m.sharingForm.focused
+				m.focusSharingForm() // Focus the active text input
+				return m, nil
+			case "up":
+				m.sharingForm.focused = (m.sharingForm.focused - 1 + sharingFieldCount) % sharingFieldCount
+			case "down":
+				m.sharingForm.focused = (m.sharingForm.focused + 1) % sharingFieldCount
+			case "left", "right":
+				// Cycle the focused field through the interfaces of this machine
+				interfaces := m.sharingForm.interfaces
+				if input := m.sharingForm.textInput(m.sharingForm.focused); input != nil && len(interfaces) > 0 {
+					delta := 1
+					if msg.String() == "left" {
+						delta = -1
+					}
+					next := 0
+					for i, name := range interfaces {
+						if name == input.Value() {
+							next = (i + delta + len(interfaces)) % len(interfaces)
+							break
+						}
+					}
+					input.SetValue(interfaces[next])
+				}
+			}
+			return m, nil
+		case settingsFormView:
+			// If a text input is active, let it handle the key presses
+			if m.settingsForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				if input := m.settingsForm.textInput(m.settingsForm.activeTextInput); input != nil {
+					*input, cmd = input.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.settingsForm.activeTextInput = -1
+					m.focusSettingsForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveSettings()
+			case "b":
+				m.notifyProgress("Backing up...")
+				return m, runScheduledBackup(m.firewallManager.Config.Settings)
+			case "enter":
+				if m.settingsForm.textInput(m.settingsForm.focused) != nil {
+					m.settingsForm.activeTextInput = m.settingsForm.focused
+					m.focusSettingsForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.settingsForm.focused = (m.settingsForm.focused - 1 + settingsFieldCount) % settingsFieldCount
+			case "down":
+				m.settingsForm.focused = (m.settingsForm.focused + 1) % settingsFieldCount
+			case "left", "right":
+				if m.settingsForm.focused == settingsFieldAutoBan {
+					if m.settingsForm.autoBan == "Yes" {
+						m.settingsForm.autoBan = "No"
+					} else {
+						m.settingsForm.autoBan = "Yes"
+					}
+				}
+			}
+			return m, nil
+		case scrubFormView:
+			// If a text input is active, let it handle the key presses
+			if m.scrubForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				m.scrubForm.interfaceInput, cmd = m.scrubForm.interfaceInput.Update(msg)
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.scrubForm.activeTextInput = -1
+					m.focusScrubForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveScrubOptions()
+			case "enter":
+				if m.scrubForm.focused == scrubFieldInterface {
+					m.scrubForm.activeTextInput = scrubFieldInterface
+					m.focusScrubForm() // Focus the active text input
+					return m, nil
+				}
+			case "up":
+				m.scrubForm.focused = (m.scrubForm.focused - 1 + scrubFieldCount) % scrubFieldCount
+			case "down":
+				m.scrubForm.focused = (m.scrubForm.focused + 1) % scrubFieldCount
+			case "left", "right":
+				if options, selected := m.scrubForm.optionField(m.scrubForm.focused); options != nil {
+					delta := 1
+					if msg.String() == "left" {
+						delta = -1
+					}
+					for i, opt := range options {
+						if opt == *selected {
+							*selected = options[(i+delta+len(options))%len(options)]
+							break
+						}
+					}
+				}
+			}
+			return m, nil
+		case macroFormView:
+			// If a text input is active, let it handle the key presses
+			if m.macroForm.activeTextInput != -1 {
+				var cmd tea.Cmd
+				switch m.macroForm.activeTextInput {
+				case 0:
+					m.macroForm.nameInput, cmd = m.macroForm.nameInput.Update(msg)
+				case 1:
+					m.macroForm.valueInput, cmd = m.macroForm.valueInput.Update(msg)
+				case 2:
+					m.macroForm.descriptionInput, cmd = m.macroForm.descriptionInput.Update(msg)
+				}
+
+				if msg.String() == "enter" {
+					// Finalize input and unfocus
+					m.macroForm.activeTextInput = -1
+					m.focusMacroForm() // Blur all text inputs
+					return m, nil
+				}
+				return m, cmd
+			}
+
+			switch msg.String() {
+			case "s":
+				return m, m.saveMacro()
+			case "enter":
+				// All macro fields are text inputs
+				m.macroForm.activeTextInput = m.macroForm.focused
+				m.focusMacroForm() // Focus the active text input
+				return m, nil
+			case "up":
+				m.macroForm.focused = (m.macroForm.focused - 1 + 3) % 3
+				m.focusMacroForm()
+			case "down":
+				m.macroForm.focused = (m.macroForm.focused + 1) % 3
+				m.focusMacroForm()
+			}
+			return m, nil
+		case pflogView:
+			// While the filter is edited, let it handle the key presses
+			if m.pflogFiltering {
+				m.pflogFilterInput, cmd = m.pflogFilterInput.Update(msg)
+				if msg.String() == "enter" {
+					m.pflogFiltering = false
+					m.pflogFilterInput.Blur()
+				}
+				m.refreshPflogView()
+				return m, cmd
+			}
+
+			// After "b", the next key picks how the address is blocked
+			if addr := m.pflogBlockAddr; addr != "" {
+				m.pflogBlockAddr = ""
+				switch msg.String() {
+				case "r":
+					return m, quickBlock(m.firewallManager.BlockAddress, addr, fmt.Sprintf("Added a quick rule blocking %s.", addr))
+				case "t":
+					return m, quickBlock(m.firewallManager.AddToBlocklist, addr, fmt.Sprintf("Added %s to <%s>.", addr, config.BlocklistTable))
+				}
+				m.clearToast()
+				return m, nil
+			}
+
+			switch msg.String() {
+			case "q":
+				m.stopPflog()
+				m.popView()
+				return m, nil
+			case "b":
+				if m.pflogCursor < len(m.pflogVisible) {
+					if addr := pfcli.PflogSourceAddress(m.pflogVisible[m.pflogCursor]); addr != "" {
+						m.pflogBlockAddr = addr
+						m.notifyProgress(fmt.Sprintf("Block %s: 'r' quick rule | 't' add to <%s> | any other key: cancel", addr, config.BlocklistTable))
+						return m, nil
+					}
+				}
+				m.notify(levelWarn, "The selected line has no source address.")
+				return m, nil
+			case "w":
+				if m.pflogCursor < len(m.pflogVisible) {
+					if addr := pfcli.PflogSourceAddress(m.pflogVisible[m.pflogCursor]); addr != "" {
+						return m, m.openWhois(addr)
+					}
+				}
+				m.notify(levelWarn, "The selected line has no source address.")
+				return m, nil
+			case "p":
+				m.pflogPaused = !m.pflogPaused
+				m.refreshPflogView()
+				return m, nil
+			case "f":
+				m.pflogFollow = !m.pflogFollow
+				m.refreshPflogView()
+				return m, nil
+			case "/":
+				m.pflogFiltering = true
+				m.pflogFilterInput.Focus()
+				return m, nil
+			case "c":
+				m.pflogLines = nil
+				m.refreshPflogView()
+				return m, nil
+			case "n":
+				m.resolveNames = !m.resolveNames
+				m.refreshPflogView()
+				var addrs []string
+				for _, line := range m.pflogLines {
+					addrs = append(addrs, pfcli.PflogSourceAddress(line), pfcli.PflogDestinationAddress(line))
+				}
+				return m, m.resolveAddresses(addrs...)
+			case "up", "k", "pgup":
+				// Moving back stops following the newest lines
+				m.pflogFollow = false
+				m.pflogCursor--
+				if msg.String() == "pgup" {
+					m.pflogCursor -= m.viewport.Height - 1
+				}
+				m.refreshPflogView()
+			case "down", "j", "pgdown":
+				m.pflogCursor++
+				if msg.String() == "pgdown" {
+					m.pflogCursor += m.viewport.Height - 1
+				}
+				m.refreshPflogView()
+			}
+			return m, nil
+		case applyPreviewView:
+			switch msg.String() {
+			case "y", "enter":
+				if m.applyPreviewReady && len(m.lockoutWarnings) > 0 {
+					// The confirmation takes the place of the preview
+					m.popView()
+					m.pushView(confirmationView)
+					m.confirming = true
+					m.confirmCmd = saveAndApplyRules(m.firewallManager, m.runner)
+					m.confirmationMessage = lockoutConfirmation(m.lockoutWarnings, "Apply anyway?")
+					return m, nil
+				}
+				if m.applyPreviewReady {
+					m.popView()
+					return m, saveAndApplyRules(m.firewallManager, m.runner)
+				}
+				return m, nil
+			case "n", "q":
+				m.notify(levelInfo, "Apply cancelled.")
+				m.unsavedNext = nil
+				m.popView()
+				return m, nil
+			}
+			m.viewport, cmd = m.viewport.Update(msg)
+		case unsavedChangesView:
+			switch msg.String() {
+			case "a":
+				// The preview takes the place of the question
+				m.popView()
+				return m, m.openApplyPreview()
+			case "s":
+				return m, func() tea.Msg {
+					if err := m.firewallManager.SaveConfig(); err != nil {
+						return errMsg{err}
+					}
+					return unsavedResolvedMsg("Configuration saved.")
+				}
+			case "d":
+				return m, func() tea.Msg {
+					if err := m.firewallManager.LoadConfig(); err != nil {
+						return errMsg{err}
+					}
+					return unsavedResolvedMsg("Changes discarded.")
+				}
+			}
+			return m, nil
+		case rollbackView:
+			rollback := m.rollback
+			if rollback == nil {
+				return m, nil
+			}
+			switch msg.String() {
+			case "y", "enter":
+				m.rollback = nil
+				return m, confirmRollback(rollback)
+			case "r":
+				m.rollback = nil
+				return m, revertRollback(rollback)
+			}
+			return m, nil
+		case whoisView:
+			m.viewport, cmd = m.viewport.Update(msg)
+			if msg.String() == "q" {
+				return m, m.closeWhois()
+			}
+		case ruleDetailView:
+			m.viewport, cmd = m.viewport.Update(msg)
+			if msg.String() == "q" {
+				m.popView()
+				return m, nil
+			}
+		case infoView:
+			if m.infoViewTitle == "Top Talkers" {
+				// Up/Down select a host instead of scrolling
+				switch msg.String() {
+				case "up", "k":
+					if m.topTalkerCursor > 0 {
+						m.topTalkerCursor--
+					}
+					m.showTopTalkers()
+					return m, nil
+				case "down", "j":
+					m.topTalkerCursor++
+					m.showTopTalkers()
+					return m, nil
+				case "w":
+					if m.topTalkerCursor < len(m.topTalkers) {
+						return m, m.openWhois(m.topTalkers[m.topTalkerCursor].Host)
+					}
+					return m, nil
+				}
+			}
+			if m.searchable() {
+				if cmd, ok := m.updateSearch(msg); ok {
+					return m, cmd
+				}
+			}
+			if msg.String() == " " && m.refreshesLive() {
+				// Space pauses instead of paging down
+				return m, m.togglePause()
+			}
+			m.viewport, cmd = m.viewport.Update(msg)
+			switch msg.String() {
+			case "esc", "q":
+				m.popView()
+				return m, nil
+			case "r":
+				return m, m.refreshNow()
+			case "a":
+				// Switch between all loaded rules and the pf-tui anchor only
+				switch m.infoViewTitle {
+				case "Current Live PF Rules":
+					m.infoViewTitle = "pf-tui Anchor Rules"
+					m.viewport.SetContent("Loading...")
+					return m, asOperation("Reading the anchor rules", getAnchorRules(m.runner))
+				case "pf-tui Anchor Rules":
+					m.infoViewTitle = "Current Live PF Rules"
+					m.viewport.SetContent("Loading...")
+					return m, asOperation("Reading the rules", getCurrentRules(m.runner))
+				}
+			case "+", "=", "-":
+				if m.infoViewTitle == "Live PF Info" {
+					if msg.String() == "-" {
+						m.stepInfoRefresh(-1)
+					} else {
+						m.stepInfoRefresh(1)
+					}
+				}
+			case "n":
+				if m.infoViewTitle == "Top Talkers" {
+					m.resolveNames = !m.resolveNames
+					m.showTopTalkers()
+					return m, m.resolveTopTalkers()
+				}
+			case "c":
+				if m.infoViewTitle == "Generated pf.conf" {
+					conf := m.firewallManager.GeneratePfConf()
+					return m, func() tea.Msg {
+						if err := CopyToClipboard(conf); err != nil {
+							return errMsg{err}
+						}
+						return copiedMsg("Generated pf.conf copied to the clipboard.")
+					}
+				}
+			case "s":
+				if m.infoViewTitle == "Generated pf.conf" {
+					m.openExport("pf-tui.conf", nil)
+					m.exportFormat = "pf.conf"
+					return m, nil
+				}
+			}
+		case saveConfigView:
+			switch msg.String() {
+			case "tab":
+				// Complete the directory being typed
+				path, matches := config.CompleteDirectory(m.textinput.Value())
+				m.textinput.SetValue(path)
+				m.textinput.CursorEnd()
+				m.exportMatches = matches
+				return m, nil
+			case "up", "down":
+				// Switch the directory between the recent export destinations
+				if len(m.recentExports) == 0 {
+					return m, nil
+				}
+				if msg.String() == "up" {
+					m.recentExportIndex = (m.recentExportIndex + 1) % len(m.recentExports)
+				} else if m.recentExportIndex--; m.recentExportIndex < 0 {
+					m.recentExportIndex = len(m.recentExports) - 1
+				}
+				m.textinput.SetValue(filepath.Join(m.recentExports[m.recentExportIndex], filepath.Base(m.textinput.Value())))
+				m.textinput.CursorEnd()
+				m.exportMatches = nil
+				return m, nil
+			}
+			m.textinput, cmd = m.textinput.Update(msg)
+			m.exportMatches = nil
+			switch msg.String() {
+			case "esc":
+				m.popView()
+			case "enter":
+				path := config.ExpandHome(m.textinput.Value())
+				m.textinput.SetValue(path)
+				if path != "" {
+					// Check if file exists
+					if _, err := os.Stat(path); err == nil {
+						m.pushView(confirmationView)
+						m.confirming = true
+						m.confirmationMessage = fmt.Sprintf("File '%s' already exists. Overwrite?", path)
+						return m, nil
+					}
+					return m, m.exportConfig(path)
+				}
+			case "shift+tab":
+				// Switch the format, and the extension of the file name with it
+				m.exportFormat = exportFormats[(slices.Index(exportFormats, m.exportFormat)+1)%len(exportFormats)]
+				path := m.textinput.Value()
+				if ext := filepath.Ext(path); config.IsConfigFile(path) || ext == ".conf" {
+					m.textinput.SetValue(strings.TrimSuffix(path, ext) + exportExtension(m.exportFormat))
+				}
+				m.textinput.CursorEnd()
+			}
+		case importConfigView:
+			if m.browseTyping {
+				m.textinput, cmd = m.textinput.Update(msg)
+				if msg.String() == "enter" {
+					m.browseTyping = false
+					m.textinput.Blur()
+					return m, m.openBrowsePath(m.textinput.Value())
+				}
+				return m, cmd
+			}
+			m.fileList, cmd = m.fileList.Update(msg)
+			switch msg.String() {
+			case "enter":
+				selectedItem, ok := m.fileList.SelectedItem().(fileInfo)
+				if ok {
+					return m, m.openBrowsePath(selectedItem.path)
+				}
+			case "backspace":
+				return m, m.updateFileList(filepath.Dir(m.browseDir))
+			case "~":
+				home, _ := os.UserHomeDir()
+				return m, m.updateFileList(home)
+			case ".":
+				m.browseHidden = !m.browseHidden
+				return m, m.updateFileList(m.browseDir)
+			case "/":
+				m.browseTyping = true
+				m.textinput.SetValue(m.browseDir + string(filepath.Separator))
+				m.textinput.Focus()
+				m.textinput.CursorEnd()
+				return m, nil
+			case "esc":
+				m.popView()
+			}
+			return m, cmd
+		case ruleGroupView:
+			m.textinput, cmd = m.textinput.Update(msg)
+			if msg.String() == "enter" {
+				ids, group := m.groupRuleIDs, strings.TrimSpace(m.textinput.Value())
+				m.textinput.Blur()
+				return m, func() tea.Msg {
+					moved, err := m.firewallManager.SetFirewallRulesGroup(ids, group)
+					if err != nil {
+						return errMsg{err}
+					}
+					if group == "" {
+						return firewallRuleSavedMsg(fmt.Sprintf("%d rules moved out of their groups.", moved))
+					}
+					return firewallRuleSavedMsg(fmt.Sprintf("%d rules moved to group %s.", moved, group))
+				}
+			}
+			return m, cmd
+		case pfConfPathView:
+			m.textinput, cmd = m.textinput.Update(msg)
+			if msg.String() == "enter" && m.textinput.Value() != "" {
+				return m, parsePfConfFile(m.runner, m.textinput.Value())
+			}
+			return m, cmd
+		case importPreviewView:
+			switch msg.String() {
+			case "enter", "y":
+				if m.importPreview != nil && m.importPreview.Err == nil {
+					path := m.importPreview.Path
+					return m, m.withUnsavedChanges(func() tea.Cmd { return importConfig(m.firewallManager, path) })
+				}
+				return m, nil
+			case "q", "n":
+				m.popView()
+				return m, nil
+			}
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		case pfConfImportView:
+			switch msg.String() {
+			case "enter", "y":
+				if m.pfConfImport != nil && len(m.pfConfImport.Recognized) > 0 {
+					imp := *m.pfConfImport
+					return m, m.withUnsavedChanges(func() tea.Cmd { return mergeImport(m.firewallManager, imp) })
+				}
+				return m, nil
+			case "q":
+				m.popView()
+				return m, nil
+			}
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		case backupsView:
+			switch msg.String() {
+			case "enter", "r":
+				if selected, ok := m.backupList.SelectedItem().(backupListItem); ok {
+					return m, m.withUnsavedChanges(func() tea.Cmd { return restoreBackup(m.firewallManager, selected.backup) })
+				}
+				return m, nil
+			}
+			m.backupList, cmd = m.backupList.Update(msg)
+			return m, cmd
+		case archiveView:
+			selected, ok := m.archiveList.SelectedItem().(archiveListItem)
+			switch msg.String() {
+			case "enter", "r":
+				if ok {
+					return m, restoreArchivedRule(m.firewallManager, selected.index)
+				}
+				return m, nil
+			case "d":
+				if ok {
+					m.pushView(confirmationView)
+					m.confirming = true
+					m.confirmCmd = purgeArchivedRule(m.firewallManager, selected.index)
+					m.confirmationMessage = fmt.Sprintf("Delete the archived %s rule for good?", selected.rule.Kind())
+				}
+				return m, nil
+			case "D":
+				if len(m.firewallManager.Config.Archive) > 0 {
+					m.pushView(confirmationView)
+					m.confirming = true
+					m.confirmCmd = purgeArchive(m.firewallManager)
+					m.confirmationMessage = fmt.Sprintf("Delete all %d archived rules for good?", len(m.firewallManager.Config.Archive))
+				}
+				return m, nil
+			case "i":
+				if ok {
+					m.openRuleDetails("Archived "+selected.rule.Kind()+" Rule", formatArchivedRuleDetails(selected.rule))
+				}
+				return m, nil
+			}
+			m.archiveList, cmd = m.archiveList.Update(msg)
+			return m, cmd
+		case historyView:
+			selected, ok := m.historyList.SelectedItem().(snapshotListItem)
+			switch msg.String() {
+			case " ", "m":
+				if ok {
+					if m.historyBase.Equal(selected.snapshot.Time) {
+						m.historyBase = time.Time{}
+					} else {
+						m.historyBase = selected.snapshot.Time
+					}
+				}
+				return m, nil
+			case "c":
+				m.historyShowConfig = !m.historyShowConfig
+				return m, nil
+			case "pgdown":
+				m.historyDiffOffset += 10
+				return m, nil
+			case "pgup":
+				m.historyDiffOffset -= 10
+				if m.historyDiffOffset < 0 {
+					m.historyDiffOffset = 0
+				}
+				return m, nil
+			case "r":
+				if ok {
+					m.pushView(confirmationView)
+					m.confirming = true
+					m.confirmCmd = restoreSnapshot(m.firewallManager, selected.snapshot)
+					m.confirmationMessage = fmt.Sprintf("Restore the configuration applied at %s and review it for Save & Apply?",
+						selected.snapshot.Time.Format("2006-01-02 15:04:05"))
+					if m.firewallManager.IsDirty() {
+						m.confirmationMessage += "\nThe unsaved changes of the current configuration are lost."
+					}
+				}
+				return m, nil
+			}
+			m.historyList, cmd = m.historyList.Update(msg)
+			return m, cmd
+		}
+
+	case tea.FocusMsg:
+		m.unfocused = false
+		return m, m.resumeRefresh()
+
+	case tea.BlurMsg:
+		// The refresh timers stop at their next tick, see resumeRefresh
+		m.unfocused = true
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		h, v := appStyle.GetFrameSize()
+		m.list.SetSize(msg.Width-h, msg.Height-v-4)
+		m.sizeRuleList()
+		m.portForwardingList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.natList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.tableList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.tableEntryList.SetSize(msg.Width-h, msg.Height-v-6)
+		m.macroList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.pipeList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.fileList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.backupList.SetSize(msg.Width-h, (msg.Height-v-4)/2)
+		m.archiveList.SetSize(msg.Width-h, (msg.Height-v-4)/2)
+		m.historyList.SetSize(msg.Width-h, (msg.Height-v-4)/2)
+		m.serviceList.SetSize(msg.Width-h, msg.Height-v-4)
+		m.viewport.Width = msg.Width - h
+		m.viewport.Height = msg.Height - v - 4
+		m.help.Width = msg.Width - h
+
+	case pfStatusMsg:
+		m.pfStatus = string(msg)
+		return m, nil
+
+	case pfStartupStatusMsg:
+		m.startupStatus = string(msg)
+		return m, nil
+
+	case statesFlushedMsg:
+		m.notify(levelInfo, string(msg))
+		return m, nil
+
+	case pfReleasedMsg:
+		m.pfStatus = msg.status
+		m.notify(levelInfo, msg.message)
+		return m, nil
+
+	case panicModeMsg:
+		m.panicMode = msg.active
+		if msg.status != "" {
+			m.notify(levelWarn, msg.status)
+		}
+		return m, nil
+
+	case pflogLineMsg:
+		if msg.stream != m.pflog {
+			return m, nil // from a stream that has been stopped
+		}
+		m.pflogLines = append(m.pflogLines, msg.line)
+		if len(m.pflogLines) > maxPflogLines {
+			m.pflogLines = m.pflogLines[len(m.pflogLines)-maxPflogLines:]
+		}
+		if m.currentView == pflogView && !m.pflogPaused {
+			m.refreshPflogView()
+		}
+		return m, tea.Batch(
+			waitForPflog(msg.stream),
+			m.resolveAddresses(pfcli.PflogSourceAddress(msg.line), pfcli.PflogDestinationAddress(msg.line)),
+		)
+
+	case quickBlockedMsg:
+		m.notify(levelInfo, string(msg))
+		m.pushView(confirmationView)
+		m.confirming = true
+		m.confirmCmd = applyQuickBlock(m.firewallManager, m.runner)
+		m.confirmationMessage = string(msg) + " Apply the configuration now?"
+		if warnings := m.firewallManager.LockoutWarnings(m.sshSession); len(warnings) > 0 {
+			m.confirmationMessage = lockoutConfirmation(warnings, string(msg)+" Apply the configuration anyway?")
+		}
+		return m, nil
+
+	case quickBlockAppliedMsg:
+		m.panicMode = false
+		m.notify(levelInfo, string(msg))
+		return m, checkAppliedAnchor(m.runner, m.applied)
+
+	case pflogClosedMsg:
+		if msg.stream == m.pflog {
+			m.pflogLines = append(m.pflogLines, "-- tcpdump exited --")
+			if m.currentView == pflogView {
+				m.refreshPflogView()
+			}
+		}
+		return m, nil
+
+	case pfInfoMsg:
+		m.infoContent = formatStatsHistory(m.stats) + "\n" + string(msg)
+		m.showInfoContent()
+		return m, nil
+
+	case pfUsageMsg:
+		for _, usage := range msg {
+			if usage.Name == "states" {
+				m.stateUsage = usage
+			}
+		}
+		if m.currentView == infoView && m.infoViewTitle == "PF Memory & Limits" {
+			m.viewport.SetContent(formatPfUsage(msg))
+		}
+		return m, nil
+
+	case importPreviewMsg:
+		preview := config.ConfigPreview(msg)
+		m.importPreview = &preview
+		m.pushView(importPreviewView)
+		m.viewport.SetContent(formatConfigPreview(preview, m.firewallManager.Config))
+		m.viewport.GotoTop()
+		return m, nil
+
+	case pfConfParsedMsg:
+		m.pfConfImport = &msg.imp
+		m.pfConfImportSource = msg.source
+		m.pushView(pfConfImportView)
+		m.viewport.SetContent(formatPfConfImport(msg.imp))
+		m.viewport.GotoTop()
+		return m, nil
+
+	case competingRulesMsg:
+		m.competing = msg.competing
+		m.pfTuiLoaded = msg.pfTuiLoaded
+		if m.currentView == infoView && m.infoViewTitle == "Competing Rules" {
+			m.viewport.SetContent(formatCompetingRules(msg.competing, msg.pfTuiLoaded))
+		}
+		return m, nil
+
+	case appliedAnchorMsg:
+		m.applied = &msg
+		return m, nil
+
+	case usageTickMsg:
+		// Keep the state table, competing rules and applied rules in the main header current
+		runner := m.runner
+		return m, tea.Batch(
+			checkAppliedAnchor(m.runner, m.applied),
+			func() tea.Msg {
+				// Errors are only logged, the check runs in the background
+				usages, err := pfcli.GetPfUsage(runner)
+				if err != nil {
+					logging.Error(fmt.Sprintf("Failed to check pf usage: %v", err))
+					return nil
+				}
+				return pfUsageMsg(usages)
+			},
+			func() tea.Msg {
+				competing, pfTuiLoaded, err := pfcli.DetectCompetingRules(runner)
+				if err != nil {
+					logging.Error(fmt.Sprintf("Failed to check for competing rules: %v", err))
+					return nil
+				}
+				return competingRulesMsg{competing, pfTuiLoaded}
+			},
+			tea.Tick(30*time.Second, func(t time.Time) tea.Msg {
+				return usageTickMsg{}
+			}),
+		)
+
+	case topTalkersMsg:
+		if m.currentView == infoView && m.infoViewTitle == "Top Talkers" {
+			m.topTalkers = msg
+			m.showTopTalkers()
+			return m, m.resolveTopTalkers()
+		}
+		return m, nil
+
+	case hostnameResolvedMsg:
+		if m.currentView == pflogView && !m.pflogPaused {
+			m.refreshPflogView()
+		} else if m.currentView == infoView && m.infoViewTitle == "Top Talkers" {
+			m.showTopTalkers()
+		}
+		return m, nil
+
+	case whoisMsg:
+		if m.currentView == whoisView && m.whoisTitle == "Whois "+msg.addr {
+			m.viewport.SetContent(msg.result)
+		}
+		return m, nil
+
+	case topTalkersRefreshMsg:
+		if m.currentView == infoView && m.infoViewTitle == "Top Talkers" {
+			return m, tea.Batch(
+				getTopTalkers(m.runner),
+				tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+					return topTalkersRefreshMsg{}
+				}),
+			)
+		}
+		return m, nil
+
+	case statesMsg:
+		if m.currentView == infoView && m.infoViewTitle == "PF States" {
+			m.viewport.SetContent(formatStates(msg, m.geoip))
+		}
+		return m, nil
+
+	case statesRefreshMsg:
+		m.statesTimerRunning = false
+		if m.currentView == infoView && m.infoViewTitle == "PF States" && !m.unfocused && !m.refreshPaused {
+			return m, tea.Batch(getStates(m.runner), m.scheduleStatesRefresh())
+		}
+		return m, nil
+
+	case statsMsg:
+		m.stats = msg
+		return m, tea.Tick(config.StatsInterval, func(t time.Time) tea.Msg {
+			return statsTickMsg{}
+		})
+
+	case feedTickMsg:
+		// Download the blocklist feeds that are due, then check again later
+		return m, tea.Batch(
+			m.updateDueFeeds(),
+			tea.Tick(config.FeedCheckInterval, func(t time.Time) tea.Msg {
+				return feedTickMsg{}
+			}),
+		)
+
+	case backupTickMsg:
+		// Back up when the interval has passed since the last backup, then check again later
+		var backup tea.Cmd
+		if config.BackupDue(m.firewallManager.Config.Settings) {
+			backup = runScheduledBackup(m.firewallManager.Config.Settings)
+		}
+		return m, tea.Batch(
+			backup,
+			tea.Tick(config.BackupCheckInterval, func(t time.Time) tea.Msg {
+				return backupTickMsg{}
+			}),
+		)
+
+	case scheduledBackupMsg:
+		if msg.err != nil {
+			m.notify(levelError, fmt.Sprintf("Scheduled backup failed: %v", msg.err))
+			return m, nil
+		}
+		if m.currentView == settingsFormView {
+			m.settingsForm.lastBackup = time.Now()
+			m.notify(levelInfo, fmt.Sprintf("Backed up to %s", msg.dir))
+		}
+		return m, nil
+
+	case feedUpdatedMsg:
+		delete(m.feedsUpdating, msg.Table)
+		m.feedStatus[msg.Table] = config.FeedStatus(msg)
+		if m.currentView == tableListView {
+			if msg.Err != nil {
+				m.notify(levelError, fmt.Sprintf("Failed to update the feed of <%s>: %v", msg.Table, msg.Err))
+			} else {
+				m.notify(levelInfo, fmt.Sprintf("Updated <%s> with %d entries.", msg.Table, msg.Entries))
+			}
+			m.updateTableList()
+		}
+		return m, nil
+
+	case statsTickMsg:
+		fm, runner := m.firewallManager, m.runner
+		return m, func() tea.Msg {
+			// Errors are only logged, the samples are taken in the background
+			samples, err := fm.RecordStatsSample(runner)
+			if err != nil {
+				logging.Error(fmt.Sprintf("Failed to record statistics: %v", err))
+			}
+			if samples == nil {
+				samples = m.stats
+			}
+			return statsMsg(samples)
+		}
+
+	case infoRefreshMsg:
+		m.infoTimerRunning = false
+		if m.currentView == infoView && m.infoViewTitle == "Live PF Info" && m.pfStatus == "Enabled" && !m.unfocused && !m.refreshPaused {
+			return m, tea.Batch(getPfInfo(m.runner), m.scheduleInfoRefresh())
+		}
+		return m, nil
+
+	case currentRulesMsg:
+		m.infoContent = string(msg)
+		m.showInfoContent()
+		return m, nil
+
+	case firewallRuleSavedMsg:
+		m.notify(levelInfo, string(msg))
+		// Deleted rules are no longer selected
+		for id := range m.markedRules {
+			if m.firewallManager.FindFirewallRule(id) == -1 {
+				delete(m.markedRules, id)
+			}
+		}
+		m.backTo(ruleListView)
+		return m, m.updateRuleList()
+
+	case rulesDeletedMsg, deleteUndoneMsg:
+		return m.handleDeleteMsg(msg)
+
+	case portForwardingRuleSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(portForwardingListView)
+		m.updatePortForwardingList()
+		return m, nil
+
+	case ruleCountersMsg:
+		m.ruleCounters = msg
+		return m, nil
+
+	case natRuleSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(natListView)
+		m.updateNatList()
+		return m, nil
+
+	case tableEntriesMsg:
+		items := []list.Item{}
+		for i, addr := range msg {
+			items = append(items, tableEntryListItem{addr: addr, index: i})
+		}
+		m.tableEntryList.SetItems(items)
+		if m.notifications.progress {
+			m.clearToast()
+		}
+		return m, nil
+
+	case tableEntriesChangedMsg:
+		m.notify(levelInfo, string(msg))
+		return m, getTableEntries(m.runner, m.tableEntriesName)
+
+	case tableSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(tableListView)
+		m.updateTableList()
+		// Download the feed of a new or changed feed table right away
+		return m, m.updateDueFeeds()
+
+	case macroSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(macroListView)
+		m.updateMacroList()
+		return m, nil
+
+	case pipeSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(pipeListView)
+		m.updatePipeList()
+		return m, nil
+
+	case timeoutsMsg:
+		if m.currentView == timeoutsFormView {
+			m.timeoutsForm = newTimeoutsForm(msg, m.firewallManager.Config.Options.Timeouts)
+		}
+		return m, nil
+
+	case optionsSavedMsg:
+		m.notify(levelInfo, string(msg))
+		// From the global options or the timeouts
+		m.closeView(optionsFormView)
+		m.closeView(timeoutsFormView)
+		return m, nil
+
+	case scrubSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.closeView(scrubFormView)
+		return m, nil
+
+	case ipForwardingMsg:
+		if bool(msg) {
+			m.sharingForm.forwarding = "on"
+		} else {
+			m.sharingForm.forwarding = "off"
+		}
+		return m, nil
+
+	case sharingSavedMsg:
+		m.notify(levelInfo, string(msg))
+		m.closeView(sharingFormView)
+		return m, nil
+
+	case settingsSavedMsg:
+		m.geoip.Close()
+		m.geoip = msg.geoip
+		m.notify(levelInfo, msg.status)
+		m.infoRefreshSeconds = 0
+		m.closeView(settingsFormView)
+		return m, m.restartAutoBan(msg.settings)
+
+	case autoBanMsg:
+		if msg.banner != m.autoBan {
+			return m, nil // from a watcher that has been stopped
+		}
+		if msg.event.Err != nil {
+			m.notify(levelError, fmt.Sprintf("Auto-ban of %s failed: %v", msg.event.Addr, msg.event.Err))
+		} else {
+			m.notify(levelWarn, fmt.Sprintf("Auto-banned %s after %d blocked packets.", msg.event.Addr, msg.event.Attempts))
+		}
+		return m, waitForAutoBan(msg.banner)
+
+	case autoBanClosedMsg:
+		if msg.banner == m.autoBan {
+			m.autoBan = nil
+			m.notify(levelWarn, "Auto-ban stopped: tcpdump on pflog0 exited.")
+		}
+		return m, nil
+
+	case configLoadedMsg:
+		m.notify(levelInfo, string(msg))
+		m.rulesFileChanged = false
+		m.backTo(mainView)
+		return m, tea.Batch(m.updateRuleList(), func() tea.Msg { m.updatePortForwardingList(); return nil })
+
+	case configExportedMsg:
+		m.notify(levelInfo, string(msg))
+		m.closeView(saveConfigView)
+		return m, nil
+
+	case pastedMsg:
+		if m.currentView == ruleListView {
+			m.pasteRule(string(msg))
+		}
+		return m, nil
+
+	case copiedMsg:
+		m.notify(levelInfo, string(msg))
+		return m, nil
+
+	case ruleColumnsSavedMsg:
+		m.ruleList.SetDelegate(newRuleListDelegate(m.firewallManager.Config.Settings.RuleListLayout))
+		m.backTo(ruleListView)
+		return m, nil
+
+	case configSavedAndBackToMainMsg:
+		m.notify(levelInfo, string(msg))
+		m.backTo(mainView)
+		return m, nil
+
+	case applyPreviewMsg:
+		if m.currentView == applyPreviewView {
+			m.applyPreviewReady = true
+			m.lockoutWarnings = msg.lockout
+			m.viewport.SetContent(formatApplyPreview(msg.diff, msg.lockout))
+		}
+		return m, nil
+
+	case rulesAppliedMsg:
+		m.ruleErrors = nil
+		m.panicMode = false
+		m.notify(levelInfo, string(msg))
+		if m.currentView == ruleListView {
+			// Drop the pfctl errors of the last apply from the list
+			m.ruleList.SetItems(m.getRuleListItems())
+		}
+		return m, tea.Batch(checkAppliedAnchor(m.runner, m.applied), m.runUnsavedNext())
+
+	case unsavedResolvedMsg:
+		m.notify(levelInfo, string(msg))
+		m.closeView(unsavedChangesView)
+		return m, m.runUnsavedNext()
+
+	case rollbackPendingMsg:
+		// Also from a quick block in the pflog view
+		if m.currentView == pflogView {
+			m.stopPflog()
+			m.popView()
+		}
+		m.ruleErrors = nil
+		m.panicMode = false
+		m.unsavedNext = nil // the new rules have to be confirmed first
+		m.rollback = msg.rollback
+		m.notify(levelInfo, msg.status)
+		m.pushView(rollbackView)
+		return m, tea.Batch(tickRollback(), checkAppliedAnchor(m.runner, m.applied))
+
+	case rollbackTickMsg:
+		if m.rollback == nil {
+			return m, nil // confirmed or reverted
+		}
+		if time.Now().Before(m.rollback.Deadline) {
+			return m, tickRollback()
+		}
+		// The scheduled revert restores the previous rules on its own
+		m.rollback = nil
+		m.notify(levelWarn, "The new rules were not confirmed in time and have been reverted.")
+		m.closeView(rollbackView)
+		return m, checkAppliedAnchor(m.runner, m.applied)
+
+	case rollbackDoneMsg:
+		m.notify(levelInfo, string(msg))
+		m.closeView(rollbackView)
+		return m, checkAppliedAnchor(m.runner, m.applied)
+
+	case rulesRejectedMsg:
+		// Nothing was applied. Point out the rejected rules in the rule list.
+		m.ruleErrors = make(map[string]string)
+		var unmapped []string
+		firstRule := ""
+		for _, e := range msg {
+			if e.RuleID == "" {
+				unmapped = append(unmapped, fmt.Sprintf("line %d: %s (%s)", e.Line, e.Message, strings.TrimSpace(e.Text)))
+				continue
+			}
+			if _, ok := m.ruleErrors[e.RuleID]; !ok {
+				m.ruleErrors[e.RuleID] = e.Message
+			}
+			if firstRule == "" {
+				firstRule = e.RuleID
+			}
+		}
+		if firstRule == "" {
+			m.notify(levelError, "pfctl rejected the rules, nothing was applied: "+strings.Join(unmapped, "; "))
+			return m, nil
+		}
+		rejected := fmt.Sprintf("pfctl rejected %d rule(s), nothing was applied. Fix the marked rules and apply again.", len(m.ruleErrors))
+		if len(unmapped) > 0 {
+			rejected += " Also: " + strings.Join(unmapped, "; ")
+		}
+		m.notify(levelError, rejected)
+		for _, rule := range m.firewallManager.Config.FirewallRules {
+			if rule.ID == firstRule && rule.Group != "" {
+				delete(m.collapsedGroups, rule.Group)
+			}
+		}
+		m.backTo(ruleListView)
+		m.updateRuleList()
+		m.selectRuleListItem(func(item list.Item) bool {
+			ruleItem, ok := item.(ruleListItem)
+			return ok && ruleItem.rule.ID == firstRule
+		})
+		return m, nil
+
+	case fileListMsg:
+		m.browseDir = msg.dir
+		m.fileList.Title = "Import from " + msg.dir
+		m.fileList.SetItems(msg.items)
+		m.fileList.Select(0)
+		return m, nil
+
+	case archiveChangedMsg:
+		m.notify(levelInfo, string(msg))
+		m.updateArchiveList()
+		return m, tea.Batch(m.updateRuleList(), func() tea.Msg {
+			m.updatePortForwardingList()
+			m.updateNatList()
+			return nil
+		})
+
+	case backupListMsg:
+		m.backupList.SetItems(msg)
+		m.backupList.Select(0)
+		return m, nil
+
+	case historyListMsg:
+		m.historyList.SetItems(msg)
+		m.historyList.Select(0)
+		return m, nil
+
+	case snapshotRestoredMsg:
+		m.notify(levelInfo, string(msg))
+		return m, m.openApplyPreview()
+
+	case errMsg:
+		m.unsavedNext = nil
+		if errors.Is(msg.err, pfcli.ErrSudoExpired) {
+			m.notify(levelError, config.ErrorMessage(msg.err)+". Retry once the password is entered.")
+			return m, m.promptSudo(sudoExpiredPrompt)
+		}
+		if errors.Is(msg.err, pfcli.ErrSudoCancelled) {
+			m.notify(levelWarn, msg.err.Error())
+			return m, nil
+		}
+		m.notify(levelError, config.ErrorMessage(msg.err))
+		return m, nil
+
+	case configChangedMsg:
+		// pf-tui's own saves change the file too, those are not offered
+		if !m.configLoading && m.firewallManager.ChangedOnDisk() {
+			logging.Info("The rules file changed on disk")
+			m.rulesFileChanged = true
+			if m.currentView == mainView {
+				m.offerReload()
+			}
+		}
+		return m, waitForConfigChange(m.configWatcher)
+
+	case openMenuItemMsg:
+		// Select the item too, so that leaving the view returns to it in the menu
+		for i, listItem := range m.list.Items() {
+			if listItem.(item).title == string(msg) {
+				m.list.Select(i)
+			}
+		}
+		return m, m.selectMenuItem(string(msg))
+
+	case reloadConfigMsg:
+		return m, m.withUnsavedChanges(func() tea.Cmd { return reloadConfig(m.firewallManager) })
+
+	case sudoExpiredMsg:
+		return m, tea.Batch(m.promptSudo(sudoExpiredPrompt), waitForSudoExpired(m.sudoKeepAlive))
+
+	case sudoRenewedMsg:
+		m.sudoPrompting = false
+		if msg.err != nil {
+			logging.Error(fmt.Sprintf("Failed to renew the sudo credentials: %v", msg.err))
+			m.notify(levelError, fmt.Sprintf("Sudo credentials not renewed: %v. Commands fail until they are; pf-tui asks again within a minute.", msg.err))
+		} else {
+			logging.Info("Sudo credentials renewed")
+			m.notify(levelInfo, "Sudo credentials renewed.")
+		}
+		if msg.err == nil && len(m.deferredChecks) > 0 {
+			return m, m.runDeferredChecks()
+		}
+		return m, nil
+
+	case sudoCheckedMsg, startupCheckDeferredMsg, startupConfigMsg, portForwardingItemsMsg:
+		return m.handleStartupMsg(msg)
+
+	case operationStartMsg:
+		return m, m.startOperation(msg)
+
+	case operationStepMsg:
+		msg.op.step = msg.step
+		return m, waitForStep(msg.op)
+
+	case operationDoneMsg:
+		m.finishOperation(msg.op)
+		if msg.msg == nil {
+			return m, nil
+		}
+		return m.update(msg.msg)
+
+	case spinner.TickMsg:
+		// The spinner stops with the last operation
+		if len(m.operations) == 0 {
+			return m, nil
+		}
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	}
+
+	return m, cmd
+}
+
+func (m *model) View() string {
+	if m.help.ShowAll {
+		return m.helpOverlay()
+	}
+	return m.renderView()
+}
+
+// renderView renders the current view.
+func (m *model) renderView() string {
+	switch m.currentView {
+	case confirmationView:
+		return m.confirmationView()
+	case mainView:
+		return m.mainView()
+	case ruleListView:
+		return m.ruleListView()
+	case ruleFormView:
+		return m.ruleFormView()
+	case portForwardingListView:
+		return m.portForwardingListView()
+	case portForwardingFormView:
+		return m.portForwardingFormView()
+	case natListView:
+		return m.natListView()
+	case natFormView:
+		return m.natFormView()
+	case tableListView:
+		return m.tableListView()
+	case tableFormView:
+		return m.tableFormView()
+	case macroListView:
+		return m.macroListView()
+	case macroFormView:
+		return m.macroFormView()
+	case scrubFormView:
+		return m.scrubFormView()
+	case optionsFormView:
+		return m.optionsFormView()
+	case timeoutsFormView:
+		return m.timeoutsFormView()
+	case sharingFormView:
+		return m.sharingFormView()
+	case settingsFormView:
+		return m.settingsFormView()
+	case pipeListView:
+		return m.pipeListView()
+	case pipeFormView:
+		return m.pipeFormView()
+	case pflogView:
+		return m.pflogView()
+	case tableEntriesView:
+		return m.tableEntriesView()
+	case whoisView:
+		return m.whoisView()
+	case ruleDetailView:
+		return m.ruleDetailView()
+	case applyPreviewView:
+		return m.applyPreviewView()
+	case rollbackView:
+		return m.rollbackView()
+	case unsavedChangesView:
+		return m.unsavedChangesView()
+	case infoView:
+		return m.infoView()
+	case saveConfigView:
+		return m.saveConfigView()
+	case importConfigView:
+		return m.importConfigView()
+	case backupsView:
+		return m.backupsView()
+	case archiveView:
+		return m.archiveView()
+	case historyView:
+		return m.historyView()
+	case pfConfPathView:
+		return m.pfConfPathView()
+	case ruleGroupView:
+		return m.ruleGroupView()
+	case servicePickerView:
+		return m.servicePickerView()
+	case ruleColumnsView:
+		return m.ruleColumnsView()
+	case pfConfImportView:
+		return m.pfConfImportView()
+	case importPreviewView:
+		return m.importPreviewView()
+	default:
+		return "Unknown view"
+	}
+}
+
+func (m *model) mainView() string {
+	var s strings.Builder
+	status := fmt.Sprintf("PF Status: %s | Startup: %s", m.pfStatus, m.startupStatus)
+	if m.applied != nil {
+		status += " | Applied: " + formatAppliedTime(m.applied.time, time.Now())
+	}
+	s.WriteString(statusStyle.Render(status))
+	if m.firewallManager.IsDirty() {
+		s.WriteString("  " + warningStyle.Render("* Unsaved changes"))
+	}
+	if m.configLoading {
+		s.WriteString("  " + statusStyle.Render("Loading the configuration..."))
+	} else if m.applied != nil && !m.applied.time.IsZero() && m.applied.content != m.firewallManager.GeneratePfConf() {
+		s.WriteString("  " + warningStyle.Render("Config differs from the applied rules"))
+	}
+	if m.panicMode {
+		s.WriteString("  " + warningStyle.Render("PANIC: ALL TRAFFIC PASSED"))
+	}
+	if m.rulesFileChanged {
+		s.WriteString("  " + warningStyle.Render("Rules file changed on disk (r: Reload)"))
+	}
+	if !m.pfTuiLoaded {
+		s.WriteString("  " + warningStyle.Render("pf-tui rules not loaded"))
+	} else if len(m.competing) > 0 {
+		s.WriteString("  " + warningStyle.Render(fmt.Sprintf("%d competing rulesets", len(m.competing))))
+	}
+	if percent := m.stateUsage.Percent(); percent >= stateUsageWarning {
+		s.WriteString("  " + warningStyle.Render(fmt.Sprintf("States %d/%d (%.0f%%)", m.stateUsage.Current, m.stateUsage.Limit, percent)))
+	}
+	s.WriteString("\n\n")
+	s.WriteString(m.list.View())
+	s.WriteString("\n")
+	s.WriteString(m.helpView("") + "\n")
+	s.WriteString(m.toastView())
+	return appStyle.Render(s.String())
+}
+
+// formatAppliedTime renders the time of the last apply for the main header:
+// the time of day for today, else the date too.
+func formatAppliedTime(applied, now time.Time) string {
+	switch {
+	case applied.IsZero():
+		return "never"
+	case applied.Format("2006-01-02") == now.Format("2006-01-02"):
+		return applied.Format("15:04")
+	}
+	return applied.Format("2006-01-02 15:04")
+}
+
+// stateUsageWarning is the state table usage in percent from which the main
+// header warns that the limit is near. New connections fail at the limit.
+const stateUsageWarning = 80
+
+// formatCompetingRules renders the competing rulesets for the info view.
+func formatCompetingRules(competing []pfcli.CompetingRuleset, pfTuiLoaded bool) string {
+	var b strings.Builder
+	if !pfTuiLoaded {
+		b.WriteString(warningStyle.Render("The loaded main ruleset neither evaluates the pf-tui anchor nor has pf-tui's rules."))
+		b.WriteString("\nNone of the pf-tui rules apply. Another tool may have loaded its own pf.conf; Save & Apply loads the rules again.\n\n")
+	}
+	if len(competing) == 0 {
+		if pfTuiLoaded {
+			b.WriteString("No rules of other tools found. Only macOS's own anchors and pf-tui's rules are loaded.\n")
+		}
+		return b.String()
+	}
+	for _, ruleset := range competing {
+		name := "Main ruleset"
+		if ruleset.Anchor != "" {
+			name = fmt.Sprintf("Anchor %q", ruleset.Anchor)
+		}
+		b.WriteString(warningStyle.Render(fmt.Sprintf("%s (%s), %d rules", name, ruleset.Tool, len(ruleset.Rules))) + "\n")
+		b.WriteString("  " + strings.ReplaceAll(ruleset.Explanation(), "\n", "\n  ") + "\n")
+		for _, rule := range ruleset.Rules {
+			b.WriteString("    " + rule + "\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// formatPfConfImport lists what an import recognized, with the options it
+// drops, and the statements it skips.
+func formatPfConfImport(imp config.PfConfImport) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Recognized: %s\n\n", imp.Summary()))
+	if len(imp.Recognized) > 0 {
+		b.WriteString(titleStyle.Render("Imported") + "\n")
+		for _, statement := range imp.Recognized {
+			b.WriteString(fmt.Sprintf("%5d  %s\n", statement.Line, statement.Text))
+			if statement.Note != "" {
+				b.WriteString(warningStyle.Render("       "+statement.Note) + "\n")
+			}
+		}
+		b.WriteString("\n")
+	}
+	if len(imp.Skipped) > 0 {
+		b.WriteString(titleStyle.Render("Skipped") + "\n")
+		for _, statement := range imp.Skipped {
+			b.WriteString(fmt.Sprintf("%5d  %s\n", statement.Line, statement.Text))
+			b.WriteString(warningStyle.Render("       "+statement.Note) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// formatConfigPreview renders what importing a configuration file would do:
+// whether it can be imported, what it has compared with the current
+// configuration, and the pf rules it generates.
+func formatConfigPreview(preview config.ConfigPreview, current *config.Config) string {
+	var b strings.Builder
+	if preview.Err != nil {
+		b.WriteString(warningStyle.Render(fmt.Sprintf("Cannot import this %s file: %v", preview.Format, preview.Err)) + "\n")
+		return b.String()
+	}
+	schema := fmt.Sprintf("Schema: version %d", preview.Version)
+	if preview.Version < config.ConfigSchemaVersion {
+		schema += fmt.Sprintf(", migrated to version %d on import", config.ConfigSchemaVersion)
+	}
+	b.WriteString(fmt.Sprintf("Format: %s\n%s\n", preview.Format, schema))
+	if len(preview.Unknown) > 0 {
+		b.WriteString(warningStyle.Render("Unknown fields, dropped on import: "+strings.Join(preview.Unknown, ", ")) + "\n")
+	}
+	for _, problem := range preview.Problems {
+		b.WriteString(warningStyle.Render(problem) + "\n")
+	}
+
+	disabled := 0
+	for _, rule := range preview.Config.FirewallRules {
+		if !rule.Enabled {
+			disabled++
+		}
+	}
+	counts := []struct {
+		name              string
+		imported, current int
+	}{
+		{"Filter rules", len(preview.Config.FirewallRules), len(current.FirewallRules)},
+		{"Port forwarding rules", len(preview.Config.PortForwardingRules), len(current.PortForwardingRules)},
+		{"NAT rules", len(preview.Config.NatRules), len(current.NatRules)},
+		{"Tables", len(preview.Config.Tables), len(current.Tables)},
+		{"Macros", len(preview.Config.Macros), len(current.Macros)},
+		{"Rule groups", len(preview.Config.RuleGroups), len(current.RuleGroups)},
+		{"Pipes", len(preview.Config.Pipes), len(current.Pipes)},
+	}
+	b.WriteString(fmt.Sprintf("\n%-22s %8s %8s\n", "", "File", "Current"))
+	for i, count := range counts {
+		line := fmt.Sprintf("%-22s %8d %8d", count.name, count.imported, count.current)
+		if i == 0 && disabled > 0 {
+			line += fmt.Sprintf("  (%d disabled)", disabled)
+		}
+		b.WriteString(line + "\n")
+	}
+
+	imported := &config.FirewallManager{Config: &preview.Config}
+	b.WriteString("\n" + titleStyle.Render("Generated Rules") + "\n")
+	if rules := strings.TrimSpace(imported.GeneratePfConf()); rules != "" {
+		b.WriteString(rules + "\n")
+	} else {
+		b.WriteString("(none)\n")
+	}
+	return b.String()
+}
+
+// formatPfUsage renders the current use of pf's memory pools against their limits.
+func formatPfUsage(usages []pfcli.PfUsage) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%-15s %10s %10s %7s\n", "Pool", "Current", "Limit", "Usage"))
+	for _, usage := range usages {
+		current, percent := "-", "-"
+		if usage.Current >= 0 {
+			current = strconv.Itoa(usage.Current)
+		}
+		if p := usage.Percent(); p >= 0 {
+			percent = fmt.Sprintf("%.1f%%", p)
+			if p >= stateUsageWarning {
+				percent = warningStyle.Render(percent)
+			}
+		}
+		b.WriteString(fmt.Sprintf("%-15s %10s %10d %7s\n", usage.Name, current, usage.Limit, percent))
+	}
+	b.WriteString("\nLimits come from `pfctl -s memory` and are raised with \"set limit\" in Edit Global Options.\n")
+	b.WriteString("Tables and table entries are counted in the pf-tui anchor only; frags are not counted.\n")
+	return b.String()
+}
+
+// formatStatsHistory renders the recorded samples as sparklines, oldest on the left.
+func formatStatsHistory(samples []config.StatsSample) string {
+	if len(samples) < 2 {
+		return fmt.Sprintf("Activity history: collecting samples (one every %s)...\n", config.StatsInterval)
+	}
+	states := make([]uint64, len(samples))
+	var maxStates uint64
+	for i, sample := range samples {
+		if sample.States > 0 {
+			states[i] = uint64(sample.States)
+		}
+		if states[i] > maxStates {
+			maxStates = states[i]
+		}
+	}
+	passed := config.CounterDeltas(samples, func(s config.StatsSample) uint64 { return s.Passed })
+	blocked := config.CounterDeltas(samples, func(s config.StatsSample) uint64 { return s.Blocked })
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Activity since %s (one sample every %s)\n", samples[0].Time.Format("15:04"), config.StatsInterval))
+	b.WriteString(fmt.Sprintf("  States   %s  now %d, max %d\n", Sparkline(states), states[len(states)-1], maxStates))
+	b.WriteString(fmt.Sprintf("  Passed   %s  %s packets in the last interval\n", Sparkline(passed), formatCount(passed[len(passed)-1])))
+	b.WriteString(fmt.Sprintf("  Blocked  %s  %s packets in the last interval\n", Sparkline(blocked), formatCount(blocked[len(blocked)-1])))
+	return b.String()
+}
+
+// maxTopTalkers is the number of hosts listed in the top talkers view.
+const maxTopTalkers = 20
+
+// showTopTalkers shows m.topTalkers in the viewport, keeping the selected host in range.
+func (m *model) showTopTalkers() {
+	if m.topTalkerCursor >= len(m.topTalkers) || m.topTalkerCursor >= maxTopTalkers {
+		m.topTalkerCursor = len(m.topTalkers) - 1
+		if m.topTalkerCursor >= maxTopTalkers {
+			m.topTalkerCursor = maxTopTalkers - 1
+		}
+	}
+	if m.topTalkerCursor < 0 {
+		m.topTalkerCursor = 0
+	}
+	m.viewport.SetContent(formatTopTalkers(m.topTalkers, m.geoip, m.names(), m.topTalkerCursor))
+}
+
+// formatTopTalkers renders the hosts with the most traffic in the state table,
+// with their country and AS if geoip is set, and their names if names is set.
+// The host at index selected is highlighted.
+func formatTopTalkers(talkers []pfcli.HostTraffic, geoip *GeoIP, names *Resolver, selected int) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%3s  %-40s %7s %9s %9s  %s\n", "#", "Remote Host", "States", "Packets", "Bytes", "Location"))
+	for i, talker := range talkers {
+		if i == maxTopTalkers {
+			break
+		}
+		host := talker.Host
+		if name := names.Name(host); name != "" {
+			host = name
+		}
+		line := fmt.Sprintf("%3d  %-40s %7d %9s %9s  %s", i+1, host, talker.States, formatCount(talker.Packets), formatCount(talker.Bytes), geoip.Annotate(talker.Host))
+		b.WriteString(renderSelectedLine(line, i == selected) + "\n")
+	}
+	if len(talkers) == 0 {
+		b.WriteString("\nNo states.\n")
+	}
+	b.WriteString(fmt.Sprintf("\nTotals of the current states by remote host, in both directions. Refreshed every 2 seconds; %d hosts.\n", len(talkers)))
+	return b.String()
+}
+
+// formatStates renders the state table, with the country and AS of the remote
+// addresses if geoip is set.
+func formatStates(states []pfcli.PfState, geoip *GeoIP) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%-6s %-5s %-3s %-40s %-40s %-24s %s\n", "If", "Proto", "Dir", "Local", "Remote", "State", "Location"))
+	for _, state := range states {
+		b.WriteString(fmt.Sprintf("%-6s %-5s %-3s %-40s %-40s %-24s %s\n", state.Interface, state.Protocol, state.Direction,
+			state.Local, state.Remote, state.State, geoip.Annotate(pfcli.StripStatePort(state.Remote))))
+	}
+	if len(states) == 0 {
+		b.WriteString("\nNo states.\n")
+	}
+	b.WriteString(fmt.Sprintf("\nThe state table of `pfctl -s states`. Refreshed every 2 seconds; %d states.\n", len(states)))
+	return b.String()
+}
+
+func (m *model) confirmationView() string {
+	return lipgloss.Place(
+		m.width,
+		m.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(
+			lipgloss.Left,
+			m.confirmationMessage,
+			lipgloss.NewStyle().Foreground(lipgloss.Color("#FF0000")).Render("(y/n)"),
+		),
+	)
+}
+
+func (m *model) ruleListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Firewall Rules"))
+	s.WriteString("\n")
+	m.ruleList.SetItems(m.getRuleListItems())
+	rules := lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.NewStyle().Bold(true).Padding(0, 1).MaxWidth(m.ruleListWidth()).Render(ruleListHeader(shownRuleColumns(m.firewallManager.Config.Settings))),
+		m.ruleList.View(),
+	)
+	if m.ruleDetailPanelShown() {
+		h, _ := appStyle.GetFrameSize()
+		panel := m.ruleDetailPanel(m.width-h-m.ruleListWidth(), lipgloss.Height(rules))
+		rules = lipgloss.JoinHorizontal(lipgloss.Top, rules, panel)
+	}
+	s.WriteString(rules)
+	s.WriteString("\n" + m.helpView("  "))
+	if m.notifications.toast != nil {
+		s.WriteString("\n\n  " + m.toastView())
+	}
+	return appStyle.Render(s.String())
+}
+
+func (m *model) ruleFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit Firewall Rule\n\n")
+
+	for _, field := range m.form.visibleFields() {
+		isFocused := m.form.focused == field
+		label := ruleFieldLabels[field]
+		if input := m.form.textInput(field); input != nil {
+			b.WriteString(renderInput(label, *input, isFocused, m.form.activeTextInput, field, label))
+			b.WriteString(renderFieldError(*input, m.ruleFieldError(field)))
+		} else {
+			options, selected := m.form.optionField(field)
+			b.WriteString(renderOptions(label, options, *selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(m.form.ruleFieldHint()))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    pf.conf:\n")
+	b.WriteString(m.ruleFormPreview())
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+// ruleFormPreview renders the pf lines GeneratePfConf generates for the rule
+// of the rule form as it is, or why it cannot be saved.
+func (m *model) ruleFormPreview() string {
+	rule, err := m.formRule()
+	if err != nil {
+		return fieldErrorStyle.Render("    "+fieldErrorMarker+err.Error()) + "\n"
+	}
+	if !m.form.isNew && m.form.ruleIndex < len(m.firewallManager.Config.FirewallRules) {
+		rule.ID = m.firewallManager.Config.FirewallRules[m.form.ruleIndex].ID
+	}
+	filterRules, dummynetRules := m.firewallManager.GenerateFirewallRule(rule)
+	var b strings.Builder
+	for _, line := range append(filterRules, dummynetRules...) {
+		b.WriteString("    " + line + "\n")
+	}
+	if !rule.Enabled {
+		b.WriteString(disabledStyle.Render("    (disabled: not in the generated rules)") + "\n")
+	}
+	return b.String()
+}
+
+func (m *model) portForwardingListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Port Forwarding Rules"))
+	s.WriteString("\n")
+
+	s.WriteString("\n")
+	s.WriteString(m.portForwardingList.View())
+	s.WriteString("\n" + m.helpView("  "))
+	return appStyle.Render(s.String())
+}
+
+type portForwardingForm struct {
+	focused           int
+	activeTextInput   int // -1 if no text input is active, otherwise the index of the active text input
+	isNew             bool
+	ruleIndex         int
+	enabled           bool // kept as-is when editing, toggled from the port forwarding list
+	protocol          string
+	interfaceInput    textinput.Model
+	externalIPInput   textinput.Model
+	externalPortInput textinput.Model
+	internalIPInput   textinput.Model
+	internalPortInput textinput.Model
+	descriptionInput  textinput.Model
+}
+
+func (m *model) portForwardingFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit Port Forwarding Rule\n\n")
+
+	fields := []struct {
+		label    string
+		isInput  bool
+		options  []string
+		selected string
+		input    *textinput.Model
+	}{
+		{"Interface", true, nil, "", &m.portForwardingForm.interfaceInput},
+		{"Protocol", false, []string{"tcp", "udp"}, m.portForwardingForm.protocol, nil},
+		{"External IP", true, nil, "", &m.portForwardingForm.externalIPInput},
+		{"External Port", true, nil, "", &m.portForwardingForm.externalPortInput},
+		{"Internal IP", true, nil, "", &m.portForwardingForm.internalIPInput},
+		{"Internal Port", true, nil, "", &m.portForwardingForm.internalPortInput},
+		{"Description", true, nil, "", &m.portForwardingForm.descriptionInput},
+	}
+
+	for i, field := range fields {
+		isFocused := m.portForwardingForm.focused == i
+		if field.isInput {
+			b.WriteString(renderInput(field.label, *field.input, isFocused, m.portForwardingForm.activeTextInput, i, field.label))
+			b.WriteString(renderFieldError(*field.input, m.portForwardingFieldError(i)))
+		} else {
+			b.WriteString(renderOptions(field.label, field.options, field.selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(portForwardingFieldHints, m.portForwardingForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) natListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("NAT Rules"))
+	s.WriteString("\n")
+	s.WriteString(lipgloss.NewStyle().Bold(true).Padding(0, 1).Render("  #   Interface       Proto   Source             Dest               Translation        Description"))
+	s.WriteString("\n")
+	s.WriteString(m.natList.View())
+	s.WriteString("\n" + m.helpView("  "))
+	return appStyle.Render(s.String())
+}
+
+type natForm struct {
+	focused          int
+	activeTextInput  int // -1 if no text input is active, otherwise the index of the active text input
+	isNew            bool
+	ruleIndex        int
+	protocol         string
+	interfaceInput   textinput.Model
+	sourceInput      textinput.Model
+	destinationInput textinput.Model
+	translationInput textinput.Model
+	descriptionInput textinput.Model
+}
+
+func (m *model) natFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit NAT Rule\n\n")
+
+	fields := []struct {
+		label    string
+		isInput  bool
+		options  []string
+		selected string
+		input    *textinput.Model
+	}{
+		{"Interface", true, nil, "", &m.natForm.interfaceInput},
+		{"Protocol", false, []string{"any", "tcp", "udp", "icmp"}, m.natForm.protocol, nil},
+		{"Source", true, nil, "", &m.natForm.sourceInput},
+		{"Destination", true, nil, "", &m.natForm.destinationInput},
+		{"Translation", true, nil, "", &m.natForm.translationInput},
+		{"Description", true, nil, "", &m.natForm.descriptionInput},
+	}
+
+	for i, field := range fields {
+		isFocused := m.natForm.focused == i
+		if field.isInput {
+			b.WriteString(renderInput(field.label, *field.input, isFocused, m.natForm.activeTextInput, i, field.label))
+			b.WriteString(renderFieldError(*field.input, m.natFieldError(i)))
+		} else {
+			b.WriteString(renderOptions(field.label, field.options, field.selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(natFieldHints, m.natForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) tableListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Tables"))
+	s.WriteString("\n")
+	s.WriteString(lipgloss.NewStyle().Bold(true).Padding(0, 1).Render("  #   Name                 P   Addresses                                Description"))
+	s.WriteString("\n")
+	s.WriteString(m.tableList.View())
+	s.WriteString("\n" + m.helpView("  "))
+	s.WriteString(`
+  Reference a table in a rule's Source or Destination as <name>.`)
+	if m.notifications.toast != nil {
+		s.WriteString("\n\n  " + m.toastView())
+	}
+	return appStyle.Render(s.String())
+}
+
+func (m *model) tableEntriesView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Loaded Entries of <%s>", m.tableEntriesName)))
+	s.WriteString("\n")
+	s.WriteString(m.tableEntryList.View())
+	s.WriteString("\n")
+	if m.tableEntryAdding {
+		s.WriteString("  Add address: " + m.tableEntryInput.View() + "\n")
+	}
+	s.WriteString("\n" + m.helpView("  "))
+	s.WriteString(`
+  Changes apply to the running pf only; edit the table to keep addresses in the configuration.`)
+	s.WriteString("\n  " + m.toastView())
+	return appStyle.Render(s.String())
+}
+
+func newTableEntryInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Placeholder = "e.g. 192.0.2.1 or 198.51.100.0/24"
+	input.Blur()
+	return input
+}
+
+// validateTableEntry checks an address typed into tableEntriesView.
+func validateTableEntry(addr string) error {
+	if net.ParseIP(addr) != nil || config.HostnamePattern.MatchString(addr) {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(addr); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q, expected an IP address or network", addr)
+}
+
+type tableForm struct {
+	focused          int
+	activeTextInput  int // -1 if no text input is active, otherwise the index of the active text input
+	isNew            bool
+	tableIndex       int
+	persist          string
+	nameInput        textinput.Model
+	addressesInput   textinput.Model
+	descriptionInput textinput.Model
+	feedURLInput     textinput.Model
+	feedRefreshInput textinput.Model
+}
+
+func (m *model) tableFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit Table\n\n")
+
+	fields := []struct {
+		label    string
+		isInput  bool
+		options  []string
+		selected string
+		input    *textinput.Model
+	}{
+		{"Name", true, nil, "", &m.tableForm.nameInput},
+		{"Persist", false, []string{"Yes", "No"}, m.tableForm.persist, nil},
+		{"Addresses", true, nil, "", &m.tableForm.addressesInput},
+		{"Description", true, nil, "", &m.tableForm.descriptionInput},
+		{"Feed URL", true, nil, "", &m.tableForm.feedURLInput},
+		{"Refresh (hours)", true, nil, "", &m.tableForm.feedRefreshInput},
+	}
+
+	for i, field := range fields {
+		isFocused := m.tableForm.focused == i
+		if field.isInput {
+			b.WriteString(renderInput(field.label, *field.input, isFocused, m.tableForm.activeTextInput, i, field.label))
+		} else {
+			b.WriteString(renderOptions(field.label, field.options, field.selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(tableFieldHints, m.tableForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    Feed URL: Optional blocklist (e.g. Spamhaus DROP) downloaded into the table\n")
+	b.WriteString(fmt.Sprintf("    every Refresh hours (default %d) while pf-tui runs.\n", config.DefaultFeedRefreshHours))
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) macroListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Macros"))
+	s.WriteString("\n")
+	s.WriteString(lipgloss.NewStyle().Bold(true).Padding(0, 1).Render("  #   Name                 Value                          Description"))
+	s.WriteString("\n")
+	s.WriteString(m.macroList.View())
+	s.WriteString("\n" + m.helpView("  "))
+	s.WriteString(`
+  Reference a macro in a rule field as $name.`)
+	return appStyle.Render(s.String())
+}
+
+type macroForm struct {
+	focused          int
+	activeTextInput  int // -1 if no text input is active, otherwise the index of the active text input
+	isNew            bool
+	macroIndex       int
+	nameInput        textinput.Model
+	valueInput       textinput.Model
+	descriptionInput textinput.Model
+}
+
+func (m *model) macroFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit Macro\n\n")
+
+	fields := []struct {
+		label string
+		input *textinput.Model
+	}{
+		{"Name", &m.macroForm.nameInput},
+		{"Value", &m.macroForm.valueInput},
+		{"Description", &m.macroForm.descriptionInput},
+	}
+
+	for i, field := range fields {
+		isFocused := m.macroForm.focused == i
+		b.WriteString(renderInput(field.label, *field.input, isFocused, m.macroForm.activeTextInput, i, field.label))
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(macroFieldHints, m.macroForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) pipeListView() string {
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Pipes"))
+	s.WriteString("\n")
+	s.WriteString(lipgloss.NewStyle().Bold(true).Padding(0, 1).Render("  #   Pipe   Bandwidth      Delay    Queue  Description"))
+	s.WriteString("\n")
+	s.WriteString(m.pipeList.View())
+	s.WriteString("\n" + m.helpView("  "))
+	s.WriteString(`
+  Attach a pipe to a firewall rule with the rule's Pipe field.`)
+	return appStyle.Render(s.String())
+}
+
+// pipeFieldLabels are the pipe form fields, in display order.
+var pipeFieldLabels = []string{"Pipe Number", "Bandwidth", "Delay (ms)", "Queue Size", "Description"}
+
+type pipeForm struct {
+	focused          int
+	activeTextInput  int // -1 if no text input is active, otherwise the index of the active text input
+	isNew            bool
+	pipeIndex        int
+	numberInput      textinput.Model
+	bandwidthInput   textinput.Model
+	delayInput       textinput.Model
+	queueInput       textinput.Model
+	descriptionInput textinput.Model
+}
+
+// input returns the text input of the given field.
+func (f *pipeForm) input(field int) *textinput.Model {
+	return []*textinput.Model{&f.numberInput, &f.bandwidthInput, &f.delayInput, &f.queueInput, &f.descriptionInput}[field]
+}
+
+func (m *model) pipeFormView() string {
+	var b strings.Builder
+	b.WriteString("  Add/Edit Pipe\n\n")
+
+	for i, label := range pipeFieldLabels {
+		isFocused := m.pipeForm.focused == i
+		b.WriteString(renderInput(label, *m.pipeForm.input(i), isFocused, m.pipeForm.activeTextInput, i, label))
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(pipeFieldHints, m.pipeForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) focusPipeForm() {
+	for i := range pipeFieldLabels {
+		m.pipeForm.input(i).Blur()
+	}
+	if m.pipeForm.activeTextInput != -1 {
+		m.pipeForm.input(m.pipeForm.activeTextInput).Focus()
+	}
+}
+
+type timeoutsForm struct {
+	focused         int
+	activeTextInput int // -1 if no text input is active, otherwise the index of the active text input
+	loading         bool
+	timeouts        []pfcli.PfTimeout // current values, from pfctl
+	inputs          []textinput.Model
+}
+
+// newTimeoutsForm builds the timeouts form from the current pf timeouts, showing
+// the configured value instead where there is one.
+func newTimeoutsForm(timeouts []pfcli.PfTimeout, configured map[string]int) timeoutsForm {
+	form := timeoutsForm{activeTextInput: -1, timeouts: timeouts}
+	for _, timeout := range timeouts {
+		value := timeout.Value
+		if v, ok := configured[timeout.Name]; ok {
+			value = v
+		}
+		input := textinput.New()
+		input.SetValue(strconv.Itoa(value))
+		input.Prompt = ""
+		input.Blur()
+		form.inputs = append(form.inputs, input)
+	}
+	return form
+}
+
+func (m *model) timeoutsFormView() string {
+	var b strings.Builder
+	b.WriteString("  Edit Timeouts\n\n")
+
+	if m.timeoutsForm.loading {
+		b.WriteString("    Loading current timeouts from pf...\n")
+		b.WriteString("\n    " + m.toastView() + "\n")
+		return appStyle.Render(b.String())
+	}
+	if len(m.timeoutsForm.timeouts) == 0 {
+		b.WriteString("    pf did not report any timeouts.\n")
+	}
+	for i, timeout := range m.timeoutsForm.timeouts {
+		isFocused := m.timeoutsForm.focused == i
+		b.WriteString(renderInput(timeout.Name, m.timeoutsForm.inputs[i], isFocused, m.timeoutsForm.activeTextInput, i, timeout.Name))
+	}
+
+	b.WriteString("\n\n" + m.helpView("    ") + "\n")
+	b.WriteString("\n    Values are in seconds (adaptive.start/end: number of states).\n")
+	b.WriteString("    Raise tcp.established to keep idle SSH sessions alive.\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+type optionsForm struct {
+	focused                int // one of the optionsField* constants
+	activeTextInput        int // -1 if no text input is active, otherwise the optionsField* constant of the active text input
+	skipInput              textinput.Model
+	stateLimitInput        textinput.Model
+	tableEntriesLimitInput textinput.Model
+	blockPolicy            string
+	optimization           string
+}
+
+// textInput returns the text input backing the given field, or nil for option fields.
+func (f *optionsForm) textInput(field int) *textinput.Model {
+	switch field {
+	case optionsFieldSkip:
+		return &f.skipInput
+	case optionsFieldStateLimit:
+		return &f.stateLimitInput
+	case optionsFieldTableEntriesLimit:
+		return &f.tableEntriesLimitInput
+	}
+	return nil
+}
+
+// optionField returns the options and the selected value of the given field, or nil for text fields.
+func (f *optionsForm) optionField(field int) ([]string, *string) {
+	switch field {
+	case optionsFieldBlockPolicy:
+		return []string{"default", "drop", "return"}, &f.blockPolicy
+	case optionsFieldOptimization:
+		return []string{"default", "normal", "high-latency", "satellite", "aggressive", "conservative"}, &f.optimization
+	}
+	return nil, nil
+}
+
+func (m *model) optionsFormView() string {
+	var b strings.Builder
+	b.WriteString("  Edit Global Options\n\n")
+
+	for field := 0; field < optionsFieldCount; field++ {
+		isFocused := m.optionsForm.focused == field
+		label := optionsFieldLabels[field]
+		if input := m.optionsForm.textInput(field); input != nil {
+			b.WriteString(renderInput(label, *input, isFocused, m.optionsForm.activeTextInput, field, label))
+		} else {
+			options, selected := m.optionsForm.optionField(field)
+			b.WriteString(renderOptions(label, options, *selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(optionsFieldHints[:], m.optionsForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    Options apply to pf as a whole, not only to the pf-tui rules.\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) focusOptionsForm() {
+	for field := 0; field < optionsFieldCount; field++ {
+		if input := m.optionsForm.textInput(field); input != nil {
+			input.Blur()
+		}
+	}
+	if input := m.optionsForm.textInput(m.optionsForm.activeTextInput); input != nil {
+		input.Focus()
+	}
+}
+
+// Internet Sharing wizard fields, in display order.
+const (
+	sharingFieldWan = iota
+	sharingFieldLan
+	sharingFieldCount
+)
+
+var sharingFieldLabels = [sharingFieldCount]string{
+	sharingFieldWan: "WAN Interface",
+	sharingFieldLan: "LAN Interface",
+}
+
+type sharingForm struct {
+	focused         int // one of the sharingField* constants
+	activeTextInput int // -1 if no text input is active, otherwise the sharingField* constant of the active text input
+	wanInput        textinput.Model
+	lanInput        textinput.Model
+	interfaces      []string // interfaces of this machine, cycled with left/right
+	forwarding      string   // "on" or "off" once net.inet.ip.forwarding has been read
+}
+
+// shareableInterfaces returns the names of the interfaces that are up, except loopback.
+func shareableInterfaces() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to list interfaces: %v", err))
+		return nil
+	}
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			names = append(names, iface.Name)
+		}
+	}
+	return names
+}
+
+func newSharingForm() sharingForm {
+	interfaces := shareableInterfaces()
+	wanInput := textinput.New()
+	wanInput.Prompt = ""
+	wanInput.Blur()
+	lanInput := textinput.New()
+	lanInput.Prompt = ""
+	lanInput.Blur()
+	if len(interfaces) > 0 {
+		wanInput.SetValue(interfaces[0])
+	}
+	if len(interfaces) > 1 {
+		lanInput.SetValue(interfaces[1])
+	}
+
+	return sharingForm{
+		focused:         0,
+		activeTextInput: -1,
+		wanInput:        wanInput,
+		lanInput:        lanInput,
+		interfaces:      interfaces,
+	}
+}
+
+// textInput returns the text input backing the given field.
+func (f *sharingForm) textInput(field int) *textinput.Model {
+	switch field {
+	case sharingFieldWan:
+		return &f.wanInput
+	case sharingFieldLan:
+		return &f.lanInput
+	}
+	return nil
+}
+
+func (m *model) sharingFormView() string {
+	var b strings.Builder
+	b.WriteString("  Internet Sharing Wizard\n\n")
+	b.WriteString("    Share the connection of the WAN interface (e.g. Ethernet) with the\n")
+	b.WriteString("    clients on the LAN interface (e.g. a USB adapter or bridge100).\n\n")
+
+	for field := 0; field < sharingFieldCount; field++ {
+		label := sharingFieldLabels[field]
+		b.WriteString(renderInput(label, *m.sharingForm.textInput(field), m.sharingForm.focused == field, m.sharingForm.activeTextInput, field, label))
+	}
+	if len(m.sharingForm.interfaces) > 0 {
+		b.WriteString(fmt.Sprintf("\n    Interfaces: %s\n", strings.Join(m.sharingForm.interfaces, ", ")))
+	}
+
+	wan := strings.TrimSpace(m.sharingForm.wanInput.Value())
+	lan := strings.TrimSpace(m.sharingForm.lanInput.Value())
+	if wan != "" && lan != "" {
+		nat, rules := config.InternetSharingRules(wan, lan)
+		b.WriteString("\n    Rules to add:\n")
+		b.WriteString(fmt.Sprintf("      nat on %s from %s to any -> (%s)\n", nat.Interface, nat.Source, nat.Interface))
+		for _, rule := range rules {
+			b.WriteString(fmt.Sprintf("      %s %s quick on %s from %s to %s %s\n", rule.Action, rule.Direction, rule.Interface, rule.Source, rule.Destination, rule.State))
+		}
+	}
+
+	switch m.sharingForm.forwarding {
+	case "on":
+		b.WriteString("\n    IP forwarding is enabled.\n")
+	case "off":
+		b.WriteString("\n    IP forwarding is disabled. Enable it with:\n")
+		b.WriteString("      sudo sysctl -w net.inet.ip.forwarding=1\n")
+		b.WriteString("    and add net.inet.ip.forwarding=1 to /etc/sysctl.conf to keep it after a reboot.\n")
+	}
+
+	b.WriteString("\n\n" + m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) focusSharingForm() {
+	m.sharingForm.wanInput.Blur()
+	m.sharingForm.lanInput.Blur()
+	if input := m.sharingForm.textInput(m.sharingForm.activeTextInput); input != nil {
+		input.Focus()
+	}
+}
+
+const (
+	settingsFieldCountryDB = iota
+	settingsFieldASNDB
+	settingsFieldAutoBan
+	settingsFieldAutoBanThreshold
+	settingsFieldAutoBanWindow
+	settingsFieldAutoBanMinutes
+	settingsFieldRollback
+	settingsFieldBackupInterval
+	settingsFieldBackupRetention
+	settingsFieldInfoRefresh
+	settingsFieldCount
+)
+
+var settingsFieldLabels = [settingsFieldCount]string{
+	settingsFieldCountryDB:        "GeoIP Country DB",
+	settingsFieldASNDB:            "GeoIP ASN DB",
+	settingsFieldAutoBan:          "Auto-Ban",
+	settingsFieldAutoBanThreshold: "Ban Threshold",
+	settingsFieldAutoBanWindow:    "Window (minutes)",
+	settingsFieldAutoBanMinutes:   "Ban (minutes)",
+	settingsFieldRollback:         "Rollback (sec)",
+	settingsFieldBackupInterval:   "Backup (hours)",
+	settingsFieldBackupRetention:  "Keep Backups",
+	settingsFieldInfoRefresh:      "Info Refresh (sec)",
+}
+
+type settingsForm struct {
+	focused         int // one of the settingsField* constants
+	activeTextInput int // -1 if no text input is active, otherwise the settingsField* constant of the active text input
+	countryDBInput  textinput.Model
+	asnDBInput      textinput.Model
+	autoBan         string
+	thresholdInput  textinput.Model
+	windowInput     textinput.Model
+	banMinutesInput textinput.Model
+	rollbackInput   textinput.Model
+	intervalInput   textinput.Model
+	retentionInput  textinput.Model
+	refreshInput    textinput.Model
+	lastBackup      time.Time // time of the newest scheduled backup, zero if there is none
+}
+
+// newSettingsNumberInput returns a text input for a number setting, empty if
+// value is 0, with the default used then as the placeholder.
+func newSettingsNumberInput(value, defaultValue int) textinput.Model {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Placeholder = strconv.Itoa(defaultValue)
+	if value > 0 {
+		input.SetValue(strconv.Itoa(value))
+	}
+	input.Blur()
+	return input
+}
+
+func newSettingsForm(settings config.Settings) settingsForm {
+	countryDBInput := textinput.New()
+	countryDBInput.Prompt = ""
+	countryDBInput.Placeholder = "/usr/local/share/GeoIP/GeoLite2-Country.mmdb"
+	countryDBInput.SetValue(settings.GeoIPCountryDB)
+	countryDBInput.Blur()
+	asnDBInput := textinput.New()
+	asnDBInput.Prompt = ""
+	asnDBInput.Placeholder = "/usr/local/share/GeoIP/GeoLite2-ASN.mmdb"
+	asnDBInput.SetValue(settings.GeoIPASNDB)
+	asnDBInput.Blur()
+	rollbackInput := textinput.New()
+	rollbackInput.Prompt = ""
+	rollbackInput.Placeholder = "off"
+	if settings.RollbackSeconds > 0 {
+		rollbackInput.SetValue(strconv.Itoa(settings.RollbackSeconds))
+	}
+	rollbackInput.Blur()
+	intervalInput := textinput.New()
+	intervalInput.Prompt = ""
+	intervalInput.Placeholder = "off"
+	if settings.BackupIntervalHours > 0 {
+		intervalInput.SetValue(strconv.Itoa(settings.BackupIntervalHours))
+	}
+	intervalInput.Blur()
+
+	return settingsForm{
+		focused:         0,
+		activeTextInput: -1,
+		countryDBInput:  countryDBInput,
+		asnDBInput:      asnDBInput,
+		autoBan:         map[bool]string{true: "Yes", false: "No"}[settings.AutoBan],
+		thresholdInput:  newSettingsNumberInput(settings.AutoBanThreshold, config.DefaultAutoBanThreshold),
+		windowInput:     newSettingsNumberInput(settings.AutoBanWindowMinutes, config.DefaultAutoBanWindowMinutes),
+		banMinutesInput: newSettingsNumberInput(settings.AutoBanMinutes, config.DefaultAutoBanMinutes),
+		rollbackInput:   rollbackInput,
+		intervalInput:   intervalInput,
+		retentionInput:  newSettingsNumberInput(settings.BackupRetention, config.DefaultBackupRetention),
+		refreshInput:    newSettingsNumberInput(settings.InfoRefreshSeconds, defaultInfoRefreshSeconds),
+	}
+}
+
+// textInput returns the text input backing the given field, or nil for option fields.
+func (f *settingsForm) textInput(field int) *textinput.Model {
+	switch field {
+	case settingsFieldCountryDB:
+		return &f.countryDBInput
+	case settingsFieldASNDB:
+		return &f.asnDBInput
+	case settingsFieldAutoBanThreshold:
+		return &f.thresholdInput
+	case settingsFieldAutoBanWindow:
+		return &f.windowInput
+	case settingsFieldAutoBanMinutes:
+		return &f.banMinutesInput
+	case settingsFieldRollback:
+		return &f.rollbackInput
+	case settingsFieldBackupInterval:
+		return &f.intervalInput
+	case settingsFieldBackupRetention:
+		return &f.retentionInput
+	case settingsFieldInfoRefresh:
+		return &f.refreshInput
+	}
+	return nil
+}
+
+func (m *model) settingsFormView() string {
+	var b strings.Builder
+	b.WriteString("  Settings\n\n")
+	b.WriteString("    MaxMind GeoLite2/GeoIP2 databases (.mmdb) used to show the country and\n")
+	b.WriteString("    autonomous system of addresses in Live Pflog and Top Talkers.\n")
+	b.WriteString("    Leave a path empty to not use that database.\n\n")
+
+	for field := 0; field < settingsFieldCount; field++ {
+		if field == settingsFieldAutoBan {
+			b.WriteString("\n    Auto-Ban bans sources with repeated blocked inbound packets in <" + config.AutoBanTable + ">.\n")
+			b.WriteString("    Only packets of rules with \"log\" are seen.\n\n")
+		}
+		if field == settingsFieldRollback {
+			b.WriteString("\n    Save & Apply reverts to the previous rules after this many seconds\n")
+			b.WriteString("    unless the new rules are confirmed. Leave empty to apply without rollback.\n\n")
+		}
+		if field == settingsFieldBackupInterval {
+			b.WriteString("\n    Copies the rules file and the applied anchor to " + config.ScheduledBackupsDirName + "/ in the config\n")
+			b.WriteString("    directory this often while pf-tui runs. Leave empty to not back up.\n")
+			if m.settingsForm.lastBackup.IsZero() {
+				b.WriteString("    Last backup: never\n\n")
+			} else {
+				b.WriteString("    Last backup: " + m.settingsForm.lastBackup.Format("2006-01-02 15:04:05") + "\n\n")
+			}
+		}
+		if field == settingsFieldInfoRefresh {
+			b.WriteString("\n    Show Info runs pfctl this often while the terminal has the focus.\n")
+			b.WriteString("    + and - change it there for the session.\n\n")
+		}
+		label := settingsFieldLabels[field]
+		isFocused := m.settingsForm.focused == field
+		if input := m.settingsForm.textInput(field); input != nil {
+			b.WriteString(renderInput(label, *input, isFocused, m.settingsForm.activeTextInput, field, label))
+		} else {
+			b.WriteString(renderOptions(label, []string{"Yes", "No"}, m.settingsForm.autoBan, isFocused))
+		}
+	}
+
+	b.WriteString("\n\n" + m.helpView("    ") + "\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) focusSettingsForm() {
+	m.settingsForm.countryDBInput.Blur()
+	m.settingsForm.asnDBInput.Blur()
+	m.settingsForm.thresholdInput.Blur()
+	m.settingsForm.windowInput.Blur()
+	m.settingsForm.banMinutesInput.Blur()
+	m.settingsForm.rollbackInput.Blur()
+	m.settingsForm.intervalInput.Blur()
+	m.settingsForm.retentionInput.Blur()
+	m.settingsForm.refreshInput.Blur()
+	if input := m.settingsForm.textInput(m.settingsForm.activeTextInput); input != nil {
+		input.Focus()
+	}
+}
+
+type scrubForm struct {
+	focused         int // one of the scrubField* constants
+	activeTextInput int // -1 if no text input is active, otherwise scrubFieldInterface
+	enabled         string
+	direction       string
+	reassembleTCP   string
+	noDF            string
+	randomID        string
+	fragment        string
+	interfaceInput  textinput.Model
+}
+
+// optionField returns the options and the selected value of the given field, or nil for the interface input.
+func (f *scrubForm) optionField(field int) ([]string, *string) {
+	switch field {
+	case scrubFieldEnabled:
+		return []string{"Yes", "No"}, &f.enabled
+	case scrubFieldDirection:
+		return []string{"in", "out", "both"}, &f.direction
+	case scrubFieldReassembleTCP:
+		return []string{"Yes", "No"}, &f.reassembleTCP
+	case scrubFieldNoDF:
+		return []string{"Yes", "No"}, &f.noDF
+	case scrubFieldRandomID:
+		return []string{"Yes", "No"}, &f.randomID
+	case scrubFieldFragment:
+		return []string{"reassemble", "crop", "drop-ovl"}, &f.fragment
+	}
+	return nil, nil
+}
+
+func (m *model) scrubFormView() string {
+	var b strings.Builder
+	b.WriteString("  Edit Scrub Options\n\n")
+
+	for field := 0; field < scrubFieldCount; field++ {
+		isFocused := m.scrubForm.focused == field
+		label := scrubFieldLabels[field]
+		if field == scrubFieldInterface {
+			b.WriteString(renderInput(label, m.scrubForm.interfaceInput, isFocused, m.scrubForm.activeTextInput, field, label))
+		} else {
+			options, selected := m.scrubForm.optionField(field)
+			b.WriteString(renderOptions(label, options, *selected, isFocused))
+		}
+	}
+
+	b.WriteString("\n" + renderFieldHint(fieldHint(scrubFieldHints[:], m.scrubForm.focused)))
+	b.WriteString(m.helpView("    ") + "\n")
+	b.WriteString("\n    Scrub normalizes packets before they are translated and filtered.\n")
+	b.WriteString("    'No DF' clears the don't-fragment bit and 'Random ID' randomizes IP IDs.\n")
+	b.WriteString("\n    " + m.toastView() + "\n")
+
+	return appStyle.Render(b.String())
+}
+
+func (m *model) focusScrubForm() {
+	m.scrubForm.interfaceInput.Blur()
+	if m.scrubForm.activeTextInput == scrubFieldInterface {
+		m.scrubForm.interfaceInput.Focus()
+	}
+}
+
+func (m *model) focusRuleForm() {
+	// Blur all text inputs first
+	for field := 0; field < ruleFieldCount; field++ {
+		if input := m.form.textInput(field); input != nil {
+			input.Blur()
+		}
+	}
+
+	// If a text input is active, focus only that one
+	if input := m.form.textInput(m.form.activeTextInput); input != nil {
+		input.Focus()
+	}
+}
+
+func (m *model) focusPortForwardingForm() {
+	// Blur all text inputs first
+	m.portForwardingForm.interfaceInput.Blur()
+	m.portForwardingForm.externalIPInput.Blur()
+	m.portForwardingForm.externalPortInput.Blur()
+	m.portForwardingForm.internalIPInput.Blur()
+	m.portForwardingForm.internalPortInput.Blur()
+	m.portForwardingForm.descriptionInput.Blur()
+
+	// If a text input is active, focus only that one
+	if m.portForwardingForm.activeTextInput != -1 {
+		switch m.portForwardingForm.activeTextInput {
+		case 0:
+			m.portForwardingForm.interfaceInput.Focus()
+		case 2:
+			m.portForwardingForm.externalIPInput.Focus()
+		case 3:
+			m.portForwardingForm.externalPortInput.Focus()
+		case 4:
+			m.portForwardingForm.internalIPInput.Focus()
+		case 5:
+			m.portForwardingForm.internalPortInput.Focus()
+		case 6:
+			m.portForwardingForm.descriptionInput.Focus()
+		}
+	} else { // Otherwise, ensure no text input is focused
+		m.portForwardingForm.interfaceInput.Blur()
+		m.portForwardingForm.externalIPInput.Blur()
+		m.portForwardingForm.externalPortInput.Blur()
+		m.portForwardingForm.internalIPInput.Blur()
+		m.portForwardingForm.internalPortInput.Blur()
+		m.portForwardingForm.descriptionInput.Blur()
+	}
+}
+
+func (m *model) focusNatForm() {
+	// Blur all text inputs first
+	m.natForm.interfaceInput.Blur()
+	m.natForm.sourceInput.Blur()
+	m.natForm.destinationInput.Blur()
+	m.natForm.translationInput.Blur()
+	m.natForm.descriptionInput.Blur()
+
+	// If a text input is active, focus only that one
+	switch m.natForm.activeTextInput {
+	case 0:
+		m.natForm.interfaceInput.Focus()
+	case 2:
+		m.natForm.sourceInput.Focus()
+	case 3:
+		m.natForm.destinationInput.Focus()
+	case 4:
+		m.natForm.translationInput.Focus()
+	case 5:
+		m.natForm.descriptionInput.Focus()
+	}
+}
+
+func (m *model) focusTableForm() {
+	// Blur all text inputs first
+	m.tableForm.nameInput.Blur()
+	m.tableForm.addressesInput.Blur()
+	m.tableForm.descriptionInput.Blur()
+	m.tableForm.feedURLInput.Blur()
+	m.tableForm.feedRefreshInput.Blur()
+
+	// If a text input is active, focus only that one
+	switch m.tableForm.activeTextInput {
+	case 0:
+		m.tableForm.nameInput.Focus()
+	case 2:
+		m.tableForm.addressesInput.Focus()
+	case 3:
+		m.tableForm.descriptionInput.Focus()
+	case 4:
+		m.tableForm.feedURLInput.Focus()
+	case 5:
+		m.tableForm.feedRefreshInput.Focus()
+	}
+}
+
+func (m *model) focusMacroForm() {
+	// Blur all text inputs first
+	m.macroForm.nameInput.Blur()
+	m.macroForm.valueInput.Blur()
+	m.macroForm.descriptionInput.Blur()
+
+	// If a text input is active, focus only that one
+	switch m.macroForm.activeTextInput {
+	case 0:
+		m.macroForm.nameInput.Focus()
+	case 1:
+		m.macroForm.valueInput.Focus()
+	case 2:
+		m.macroForm.descriptionInput.Focus()
+	}
+}
+
+func (m *model) infoView() string {
+	title := titleStyle.Render(m.infoViewTitle)
+	if m.refreshesLive() {
+		title += "  " + disabledStyle.Render(m.refreshStatus())
+	}
+	sections := []string{title, m.viewport.View()}
+	if search := m.searchView(); search != "" {
+		sections = append(sections, search)
+	}
+	sections = append(sections, m.helpView(""), m.toastView())
+	return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left, sections...))
+}
+
+// maxPflogLines is the number of pflog lines kept for scrolling back.
+const maxPflogLines = 5000
+
+// refreshPflogView shows the pflog lines that match the filter in the viewport
+// and keeps the selected line in view.
+func (m *model) refreshPflogView() {
+	filter := strings.ToLower(strings.TrimSpace(m.pflogFilterInput.Value()))
+	m.pflogVisible = m.pflogVisible[:0]
+	var shown []string // the visible lines as displayed
+	for _, line := range m.pflogLines {
+		// Annotate the line before filtering so that the filter can match a host name, country or AS
+		display := pflogWithNames(line, m.names())
+		if annotation := m.geoip.Annotate(pfcli.PflogSourceAddress(line)); annotation != "" {
+			display += "  [" + annotation + "]"
+		}
+		if filter == "" || strings.Contains(strings.ToLower(display), filter) {
+			m.pflogVisible = append(m.pflogVisible, line)
+			shown = append(shown, display)
+		}
+	}
+	if m.pflogFollow || m.pflogCursor >= len(m.pflogVisible) {
+		m.pflogCursor = len(m.pflogVisible) - 1
+	}
+	if m.pflogCursor < 0 {
+		m.pflogCursor = 0
+	}
+
+	lines := make([]string, len(shown))
+	for i, line := range shown {
+		lines[i] = renderSelectedLine(line, i == m.pflogCursor)
+	}
+	if len(m.pflogLines) == 0 {
+		lines = append(lines, "Waiting for packets logged by rules with \"log\"...")
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+	if m.pflogCursor < m.viewport.YOffset {
+		m.viewport.SetYOffset(m.pflogCursor)
+	} else if m.pflogCursor >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(m.pflogCursor - m.viewport.Height + 1)
+	}
+}
+
+// openWhois switches to the whois view and looks up addr.
+func (m *model) openWhois(addr string) tea.Cmd {
+	m.whoisTitle = "Whois " + addr
+	m.pushView(whoisView)
+	m.viewport.SetContent(fmt.Sprintf("Looking up %s...", addr))
+	m.viewport.GotoTop()
+	if m.testMode {
+		return func() tea.Msg {
+			return whoisMsg{addr: addr, result: fmt.Sprintf("NetRange:       %s - %s\nOrgName:        Example Org\nCountry:        US\n", addr, addr)}
+		}
+	}
+	return func() tea.Msg {
+		result, err := Whois(addr)
+		if err != nil {
+			result = err.Error()
+		}
+		return whoisMsg{addr: addr, result: result}
+	}
+}
+
+// closeWhois returns from the whois view to the view it was opened from,
+// whose content is shown again in the viewport.
+func (m *model) closeWhois() tea.Cmd {
+	m.popView()
+	if m.currentView == pflogView {
+		m.refreshPflogView()
+		return nil
+	}
+	// The top talkers view stops refreshing when it is left, restart it
+	m.showTopTalkers()
+	return func() tea.Msg { return topTalkersRefreshMsg{} }
+}
+
+var (
+	diffAddedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	diffRemovedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	diffHunkStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+)
+
+// pfKeywordStyles color the first word of the lines of a pf.conf: pass and
+// block rules like added and removed lines, the other statements like hunks.
+var pfKeywordStyles = map[string]lipgloss.Style{
+	"pass":     diffAddedStyle,
+	"block":    diffRemovedStyle,
+	"nat":      diffHunkStyle,
+	"rdr":      diffHunkStyle,
+	"table":    diffHunkStyle,
+	"set":      diffHunkStyle,
+	"scrub":    diffHunkStyle,
+	"dummynet": diffHunkStyle,
+}
+
+// tableRefPattern matches table references such as <blocklist>.
+var tableRefPattern = regexp.MustCompile(`<[A-Za-z0-9_-]+>`)
+
+// highlightPfConf colors a generated pf.conf: comments are dimmed, the
+// keyword each statement starts with is colored, see pfKeywordStyles, and
+// table references are highlighted.
+func highlightPfConf(conf string) string {
+	lines := strings.Split(strings.TrimSuffix(conf, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			lines[i] = disabledStyle.Render(line)
+			continue
+		}
+		line = tableRefPattern.ReplaceAllStringFunc(line, func(ref string) string { return selectedStyle.Render(ref) })
+		keyword, _, _ := strings.Cut(line, " ")
+		if style, ok := pfKeywordStyles[keyword]; ok {
+			line = style.Render(keyword) + line[len(keyword):]
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatApplyPreview colors a unified diff for the apply preview, below the
+// lockout warnings.
+func formatApplyPreview(diff string, lockout []string) string {
+	var warnings string
+	for _, warning := range lockout {
+		warnings += warningStyle.Render("Lockout: "+warning) + "\n"
+	}
+	if warnings != "" {
+		warnings += "\n"
+	}
+	if diff == "" {
+		return warnings + "No changes to the rules.\n\nApplying reloads them as they are, with the global options and pipes."
+	}
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = lipgloss.NewStyle().Bold(true).Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = diffAddedStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = diffRemovedStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = diffHunkStyle.Render(line)
+		}
+	}
+	return warnings + strings.Join(lines, "\n")
+}
+
+// lockoutConfirmation returns the message of the confirmation that applying
+// rules with lockout warnings needs.
+func lockoutConfirmation(warnings []string, question string) string {
+	var b strings.Builder
+	b.WriteString(warningStyle.Render("WARNING: these rules may lock you out of this host") + "\n\n")
+	for _, warning := range warnings {
+		b.WriteString("  - " + warning + "\n")
+	}
+	b.WriteString("\nThe current session keeps its state until it ends or the states are flushed.\n\n")
+	b.WriteString(question)
+	return b.String()
+}
+
+func (m *model) applyPreviewView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render("Review Changes"),
+			m.viewport.View(),
+			m.helpView(""),
+			m.toastView(),
+		),
+	)
+}
+
+// openApplyPreview opens the review of the changes Save & Apply would make.
+func (m *model) openApplyPreview() tea.Cmd {
+	m.pushView(applyPreviewView)
+	m.applyPreviewReady = false
+	m.viewport.SetContent("Loading...")
+	m.viewport.GotoTop()
+	return getApplyPreview(m.firewallManager, m.runner, m.sshSession)
+}
+
+// requestExit asks to confirm exiting, or what to do with the unsaved changes
+// of the configuration first.
+func (m *model) requestExit() {
+	if m.firewallManager.IsDirty() {
+		m.withUnsavedChanges(func() tea.Cmd {
+			m.stopAutoBan()
+			return tea.Quit
+		})
+		return
+	}
+	m.pushView(confirmationView)
+	m.confirming = true
+	m.confirmationMessage = "Are you sure you want to exit?"
+}
+
+// withUnsavedChanges runs next right away if the configuration has no unsaved
+// changes. Otherwise it opens the unsaved changes view, which runs next once
+// the changes are applied, saved or discarded.
+func (m *model) withUnsavedChanges(next func() tea.Cmd) tea.Cmd {
+	if !m.firewallManager.IsDirty() {
+		return next()
+	}
+	m.unsavedNext = next
+	m.pushView(unsavedChangesView)
+	return nil
+}
+
+// runUnsavedNext runs what the unsaved changes view was opened for, if any.
+func (m *model) runUnsavedNext() tea.Cmd {
+	next := m.unsavedNext
+	m.unsavedNext = nil
+	if next == nil {
+		return nil
+	}
+	return next()
+}
+
+func (m *model) unsavedChangesView() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Unsaved Changes") + "\n\n")
+	b.WriteString("The configuration has changes that are not saved yet, such as a new rule order.\n\n")
+	b.WriteString(m.helpView("") + "\n\n")
+	b.WriteString(m.toastView())
+	return appStyle.Render(b.String())
+}
+
+func (m *model) rollbackView() string {
+	remaining := 0
+	if m.rollback != nil {
+		remaining = int(time.Until(m.rollback.Deadline).Round(time.Second).Seconds())
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Confirm New Rules") + "\n\n")
+	b.WriteString(warningStyle.Render(fmt.Sprintf("The previous rules will be restored in %d seconds.", remaining)) + "\n\n")
+	b.WriteString("If the new rules work as intended, confirm them to keep them.\n")
+	b.WriteString("If they cut off this session, they are reverted without any action.\n\n")
+	b.WriteString(m.helpView("") + "\n\n")
+	b.WriteString(m.toastView())
+	return appStyle.Render(b.String())
+}
+
+func tickRollback() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return rollbackTickMsg{}
+	})
+}
+
+func confirmRollback(rollback *config.PendingRollback) tea.Cmd {
+	return func() tea.Msg {
+		if err := rollback.Confirm(); err != nil {
+			return errMsg{err}
+		}
+		if rollback.Snapshot != nil {
+			if err := config.RecordSnapshot(*rollback.Snapshot); err != nil {
+				logging.Error(fmt.Sprintf("Failed to record the snapshot: %v", err))
+			}
+		}
+		return rollbackDoneMsg("New rules confirmed and kept.")
+	}
+}
+
+func revertRollback(rollback *config.PendingRollback) tea.Cmd {
+	return func() tea.Msg {
+		if output, err := rollback.Revert(); err != nil {
+			return errMsg{fmt.Errorf("failed to revert the rules: %w, output: %s", err, output)}
+		}
+		return rollbackDoneMsg("Reverted to the previous rules.")
+	}
+}
+
+func (m *model) whoisView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render(m.whoisTitle),
+			m.viewport.View(),
+			m.helpView(""),
+		),
+	)
+}
+
+// openRuleDetails shows the details of a rule, returning to the current rule
+// list when closed.
+func (m *model) openRuleDetails(title, content string) {
+	m.detailTitle = title
+	m.pushView(ruleDetailView)
+	m.viewport.SetContent(content)
+	m.viewport.GotoTop()
+}
+
+func (m *model) ruleDetailView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render(m.detailTitle),
+			m.viewport.View(),
+			m.helpView(""),
+		),
+	)
+}
+
+// formatRuleMetadata formats the ID and timestamps FirewallManager keeps for a
+// rule. Rules saved before the timestamps existed have none.
+func formatRuleMetadata(id string, created, modified *time.Time) string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "unknown"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	if id == "" {
+		id = "(assigned on save)"
+	}
+	return fmt.Sprintf("ID:          %s\nCreated:     %s\nModified:    %s\n",
+		id, formatTime(created), formatTime(modified))
+}
+
+// formatRuleFields formats label and value pairs one per line, skipping empty values.
+func formatRuleFields(fields ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] != "" {
+			b.WriteString(fmt.Sprintf("%-12s %s\n", fields[i]+":", fields[i+1]))
+		}
+	}
+	return b.String()
+}
+
+func formatFirewallRuleDetails(rule config.FirewallRule) string {
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	source, destination := rule.Source, rule.Destination
+	if rule.SourceNot {
+		source = "! " + source
+	}
+	if rule.DestinationNot {
+		destination = "! " + destination
+	}
+	var limits []string
+	if rule.StateMax > 0 {
+		limits = append(limits, fmt.Sprintf("max %d", rule.StateMax))
+	}
+	if rule.MaxSrcConn > 0 {
+		limits = append(limits, fmt.Sprintf("max-src-conn %d", rule.MaxSrcConn))
+	}
+	if rule.MaxSrcConnRate != "" {
+		limits = append(limits, "max-src-conn-rate "+rule.MaxSrcConnRate)
+	}
+	if rule.OverloadTable != "" {
+		limits = append(limits, strings.TrimSpace("overload <"+rule.OverloadTable+"> "+rule.OverloadFlush))
+	}
+	var pipe, probability string
+	if rule.Pipe > 0 {
+		pipe = strconv.Itoa(rule.Pipe)
+	}
+	if rule.Probability > 0 {
+		probability = fmt.Sprintf("%d%%", rule.Probability)
+	}
+	label := ""
+	if rule.ID != "" {
+		label = config.RuleLabel(rule)
+	}
+	return formatRuleMetadata(rule.ID, rule.CreatedAt, rule.ModifiedAt) + "\n" + formatRuleFields(
+		"Enabled", yesNo[rule.Enabled],
+		"Group", rule.Group,
+		"Action", rule.Action,
+		"Direction", rule.Direction,
+		"Quick", yesNo[rule.Quick],
+		"Log", rule.Log,
+		"Interface", rule.Interface,
+		"Route", config.FormatRoute(rule),
+		"Protocol", rule.Protocol,
+		"ICMP type", strings.TrimSpace(rule.IcmpType+" "+rule.IcmpCode),
+		"Source", source,
+		"Source port", rule.SourcePort,
+		"Destination", destination,
+		"Dest port", rule.DestinationPort,
+		"State", rule.State,
+		"Limits", strings.Join(limits, ", "),
+		"Pipe", pipe,
+		"Probability", probability,
+		"Label", label,
+		"Description", rule.Description,
+	)
+}
+
+func formatPortForwardingRuleDetails(rule config.PortForwardingRule) string {
+	return formatRuleMetadata(rule.ID, rule.CreatedAt, rule.ModifiedAt) + "\n" + formatRuleFields(
+		"Enabled", map[bool]string{true: "yes", false: "no"}[rule.Enabled],
+		"Interface", rule.Interface,
+		"Protocol", rule.Protocol,
+		"External", rule.ExternalIP+":"+rule.ExternalPort,
+		"Internal", rule.InternalIP+":"+rule.InternalPort,
+		"Description", rule.Description,
+	)
+}
+
+func formatNatRuleDetails(rule config.NatRule) string {
+	translation := rule.Translation
+	if translation == "" {
+		translation = fmt.Sprintf("(%s)", rule.Interface)
+	}
+	return formatRuleMetadata(rule.ID, rule.CreatedAt, rule.ModifiedAt) + "\n" + formatRuleFields(
+		"Interface", rule.Interface,
+		"Protocol", rule.Protocol,
+		"Source", rule.Source,
+		"Destination", rule.Destination,
+		"Translation", translation,
+		"Description", rule.Description,
+	)
+}
+
+// pflogWithNames replaces the source and destination addresses of a pflog line
+// with their host names, keeping the ports.
+func pflogWithNames(line string, names *Resolver) string {
+	if names == nil {
+		return line
+	}
+	end := strings.Index(line, " > ")
+	if end == -1 {
+		return line
+	}
+	// The destination first, so that end stays valid for the source
+	if addr := pfcli.PflogDestinationAddress(line); addr != "" {
+		if name := names.Name(addr); name != "" && strings.HasPrefix(line[end+3:], addr) {
+			line = line[:end+3] + name + line[end+3+len(addr):]
+		}
+	}
+	if addr := pfcli.PflogSourceAddress(line); addr != "" {
+		start := strings.LastIndex(line[:end], " ") + 1
+		if name := names.Name(addr); name != "" && strings.HasPrefix(line[start:end], addr) {
+			line = line[:start] + name + line[start+len(addr):]
+		}
+	}
+	return line
+}
+
+// names returns the resolver if host names are shown, otherwise nil.
+func (m *model) names() *Resolver {
+	if !m.resolveNames {
+		return nil
+	}
+	return m.resolver
+}
+
+// resolveAddresses looks up the host names of the addresses that have not been
+// looked up yet, if host names are shown. Each lookup reports back with a
+// hostnameResolvedMsg so that the view shows the name.
+func (m *model) resolveAddresses(addrs ...string) tea.Cmd {
+	if !m.resolveNames {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, addr := range m.resolver.Unresolved(addrs...) {
+		addr := addr
+		cmds = append(cmds, func() tea.Msg {
+			m.resolver.Resolve(addr)
+			return hostnameResolvedMsg{}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// resolveTopTalkers looks up the host names of the hosts in the top talkers view.
+func (m *model) resolveTopTalkers() tea.Cmd {
+	var addrs []string
+	for i, talker := range m.topTalkers {
+		if i == maxTopTalkers {
+			break
+		}
+		addrs = append(addrs, talker.Host)
+	}
+	return m.resolveAddresses(addrs...)
+}
+
+// quickBlock runs one of the quick-block actions of the pflog view on addr.
+func quickBlock(block func(addr string) error, addr, status string) tea.Cmd {
+	return func() tea.Msg {
+		if err := block(addr); err != nil {
+			return errMsg{err}
+		}
+		return quickBlockedMsg(status)
+	}
+}
+
+// applyQuickBlock saves and applies the configuration like "Save & Apply
+// Configuration", but reports back without leaving the current view.
+func applyQuickBlock(fm *config.FirewallManager, runner pfcli.CommandRunner) tea.Cmd {
+	return runOperation("Applying the rules", func(step func(string)) tea.Msg {
+		msg := saveAndApply(fm, runner, step)
+		if saved, ok := msg.(rulesAppliedMsg); ok {
+			return quickBlockAppliedMsg(saved)
+		}
+		return msg
+	})
+}
+
+func newPflogFilterInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Placeholder = "e.g. block, en0 or an address"
+	input.Blur()
+	return input
+}
+
+func (m *model) stopAutoBan() {
+	if m.autoBan != nil {
+		m.autoBan.Stop()
+		m.autoBan = nil
+	}
+}
+
+// restartAutoBan stops the auto-ban watcher and starts it again with settings, if enabled.
+func (m *model) restartAutoBan(settings config.Settings) tea.Cmd {
+	m.stopAutoBan()
+	if !settings.AutoBan {
+		return nil
+	}
+	banner, err := config.StartAutoBan(m.runner, settings)
+	if err != nil {
+		m.notify(levelError, fmt.Sprintf("Failed to start auto-ban: %v", err))
+		return nil
+	}
+	m.autoBan = banner
+	return waitForAutoBan(banner)
+}
+
+func (m *model) stopPflog() {
+	if m.pflog != nil {
+		m.pflog.Stop()
+		m.pflog = nil
+	}
+}
+
+func (m *model) pflogView() string {
+	var status []string
+	if m.pflogPaused {
+		status = append(status, "PAUSED")
+	}
+	if m.pflogFollow {
+		status = append(status, "following")
+	}
+	status = append(status, fmt.Sprintf("%d lines", len(m.pflogLines)))
+	filter := "Filter: " + m.pflogFilterInput.Value()
+	if m.pflogFiltering {
+		filter = "Filter: " + m.pflogFilterInput.View()
+	}
+
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render("Live Pflog")+"  "+strings.Join(status, " | "),
+			m.viewport.View(),
+			filter,
+			m.helpView(""),
+			m.toastView(),
+		),
+	)
+}
+
+// exportFormats are the formats the export view switches between: the
+// configuration formats, and the generated pf.conf.
+var exportFormats = []string{string(config.FormatJSON), string(config.FormatYAML), string(config.FormatTOML), "pf.conf"}
+
+// exportExtension returns the file name extension of an export format.
+func exportExtension(format string) string {
+	if format == "pf.conf" {
+		return ".conf"
+	}
+	return config.ConfigFormat(format).Extension()
+}
+
+func (m *model) saveConfigView() string {
+	title := "Export Configuration As..."
+	if m.exportRuleIDs != nil {
+		title = fmt.Sprintf("Export %d Selected Rules As...", len(m.exportRuleIDs))
+	}
+	lines := []string{title, m.textinput.View()}
+	if len(m.exportMatches) > 0 {
+		lines = append(lines, disabledStyle.Render(strings.Join(m.exportMatches, "  ")))
+	}
+	lines = append(lines, renderOptions("Format", exportFormats, m.exportFormat, true))
+	if len(m.recentExports) > 0 {
+		recent := make([]string, len(m.recentExports))
+		for i, dir := range m.recentExports {
+			recent[i] = "  " + dir
+			if i == m.recentExportIndex {
+				recent[i] = "> " + dir
+			}
+		}
+		lines = append(lines, "Recent destinations:\n"+strings.Join(recent, "\n"))
+	}
+	lines = append(lines, m.helpView(""))
+	return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func (m *model) importConfigView() string {
+	footer := m.helpView("")
+	if m.browseTyping {
+		footer = lipgloss.JoinVertical(lipgloss.Left, m.textinput.View(), footer)
+	}
+	return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left, m.fileList.View(), footer, m.toastView()))
+}
+
+func (m *model) ruleGroupView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			fmt.Sprintf("Move %d Rules to Group", len(m.groupRuleIDs)),
+			m.textinput.View(),
+			disabledStyle.Render("Leave the name empty to take them out of their group."),
+			m.helpView(""),
+			m.toastView(),
+		),
+	)
+}
+
+// serviceListItem is a service in servicePickerView.
+type serviceListItem struct {
+	service config.Service
+}
+
+func (i serviceListItem) Title() string { return i.service.Name }
+func (i serviceListItem) Description() string {
+	return fmt.Sprintf("%d  %s", i.service.Port, i.service.Description)
+}
+func (i serviceListItem) FilterValue() string {
+	return fmt.Sprintf("%s %d %s", i.service.Name, i.service.Port, i.service.Description)
+}
+
+// openServicePicker opens servicePickerView for a port field of the rule form.
+func (m *model) openServicePicker(field int) {
+	services := config.PickableServices()
+	items := make([]list.Item, len(services))
+	for i, service := range services {
+		items[i] = serviceListItem{service: service}
+	}
+	m.serviceList.ResetFilter()
+	m.serviceList.SetItems(items)
+	m.serviceList.Select(0)
+	m.serviceField = field
+	m.pushView(servicePickerView)
+}
+
+// pickService adds a service to the port field servicePickerView was opened
+// for, in place of "any", and returns to the rule form.
+func (m *model) pickService(name string) {
+	input := m.form.textInput(m.serviceField)
+	ports := config.SplitList(input.Value())
+	switch {
+	case len(ports) == 0 || input.Value() == "any":
+		input.SetValue(name)
+	case !slices.Contains(ports, name):
+		input.SetValue(input.Value() + ", " + name)
+	}
+	m.popView()
+}
+
+func (m *model) servicePickerView() string {
+	return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left, m.serviceList.View(), m.helpView("")))
+}
+
+func (m *model) pfConfPathView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			"Import pf.conf or Anchor File",
+			m.textinput.View(),
+			m.helpView(""),
+			m.toastView(),
+		),
+	)
+}
+
+func (m *model) pfConfImportView() string {
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render("Import "+m.pfConfImportSource),
+			m.viewport.View(),
+			m.helpView(""),
+		),
+	)
+}
+
+func (m *model) importPreviewView() string {
+	title := "Import"
+	if m.importPreview != nil {
+		title += " " + m.importPreview.Path
+	}
+	return appStyle.Render(
+		lipgloss.JoinVertical(lipgloss.Left,
+			titleStyle.Render(title),
+			m.viewport.View(),
+			m.helpView(""),
+		),
+	)
+}
+
+// fileInfo is a file or directory in the import file browser.
+type fileInfo struct {
+	name    string
+	path    string
+	isDir   bool
+	modTime time.Time
+}
+
+func (i fileInfo) Title() string {
+	if i.isDir {
+		return i.name + "/"
+	}
+	return i.name
+}
+
+func (i fileInfo) Description() string {
+	if i.name == ".." {
+		return "parent directory"
+	}
+	if i.isDir {
+		return "directory"
+	}
+	return i.modTime.Format("2006-01-02 15:04:05")
+}
+
+func (i fileInfo) FilterValue() string { return i.name }
+
+type backupListItem struct {
+	backup  config.ConfigBackup
+	summary string // what the backup configures, or why it cannot be read
+}
+
+func (i backupListItem) Title() string       { return i.backup.Time.Format("2006-01-02 15:04:05") }
+func (i backupListItem) Description() string { return i.summary }
+func (i backupListItem) FilterValue() string { return i.backup.Path }
+
+// summarizeConfig describes the size of a configuration for the backup list.
+func summarizeConfig(cfg config.Config) string {
+	return fmt.Sprintf("%d rules, %d port forwarding, %d NAT, %d tables, %d macros",
+		len(cfg.FirewallRules), len(cfg.PortForwardingRules), len(cfg.NatRules), len(cfg.Tables), len(cfg.Macros))
+}
+
+func getBackupList() tea.Msg {
+	backups, err := config.ListConfigBackups()
+	if err != nil {
+		logging.Error(fmt.Sprintf("Error listing backups: %v", err))
+		return errMsg{err}
+	}
+	items := make([]list.Item, len(backups))
+	for i, backup := range backups {
+		item := backupListItem{backup: backup}
+		var cfg config.Config
+		if data, err := os.ReadFile(backup.Path); err != nil {
+			item.summary = fmt.Sprintf("cannot be read: %v", err)
+		} else if _, _, err := config.UnmarshalConfig(data, config.ConfigFormatOf(backup.Path), &cfg); err != nil {
+			item.summary = fmt.Sprintf("invalid configuration: %v", err)
+		} else {
+			item.summary = summarizeConfig(cfg)
+		}
+		items[i] = item
+	}
+	return backupListMsg(items)
+}
+
+func restoreBackup(fm *config.FirewallManager, backup config.ConfigBackup) tea.Cmd {
+	return func() tea.Msg {
+		logging.Info(fmt.Sprintf("Restoring backup: %s", backup.Path))
+		if err := fm.ImportConfigFile(backup.Path); err != nil {
+			logging.Error(fmt.Sprintf("Error restoring backup: %v", err))
+			return errMsg{err}
+		}
+		return configLoadedMsg(fmt.Sprintf("Restored the configuration replaced at %s. Save & Apply to load it.",
+			backup.Time.Format("2006-01-02 15:04:05")))
+	}
+}
+
+// backupDiff returns the changes restoring a backup would make to the rules file.
+func backupDiff(backup config.ConfigBackup) string {
+	data, err := os.ReadFile(backup.Path)
+	if err != nil {
+		return fmt.Sprintf("Cannot read the backup: %v", err)
+	}
+	path, err := config.GetDefaultConfigPath()
+	if err != nil {
+		return err.Error()
+	}
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Sprintf("Cannot read %s: %v", path, err)
+	}
+	diff := UnifiedDiff(filepath.Base(path), filepath.Base(backup.Path), string(current), string(data), 2)
+	if diff == "" {
+		return fmt.Sprintf("Same as the current %s.", filepath.Base(path))
+	}
+	return diff
+}
+
+type snapshotListItem struct {
+	snapshot config.Snapshot
+	summary  string // what the configuration of the snapshot has
+}
+
+func (i snapshotListItem) Title() string       { return i.snapshot.Time.Format("2006-01-02 15:04:05") }
+func (i snapshotListItem) Description() string { return i.summary }
+func (i snapshotListItem) FilterValue() string { return i.Title() }
+
+func getHistoryList() tea.Msg {
+	snapshots, err := config.ListSnapshots()
+	if err != nil {
+		logging.Error(fmt.Sprintf("Error listing snapshots: %v", err))
+		return errMsg{err}
+	}
+	items := make([]list.Item, len(snapshots))
+	for i, snapshot := range snapshots {
+		item := snapshotListItem{snapshot: snapshot}
+		var cfg config.Config
+		if _, _, err := config.UnmarshalConfig(snapshot.Config, config.FormatJSON, &cfg); err != nil {
+			item.summary = fmt.Sprintf("invalid configuration: %v", err)
+		} else {
+			item.summary = summarizeConfig(cfg)
+		}
+		items[i] = item
+	}
+	return historyListMsg(items)
+}
+
+func restoreSnapshot(fm *config.FirewallManager, snapshot config.Snapshot) tea.Cmd {
+	return func() tea.Msg {
+		if err := fm.RestoreSnapshot(snapshot); err != nil {
+			logging.Error(fmt.Sprintf("Error restoring snapshot: %v", err))
+			return errMsg{err}
+		}
+		return snapshotRestoredMsg(fmt.Sprintf("Restored the configuration applied at %s.", snapshot.Time.Format("2006-01-02 15:04:05")))
+	}
+}
+
+// historyDiffSnapshots returns the snapshots the history view diffs: the
+// marked one, or else the one before the selected one, and the selected one.
+// The base is nil if the selected snapshot is the oldest.
+func (m *model) historyDiffSnapshots() (base, selected *config.Snapshot) {
+	items := m.historyList.Items()
+	index := m.historyList.Index()
+	if index < 0 || index >= len(items) {
+		return nil, nil
+	}
+	selectedItem := items[index].(snapshotListItem)
+	selected = &selectedItem.snapshot
+	for i, item := range items {
+		snapshot := item.(snapshotListItem).snapshot
+		if (m.historyBase.IsZero() && i == index+1) || (!m.historyBase.IsZero() && snapshot.Time.Equal(m.historyBase)) {
+			base = &snapshot
+		}
+	}
+	return base, selected
+}
+
+func (m *model) historyView() string {
+	var s strings.Builder
+	s.WriteString(m.historyList.View())
+	s.WriteString("\n")
+	base, selected := m.historyDiffSnapshots()
+	if selected == nil {
+		s.WriteString("  No snapshots yet. One is recorded each time Save & Apply succeeds.\n")
+	} else {
+		name := func(snapshot *config.Snapshot) string {
+			if snapshot == nil {
+				return "(nothing)"
+			}
+			return snapshot.Time.Format("2006-01-02 15:04:05")
+		}
+		text := func(snapshot *config.Snapshot) string {
+			if snapshot == nil {
+				return ""
+			} else if m.historyShowConfig {
+				return snapshot.ConfigText()
+			}
+			return snapshot.PfConf
+		}
+		what := "generated rules"
+		if m.historyShowConfig {
+			what = "configuration"
+		}
+		key := fmt.Sprintf("%s %s %v", name(base), name(selected), m.historyShowConfig)
+		if key != m.historyDiffKey {
+			m.historyDiffKey = key
+			m.historyDiffOffset = 0
+			m.historyDiff = UnifiedDiff(name(base), name(selected), text(base), text(selected), 3)
+			if m.historyDiff == "" {
+				m.historyDiff = "No changes."
+			}
+		}
+		s.WriteString(fmt.Sprintf("  Changes to the %s from %s to %s:\n", what, name(base), name(selected)))
+
+		// The diff takes the rest of the screen below the list
+		lines := strings.Split(formatApplyPreview(m.historyDiff, nil), "\n")
+		if m.historyDiffOffset >= len(lines) {
+			m.historyDiffOffset = len(lines) - 1
+		}
+		lines = lines[m.historyDiffOffset:]
+		if height := m.height - m.historyList.Height() - 9; len(lines) > height && height > 0 {
+			lines = append(lines[:height-1], "...")
+		}
+		s.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	s.WriteString("\n" + m.helpView("  "))
+	if m.notifications.toast != nil {
+		s.WriteString("\n  " + m.toastView())
+	}
+	return appStyle.Render(s.String())
+}
+
+func (m *model) backupsView() string {
+	var s strings.Builder
+	s.WriteString(m.backupList.View())
+	s.WriteString("\n")
+	selected, ok := m.backupList.SelectedItem().(backupListItem)
+	if !ok {
+		s.WriteString("  No backups yet. rules.json is backed up each time a save or import replaces it.\n")
+	} else {
+		if selected.backup.Path != m.backupPreviewPath {
+			m.backupPreviewPath = selected.backup.Path
+			m.backupPreview = backupDiff(selected.backup)
+		}
+		// The preview takes the rest of the screen below the list
+		lines := strings.Split(formatApplyPreview(m.backupPreview, nil), "\n")
+		if height := m.height - m.backupList.Height() - 8; len(lines) > height && height > 0 {
+			lines = append(lines[:height-1], "...")
+		}
+		s.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	s.WriteString("\n" + m.helpView("  "))
+	if m.notifications.toast != nil {
+		s.WriteString("\n  " + m.toastView())
+	}
+	return appStyle.Render(s.String())
+}
+
+type archiveListItem struct {
+	rule  config.ArchivedRule
+	index int
+}
+
+func (i archiveListItem) Title() string {
+	return fmt.Sprintf("%-15s %s", i.rule.Kind(), archivedRuleSummary(i.rule))
+}
+
+func (i archiveListItem) Description() string {
+	return "archived " + i.rule.ArchivedAt.Format("2006-01-02 15:04:05")
+}
+
+func (i archiveListItem) FilterValue() string { return archivedRuleSummary(i.rule) }
+
+// archivedRuleSummary describes an archived rule on one line, like the rule lists do.
+func archivedRuleSummary(archived config.ArchivedRule) string {
+	var summary string
+	switch {
+	case archived.FirewallRule != nil:
+		rule := archived.FirewallRule
+		summary = fmt.Sprintf("%s %s %s from %s to %s port %s", rule.Action, rule.Direction, rule.Protocol,
+			config.FormatHost(rule.Source, rule.SourceNot), config.FormatHost(rule.Destination, rule.DestinationNot), rule.DestinationPort)
+		if rule.Description != "" {
+			summary += "  " + rule.Description
+		}
+	case archived.PortForwarding != nil:
+		rule := archived.PortForwarding
+		summary = fmt.Sprintf("%s %s %s:%s -> %s:%s", rule.Interface, rule.Protocol, rule.ExternalIP, rule.ExternalPort, rule.InternalIP, rule.InternalPort)
+		if rule.Description != "" {
+			summary += "  " + rule.Description
+		}
+	case archived.Nat != nil:
+		rule := archived.Nat
+		translation := rule.Translation
+		if translation == "" {
+			translation = fmt.Sprintf("(%s)", rule.Interface)
+		}
+		summary = fmt.Sprintf("%s %s %s -> %s", rule.Interface, rule.Source, rule.Destination, translation)
+		if rule.Description != "" {
+			summary += "  " + rule.Description
+		}
+	}
+	return summary
+}
+
+// formatArchivedRuleDetails returns the details of the rule in an archive entry.
+func formatArchivedRuleDetails(archived config.ArchivedRule) string {
+	switch {
+	case archived.FirewallRule != nil:
+		return formatFirewallRuleDetails(*archived.FirewallRule)
+	case archived.PortForwarding != nil:
+		return formatPortForwardingRuleDetails(*archived.PortForwarding)
+	case archived.Nat != nil:
+		return formatNatRuleDetails(*archived.Nat)
+	}
+	return ""
+}
+
+func (m *model) updateArchiveList() {
+	items := []list.Item{}
+	for i, rule := range m.firewallManager.Config.Archive {
+		items = append(items, archiveListItem{rule: rule, index: i})
+	}
+	m.archiveList.SetItems(items)
+}
+
+func restoreArchivedRule(fm *config.FirewallManager, index int) tea.Cmd {
+	return func() tea.Msg {
+		if err := fm.RestoreArchivedRule(index); err != nil {
+			return errMsg{err}
+		}
+		return archiveChangedMsg("Rule restored to the end of its list.")
+	}
+}
+
+func purgeArchivedRule(fm *config.FirewallManager, index int) tea.Cmd {
+	return func() tea.Msg {
+		if err := fm.PurgeArchivedRule(index); err != nil {
+			return errMsg{err}
+		}
+		return archiveChangedMsg("Archived rule deleted.")
+	}
+}
+
+func purgeArchive(fm *config.FirewallManager) tea.Cmd {
+	return func() tea.Msg {
+		if err := fm.PurgeArchive(); err != nil {
+			return errMsg{err}
+		}
+		return archiveChangedMsg("Archive emptied.")
+	}
+}
+
+func (m *model) archiveView() string {
+	var s strings.Builder
+	s.WriteString(m.archiveList.View())
+	s.WriteString("\n")
+	selected, ok := m.archiveList.SelectedItem().(archiveListItem)
+	if !ok {
+		s.WriteString("  No archived rules. Deleted rules are kept here until they are purged.\n")
+	} else {
+		// The details take the rest of the screen below the list
+		lines := strings.Split(strings.TrimRight(formatArchivedRuleDetails(selected.rule), "\n"), "\n")
+		if height := m.height - m.archiveList.Height() - 8; len(lines) > height && height > 0 {
+			lines = append(lines[:height-1], "...")
+		}
+		s.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	s.WriteString("\n" + m.helpView("  "))
+	if m.notifications.toast != nil {
+		s.WriteString("\n  " + m.toastView())
+	}
+	return appStyle.Render(s.String())
+}
+
+type ruleListItem struct {
+	rule     config.FirewallRule
+	index    int
+	marked   bool                // selected for exporting
+	counters *pfcli.RuleCounters // nil if pf has no counters for this rule (e.g. not applied yet)
+	err      string              // error pfctl reported for this rule on the last Save & Apply
+	shadow   string              // why the rule can never match, see ShadowedRules
+	columns  []string            // the columns shown, see ruleColumns
+}
+
+func (i ruleListItem) Title() string {
+	marker := " "
+	if i.marked {
+		marker = "*"
+	}
+	title := fmt.Sprintf("%s%3d  %-7s %-5s ", marker, i.index+1, i.rule.Action, i.rule.Direction)
+	for _, column := range ruleColumns {
+		if slices.Contains(i.columns, column.name) {
+			title += fmt.Sprintf("%-*s ", column.width, column.value(i))
+		}
+	}
+	title = strings.TrimRight(title, " ")
+	if i.err != "" {
+		title += "  " + warningStyle.Render("pfctl: "+i.err)
+	}
+	if i.shadow != "" {
+		title += "  " + warningStyle.Render(i.shadow)
+	}
+	if !i.rule.Enabled {
+		return disabledStyle.Render(title + " (disabled)")
+	}
+	return title
+}
+
+// ruleGroupListItem is the header row of a rule group in the rule list.
+type ruleGroupListItem struct {
+	name      string
+	size      int
+	collapsed bool
+}
+
+func (i ruleGroupListItem) Title() string {
+	marker := groupMarker(i.collapsed)
+	rules := "rules"
+	if i.size == 1 {
+		rules = "rule"
+	}
+	return selectedItemStyle.Render(fmt.Sprintf("%s %s (%d %s)", marker, i.name, i.size, rules))
+}
+
+func (i ruleGroupListItem) Description() string { return "" }
+func (i ruleGroupListItem) FilterValue() string { return i.name }
+
+// formatCount renders a counter compactly, e.g. 1234567 as "1.2M".
+func formatCount(n uint64) string {
+	switch {
+	case n >= 1000000000:
+		return fmt.Sprintf("%.1fG", float64(n)/1e9)
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d", n)
+}
+func (i ruleListItem) Description() string { return i.details() }
+func (i ruleListItem) FilterValue() string { return i.rule.Description }
+
+type portForwardingListItem struct {
+	rule  config.PortForwardingRule
+	index int
+}
+
+func (i portForwardingListItem) Title() string {
+	title := fmt.Sprintf("%3d  %-15s %-7s %-15s:%-5s -> %-15s:%-5s %s",
+		i.index+1,
+		i.rule.Interface,
+		i.rule.Protocol,
+		i.rule.ExternalIP,
+		i.rule.ExternalPort,
+		i.rule.InternalIP,
+		i.rule.InternalPort,
+		i.rule.Description,
+	)
+	if !i.rule.Enabled {
+		return disabledStyle.Render(title + " (disabled)")
+	}
+	return title
+}
+
+func (i portForwardingListItem) Description() string { return "" }
+func (i portForwardingListItem) FilterValue() string { return i.rule.Description }
+
+type natListItem struct {
+	rule  config.NatRule
+	index int
+}
+
+func (i natListItem) Title() string {
+	translation := i.rule.Translation
+	if translation == "" {
+		translation = fmt.Sprintf("(%s)", i.rule.Interface)
+	}
+	return fmt.Sprintf("%3d  %-15s %-7s %-18s %-18s %-18s %s",
+		i.index+1,
+		i.rule.Interface,
+		i.rule.Protocol,
+		i.rule.Source,
+		i.rule.Destination,
+		translation,
+		i.rule.Description,
+	)
+}
+
+func (i natListItem) Description() string { return "" }
+func (i natListItem) FilterValue() string { return i.rule.Description }
+
+type tableListItem struct {
+	table config.PfTable
+	index int
+	feed  string // status of the table's feed, "" if it has none
+}
+
+func (i tableListItem) Title() string {
+	persist := ""
+	if i.table.Persist {
+		persist = "Y"
+	}
+	addresses := strings.Join(i.table.Addresses, ", ")
+	if i.feed != "" {
+		addresses = i.feed
+	}
+	if len(addresses) > 40 {
+		addresses = addresses[:37] + "..."
+	}
+	return fmt.Sprintf("%3d  %-20s %-3s %-40s %s",
+		i.index+1,
+		"<"+i.table.Name+">",
+		persist,
+		addresses,
+		i.table.Description,
+	)
+}
+
+func (i tableListItem) Description() string { return "" }
+func (i tableListItem) FilterValue() string { return i.table.Name }
+
+type tableEntryListItem struct {
+	addr  string
+	index int
+}
+
+func (i tableEntryListItem) Title() string       { return fmt.Sprintf("%3d  %s", i.index+1, i.addr) }
+func (i tableEntryListItem) Description() string { return "" }
+func (i tableEntryListItem) FilterValue() string { return i.addr }
+
+type macroListItem struct {
+	macro config.Macro
+	index int
+}
+
+func (i macroListItem) Title() string {
+	return fmt.Sprintf("%3d  %-20s %-30s %s",
+		i.index+1,
+		"$"+i.macro.Name,
+		i.macro.Value,
+		i.macro.Description,
+	)
+}
+
+func (i macroListItem) Description() string { return "" }
+func (i macroListItem) FilterValue() string { return i.macro.Name }
+
+type pipeListItem struct {
+	pipe  config.DummynetPipe
+	index int
+}
+
+func (i pipeListItem) Title() string {
+	bandwidth := i.pipe.Bandwidth
+	if bandwidth == "" {
+		bandwidth = "unlimited"
+	}
+	return fmt.Sprintf("%3d  %-6d %-14s %-8s %-6s %s",
+		i.index+1,
+		i.pipe.Number,
+		bandwidth,
+		fmt.Sprintf("%dms", i.pipe.Delay),
+		map[bool]string{true: strconv.Itoa(i.pipe.QueueSize), false: "-"}[i.pipe.QueueSize > 0],
+		i.pipe.Description,
+	)
+}
+
+func (i pipeListItem) Description() string { return "" }
+func (i pipeListItem) FilterValue() string { return i.pipe.Description }
+
+func (m *model) getRuleListItems() []list.Item {
+	groupSizes := make(map[string]int)
+	for _, rule := range m.firewallManager.Config.FirewallRules {
+		groupSizes[rule.Group]++
+	}
+
+	shadowed := m.firewallManager.ShadowedRules()
+	columns := shownRuleColumns(m.firewallManager.Config.Settings)
+	items := []list.Item{}
+	currentGroup := ""
+	for i, rule := range m.firewallManager.Config.FirewallRules {
+		if rule.Group != currentGroup {
+			currentGroup = rule.Group
+			items = append(items, ruleGroupListItem{
+				name:      rule.Group,
+				size:      groupSizes[rule.Group],
+				collapsed: m.collapsedGroups[rule.Group],
+			})
+		}
+		if rule.Group != "" && m.collapsedGroups[rule.Group] {
+			continue
+		}
+		listItem := ruleListItem{rule: rule, index: i, marked: m.markedRules[rule.ID], err: m.ruleErrors[rule.ID], shadow: shadowed[i], columns: columns}
+		if c, ok := m.ruleCounters[config.RuleLabel(rule)]; ok {
+			listItem.counters = &c
+		}
+		items = append(items, listItem)
+	}
+	return items
+}
+
+// openFirewallRuleForm opens the rule form with the firewall rule at index,
+// to edit it or, if isNew, to add a copy of it.
+func (m *model) openFirewallRuleForm(index int, isNew bool) {
+	m.pushView(ruleFormView)
+	m.form = newRuleForm()
+	m.form.isNew = isNew
+	m.form.ruleIndex = index
+	m.fillRuleForm(m.firewallManager.Config.FirewallRules[index])
+}
+
+// fillRuleForm sets the fields of the rule form to those of a rule.
+func (m *model) fillRuleForm(rule config.FirewallRule) {
+	m.form.enabled = rule.Enabled
+	m.form.action = rule.Action
+	m.form.direction = rule.Direction
+	m.form.quick = map[bool]string{true: "Yes", false: "No"}[rule.Quick]
+	if rule.Log != "" {
+		m.form.log = rule.Log
+	}
+	m.form.interfaceInput.SetValue(rule.Interface)
+	if rule.Route != "" {
+		m.form.route = rule.Route
+	}
+	m.form.routeInterfaceInput.SetValue(rule.RouteInterface)
+	m.form.routeGatewayInput.SetValue(rule.RouteGateway)
+	m.form.protocol = rule.Protocol
+	if rule.IcmpType != "" {
+		m.form.icmpType = rule.IcmpType
+	}
+	if rule.IcmpCode != "" {
+		m.form.icmpCode = rule.IcmpCode
+	}
+	m.form.sourceInput.SetValue(rule.Source)
+	m.form.destinationInput.SetValue(rule.Destination)
+	m.form.sourceNot = map[bool]string{true: "Yes", false: "No"}[rule.SourceNot]
+	m.form.destinationNot = map[bool]string{true: "Yes", false: "No"}[rule.DestinationNot]
+	m.form.sourcePortInput.SetValue(rule.SourcePort)
+	m.form.destinationPortInput.SetValue(rule.DestinationPort)
+	if rule.State != "" {
+		m.form.state = rule.State
+	}
+	if rule.StateMax > 0 {
+		m.form.stateMaxInput.SetValue(strconv.Itoa(rule.StateMax))
+	}
+	if rule.SourceTrack != "" {
+		m.form.sourceTrack = rule.SourceTrack
+	}
+	if rule.MaxSrcConn > 0 {
+		m.form.maxSrcConnInput.SetValue(strconv.Itoa(rule.MaxSrcConn))
+	}
+	m.form.maxSrcConnRateInput.SetValue(rule.MaxSrcConnRate)
+	m.form.overloadTableInput.SetValue(rule.OverloadTable)
+	if rule.OverloadFlush != "" {
+		m.form.overloadFlush = rule.OverloadFlush
+	}
+	if rule.Pipe > 0 {
+		m.form.pipeInput.SetValue(strconv.Itoa(rule.Pipe))
+	}
+	if rule.Probability > 0 {
+		m.form.probabilityInput.SetValue(strconv.Itoa(rule.Probability))
+	}
+	m.form.groupInput.SetValue(rule.Group)
+	m.form.descriptionInput.SetValue(rule.Description)
+	m.focusRuleForm()
+}
+
+// pasteRule opens the rule form with the first filter rule of a pf.conf text,
+// e.g. a line from documentation, to add it as a new rule.
+func (m *model) pasteRule(text string) {
+	imp := config.ParsePfConf(text)
+	if len(imp.FirewallRules) == 0 {
+		reason := "no pass or block rule found"
+		if len(imp.Skipped) > 0 {
+			reason = imp.Skipped[0].Note
+		}
+		m.notify(levelWarn, fmt.Sprintf("Nothing to paste: %s.", reason))
+		return
+	}
+	rule := imp.FirewallRules[0]
+	rule.ID = "" // a pasted pf-tui rule is added as a new one
+	if groupItem, ok := m.ruleList.SelectedItem().(ruleGroupListItem); ok && rule.Group == "" {
+		rule.Group = groupItem.name
+	}
+	m.pushView(ruleFormView)
+	m.form = newRuleForm()
+	m.form.isNew = true
+	m.fillRuleForm(rule)
+
+	var notes []string
+	if len(imp.Recognized) > 0 && imp.Recognized[0].Note != "" {
+		notes = append(notes, "pasted "+imp.Recognized[0].Note)
+	}
+	if len(imp.FirewallRules) > 1 {
+		notes = append(notes, fmt.Sprintf("only the first of %d rules (one per direction if the rule has none)", len(imp.FirewallRules)))
+	}
+	if len(notes) > 0 {
+		m.notify(levelWarn, "Check the pasted rule: "+strings.Join(notes, ", ")+".")
+	}
+}
+
+// openPortForwardingRuleForm opens the port forwarding form with the rule at
+// index, to edit it or, if isNew, to add a copy of it.
+func (m *model) openPortForwardingRuleForm(index int, isNew bool) {
+	m.pushView(portForwardingFormView)
+	m.portForwardingForm = newPortForwardingForm()
+	m.portForwardingForm.isNew = isNew
+	m.portForwardingForm.ruleIndex = index
+	rule := m.firewallManager.Config.PortForwardingRules[index]
+	m.portForwardingForm.enabled = rule.Enabled
+	m.portForwardingForm.interfaceInput.SetValue(rule.Interface)
+	m.portForwardingForm.protocol = rule.Protocol
+	m.portForwardingForm.externalIPInput.SetValue(rule.ExternalIP)
+	m.portForwardingForm.externalPortInput.SetValue(rule.ExternalPort)
+	m.portForwardingForm.internalIPInput.SetValue(rule.InternalIP)
+	m.portForwardingForm.internalPortInput.SetValue(rule.InternalPort)
+	m.portForwardingForm.descriptionInput.SetValue(rule.Description)
+	m.focusPortForwardingForm()
+}
+
+// yankRules copies the rules with the given IDs to the clipboard, as the pf
+// lines they generate or as JSON: an object for one rule, an array for more.
+func (m *model) yankRules(ids map[string]bool, asJSON bool) tea.Cmd {
+	var rules []config.FirewallRule
+	for _, rule := range m.firewallManager.Config.FirewallRules {
+		if ids[rule.ID] {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	var text string
+	if asJSON {
+		var value interface{} = rules
+		if len(rules) == 1 {
+			value = rules[0]
+		}
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return func() tea.Msg { return errMsg{err} }
+		}
+		text = string(data) + "\n"
+	} else {
+		var lines []string
+		for _, rule := range rules {
+			filterRules, dummynetRules := m.firewallManager.GenerateFirewallRule(rule)
+			lines = append(append(lines, filterRules...), dummynetRules...)
+		}
+		text = strings.Join(lines, "\n") + "\n"
+	}
+	format := "pf syntax"
+	if asJSON {
+		format = "JSON"
+	}
+	copied := fmt.Sprintf("Rule copied to the clipboard as %s.", format)
+	if len(rules) > 1 {
+		copied = fmt.Sprintf("%d rules copied to the clipboard as %s.", len(rules), format)
+	}
+	return func() tea.Msg {
+		if err := CopyToClipboard(text); err != nil {
+			return errMsg{err}
+		}
+		return copiedMsg(copied)
+	}
+}
+
+// selectedRuleIDs returns the IDs of the marked rules, or else of the
+// selected rule, for the bulk actions of the rule list.
+func (m *model) selectedRuleIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for id := range m.markedRules {
+		ids[id] = true
+	}
+	if selectedItem, ok := m.ruleList.SelectedItem().(ruleListItem); ok && len(ids) == 0 {
+		ids[selectedItem.rule.ID] = true
+	}
+	return ids
+}
+
+// markRules toggles the selection of the rule with the given ID, or of
+// all the rules of group if id is "": they are all selected unless they
+// already are.
+func (m *model) markRules(group, id string) {
+	if m.markedRules == nil {
+		m.markedRules = make(map[string]bool)
+	}
+	var ids []string
+	all := true
+	for _, rule := range m.firewallManager.Config.FirewallRules {
+		if rule.ID == id || (id == "" && rule.Group == group) {
+			ids = append(ids, rule.ID)
+			all = all && m.markedRules[rule.ID]
+		}
+	}
+	for _, ruleID := range ids {
+		if all {
+			delete(m.markedRules, ruleID)
+		} else {
+			m.markedRules[ruleID] = true
+		}
+	}
+}
+
+// selectRuleListItem selects the first rule list item that matches.
+func (m *model) selectRuleListItem(match func(list.Item) bool) {
+	for i, item := range m.ruleList.Items() {
+		if match(item) {
+			m.ruleList.Select(i)
+			return
+		}
+	}
+}
+
+func (m *model) updateRuleList() tea.Cmd {
+	m.ruleList.SetItems(m.getRuleListItems())
+	return nil
+}
+
+// updateFileList lists dir in the import file browser: its parent, its
+// directories by name, and its configuration files, newest first. The rules
+// file itself is left out of the config directory.
+func (m *model) updateFileList(dir string) tea.Cmd {
+	showHidden := m.browseHidden
+	return func() tea.Msg {
+		configPath, _ := config.GetConfigPath()
+		logging.Info(fmt.Sprintf("Reading files from: %s", dir))
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			logging.Error(fmt.Sprintf("Error reading directory %s: %v", dir, err))
+			return errMsg{err}
+		}
+
+		var dirs, fileInfos []fileInfo
+		for _, file := range files {
+			if !showHidden && strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dir, file.Name())
+			// Follow symlinks, e.g. to mounted volumes
+			info, err := os.Stat(path)
+			if err != nil {
+				logging.Error(fmt.Sprintf("Error getting file info: %v", err))
+				continue
+			}
+			if info.IsDir() {
+				dirs = append(dirs, fileInfo{name: file.Name(), path: path, isDir: true, modTime: info.ModTime()})
+			} else if config.IsConfigFile(file.Name()) && !(dir == configPath && slices.Contains(config.ConfigFileNames, file.Name())) {
+				fileInfos = append(fileInfos, fileInfo{name: file.Name(), path: path, modTime: info.ModTime()})
+			}
+		}
+
+		sort.Slice(dirs, func(i, j int) bool {
+			return strings.ToLower(dirs[i].name) < strings.ToLower(dirs[j].name)
+		})
+		sort.Slice(fileInfos, func(i, j int) bool {
+			return fileInfos[i].modTime.After(fileInfos[j].modTime)
+		})
+
+		var items []list.Item
+		if parent := filepath.Dir(dir); parent != dir {
+			items = append(items, fileInfo{name: "..", path: parent, isDir: true})
+		}
+		for _, fi := range dirs {
+			items = append(items, fi)
+		}
+		for _, fi := range fileInfos {
+			items = append(items, fi)
+		}
+
+		logging.Info(fmt.Sprintf("Found %d configuration files", len(fileInfos)))
+		return fileListMsg{dir: dir, items: items}
+	}
+}
+
+// openBrowsePath opens a path picked or typed in the import file browser: a
+// directory is listed, a file is imported. A typed path can be relative to the
+// current directory or start with ~.
+func (m *model) openBrowsePath(path string) tea.Cmd {
+	if path = config.ExpandHome(path); !filepath.IsAbs(path) {
+		path = filepath.Join(m.browseDir, path)
+	}
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return func() tea.Msg { return errMsg{err} }
+	}
+	if info.IsDir() {
+		return m.updateFileList(path)
+	}
+	return func() tea.Msg { return importPreviewMsg(config.PreviewConfigFile(path)) }
+}
+
+func (m *model) updatePortForwardingList() {
+	m.portForwardingList.SetItems(portForwardingListItems(m.firewallManager.Config.PortForwardingRules))
+}
+
+// portForwardingListItems returns the items of the port forwarding list.
+func portForwardingListItems(rules []config.PortForwardingRule) []list.Item {
+	items := []list.Item{}
+	for i, rule := range rules {
+		items = append(items, portForwardingListItem{rule: rule, index: i})
+	}
+	return items
+}
+
+func (m *model) updateNatList() {
+	items := []list.Item{}
+	for i, rule := range m.firewallManager.Config.NatRules {
+		items = append(items, natListItem{rule: rule, index: i})
+	}
+	m.natList.SetItems(items)
+}
+
+func (m *model) updateTableList() {
+	items := []list.Item{}
+	for i, table := range m.firewallManager.Config.Tables {
+		items = append(items, tableListItem{table: table, index: i, feed: m.feedSummary(table)})
+	}
+	m.tableList.SetItems(items)
+}
+
+// feedSummary describes the state of the blocklist feed of table for the
+// table list, or returns "" if the table has no feed.
+func (m *model) feedSummary(table config.PfTable) string {
+	if table.FeedURL == "" {
+		return ""
+	}
+	if m.feedsUpdating[table.Name] {
+		return "feed: updating..."
+	}
+	status, ok := m.feedStatus[table.Name]
+	if !ok {
+		status = config.GetFeedStatus(table)
+	}
+	summary := "feed: not downloaded"
+	if !status.Updated.IsZero() {
+		summary = fmt.Sprintf("feed: %d entries, %s", status.Entries, status.Updated.Format("01-02 15:04"))
+	}
+	if status.Err != nil {
+		summary += ", failed"
+	}
+	return summary
+}
+
+// updateFeed downloads the feed of table in the background.
+func (m *model) updateFeed(table config.PfTable) tea.Cmd {
+	m.feedsUpdating[table.Name] = true
+	if m.currentView == tableListView {
+		m.updateTableList()
+	}
+	runner := m.runner
+	return func() tea.Msg {
+		return feedUpdatedMsg(config.UpdateFeed(runner, table))
+	}
+}
+
+// updateDueFeeds downloads the feeds that are due and not being downloaded.
+func (m *model) updateDueFeeds() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, table := range m.firewallManager.Config.Tables {
+		if !m.feedsUpdating[table.Name] && config.FeedDue(table) {
+			cmds = append(cmds, m.updateFeed(table))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) updatePipeList() {
+	items := []list.Item{}
+	for i, pipe := range m.firewallManager.Config.Pipes {
+		items = append(items, pipeListItem{pipe: pipe, index: i})
+	}
+	m.pipeList.SetItems(items)
+}
+
+func (m *model) updateMacroList() {
+	items := []list.Item{}
+	for i, macro := range m.firewallManager.Config.Macros {
+		items = append(items, macroListItem{macro: macro, index: i})
+	}
+	m.macroList.SetItems(items)
+}
+
+// checkField validates a form field value, see CheckRuleField.
+func (m *model) checkField(value string, validate func(string) error) error {
+	return m.firewallManager.CheckRuleField(value, validate)
+}
+
+// checkInterface validates an interface field against the interfaces of this host.
+func (m *model) checkInterface(value string) error {
+	return m.checkField(value, func(value string) error { return config.ValidateInterface(value, m.interfaces) })
+}
+
+// checkHosts validates a source, destination or address field, see CheckHosts.
+func (m *model) checkHosts(value string) error {
+	return m.firewallManager.CheckHosts(value)
+}
+
+// ruleFieldError returns the validation error of a text field of the rule
+// form, or nil if it is valid or not checked while typing.
+func (m *model) ruleFieldError(field int) error {
+	switch field {
+	case ruleFieldInterface:
+		return m.checkInterface(m.form.interfaceInput.Value())
+	case ruleFieldRouteInterface:
+		return m.checkInterface(m.form.routeInterfaceInput.Value())
+	case ruleFieldRouteGateway:
+		if m.form.routeGatewayInput.Value() == "" {
+			return nil // optional
+		}
+		return m.checkField(m.form.routeGatewayInput.Value(), func(value string) error {
+			if net.ParseIP(value) == nil {
+				return fmt.Errorf("invalid route gateway %q, expected an IP address", value)
+			}
+			return nil
+		})
+	case ruleFieldSource:
+		return m.checkHosts(m.form.sourceInput.Value())
+	case ruleFieldDestination:
+		return m.checkHosts(m.form.destinationInput.Value())
+	case ruleFieldSourcePort:
+		return m.checkField(m.form.sourcePortInput.Value(), config.ValidatePortList)
+	case ruleFieldDestinationPort:
+		return m.checkField(m.form.destinationPortInput.Value(), config.ValidatePortList)
+	}
+	return nil
+}
+
+// Port forwarding form fields, in the order of portForwardingFormView.
+const (
+	portForwardingFieldInterface    = 0
+	portForwardingFieldExternalIP   = 2
+	portForwardingFieldExternalPort = 3
+	portForwardingFieldInternalIP   = 4
+	portForwardingFieldInternalPort = 5
+	portForwardingFieldCount        = 7
+)
+
+// portForwardingFieldError returns the validation error of a text field of
+// the port forwarding form, or nil if it is valid.
+func (m *model) portForwardingFieldError(field int) error {
+	f := &m.portForwardingForm
+	switch field {
+	case portForwardingFieldInterface:
+		return m.checkInterface(f.interfaceInput.Value())
+	case portForwardingFieldExternalIP:
+		return m.checkHosts(f.externalIPInput.Value())
+	case portForwardingFieldExternalPort:
+		return m.checkField(f.externalPortInput.Value(), func(value string) error {
+			if _, _, ok := config.ParsePortRange(value); !ok && !config.ServiceNamePattern.MatchString(value) {
+				return fmt.Errorf("invalid external port %q, expected a port or a range like 6000:6100", value)
+			} else if !ok && !config.KnownService(value) {
+				return fmt.Errorf("unknown service %q, not in %s", value, config.ServicesFile)
+			}
+			return nil
+		})
+	case portForwardingFieldInternalIP:
+		return m.checkHosts(f.internalIPInput.Value())
+	case portForwardingFieldInternalPort:
+		if m.portForwardingFieldError(portForwardingFieldExternalPort) != nil {
+			return nil // reported on the external port
+		}
+		external := m.firewallManager.ExpandMacros(strings.TrimSpace(f.externalPortInput.Value()))
+		return m.checkField(f.internalPortInput.Value(), func(value string) error { return config.ValidateRdrPorts(external, value) })
+	}
+	return nil
+}
+
+// NAT form fields, in the order of natFormView.
+const (
+	natFieldInterface   = 0
+	natFieldSource      = 2
+	natFieldDestination = 3
+	natFieldTranslation = 4
+	natFieldCount       = 6
+)
+
+// natFieldError returns the validation error of a text field of the NAT
+// form, or nil if it is valid.
+func (m *model) natFieldError(field int) error {
+	switch field {
+	case natFieldInterface:
+		return m.checkInterface(m.natForm.interfaceInput.Value())
+	case natFieldSource:
+		return m.checkHosts(m.natForm.sourceInput.Value())
+	case natFieldDestination:
+		return m.checkHosts(m.natForm.destinationInput.Value())
+	case natFieldTranslation:
+		if m.natForm.translationInput.Value() == "" {
+			return nil // the interface address
+		}
+		return m.checkHosts(m.natForm.translationInput.Value())
+	}
+	return nil
+}
+
+// formRule returns the rule of the rule form, validated as it is saved.
+func (m *model) formRule() (config.FirewallRule, error) {
+	rule := config.FirewallRule{
+		Enabled:         m.form.enabled,
+		Action:          m.form.action,
+		Direction:       m.form.direction,
+		Quick:           m.form.quick == "Yes",
+		Interface:       m.form.interfaceInput.Value(),
+		Protocol:        m.form.protocol,
+		Source:          m.form.sourceInput.Value(),
+		Destination:     m.form.destinationInput.Value(),
+		SourceNot:       m.form.sourceNot == "Yes",
+		DestinationNot:  m.form.destinationNot == "Yes",
+		SourcePort:      m.form.sourcePortInput.Value(),
+		DestinationPort: m.form.destinationPortInput.Value(),
+		Group:           strings.TrimSpace(m.form.groupInput.Value()),
+		Description:     m.form.descriptionInput.Value(),
+	}
+	for _, field := range m.form.visibleFields() {
+		if err := m.ruleFieldError(field); err != nil {
+			return rule, fmt.Errorf("%s: %w", ruleFieldLabels[field], err)
+		}
+	}
+	if value := strings.TrimSpace(m.form.pipeInput.Value()); value != "" {
+		pipe, err := strconv.Atoi(value)
+		if err != nil {
+			return rule, fmt.Errorf("pipe %s is not defined", value)
+		}
+		rule.Pipe = pipe
+	}
+	if value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(m.form.probabilityInput.Value()), "%")); value != "" {
+		probability, err := strconv.Atoi(value)
+		if err != nil || probability < 1 {
+			return rule, fmt.Errorf("invalid probability %q, expected a percentage from 1 to 100", value)
+		}
+		rule.Probability = probability
+	}
+	if m.form.log != "none" {
+		rule.Log = m.form.log
+	}
+	if m.form.route != "none" {
+		rule.Route = m.form.route
+		rule.RouteInterface = strings.TrimSpace(m.form.routeInterfaceInput.Value())
+		rule.RouteGateway = strings.TrimSpace(m.form.routeGatewayInput.Value())
+	}
+	if m.form.state != "default" {
+		rule.State = m.form.state
+	}
+	if rule.State != "" && rule.State != "no state" {
+		if value := strings.TrimSpace(m.form.stateMaxInput.Value()); value != "" {
+			max, err := strconv.Atoi(value)
+			if err != nil {
+				return rule, fmt.Errorf("invalid max states %q", value)
+			}
+			rule.StateMax = max
+		}
+		if m.form.sourceTrack != "none" {
+			rule.SourceTrack = m.form.sourceTrack
+		}
+		if value := strings.TrimSpace(m.form.maxSrcConnInput.Value()); value != "" {
+			max, err := strconv.Atoi(value)
+			if err != nil {
+				return rule, fmt.Errorf("invalid max source connections %q", value)
+			}
+			rule.MaxSrcConn = max
+		}
+		rule.MaxSrcConnRate = strings.TrimSpace(m.form.maxSrcConnRateInput.Value())
+		if rule.OverloadTable = strings.TrimSpace(m.form.overloadTableInput.Value()); rule.OverloadTable != "" && m.form.overloadFlush != "none" {
+			rule.OverloadFlush = m.form.overloadFlush
+		}
+	}
+	if rule.Protocol == "icmp" && m.form.icmpType != "any" {
+		rule.IcmpType = m.form.icmpType
+		if m.form.icmpCode != "any" {
+			rule.IcmpCode = m.form.icmpCode
+		}
+	}
+
+	return m.firewallManager.ValidateFirewallRule(rule, m.interfaces)
+}
+
+func (m *model) saveRule() tea.Cmd {
+	rule, err := m.formRule()
+	if err != nil {
+		return func() tea.Msg { return errMsg{err} }
+	}
+
+	var cmd tea.Cmd
+	if m.form.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddFirewallRule(rule); err != nil {
+				return errMsg{err}
+			}
+			return firewallRuleSavedMsg("Rule added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdateFirewallRule(m.form.ruleIndex, rule); err != nil {
+				return errMsg{err}
+			}
+			return firewallRuleSavedMsg("Rule updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+func (m *model) savePortForwardingRule() tea.Cmd {
+	rule := config.PortForwardingRule{
+		Enabled:      m.portForwardingForm.enabled,
+		Interface:    m.portForwardingForm.interfaceInput.Value(),
+		Protocol:     m.portForwardingForm.protocol,
+		ExternalIP:   m.portForwardingForm.externalIPInput.Value(),
+		ExternalPort: m.portForwardingForm.externalPortInput.Value(),
+		InternalIP:   m.portForwardingForm.internalIPInput.Value(),
+		InternalPort: m.portForwardingForm.internalPortInput.Value(),
+		Description:  m.portForwardingForm.descriptionInput.Value(),
+	}
+
+	for field := 0; field < portForwardingFieldCount; field++ {
+		if err := m.portForwardingFieldError(field); err != nil {
+			return func() tea.Msg { return errMsg{err} }
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.portForwardingForm.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddPortForwardingRule(rule); err != nil {
+				return errMsg{err}
+			}
+			return portForwardingRuleSavedMsg("Port forwarding rule added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdatePortForwardingRule(m.portForwardingForm.ruleIndex, rule); err != nil {
+				return errMsg{err}
+			}
+			return portForwardingRuleSavedMsg("Port forwarding rule updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+func (m *model) saveNatRule() tea.Cmd {
+	rule := config.NatRule{
+		Interface:   m.natForm.interfaceInput.Value(),
+		Protocol:    m.natForm.protocol,
+		Source:      m.natForm.sourceInput.Value(),
+		Destination: m.natForm.destinationInput.Value(),
+		Translation: m.natForm.translationInput.Value(),
+		Description: m.natForm.descriptionInput.Value(),
+	}
+
+	for field := 0; field < natFieldCount; field++ {
+		if err := m.natFieldError(field); err != nil {
+			return func() tea.Msg { return errMsg{err} }
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.natForm.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddNatRule(rule); err != nil {
+				return errMsg{err}
+			}
+			return natRuleSavedMsg("NAT rule added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdateNatRule(m.natForm.ruleIndex, rule); err != nil {
+				return errMsg{err}
+			}
+			return natRuleSavedMsg("NAT rule updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+func (m *model) saveTable() tea.Cmd {
+	name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(m.tableForm.nameInput.Value()), "<"), ">")
+	var addresses []string
+	for _, addr := range strings.Split(m.tableForm.addressesInput.Value(), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	table := config.PfTable{
+		Name:        name,
+		Persist:     m.tableForm.persist == "Yes",
+		Addresses:   addresses,
+		FeedURL:     strings.TrimSpace(m.tableForm.feedURLInput.Value()),
+		Description: m.tableForm.descriptionInput.Value(),
+	}
+
+	if table.Name == "" || strings.ContainsAny(table.Name, " \t<>{}/") {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("invalid table name %q", table.Name)}
+		}
+	}
+	if table.FeedURL != "" && !strings.HasPrefix(table.FeedURL, "https://") && !strings.HasPrefix(table.FeedURL, "http://") {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("invalid feed URL %q, it must start with https:// or http://", table.FeedURL)}
+		}
+	}
+	if refresh := strings.TrimSpace(m.tableForm.feedRefreshInput.Value()); refresh != "" {
+		hours, err := strconv.Atoi(refresh)
+		if err != nil || hours < 1 {
+			return func() tea.Msg {
+				return errMsg{fmt.Errorf("invalid refresh interval %q, it must be a number of hours", refresh)}
+			}
+		}
+		table.FeedRefreshHours = hours
+	}
+
+	var cmd tea.Cmd
+	if m.tableForm.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddTable(table); err != nil {
+				return errMsg{err}
+			}
+			return tableSavedMsg("Table added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdateTable(m.tableForm.tableIndex, table); err != nil {
+				return errMsg{err}
+			}
+			return tableSavedMsg("Table updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+// interfacePattern matches an interface name, optionally ending in "*" (e.g. "utun*"), or a macro.
+var interfacePattern = regexp.MustCompile(`^(\$[A-Za-z_][A-Za-z0-9_]*|[A-Za-z][A-Za-z0-9_.]*\*?)$`)
+
+func (m *model) saveTimeouts() tea.Cmd {
+	options := m.firewallManager.Config.Options
+	timeouts := make(map[string]int)
+	for i, timeout := range m.timeoutsForm.timeouts {
+		value := strings.TrimSpace(m.timeoutsForm.inputs[i].Value())
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid value %q for %s", value, timeout.Name)} }
+		}
+		// Keep what was configured before, and whatever differs from pf's current value
+		if _, configured := options.Timeouts[timeout.Name]; configured || seconds != timeout.Value {
+			timeouts[timeout.Name] = seconds
+		}
+	}
+	options.Timeouts = timeouts
+
+	return func() tea.Msg {
+		if err := m.firewallManager.UpdateOptions(options); err != nil {
+			return errMsg{err}
+		}
+		if output, err := pfcli.ApplyOptions(m.runner, m.firewallManager.GenerateOptions()); err != nil {
+			return errMsg{fmt.Errorf("failed to apply timeouts: %w, output: %s", err, output)}
+		}
+		return optionsSavedMsg("Timeouts saved and applied.")
+	}
+}
+
+func (m *model) saveOptions() tea.Cmd {
+	// Timeouts are edited on their own screen and kept as they are
+	options := config.PfOptions{
+		SkipInterfaces: config.SplitList(m.optionsForm.skipInput.Value()),
+		Timeouts:       m.firewallManager.Config.Options.Timeouts,
+	}
+	for _, name := range options.SkipInterfaces {
+		if !interfacePattern.MatchString(name) {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid interface %q", name)} }
+		}
+	}
+	if err := m.firewallManager.CheckMacroReferences(options.SkipInterfaces...); err != nil {
+		return func() tea.Msg { return errMsg{err} }
+	}
+	if value := strings.TrimSpace(m.optionsForm.stateLimitInput.Value()); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid state limit %q", value)} }
+		}
+		options.StateLimit = limit
+	}
+	if value := strings.TrimSpace(m.optionsForm.tableEntriesLimitInput.Value()); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid table entries limit %q", value)} }
+		}
+		options.TableEntriesLimit = limit
+	}
+	if m.optionsForm.blockPolicy != "default" {
+		options.BlockPolicy = m.optionsForm.blockPolicy
+	}
+	if m.optionsForm.optimization != "default" {
+		options.Optimization = m.optionsForm.optimization
+	}
+
+	return func() tea.Msg {
+		if err := m.firewallManager.UpdateOptions(options); err != nil {
+			return errMsg{err}
+		}
+		return optionsSavedMsg("Global options saved successfully.")
+	}
+}
+
+func (m *model) saveInternetSharing() tea.Cmd {
+	wan := strings.TrimSpace(m.sharingForm.wanInput.Value())
+	lan := strings.TrimSpace(m.sharingForm.lanInput.Value())
+	for _, iface := range []string{wan, lan} {
+		// The LAN interface is used as "lan:network", which takes neither wildcards nor macros
+		if !interfacePattern.MatchString(iface) || strings.ContainsAny(iface, "*$") {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid interface %q", iface)} }
+		}
+	}
+	forwarding := m.sharingForm.forwarding
+
+	return func() tea.Msg {
+		if err := m.firewallManager.AddInternetSharing(wan, lan); err != nil {
+			return errMsg{err}
+		}
+		status := "Internet Sharing rules added. Save & Apply the configuration to load them."
+		if forwarding == "off" {
+			status += " Enable forwarding with: sudo sysctl -w net.inet.ip.forwarding=1"
+		}
+		return sharingSavedMsg(status)
+	}
+}
+
+func (m *model) saveSettings() tea.Cmd {
+	settings := config.Settings{
+		GeoIPCountryDB: strings.TrimSpace(m.settingsForm.countryDBInput.Value()),
+		GeoIPASNDB:     strings.TrimSpace(m.settingsForm.asnDBInput.Value()),
+		AutoBan:        m.settingsForm.autoBan == "Yes",
+		// Not in the form
+		Keybindings:     m.firewallManager.Config.Settings.Keybindings,
+		RuleListColumns: m.firewallManager.Config.Settings.RuleListColumns,
+		RuleListLayout:  m.firewallManager.Config.Settings.RuleListLayout,
+	}
+	for _, number := range []struct {
+		name  string
+		input textinput.Model
+		value *int
+	}{
+		{"ban threshold", m.settingsForm.thresholdInput, &settings.AutoBanThreshold},
+		{"window", m.settingsForm.windowInput, &settings.AutoBanWindowMinutes},
+		{"ban time", m.settingsForm.banMinutesInput, &settings.AutoBanMinutes},
+		{"rollback time", m.settingsForm.rollbackInput, &settings.RollbackSeconds},
+		{"backup interval", m.settingsForm.intervalInput, &settings.BackupIntervalHours},
+		{"number of backups", m.settingsForm.retentionInput, &settings.BackupRetention},
+		{"info refresh interval", m.settingsForm.refreshInput, &settings.InfoRefreshSeconds},
+	} {
+		if value := strings.TrimSpace(number.input.Value()); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return func() tea.Msg { return errMsg{fmt.Errorf("invalid %s %q", number.name, value)} }
+			}
+			*number.value = n
+		}
+	}
+
+	return func() tea.Msg {
+		// Open the databases first so that a wrong path is reported instead of saved
+		geoip, err := OpenGeoIP(settings.GeoIPCountryDB, settings.GeoIPASNDB)
+		if err != nil {
+			return errMsg{err}
+		}
+		if err := m.firewallManager.UpdateSettings(settings); err != nil {
+			geoip.Close()
+			return errMsg{err}
+		}
+		status := "Settings saved successfully."
+		if settings.AutoBan {
+			added, err := m.firewallManager.EnsureAutoBanTable()
+			if err != nil {
+				geoip.Close()
+				return errMsg{err}
+			}
+			if added {
+				status = fmt.Sprintf("Settings saved. Added <%s> and a rule blocking it; Save & Apply the configuration to load them.", config.AutoBanTable)
+			}
+		}
+		return settingsSavedMsg{geoip: geoip, settings: settings, status: status}
+	}
+}
+
+// runScheduledBackup backs up the rules file and the applied anchor, see
+// RunScheduledBackup.
+func runScheduledBackup(settings config.Settings) tea.Cmd {
+	return func() tea.Msg {
+		dir, err := config.RunScheduledBackup(settings)
+		return scheduledBackupMsg{dir: dir, err: err}
+	}
+}
+
+func (m *model) saveScrubOptions() tea.Cmd {
+	scrub := config.ScrubOptions{
+		Enabled:       m.scrubForm.enabled == "Yes",
+		Direction:     m.scrubForm.direction,
+		Interface:     strings.TrimSpace(m.scrubForm.interfaceInput.Value()),
+		ReassembleTCP: m.scrubForm.reassembleTCP == "Yes",
+		NoDF:          m.scrubForm.noDF == "Yes",
+		RandomID:      m.scrubForm.randomID == "Yes",
+		Fragment:      m.scrubForm.fragment,
+	}
+	if scrub.Direction == "both" {
+		scrub.Direction = ""
+	}
+	if scrub.Interface == "" || strings.ContainsAny(scrub.Interface, " \t") {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("invalid interface %q, use \"any\" for all interfaces", scrub.Interface)}
+		}
+	}
+	if err := m.firewallManager.CheckMacroReferences(scrub.Interface); err != nil {
+		return func() tea.Msg { return errMsg{err} }
+	}
+
+	return func() tea.Msg {
+		if err := m.firewallManager.UpdateScrubOptions(scrub); err != nil {
+			return errMsg{err}
+		}
+		return scrubSavedMsg("Scrub options saved successfully.")
+	}
+}
+
+// bandwidthPattern matches a dnctl bandwidth such as "10Mbit/s" or "512Kbit/s".
+var bandwidthPattern = regexp.MustCompile(`^[0-9]+(bit|Kbit|Mbit|Gbit|Byte|KByte|MByte)/s$`)
+
+func (m *model) savePipe() tea.Cmd {
+	pipe := config.DummynetPipe{
+		Bandwidth:   strings.TrimSpace(m.pipeForm.bandwidthInput.Value()),
+		Description: m.pipeForm.descriptionInput.Value(),
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(m.pipeForm.numberInput.Value()))
+	if err != nil || number < 1 || number > 65535 {
+		return func() tea.Msg { return errMsg{fmt.Errorf("pipe number must be between 1 and 65535")} }
+	}
+	pipe.Number = number
+	if pipe.Bandwidth != "" && !bandwidthPattern.MatchString(pipe.Bandwidth) {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("invalid bandwidth %q, expected e.g. 10Mbit/s or 512Kbit/s", pipe.Bandwidth)}
+		}
+	}
+	if value := strings.TrimSpace(m.pipeForm.delayInput.Value()); value != "" {
+		delay, err := strconv.Atoi(value)
+		if err != nil || delay < 0 {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid delay %q, expected milliseconds", value)} }
+		}
+		pipe.Delay = delay
+	}
+	if value := strings.TrimSpace(m.pipeForm.queueInput.Value()); value != "" {
+		queue, err := strconv.Atoi(value)
+		if err != nil || queue < 0 {
+			return func() tea.Msg { return errMsg{fmt.Errorf("invalid queue size %q", value)} }
+		}
+		pipe.QueueSize = queue
+	}
+
+	var cmd tea.Cmd
+	if m.pipeForm.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddPipe(pipe); err != nil {
+				return errMsg{err}
+			}
+			return pipeSavedMsg("Pipe added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdatePipe(m.pipeForm.pipeIndex, pipe); err != nil {
+				return errMsg{err}
+			}
+			return pipeSavedMsg("Pipe updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+func (m *model) saveMacro() tea.Cmd {
+	macro := config.Macro{
+		Name:        strings.TrimPrefix(strings.TrimSpace(m.macroForm.nameInput.Value()), "$"),
+		Value:       strings.TrimSpace(m.macroForm.valueInput.Value()),
+		Description: m.macroForm.descriptionInput.Value(),
+	}
+
+	if config.MacroRefPattern.FindString("$"+macro.Name) != "$"+macro.Name {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("invalid macro name %q", macro.Name)}
+		}
+	}
+	if strings.Contains(macro.Value, "\"") {
+		return func() tea.Msg {
+			return errMsg{fmt.Errorf("macro values cannot contain double quotes")}
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.macroForm.isNew {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.AddMacro(macro); err != nil {
+				return errMsg{err}
+			}
+			return macroSavedMsg("Macro added successfully.")
+		}
+	} else {
+		cmd = func() tea.Msg {
+			if err := m.firewallManager.UpdateMacro(m.macroForm.macroIndex, macro); err != nil {
+				return errMsg{err}
+			}
+			return macroSavedMsg("Macro updated successfully.")
+		}
+	}
+
+	return cmd
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a line of block characters scaled to the largest value.
+func Sparkline(values []uint64) string {
+	var max uint64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v * uint64(len(sparkBlocks)-1) / max)
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
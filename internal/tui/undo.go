@@ -0,0 +1,92 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+)
+
+// rulesDeletedMsg reports rules deleted from the rule list or the port
+// forwarding list, which u puts back.
+type rulesDeletedMsg struct {
+	status  string
+	deleted []config.DeletedRule
+}
+
+// deleteUndoneMsg reports that the rules of the last delete were put back.
+type deleteUndoneMsg []config.DeletedRule
+
+// deleteRules returns a command that runs del, which deletes the rules and
+// reports how many, and reports them as deleted for undo.
+func deleteRules(deleted []config.DeletedRule, del func() (int, error)) tea.Cmd {
+	return func() tea.Msg {
+		n, err := del()
+		if err != nil {
+			return errMsg{err}
+		}
+		status := "Rule moved to the archive, press u to undo."
+		if n != 1 {
+			status = fmt.Sprintf("%d rules moved to the archive, press u to undo.", n)
+		}
+		return rulesDeletedMsg{status: status, deleted: deleted}
+	}
+}
+
+// canUndoDelete reports whether the last delete was from the list of kind
+// ("Firewall" or "Port Forwarding"), so that u can undo it there.
+func (m *model) canUndoDelete(kind string) bool {
+	return len(m.lastDeleted) > 0 && m.lastDeleted[0].Kind == kind
+}
+
+// undoDelete puts back the rules of the last delete from the list of kind,
+// where they were.
+func (m *model) undoDelete(kind string) tea.Cmd {
+	deleted := m.lastDeleted
+	if !m.canUndoDelete(kind) {
+		m.notify(levelWarn, "No deleted rule to undo in this list.")
+		return nil
+	}
+	fm := m.firewallManager
+	return func() tea.Msg {
+		if err := fm.UndoDelete(deleted); err != nil {
+			return errMsg{fmt.Errorf("failed to undo the delete: %w", err)}
+		}
+		return deleteUndoneMsg(deleted)
+	}
+}
+
+// handleDeleteMsg handles rulesDeletedMsg and deleteUndoneMsg, refreshing the
+// list the rules are in.
+func (m *model) handleDeleteMsg(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var deleted []config.DeletedRule
+	var status string
+	switch msg := msg.(type) {
+	case rulesDeletedMsg:
+		m.lastDeleted = msg.deleted
+		deleted, status = msg.deleted, msg.status
+	case deleteUndoneMsg:
+		m.lastDeleted = nil
+		deleted, status = msg, "Rule put back where it was."
+		if len(msg) != 1 {
+			status = fmt.Sprintf("%d rules put back where they were.", len(msg))
+		}
+	}
+	if len(deleted) > 0 && deleted[0].Kind == "Port Forwarding" {
+		model, cmd := m.update(portForwardingRuleSavedMsg(status))
+		if _, ok := msg.(deleteUndoneMsg); ok {
+			m.portForwardingList.Select(deleted[0].Index)
+		}
+		return model, cmd
+	}
+	model, cmd := m.update(firewallRuleSavedMsg(status))
+	if _, ok := msg.(deleteUndoneMsg); ok && len(deleted) > 0 {
+		m.selectRuleListItem(func(item list.Item) bool {
+			rule, ok := item.(ruleListItem)
+			return ok && rule.rule.ID == deleted[0].ID
+		})
+	}
+	return model, cmd
+}
@@ -0,0 +1,207 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	searchMatchStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("220"))
+	searchCurrentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("208")).Bold(true)
+)
+
+// searchMatch is where the search text occurs in the content of the info
+// view: a line, and the byte offsets in it.
+type searchMatch struct {
+	line, start, end int
+}
+
+// viewportSearch is the "/" search of the info views that show pfctl output.
+type viewportSearch struct {
+	input   textinput.Model
+	editing bool // the search text is being typed
+	origin  int  // viewport offset when "/" was pressed, the search starts there
+	matches []searchMatch
+	current int // index in matches of the match n and N move from
+}
+
+func newSearchInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Placeholder = "text to find"
+	input.Blur()
+	return input
+}
+
+// searchable reports whether the info view has "/" search.
+func (m *model) searchable() bool {
+	switch m.infoViewTitle {
+	case "Live PF Info", "Current Live PF Rules", "pf-tui Anchor Rules":
+		return true
+	}
+	return false
+}
+
+// clearSearch ends the search, e.g. when another info view is opened. It does
+// not redraw the viewport, see showInfoContent.
+func (m *model) clearSearch() {
+	m.search.editing = false
+	m.search.input.Blur()
+	m.search.input.SetValue("")
+	m.search.matches = nil
+	m.search.current = 0
+}
+
+// showInfoContent shows infoContent in the viewport with the matches of the
+// search highlighted, case insensitive. Plain mode puts them in brackets.
+func (m *model) showInfoContent() {
+	s := &m.search
+	query := strings.ToLower(s.input.Value())
+	lines := strings.Split(m.infoContent, "\n")
+	s.matches = s.matches[:0]
+	if query != "" {
+		for i, line := range lines {
+			lower := strings.ToLower(line)
+			if len(lower) != len(line) {
+				// Offsets in lower would not be those of line
+				lower = line
+			}
+			for start := 0; ; {
+				j := strings.Index(lower[start:], query)
+				if j < 0 {
+					break
+				}
+				s.matches = append(s.matches, searchMatch{line: i, start: start + j, end: start + j + len(query)})
+				start += j + len(query)
+			}
+		}
+	}
+	if s.current >= len(s.matches) {
+		s.current = 0
+	}
+
+	// From the last match back, so that the offsets of the earlier matches of a line stay valid
+	for i := len(s.matches) - 1; i >= 0; i-- {
+		match := s.matches[i]
+		line := lines[match.line]
+		text := line[match.start:match.end]
+		switch {
+		case plainMode:
+			text = "[" + text + "]"
+		case i == s.current:
+			text = searchCurrentStyle.Render(text)
+		default:
+			text = searchMatchStyle.Render(text)
+		}
+		lines[match.line] = line[:match.start] + text + line[match.end:]
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+}
+
+// jumpToMatch makes the match at index i the current one and scrolls it into
+// view, a third from the top.
+func (m *model) jumpToMatch(i int) {
+	m.search.current = i
+	m.showInfoContent()
+	if i >= len(m.search.matches) {
+		return
+	}
+	line := m.search.matches[i].line
+	if line < m.viewport.YOffset || line >= m.viewport.YOffset+m.viewport.Height {
+		offset := line - m.viewport.Height/3
+		if offset < 0 {
+			offset = 0
+		}
+		m.viewport.SetYOffset(offset)
+	}
+}
+
+// stepMatch moves to the next (direction > 0) or previous match, around the
+// end of the content.
+func (m *model) stepMatch(direction int) {
+	n := len(m.search.matches)
+	if n == 0 {
+		m.notify(levelWarn, fmt.Sprintf("No matches for %q.", m.search.input.Value()))
+		return
+	}
+	m.jumpToMatch((m.search.current + direction + n) % n)
+}
+
+// updateSearch handles the keys of the search in the info views with
+// search, and reports whether it did. Esc, which ends the search, is handled
+// with the other uses of esc.
+func (m *model) updateSearch(msg tea.KeyMsg) (tea.Cmd, bool) {
+	s := &m.search
+	if s.editing {
+		switch msg.String() {
+		case "enter":
+			s.editing = false
+			s.input.Blur()
+			if s.input.Value() == "" {
+				m.showInfoContent()
+			} else if len(s.matches) == 0 {
+				m.notify(levelWarn, fmt.Sprintf("No matches for %q.", s.input.Value()))
+			}
+			return nil, true
+		}
+		var cmd tea.Cmd
+		s.input, cmd = s.input.Update(msg)
+		// Find as you type, from where the search started
+		m.showInfoContent()
+		first := 0
+		for i, match := range s.matches {
+			if match.line >= s.origin {
+				first = i
+				break
+			}
+		}
+		m.jumpToMatch(first)
+		return cmd, true
+	}
+
+	switch msg.String() {
+	case "/":
+		m.clearSearch()
+		s.editing = true
+		s.origin = m.viewport.YOffset
+		s.input.Focus()
+		m.showInfoContent()
+		return textinput.Blink, true
+	case "n", "N":
+		if s.input.Value() == "" {
+			return nil, false
+		}
+		if msg.String() == "n" {
+			m.stepMatch(1)
+		} else {
+			m.stepMatch(-1)
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// searchView renders the search line below the viewport, "" if there is no
+// search.
+func (m *model) searchView() string {
+	s := m.search
+	if !s.editing && s.input.Value() == "" {
+		return ""
+	}
+	line := "Search: " + s.input.Value()
+	if s.editing {
+		line = "Search: " + s.input.View()
+	}
+	switch {
+	case s.input.Value() == "":
+	case len(s.matches) == 0:
+		line += "  " + disabledStyle.Render("no matches")
+	default:
+		line += "  " + disabledStyle.Render(fmt.Sprintf("%d/%d", s.current+1, len(s.matches)))
+	}
+	return line
+}
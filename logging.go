@@ -1,19 +1,22 @@
 package main
 
 import (
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/logging"
 )
 
 const (
-	logDir        = "~/.config/pf-tui"
-	logFileName   = "pf-tui.log"
-	maxBackups    = 30
-	maxAgeDays    = 90
+	logFileName = "pf-tui.log"
+	maxBackups  = 30
+	maxAgeDays  = 90
 )
 
 var (
@@ -21,11 +24,16 @@ var (
 )
 
 func init() {
-	setupLogging()
+	// Discarded until main sets up logging, once the config directory is known
+	logger = log.New(io.Discard, "", 0)
 }
 
+// setupLogging logs to pf-tui.log in the ConfigDir.
 func setupLogging() {
-	expandedLogDir := expandUser(logDir)
+	expandedLogDir, err := config.ConfigDir()
+	if err != nil {
+		log.Fatalf("Failed to find the log directory: %v", err)
+	}
 	if err := os.MkdirAll(expandedLogDir, 0755); err != nil {
 		log.Fatalf("Failed to create log directory: %v", err)
 	}
@@ -43,6 +51,7 @@ func setupLogging() {
 
 	// Set up the standard logger to write to lumberjack
 	logger = log.New(lumberjackLogger, "", log.Ldate|log.Ltime|log.Lshortfile)
+	logging.SetLogger(logger)
 
 	// Perform log cleanup on startup
 	go cleanupOldLogs(expandedLogDir)
@@ -81,19 +90,6 @@ func cleanupOldLogs(dir string) {
 	}
 }
 
-// expandUser expands the `~` symbol in a path to the user's home directory.
-func expandUser(path string) string {
-	if len(path) > 0 && path[0] == '~' {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			// Fallback or error handling if home directory cannot be determined
-			return path // Return original path if expansion fails
-		}
-		return filepath.Join(homeDir, path[1:])
-	}
-	return path
-}
-
 // Log functions for different levels
 func LogInfo(format string, v ...interface{}) {
 	logger.Printf("INFO: "+format, v...)
@@ -105,4 +101,4 @@ func LogWarn(format string, v ...interface{}) {
 
 func LogError(format string, v ...interface{}) {
 	logger.Printf("ERROR: "+format, v...)
-}
\ No newline at end of file
+}
@@ -4,57 +4,107 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
-	
+
+	"github.com/kh813/pf-tui-go/internal/tui"
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
 )
 
 var testMode bool
 
-func main() {
-	// Logging is initialized in logging.go's init() function
+// panicFlag loads the pass-all rule of PanicAllowAll and exits, without the TUI.
+var panicFlag bool
 
-	if err := EnsureConfigDirExists(); err != nil {
-				LogError(fmt.Sprintf("Error creating config directory: %v", err))
-		fmt.Printf("Error creating config directory: %v\n", err)
-		os.Exit(1)
-	}
+// backupFlag runs a scheduled backup and exits, for a launchd or cron job.
+var backupFlag bool
+
+// plainFlag renders the TUI without colors and with ASCII only, see tui.SetupPlainMode.
+var plainFlag bool
+
+// configDirFlag is the directory of the configuration, see config.SetConfigDir.
+var configDirFlag string
 
-		flag.BoolVar(&testMode, "test", false, "Enable test mode to bypass sudo checks")
+// viewFlag names the view the TUI opens in instead of the main menu, one of tui.StartViews.
+var viewFlag string
+
+func main() {
+	// Flags first: --config-dir moves the log along with the configuration
+	flag.BoolVar(&testMode, "test", false, "Enable test mode to bypass sudo checks")
+	flag.BoolVar(&panicFlag, "panic", false, "Unload the pf-tui rules and pass all traffic, then exit")
+	flag.BoolVar(&backupFlag, "backup", false, "Back up the rules file and the applied anchor to the scheduled backups, then exit")
+	flag.BoolVar(&jsonFlag, "json", false, "Print the output of commands, -backup and -panic as JSON, errors included")
+	flag.BoolVar(&plainFlag, "plain", false, "Render the TUI without colors and with ASCII only, also set by NO_COLOR")
+	flag.StringVar(&viewFlag, "view", "", "Open the TUI in a view instead of the main menu: "+strings.Join(tui.StartViewNames(), ", "))
+	flag.StringVar(&configDirFlag, "config-dir", "", "Directory of the rules, logs and backups (default $PF_TUI_CONFIG, $XDG_CONFIG_HOME/pf-tui or ~/.config/pf-tui)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), cliUsage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	config.SetConfigDir(configDirFlag)
+
+	if err := config.EnsureConfigDirExists(); err != nil {
+		os.Exit(newCommandOutput().fail(config.KindConfig, "Error creating config directory: %v", err))
+	}
+	setupLogging()
+	configDir, _ := config.ConfigDir()
+	LogInfo(fmt.Sprintf("Config directory: %s", configDir))
 
+	// The pf functions run their root commands with runner, which only logs
+	// them in test mode
+	var runner pfcli.CommandRunner = pfcli.SudoRunner{}
 	if testMode {
 		os.Setenv("TERM", "dumb")
+		runner = pfcli.NewTestModeRunner()
 	}
+	tui.SetupPlainMode(plainFlag)
 
 	LogInfo(fmt.Sprintf("Test mode: %t", testMode))
 
-	// Check for sudo credentials before starting the TUI
-	if !testMode {
-		if err := checkSudo(); err != nil {
-			LogError(fmt.Sprintf("Error with sudo: %v", err))
-			fmt.Printf("Error with sudo: %v\n", err)
-			os.Exit(1)
-		}
+	// Commands, -backup and -panic check the sudo credentials themselves if they need them
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args(), runner))
 	}
 
-	// Initialize the firewall manager
-	fm := NewFirewallManager()
+	if backupFlag {
+		os.Exit(runBackup())
+	}
+	if panicFlag {
+		os.Exit(runPanic(runner))
+	}
+	startView, ok := tui.StartViews[viewFlag]
+	if viewFlag != "" && !ok {
+		os.Exit(newCommandOutput().fail(config.KindUsage, "Unknown view %q, one of: %s", viewFlag, strings.Join(tui.StartViewNames(), ", ")))
+	}
 
-	// Load the initial configuration
-	if err := fm.LoadConfig(); err != nil {
-		LogWarn(fmt.Sprintf("Error loading initial config: %v", err))
+	// Keep the sudo credentials from expiring while the TUI runs. The TUI
+	// checks them itself at start.
+	var keepAlive *pfcli.SudoKeepAlive
+	if auth, ok := runner.(pfcli.Authenticator); ok {
+		keepAlive = pfcli.StartSudoKeepAlive(auth, pfcli.SudoKeepAliveInterval)
+		defer keepAlive.Stop()
 	}
 
 	// Initialize the Bubble Tea program
-	programOpts := []tea.ProgramOption{}
+	// Focus reports pause the refresh of the info views while the terminal is unfocused
+	programOpts := []tea.ProgramOption{tea.WithReportFocus()}
 	if !testMode {
 		programOpts = append(programOpts, tea.WithAltScreen())
 	} else {
 		programOpts = append(programOpts, tea.WithoutRenderer())
 	}
-		p := tea.NewProgram(NewModel(fm), programOpts...)
+	opts := tui.Options{SudoKeepAlive: keepAlive, StartView: startView, TestMode: testMode}
+	if watcher, err := config.WatchConfig(); err != nil {
+		LogWarn(fmt.Sprintf("Not watching the rules file for changes: %v", err))
+	} else {
+		opts.ConfigWatcher = watcher
+		defer watcher.Stop()
+	}
+	// The configuration is loaded once the TUI runs
+	p := tea.NewProgram(tui.NewModel(config.NewFirewallManager(), runner, opts), programOpts...)
 
 	LogInfo("Attempting to run the Bubble Tea program.")
 
@@ -64,6 +114,7 @@ func main() {
 			LogError(fmt.Sprintf("Bubble Tea program exited with error: %v", err))
 			LogError(fmt.Sprintf("Alas, there's been an error: %v", err))
 			fmt.Printf("Alas, there's been an error: %v", err)
+			keepAlive.Stop() // os.Exit skips the deferred Stop
 			os.Exit(1)
 		}
 	} else {
@@ -71,17 +122,19 @@ func main() {
 	}
 }
 
-func checkSudo() error {
-	// -n, --non-interactive
-	// Avoid prompting the user for a password.  If a password is required for the command to run, sudo will display an error message and exit.
-	cmd := exec.Command("sudo", "-n", "true")
-	if err := cmd.Run(); err != nil {
+func checkSudo(runner pfcli.CommandRunner) error {
+	if err := pfcli.CheckCredentials(runner); err != nil {
+		auth, ok := runner.(pfcli.Authenticator)
+		if !ok {
+			return err
+		}
 		// If the command fails, it's likely because a password is required.
 		// Prompt the user for their password in the terminal.
-		fmt.Println("Sudo credentials required. Please enter your password.")
-		cmd := exec.Command("sudo", "-v")
+		// On stderr, to keep stdout to the output of the commands.
+		fmt.Fprintln(os.Stderr, "Sudo credentials required. Please enter your password.")
+		cmd := auth.AuthCommand("")
 		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = os.Stderr
 		cmd.Stderr = os.Stderr
 		return cmd.Run()
 	}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// ApplyResult is the outcome of SaveAndApply.
+type ApplyResult struct {
+	Status   string
+	Rejected []RuleError      // errors pfctl reported on the rules, which were not applied then
+	Rollback *PendingRollback // revert of the applied rules to confirm, nil without a rollback time
+}
+
+// SaveAndApply saves the configuration and loads it into pf: the pipes, the
+// generated rules and the options. The rules are checked with pfctl first; if
+// pfctl rejects them with errors on rules, nothing is applied and the errors
+// are returned in the result. With a rollback time in the settings, the
+// revert of the rules is scheduled before they are loaded and has to be
+// confirmed, see PendingRollback; the snapshot of the apply is only recorded
+// then. step, if not nil, is told what it is doing, e.g. "loading the rules".
+func (fm *FirewallManager) SaveAndApply(runner pfcli.CommandRunner, step func(string)) (ApplyResult, error) {
+	if step == nil {
+		step = func(string) {}
+	}
+
+	// Ensure pf.conf is set up correctly
+	step("checking pf.conf")
+	if err := pfcli.SetupPfConf(runner); err != nil {
+		return ApplyResult{}, err
+	}
+
+	// Save the configuration
+	step("saving the configuration")
+	if err := fm.SaveConfig(); err != nil {
+		return ApplyResult{}, err
+	}
+
+	// Check the rules first, so that pfctl's errors can be pointed out on the
+	// rules they belong to before anything is changed
+	step("checking the rules")
+	pfConf := fm.GeneratePfConf()
+	if output, err := pfcli.CheckRules(runner, pfConf); err != nil {
+		ruleErrors := fm.MapPfctlErrors(pfConf, pfcli.ParsePfctlErrors(output))
+		if len(ruleErrors) == 0 {
+			return ApplyResult{}, fmt.Errorf("pfctl rejected the rules: %w, output: %s", err, output)
+		}
+		return ApplyResult{Rejected: ruleErrors}, nil
+	}
+
+	// Configure the pipes before the rules that send traffic to them
+	step("configuring the pipes")
+	if err := ApplyPipes(runner, fm.Config.Pipes); err != nil {
+		return ApplyResult{}, err
+	}
+
+	// Schedule the rollback before applying, so that it also happens if the
+	// new rules cut off the session pf-tui runs in
+	var rollback *PendingRollback
+	if seconds := fm.Config.Settings.RollbackSeconds; seconds > 0 {
+		step("scheduling the rollback")
+		previous, err := pfcli.GetAppliedAnchor(runner)
+		if err != nil {
+			return ApplyResult{}, err
+		}
+		if rollback, err = ScheduleRollback(runner, previous, seconds); err != nil {
+			return ApplyResult{}, err
+		}
+	}
+
+	// Apply the rules
+	step("loading the rules")
+	output, err := pfcli.ApplyRules(runner, pfConf)
+	if err != nil {
+		if rollback != nil {
+			// Restore the anchor file, which already has the rejected rules
+			if _, revertErr := rollback.Revert(); revertErr != nil {
+				logging.Error(fmt.Sprintf("Failed to restore the previous rules: %v", revertErr))
+			}
+		}
+		return ApplyResult{}, fmt.Errorf("failed to apply rules: %w, output: %s", err, output)
+	}
+	status := "Configuration saved and applied to the system. Existing connections keep their state until Flush All States."
+	step("setting the options")
+	if output, err := pfcli.ApplyOptions(runner, fm.GenerateOptions()); err != nil {
+		if rollback == nil {
+			return ApplyResult{}, fmt.Errorf("failed to apply options: %w, output: %s", err, output)
+		}
+		// The rules are applied; they still need to be confirmed
+		status = fmt.Sprintf("Rules applied, but failed to apply options: %v, output: %s", err, output)
+	}
+
+	snapshot, err := fm.NewSnapshot(pfConf)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to take a snapshot of the applied configuration: %v", err))
+	} else if rollback != nil {
+		rollback.Snapshot = &snapshot
+	} else if err := RecordSnapshot(snapshot); err != nil {
+		logging.Error(fmt.Sprintf("Failed to record the snapshot: %v", err))
+	}
+
+	return ApplyResult{Status: status, Rollback: rollback}, nil
+}
+
+// PendingRollback is an apply that is reverted to the previous anchor
+// content unless it is confirmed in time.
+type PendingRollback struct {
+	Previous string // anchor content before the apply
+	Deadline time.Time
+	Snapshot *Snapshot // snapshot of the apply, recorded in the history once confirmed
+	backup   string    // file with Previous; the scheduled revert only runs while it exists
+	runner   pfcli.CommandRunner
+}
+
+// ScheduleRollback schedules a revert of the anchor to previous after the
+// given number of seconds. The revert runs in a root shell in the background
+// that ignores SIGHUP, so it happens even if pf-tui dies with the SSH session
+// the new rules cut off. Confirm cancels it.
+func ScheduleRollback(runner pfcli.CommandRunner, previous string, seconds int) (*PendingRollback, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	rollback := &PendingRollback{
+		Previous: previous,
+		Deadline: time.Now().Add(time.Duration(seconds) * time.Second),
+		backup:   filepath.Join(configPath, "rollback.conf"),
+		runner:   runner,
+	}
+	if err := os.WriteFile(rollback.backup, []byte(previous), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", rollback.backup, err)
+	}
+	script := `(trap '' HUP; sleep "$1"; if [ -f "$2" ]; then cat "$2" > "$3" && pfctl -a "$4" -f "$3"; rm -f "$2"; fi) </dev/null >/dev/null 2>&1 &`
+	logging.Info(fmt.Sprintf("Scheduling a rollback of %s in %d seconds", pfcli.AnchorPath, seconds))
+	if out, err := pfcli.RunSudoCmd(runner, "sh", "-c", script, "sh", strconv.Itoa(seconds), rollback.backup, pfcli.AnchorPath, pfcli.Anchor); err != nil {
+		os.Remove(rollback.backup)
+		return nil, fmt.Errorf("failed to schedule the rollback: %w, output: %s", err, out)
+	}
+	return rollback, nil
+}
+
+// cancel cancels the scheduled revert. It fails if the revert already ran.
+func (r *PendingRollback) cancel() error {
+	if err := os.Remove(r.backup); os.IsNotExist(err) {
+		return fmt.Errorf("the rules were already reverted")
+	} else if err != nil {
+		return fmt.Errorf("failed to cancel the rollback: %w", err)
+	}
+	return nil
+}
+
+// Confirm keeps the applied rules by cancelling the scheduled revert.
+func (r *PendingRollback) Confirm() error {
+	if err := r.cancel(); err != nil {
+		return err
+	}
+	logging.Info("Confirmed the applied rules, rollback cancelled")
+	return nil
+}
+
+// Revert cancels the scheduled revert and restores the previous rules now.
+func (r *PendingRollback) Revert() (string, error) {
+	if err := r.cancel(); err != nil {
+		return "", err
+	}
+	logging.Info("Reverting to the previous rules")
+	return pfcli.ApplyRules(r.runner, r.Previous)
+}
+
+// ApplyPipes configures the given dummynet pipes with dnctl, e.g.
+// "dnctl pipe 1 config bw 10Mbit/s delay 20 queue 50".
+func ApplyPipes(runner pfcli.CommandRunner, pipes []DummynetPipe) error {
+	for _, pipe := range pipes {
+		if output, err := pfcli.RunSudoCmd(runner, pipeConfigArgs(pipe)...); err != nil {
+			return fmt.Errorf("failed to configure pipe %d: %w, output: %s", pipe.Number, err, output)
+		}
+	}
+	return nil
+}
+
+// pipeConfigArgs returns the dnctl command that configures a pipe.
+func pipeConfigArgs(pipe DummynetPipe) []string {
+	args := []string{"dnctl", "pipe", strconv.Itoa(pipe.Number), "config"}
+	if pipe.Bandwidth != "" {
+		args = append(args, "bw", pipe.Bandwidth)
+	}
+	if pipe.Delay > 0 {
+		args = append(args, "delay", strconv.Itoa(pipe.Delay))
+	}
+	if pipe.QueueSize > 0 {
+		args = append(args, "queue", strconv.Itoa(pipe.QueueSize))
+	}
+	return args
+}
+
+// pfTokensFile is the file in the config directory with the tokens of the
+// references EnablePf took on pf, one per line.
+const pfTokensFile = "pf-tokens"
+
+// PfTokensPath returns the file EnablePf keeps its tokens in and DisablePf
+// releases them from.
+func PfTokensPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, pfTokensFile), nil
+}
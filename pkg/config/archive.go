@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// ArchivedRule is a deleted rule, kept in the archive of the configuration
+// until it is restored or purged. Exactly one of the rules is set.
+type ArchivedRule struct {
+	ArchivedAt     time.Time           `json:"archived_at"`
+	FirewallRule   *FirewallRule       `json:"filter_rule,omitempty"`
+	PortForwarding *PortForwardingRule `json:"rdr_rule,omitempty"`
+	Nat            *NatRule            `json:"nat_rule,omitempty"`
+}
+
+// Kind returns the kind of the archived rule, as named in the menu.
+func (a ArchivedRule) Kind() string {
+	switch {
+	case a.FirewallRule != nil:
+		return "Firewall"
+	case a.PortForwarding != nil:
+		return "Port Forwarding"
+	case a.Nat != nil:
+		return "NAT"
+	}
+	return "Unknown"
+}
+
+// archive adds a deleted rule to the front of the archive, so that the most
+// recently deleted rules come first.
+func (fm *FirewallManager) archive(rule ArchivedRule) {
+	rule.ArchivedAt = time.Now().Truncate(time.Second)
+	fm.Config.Archive = append([]ArchivedRule{rule}, fm.Config.Archive...)
+}
+
+// RestoreArchivedRule moves an archived rule back to the end of its rule list,
+// or of its group for a filter rule. The macros and tables it references must
+// still be defined.
+func (fm *FirewallManager) RestoreArchivedRule(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Archive) {
+		return fmt.Errorf("invalid archive index")
+	}
+	archived := fm.Config.Archive[index]
+	if err := fm.checkArchivedReferences(archived); err != nil {
+		return err
+	}
+
+	switch {
+	case archived.FirewallRule != nil:
+		fm.Config.FirewallRules = append(fm.Config.FirewallRules, *archived.FirewallRule)
+		fm.normalizeRuleGroups()
+	case archived.PortForwarding != nil:
+		fm.Config.PortForwardingRules = append(fm.Config.PortForwardingRules, *archived.PortForwarding)
+	case archived.Nat != nil:
+		fm.Config.NatRules = append(fm.Config.NatRules, *archived.Nat)
+	}
+	fm.Config.Archive = append(fm.Config.Archive[:index], fm.Config.Archive[index+1:]...)
+	logging.Info(fmt.Sprintf("Restored archived %s rule: %+v", archived.Kind(), archived))
+	return fm.SaveConfig()
+}
+
+// checkArchivedReferences checks that the macros, tables and pipe an archived
+// rule references are still defined, so that it can be restored.
+func (fm *FirewallManager) checkArchivedReferences(archived ArchivedRule) error {
+	var fields []string
+	switch {
+	case archived.FirewallRule != nil:
+		rule := archived.FirewallRule
+		fields = []string{rule.Interface, rule.RouteInterface, rule.RouteGateway, rule.Source, rule.Destination, rule.SourcePort, rule.DestinationPort}
+		if rule.OverloadTable != "" {
+			fields = append(fields, "<"+rule.OverloadTable+">")
+		}
+		if rule.Pipe > 0 && fm.FindPipe(rule.Pipe) == -1 {
+			return fmt.Errorf("pipe %d is not defined", rule.Pipe)
+		}
+	case archived.PortForwarding != nil:
+		rule := archived.PortForwarding
+		fields = []string{rule.Interface, rule.ExternalIP, rule.ExternalPort, rule.InternalIP, rule.InternalPort}
+	case archived.Nat != nil:
+		rule := archived.Nat
+		fields = []string{rule.Interface, rule.Source, rule.Destination, rule.Translation}
+	default:
+		return fmt.Errorf("archived rule has no rule")
+	}
+	if err := fm.CheckMacroReferences(fields...); err != nil {
+		return fmt.Errorf("cannot restore the rule: %w", err)
+	}
+	if err := fm.CheckTableReferences(fields...); err != nil {
+		return fmt.Errorf("cannot restore the rule: %w", err)
+	}
+	return nil
+}
+
+// DeletedRule is where a rule was deleted from, so that UndoDelete can put it
+// back there.
+type DeletedRule struct {
+	Kind  string // the Kind of its ArchivedRule
+	ID    string
+	Index int // index in its list before it was deleted
+}
+
+// archivedID returns the ID of the rule of an archived rule.
+func (a ArchivedRule) archivedID() string {
+	switch {
+	case a.FirewallRule != nil:
+		return a.FirewallRule.ID
+	case a.PortForwarding != nil:
+		return a.PortForwarding.ID
+	case a.Nat != nil:
+		return a.Nat.ID
+	}
+	return ""
+}
+
+// UndoDelete moves deleted rules back from the archive to the indexes they
+// were deleted from. Rules deleted together must be given in the order of
+// their indexes. Either all of them are restored or none; like
+// RestoreArchivedRule, the macros, tables and pipes they reference must still
+// be defined.
+func (fm *FirewallManager) UndoDelete(deleted []DeletedRule) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	restored := make([]ArchivedRule, len(deleted))
+	for i, rule := range deleted {
+		found := -1
+		for j, archived := range fm.Config.Archive {
+			if archived.Kind() == rule.Kind && archived.archivedID() == rule.ID {
+				found = j
+				break
+			}
+		}
+		if found == -1 {
+			return fmt.Errorf("the deleted rule is no longer in the archive")
+		}
+		if err := fm.checkArchivedReferences(fm.Config.Archive[found]); err != nil {
+			return err
+		}
+		restored[i] = fm.Config.Archive[found]
+		fm.Config.Archive = append(fm.Config.Archive[:found], fm.Config.Archive[found+1:]...)
+	}
+
+	for i, archived := range restored {
+		index := deleted[i].Index
+		switch {
+		case archived.FirewallRule != nil:
+			fm.Config.FirewallRules = insertAt(fm.Config.FirewallRules, index, *archived.FirewallRule)
+		case archived.PortForwarding != nil:
+			fm.Config.PortForwardingRules = insertAt(fm.Config.PortForwardingRules, index, *archived.PortForwarding)
+		case archived.Nat != nil:
+			fm.Config.NatRules = insertAt(fm.Config.NatRules, index, *archived.Nat)
+		}
+		logging.Info(fmt.Sprintf("Undid the delete of %s rule at index %d: %+v", archived.Kind(), index, archived))
+	}
+	// A group that lost its last rule is listed again
+	fm.normalizeRuleGroups()
+	return fm.SaveConfig()
+}
+
+// insertAt inserts rule into rules at index, or appends it if the list is
+// shorter now.
+func insertAt[T any](rules []T, index int, rule T) []T {
+	if index < 0 || index > len(rules) {
+		index = len(rules)
+	}
+	rules = append(rules, rule)
+	copy(rules[index+1:], rules[index:])
+	rules[index] = rule
+	return rules
+}
+
+// PurgeArchivedRule deletes an archived rule for good.
+func (fm *FirewallManager) PurgeArchivedRule(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Archive) {
+		return fmt.Errorf("invalid archive index")
+	}
+	logging.Info(fmt.Sprintf("Purged archived %s rule: %+v", fm.Config.Archive[index].Kind(), fm.Config.Archive[index]))
+	fm.Config.Archive = append(fm.Config.Archive[:index], fm.Config.Archive[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// PurgeArchive deletes all archived rules for good.
+func (fm *FirewallManager) PurgeArchive() error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	logging.Info(fmt.Sprintf("Purged %d archived rules", len(fm.Config.Archive)))
+	fm.Config.Archive = nil
+	return fm.SaveConfig()
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// AutoBanTable is the table the auto-ban watcher adds offending sources to.
+const AutoBanTable = "autoban"
+
+// The auto-ban settings used when they are 0.
+const (
+	DefaultAutoBanThreshold     = 10
+	DefaultAutoBanWindowMinutes = 10
+	DefaultAutoBanMinutes       = 60
+	autoBanExpireInterval       = time.Minute
+)
+
+// AutoBanEvent reports a source the auto-ban watcher banned, or failed to ban.
+type AutoBanEvent struct {
+	Addr     string
+	Attempts int
+	Err      error
+}
+
+// AutoBanner watches pflog0 like fail2ban: a source with Threshold blocked
+// inbound packets within Window is added to the AutoBanTable table, and
+// removed again after BanTime. Only packets of rules with "log" reach pflog0.
+type AutoBanner struct {
+	Events    <-chan AutoBanEvent // closed when the watcher stops
+	Threshold int
+	Window    time.Duration
+	BanTime   time.Duration
+
+	stream   *pfcli.PflogStream
+	runner   pfcli.CommandRunner
+	attempts map[string][]time.Time // recent blocked packets by source
+}
+
+// autoBanSettings returns the threshold, window and ban time of settings,
+// with defaults for the values that are not set.
+func autoBanSettings(settings Settings) (int, time.Duration, time.Duration) {
+	threshold := settings.AutoBanThreshold
+	if threshold <= 0 {
+		threshold = DefaultAutoBanThreshold
+	}
+	window := settings.AutoBanWindowMinutes
+	if window <= 0 {
+		window = DefaultAutoBanWindowMinutes
+	}
+	ban := settings.AutoBanMinutes
+	if ban <= 0 {
+		ban = DefaultAutoBanMinutes
+	}
+	return threshold, time.Duration(window) * time.Minute, time.Duration(ban) * time.Minute
+}
+
+// StartAutoBan starts a tcpdump on pflog0 for the watcher and watches it in
+// the background until Stop is called or tcpdump exits.
+func StartAutoBan(runner pfcli.CommandRunner, settings Settings) (*AutoBanner, error) {
+	stream, err := pfcli.StartPflog(runner)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan AutoBanEvent, 10)
+	b := &AutoBanner{
+		Events:   events,
+		stream:   stream,
+		runner:   runner,
+		attempts: make(map[string][]time.Time),
+	}
+	b.Threshold, b.Window, b.BanTime = autoBanSettings(settings)
+	logging.Info(fmt.Sprintf("Auto-ban started: %d blocked packets within %s, banned for %s", b.Threshold, b.Window, b.BanTime))
+
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(autoBanExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case line, ok := <-stream.Lines:
+				if !ok {
+					logging.Info("Auto-ban stopped")
+					return
+				}
+				if event, banned := b.record(line, time.Now()); banned {
+					select {
+					case events <- event:
+					case <-stream.Done():
+					}
+				}
+			case now := <-ticker.C:
+				b.expire(now)
+			}
+		}
+	}()
+	return b, nil
+}
+
+// Stop stops the watcher. Bans in effect expire when pf-tui runs the watcher
+// again; they are kept until then.
+func (b *AutoBanner) Stop() {
+	b.stream.Stop()
+}
+
+// record counts a blocked inbound packet of a pflog line against its source
+// and bans the source once it reaches the threshold.
+func (b *AutoBanner) record(line string, now time.Time) (AutoBanEvent, bool) {
+	if !strings.Contains(line, "): block in ") {
+		return AutoBanEvent{}, false
+	}
+	addr := pfcli.PflogSourceAddress(line)
+	if addr == "" {
+		return AutoBanEvent{}, false
+	}
+	recent := b.attempts[addr][:0]
+	for _, t := range b.attempts[addr] {
+		if now.Sub(t) < b.Window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < b.Threshold {
+		b.attempts[addr] = recent
+		return AutoBanEvent{}, false
+	}
+
+	delete(b.attempts, addr)
+	event := AutoBanEvent{Addr: addr, Attempts: len(recent)}
+	if _, err := pfcli.ModifyTable(b.runner, AutoBanTable, "add", addr); err != nil {
+		event.Err = err
+		logging.Error(fmt.Sprintf("Auto-ban of %s failed: %v", addr, err))
+	} else {
+		logging.Info(fmt.Sprintf("Auto-banned %s after %d blocked packets within %s", addr, len(recent), b.Window))
+	}
+	return event, true
+}
+
+// expire removes the bans older than BanTime from the table, using the time
+// pf cleared their statistics, i.e. when they were added, and forgets old
+// attempts.
+func (b *AutoBanner) expire(now time.Time) {
+	seconds := strconv.Itoa(int(b.BanTime.Seconds()))
+	if _, err := pfcli.ModifyTable(b.runner, AutoBanTable, "expire", seconds); err != nil {
+		logging.Error(fmt.Sprintf("Failed to expire auto-bans: %v", err))
+	}
+	for addr, times := range b.attempts {
+		if now.Sub(times[len(times)-1]) >= b.Window {
+			delete(b.attempts, addr)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+const (
+	// ScheduledBackupsDirName is the directory in the config directory with
+	// one timestamped directory per scheduled backup.
+	ScheduledBackupsDirName = "scheduled-backups"
+	DefaultBackupRetention  = 30               // scheduled backups kept when Settings.BackupRetention is 0
+	BackupCheckInterval     = 10 * time.Minute // how often BackupDue is worth checking
+	liveAnchorBackup        = "pf-tui.anchor"
+)
+
+// ScheduledBackup is a directory in the scheduled backups directory.
+type ScheduledBackup struct {
+	Path string
+	Time time.Time
+}
+
+// backupRetention returns the number of scheduled backups kept.
+func backupRetention(settings Settings) int {
+	if settings.BackupRetention <= 0 {
+		return DefaultBackupRetention
+	}
+	return settings.BackupRetention
+}
+
+func getScheduledBackupsPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, ScheduledBackupsDirName), nil
+}
+
+// ListScheduledBackups returns the scheduled backups, newest first.
+func ListScheduledBackups() ([]ScheduledBackup, error) {
+	dir, err := getScheduledBackupsPath()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var backups []ScheduledBackup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, entry.Name(), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, ScheduledBackup{Path: filepath.Join(dir, entry.Name()), Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// BackupDue reports whether scheduled backups are enabled and the last one is
+// older than the interval.
+func BackupDue(settings Settings) bool {
+	if settings.BackupIntervalHours <= 0 {
+		return false
+	}
+	backups, err := ListScheduledBackups()
+	if err != nil {
+		logging.Warn(fmt.Sprintf("Failed to list the scheduled backups: %v", err))
+		return false
+	}
+	interval := time.Duration(settings.BackupIntervalHours) * time.Hour
+	return len(backups) == 0 || time.Since(backups[0].Time) >= interval
+}
+
+// RunScheduledBackup copies the rules file and the live anchor file to a new
+// timestamped directory in the scheduled backups directory, and removes the
+// oldest backups beyond the retention of settings. The anchor is skipped if
+// the rules have never been applied. It returns the directory of the backup.
+func RunScheduledBackup(settings Settings) (string, error) {
+	rulesPath, err := GetDefaultConfigPath()
+	if err != nil {
+		return "", err
+	}
+	rules, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", rulesPath, err)
+	}
+	anchor, err := os.ReadFile(pfcli.AnchorPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", pfcli.AnchorPath, err)
+	}
+
+	backupsPath, err := getScheduledBackupsPath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(backupsPath, time.Now().Format(backupTimeFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, filepath.Base(rulesPath)), rules, 0644); err != nil {
+		return "", err
+	}
+	if anchor != nil {
+		if err := writeFileAtomic(filepath.Join(dir, liveAnchorBackup), anchor, 0644); err != nil {
+			return "", err
+		}
+	}
+	logging.Info(fmt.Sprintf("Backed up the rules to %s", dir))
+	pruneScheduledBackups(backupRetention(settings))
+	return dir, nil
+}
+
+// pruneScheduledBackups removes all but the newest keep scheduled backups.
+func pruneScheduledBackups(keep int) {
+	backups, err := ListScheduledBackups()
+	if err != nil || len(backups) <= keep {
+		return
+	}
+	for _, backup := range backups[keep:] {
+		if err := os.RemoveAll(backup.Path); err != nil {
+			logging.Warn(fmt.Sprintf("Failed to remove old backup %s: %v", backup.Path, err))
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat is the file format of a configuration file.
+type ConfigFormat string
+
+// The formats of configuration files, see ConfigFormatOf.
+const (
+	FormatJSON ConfigFormat = "JSON"
+	FormatYAML ConfigFormat = "YAML"
+	FormatTOML ConfigFormat = "TOML"
+)
+
+// ConfigFileNames are the names the rules file can have in the config
+// directory, by preference. rules.json is used unless only another one exists.
+var ConfigFileNames = []string{"rules.json", "rules.yaml", "rules.yml", "rules.toml"}
+
+// ConfigFormatOf returns the format of a configuration file by its
+// extension; files without a known one are JSON.
+func ConfigFormatOf(path string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// Extension returns the file name extension of the format.
+func (f ConfigFormat) Extension() string {
+	switch f {
+	case FormatYAML:
+		return ".yaml"
+	case FormatTOML:
+		return ".toml"
+	}
+	return ".json"
+}
+
+// IsConfigFile reports whether a file name has the extension of a
+// configuration format.
+func IsConfigFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// UnmarshalConfig decodes a configuration file in the given format into
+// config, migrated from older schema versions, see migrateConfig. YAML and
+// TOML are decoded through JSON, so that they use the same field names. It
+// returns the schema version the file had and the fields config has no place
+// for.
+func UnmarshalConfig(data []byte, format ConfigFormat, config *Config) (int, []string, error) {
+	switch format {
+	case FormatYAML, FormatTOML:
+		var value interface{}
+		if format == FormatYAML {
+			if err := yaml.Unmarshal(data, &value); err != nil {
+				return 0, nil, err
+			}
+		} else {
+			var table map[string]interface{}
+			if err := toml.Unmarshal(data, &table); err != nil {
+				return 0, nil, err
+			}
+			value = table
+		}
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return 0, nil, err
+	}
+	if value == nil {
+		value = make(map[string]interface{}) // an empty file
+	}
+	version, err := migrateConfig(value)
+	if err != nil {
+		return version, nil, err
+	}
+	unknown := unknownConfigFields(value)
+	if data, err = json.Marshal(value); err != nil {
+		return version, nil, err
+	}
+	return version, unknown, json.Unmarshal(data, config)
+}
+
+// MarshalConfig encodes config in the given format. For YAML, the comments of
+// previous, the file being replaced, are kept on the entries they belong to.
+func MarshalConfig(config *Config, format ConfigFormat, previous []byte) ([]byte, error) {
+	versioned := *config
+	versioned.SchemaVersion = ConfigSchemaVersion
+	data, err := json.MarshalIndent(&versioned, "", "  ")
+	if err != nil || format == FormatJSON {
+		return data, err
+	}
+
+	if format == FormatYAML {
+		// JSON is YAML, so decoding it keeps the field order, which a map would not
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		setBlockStyle(&doc)
+		var old yaml.Node
+		if len(previous) > 0 && yaml.Unmarshal(previous, &old) == nil {
+			copyYAMLComments(&old, &doc)
+		}
+		var b bytes.Buffer
+		encoder := yaml.NewEncoder(&b)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&doc); err != nil {
+			return nil, err
+		}
+		encoder.Close()
+		return b.Bytes(), nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	encoder := toml.NewEncoder(&b)
+	encoder.Indent = ""
+	if err := encoder.Encode(tomlValue(value)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// setBlockStyle drops the flow style and quotes a node decoded from JSON has,
+// so that it is written in block style, quoting only where needed.
+func setBlockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		setBlockStyle(child)
+	}
+}
+
+// copyYAMLComments copies the comments of old to the same entries of node:
+// mapping values by key, and sequence items by their "id" if they have one,
+// by position otherwise.
+func copyYAMLComments(old, node *yaml.Node) {
+	if old.Kind != node.Kind {
+		return
+	}
+	node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(old.Content) > 0 && len(node.Content) > 0 {
+			copyYAMLComments(old.Content[0], node.Content[0])
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			for j := 0; j+1 < len(old.Content); j += 2 {
+				if old.Content[j].Value == node.Content[i].Value {
+					copyYAMLComments(old.Content[j], node.Content[i])
+					copyYAMLComments(old.Content[j+1], node.Content[i+1])
+					break
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if id := yamlMappingValue(item, "id"); id != "" {
+				for _, oldItem := range old.Content {
+					if yamlMappingValue(oldItem, "id") == id {
+						copyYAMLComments(oldItem, item)
+						break
+					}
+				}
+			} else if i < len(old.Content) {
+				copyYAMLComments(old.Content[i], item)
+			}
+		}
+	}
+}
+
+// yamlMappingValue returns the scalar value of key in a mapping node, or "".
+func yamlMappingValue(node *yaml.Node, key string) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// tomlValue converts a value decoded from JSON for the TOML encoder: TOML
+// has no null, so nulls are dropped, and numbers are integers where they can be.
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		table := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item != nil {
+				table[key] = tomlValue(item)
+			}
+		}
+		return table
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				items = append(items, tomlValue(item))
+			}
+		}
+		return items
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// convertConfig re-encodes a configuration file from one format to another.
+func convertConfig(data []byte, from, to ConfigFormat) ([]byte, error) {
+	if from == to {
+		return data, nil
+	}
+	var config Config
+	if _, _, err := UnmarshalConfig(data, from, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s configuration: %w", from, err)
+	}
+	return MarshalConfig(&config, to, nil)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalConfigRoundTrip(t *testing.T) {
+	cfg := emptyConfig()
+	cfg.Macros = []Macro{{Name: "web", Value: "{ 80 443 }", Description: "web ports"}}
+	cfg.FirewallRules = []FirewallRule{
+		{ID: "a", Enabled: true, Group: "LAN", Action: "pass", Direction: "in", Quick: true, Log: "log", Interface: "en0",
+			Protocol: "tcp", Source: "192.168.1.0/24", Destination: "any", SourcePort: "any", DestinationPort: "$web",
+			State: "keep state", MaxSrcConn: 10, MaxSrcConnRate: "15/5", Description: "web"},
+		{ID: "b", Enabled: false, Action: "block", Direction: "out", Interface: "any", Protocol: "icmp",
+			Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "any", IcmpType: "echoreq"},
+	}
+	cfg.RuleGroups = []RuleGroup{{Name: "LAN"}}
+	cfg.PortForwardingRules = []PortForwardingRule{{ID: "r", Enabled: true, Interface: "en0", Protocol: "tcp",
+		ExternalIP: "any", ExternalPort: "8080", InternalIP: "192.168.1.10", InternalPort: "80"}}
+	cfg.NatRules = []NatRule{{ID: "n", Interface: "en0", Protocol: "any", Source: "192.168.1.0/24", Destination: "any"}}
+	cfg.Tables = []PfTable{{Name: "blocklist", Persist: true, Addresses: []string{"192.0.2.1", "198.51.100.0/24"}}}
+
+	for _, format := range []ConfigFormat{FormatJSON, FormatYAML, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := MarshalConfig(cfg, format, nil)
+			if err != nil {
+				t.Fatalf("MarshalConfig: %v", err)
+			}
+			got := emptyConfig()
+			version, unknown, err := UnmarshalConfig(data, format, got)
+			if err != nil {
+				t.Fatalf("UnmarshalConfig: %v\n%s", err, data)
+			}
+			if version != ConfigSchemaVersion || len(unknown) > 0 {
+				t.Errorf("version = %d, unknown = %q, want %d and none", version, unknown, ConfigSchemaVersion)
+			}
+			if !reflect.DeepEqual(got, cfg) {
+				t.Errorf("config = %+v, want %+v\n%s", got, cfg, data)
+			}
+		})
+	}
+}
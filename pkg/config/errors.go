@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// ErrorKind classifies the errors of pf-tui, for the exit codes of the
+// commands and the status messages of the TUI.
+type ErrorKind int
+
+const (
+	KindFailure    ErrorKind = iota // any other failure, exit code 1
+	KindUsage                       // wrong command line, exit code 2
+	KindConfig                      // the rules file cannot be read, parsed or written, exit code 3
+	KindPfctl                       // pfctl or another system command failed, exit code 4
+	KindPermission                  // sudo credentials or file permissions are missing, exit code 5
+	KindValidation                  // a rule or the generated rules are invalid, exit code 6
+)
+
+// ExitCode returns the exit code of the commands for errors of the kind.
+func (k ErrorKind) ExitCode() int {
+	return int(k) + 1
+}
+
+// String returns the name of the kind in the JSON of the commands and the API.
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUsage:
+		return "usage"
+	case KindConfig:
+		return "config"
+	case KindPfctl:
+		return "pfctl"
+	case KindPermission:
+		return "permission"
+	case KindValidation:
+		return "validation"
+	}
+	return "failure"
+}
+
+// Label returns the prefix of TUI status messages for errors of the kind, or
+// "" for failures without a kind.
+func (k ErrorKind) Label() string {
+	switch k {
+	case KindUsage:
+		return "Usage error"
+	case KindConfig:
+		return "Configuration error"
+	case KindPfctl:
+		return "pfctl failed"
+	case KindPermission:
+		return "Permission denied"
+	case KindValidation:
+		return "Validation failed"
+	}
+	return ""
+}
+
+// Error is an error with its kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// WithKind classifies err as kind, unless it is nil or already classified.
+// The errors of the commands pfcli.RunSudoCmd runs are pfctl errors.
+func WithKind(kind ErrorKind, err error) error {
+	var classified *Error
+	var failed *pfcli.CommandError
+	if err == nil || errors.As(err, &classified) || errors.As(err, &failed) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf returns the kind of err. Expired sudo credentials and denied file
+// access are permission errors wherever they happen.
+func KindOf(err error) ErrorKind {
+	if errors.Is(err, pfcli.ErrSudoExpired) || errors.Is(err, fs.ErrPermission) {
+		return KindPermission
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Kind
+	}
+	var failed *pfcli.CommandError
+	if errors.As(err, &failed) {
+		return KindPfctl
+	}
+	return KindFailure
+}
+
+// ErrorMessage returns err for a status message, after the label of its kind.
+func ErrorMessage(err error) string {
+	if label := KindOf(err).Label(); label != "" {
+		return label + ": " + err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+const (
+	recentExportsFileName = "recent-exports.json"
+	maxRecentExports      = 10
+)
+
+func getRecentExportsPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, recentExportsFileName), nil
+}
+
+// LoadRecentExports returns the directories configurations were exported to,
+// most recent first.
+func LoadRecentExports() ([]string, error) {
+	path, err := getRecentExportsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var dirs []string
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return dirs, nil
+}
+
+// RecordExport moves the directory of an exported file to the front of the
+// recent export destinations, keeping the last maxRecentExports.
+func RecordExport(exportPath string) error {
+	dirs, err := LoadRecentExports()
+	if err != nil {
+		logging.Warn(fmt.Sprintf("Discarding recent export destinations: %v", err))
+	}
+	dir := filepath.Dir(exportPath)
+	recent := []string{dir}
+	for _, d := range dirs {
+		if d != dir && len(recent) < maxRecentExports {
+			recent = append(recent, d)
+		}
+	}
+
+	path, err := getRecentExportsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(recent)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ExpandHome replaces a leading ~ in path with the home directory. The rest
+// of path is kept as typed, e.g. a trailing separator.
+func ExpandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return strings.TrimSuffix(home, string(filepath.Separator)) + path[1:]
+		}
+	}
+	return path
+}
+
+// CompleteDirectory completes the last element of path to the directories it
+// is the start of, like a shell does on Tab. With one match, the path of the
+// directory is returned with a trailing separator; with several, path is
+// extended to their longest common prefix, and the matches are returned too.
+// Hidden directories only match once their dot has been typed.
+func CompleteDirectory(path string) (string, []string) {
+	path = ExpandHome(path)
+	dir, prefix := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return path, nil
+	}
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		// Follow symlinks, e.g. to mounted volumes
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+
+	base := strings.TrimSuffix(path, prefix)
+	switch len(matches) {
+	case 0:
+		return path, nil
+	case 1:
+		return base + matches[0] + string(filepath.Separator), nil
+	}
+	common := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return base + common, matches
+}
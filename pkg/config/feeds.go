@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+const (
+	feedsDirName            = "feeds"
+	DefaultFeedRefreshHours = 24               // when a table's FeedRefreshHours is 0
+	FeedCheckInterval       = 10 * time.Minute // how often the feeds are checked for being due
+	feedDownloadTimeout     = 60 * time.Second
+	maxFeedSize             = 32 << 20 // bytes
+)
+
+// FeedStatus is the state of the downloaded copy of a table's blocklist feed.
+type FeedStatus struct {
+	Table   string
+	Updated time.Time // zero if the feed has not been downloaded yet
+	Entries int
+	Err     error // error of the last update, nil if it succeeded
+}
+
+// FeedPath returns the path of the downloaded copy of the feed of table <name>.
+// pf.conf loads the table from this file.
+func FeedPath(name string) (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(configPath, feedsDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".txt"), nil
+}
+
+// feedRefresh returns how often the feed of table is downloaded.
+func feedRefresh(table PfTable) time.Duration {
+	hours := table.FeedRefreshHours
+	if hours <= 0 {
+		hours = DefaultFeedRefreshHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// GetFeedStatus returns the status of the downloaded copy of the feed of table,
+// from the file's modification time and number of lines.
+func GetFeedStatus(table PfTable) FeedStatus {
+	status := FeedStatus{Table: table.Name}
+	path, err := FeedPath(table.Name)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return status // not downloaded yet
+	}
+	status.Updated = info.ModTime()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Entries = strings.Count(string(data), "\n")
+	return status
+}
+
+// FeedDue reports whether the feed of table has never been downloaded or is
+// older than its refresh interval.
+func FeedDue(table PfTable) bool {
+	if table.FeedURL == "" {
+		return false
+	}
+	status := GetFeedStatus(table)
+	return status.Updated.IsZero() || time.Since(status.Updated) >= feedRefresh(table)
+}
+
+// ParseFeed reads the addresses and networks of a blocklist, one per line.
+// Comments starting with ";" or "#" and anything after the first field are
+// ignored, as are lines that are not an address or network, so lists such as
+// Spamhaus DROP ("1.10.16.0/20 ; SBL256894") can be used as they are.
+func ParseFeed(r io.Reader) ([]string, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, ";#"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := fields[0]
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// downloadFeed downloads and parses the blocklist at url.
+func downloadFeed(url string) ([]string, error) {
+	client := &http.Client{Timeout: feedDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	entries, err := ParseFeed(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	return entries, nil
+}
+
+// UpdateFeed downloads the feed of table, saves it for pf.conf and replaces
+// the entries of the loaded table with it. A download without any entries is
+// treated as an error, so that a broken list does not empty the table.
+func UpdateFeed(runner pfcli.CommandRunner, table PfTable) FeedStatus {
+	status := GetFeedStatus(table) // kept if the update fails
+	entries, err := downloadFeed(table.FeedURL)
+	if err == nil && len(entries) == 0 {
+		err = fmt.Errorf("%s has no addresses", table.FeedURL)
+	}
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to update the feed of <%s>: %v", table.Name, err))
+		status.Err = err
+		return status
+	}
+
+	path, err := FeedPath(table.Name)
+	if err == nil {
+		// Write a temporary file first so that pfctl never reads a partial list
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, []byte(strings.Join(entries, "\n")+"\n"), 0644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to save the feed of <%s>: %v", table.Name, err))
+		status.Err = err
+		return status
+	}
+	status.Updated = time.Now()
+	status.Entries = len(entries)
+
+	if _, err := pfcli.ModifyTable(runner, table.Name, "replace", "-f", path); err != nil {
+		// Typically the table has not been loaded yet; Save & Apply loads it from the file
+		logging.Warn(fmt.Sprintf("Downloaded the feed of <%s>, but could not load it: %v", table.Name, err))
+		status.Err = fmt.Errorf("downloaded, but not loaded (Save & Apply the configuration): %w", err)
+		return status
+	}
+	logging.Info(fmt.Sprintf("Updated <%s> with %d entries from %s", table.Name, len(entries), table.FeedURL))
+	return status
+}
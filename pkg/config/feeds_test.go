@@ -0,0 +1,31 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+		want []string
+	}{
+		{name: "addresses", feed: "192.0.2.1\n2001:db8::1\n", want: []string{"192.0.2.1", "2001:db8::1"}},
+		{name: "spamhaus drop", feed: "; Spamhaus DROP List\n1.10.16.0/20 ; SBL256894\n", want: []string{"1.10.16.0/20"}},
+		{name: "comments and extra fields", feed: "# list\n198.51.100.0/24 some host # comment\n\n", want: []string{"198.51.100.0/24"}},
+		{name: "invalid lines", feed: "example.com\n192.0.2.300\n10.0.0.0/33\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFeed(strings.NewReader(tt.feed))
+			if err != nil {
+				t.Fatalf("ParseFeed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
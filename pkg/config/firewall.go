@@ -0,0 +1,2601 @@
+// Package config is the configuration of pf-tui: the rules, tables, macros
+// and settings of FirewallManager, the rules file in JSON, YAML or TOML, the
+// generation of pf.conf from it and Save & Apply with package pfcli. It also
+// keeps the backups, the apply history, the blocklist feeds and the
+// statistics in the ConfigDir.
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// FirewallRule represents a single filter rule.
+type FirewallRule struct {
+	ID              string     `json:"id,omitempty"`
+	Enabled         bool       `json:"enabled"`
+	Group           string     `json:"group,omitempty"` // name of the RuleGroup this rule belongs to, "" if ungrouped
+	Action          string     `json:"action"`
+	Direction       string     `json:"direction"`
+	Quick           bool       `json:"quick"`
+	Log             string     `json:"log,omitempty"` // "", "log" or "log (all)"
+	Interface       string     `json:"interface"`
+	Route           string     `json:"route,omitempty"`           // "", "route-to" or "reply-to"
+	RouteInterface  string     `json:"route_interface,omitempty"` // interface the matching packets are routed out of
+	RouteGateway    string     `json:"route_gateway,omitempty"`   // next hop on RouteInterface, "" for none
+	Protocol        string     `json:"protocol"`
+	Source          string     `json:"source"`
+	Destination     string     `json:"destination"`
+	SourceNot       bool       `json:"source_not,omitempty"`      // match everything except Source
+	DestinationNot  bool       `json:"destination_not,omitempty"` // match everything except Destination
+	SourcePort      string     `json:"source_port"`
+	DestinationPort string     `json:"destination_port"`
+	IcmpType        string     `json:"icmp_type,omitempty"`
+	IcmpCode        string     `json:"icmp_code,omitempty"`
+	State           string     `json:"state,omitempty"`             // "", "no state", "keep state", "modulate state" or "synproxy state"
+	StateMax        int        `json:"state_max,omitempty"`         // max states created by this rule (0 = unlimited)
+	SourceTrack     string     `json:"source_track,omitempty"`      // "", "rule" or "global"
+	MaxSrcConn      int        `json:"max_src_conn,omitempty"`      // max simultaneous connections per source (0 = unlimited)
+	MaxSrcConnRate  string     `json:"max_src_conn_rate,omitempty"` // max new connections per source as "number/seconds"
+	OverloadTable   string     `json:"overload_table,omitempty"`    // table that sources exceeding the limits are added to
+	OverloadFlush   string     `json:"overload_flush,omitempty"`    // "", "flush" or "flush global"
+	Pipe            int        `json:"pipe,omitempty"`              // number of the DummynetPipe shaping matching traffic, 0 for none
+	Probability     int        `json:"probability,omitempty"`       // percentage of matching packets the rule applies to, 0 for all
+	Description     string     `json:"description"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`  // when the rule was added, nil if before timestamps existed
+	ModifiedAt      *time.Time `json:"modified_at,omitempty"` // when the rule was last changed, see stampRules
+}
+
+// StateModes lists the state modes a filter rule can use. The empty mode leaves the
+// choice to pf's default.
+var StateModes = []string{"", "no state", "keep state", "modulate state", "synproxy state"}
+
+// PortForwardingRule represents a single port forwarding (RDR) rule.
+type PortForwardingRule struct {
+	ID           string     `json:"id,omitempty"`
+	Enabled      bool       `json:"enabled"`
+	Interface    string     `json:"interface"`
+	Protocol     string     `json:"protocol"`
+	ExternalIP   string     `json:"external_ip"`
+	ExternalPort string     `json:"external_port"`
+	InternalIP   string     `json:"internal_ip"`
+	InternalPort string     `json:"internal_port"`
+	Description  string     `json:"description"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	ModifiedAt   *time.Time `json:"modified_at,omitempty"`
+}
+
+// NatRule represents a single outbound NAT rule.
+type NatRule struct {
+	ID          string     `json:"id,omitempty"`
+	Interface   string     `json:"interface"`
+	Protocol    string     `json:"protocol"`
+	Source      string     `json:"source"`
+	Destination string     `json:"destination"`
+	Translation string     `json:"translation"`
+	Description string     `json:"description"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ModifiedAt  *time.Time `json:"modified_at,omitempty"`
+}
+
+// PfTable represents a named pf table (e.g. <blocklist>) that rules can reference.
+type PfTable struct {
+	Name             string   `json:"name"`
+	Persist          bool     `json:"persist"`
+	Addresses        []string `json:"addresses"`
+	FeedURL          string   `json:"feed_url,omitempty"`           // blocklist downloaded into the table, see feeds.go
+	FeedRefreshHours int      `json:"feed_refresh_hours,omitempty"` // 0 for DefaultFeedRefreshHours
+	Description      string   `json:"description"`
+}
+
+// Macro represents a pf macro definition (e.g. ext_if = "en0") that rule fields can reference as $name.
+type Macro struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// RuleGroup is a named section of filter rules (e.g. "LAN"). Rules of a group
+// are kept together, in the order of Config.RuleGroups, after the ungrouped rules.
+type RuleGroup struct {
+	Name string `json:"name"`
+}
+
+// ScrubOptions holds the packet normalization (scrub) settings of the anchor.
+type ScrubOptions struct {
+	Enabled       bool   `json:"enabled"`
+	Direction     string `json:"direction"` // "in", "out" or "" for both
+	Interface     string `json:"interface"`
+	ReassembleTCP bool   `json:"reassemble_tcp"`
+	NoDF          bool   `json:"no_df"`
+	RandomID      bool   `json:"random_id"`
+	Fragment      string `json:"fragment"` // "reassemble", "crop" or "drop-ovl"
+}
+
+// DummynetPipe is a dnctl pipe that shapes the traffic of the filter rules
+// attached to it.
+type DummynetPipe struct {
+	Number      int    `json:"number"`
+	Bandwidth   string `json:"bandwidth"`  // e.g. "10Mbit/s", "" for unlimited
+	Delay       int    `json:"delay"`      // added latency in milliseconds
+	QueueSize   int    `json:"queue_size"` // queue size in slots, 0 for dnctl's default
+	Description string `json:"description"`
+}
+
+// PfOptions holds pf's global "set" options.
+type PfOptions struct {
+	SkipInterfaces    []string       `json:"skip_interfaces"`               // interfaces pf does not filter; a trailing "*" matches by prefix (e.g. "utun*")
+	StateLimit        int            `json:"state_limit,omitempty"`         // "set limit states", 0 for pf's default
+	TableEntriesLimit int            `json:"table_entries_limit,omitempty"` // "set limit table-entries", 0 for pf's default
+	BlockPolicy       string         `json:"block_policy,omitempty"`        // "", "drop" or "return"
+	Optimization      string         `json:"optimization,omitempty"`        // "", "normal", "high-latency", "satellite", "aggressive" or "conservative"
+	Timeouts          map[string]int `json:"timeouts,omitempty"`            // "set timeout" values by name (e.g. "tcp.established"), only those changed from pf's defaults
+}
+
+// Settings holds the settings of pf-tui itself, as opposed to pf's.
+type Settings struct {
+	GeoIPCountryDB       string `json:"geoip_country_db,omitempty"` // path of a MaxMind GeoLite2/GeoIP2 Country or City database
+	GeoIPASNDB           string `json:"geoip_asn_db,omitempty"`     // path of a MaxMind GeoLite2 ASN database
+	AutoBan              bool   `json:"auto_ban,omitempty"`         // ban sources with repeated blocked packets, see autoban.go
+	AutoBanThreshold     int    `json:"auto_ban_threshold,omitempty"`
+	AutoBanWindowMinutes int    `json:"auto_ban_window_minutes,omitempty"`
+	AutoBanMinutes       int    `json:"auto_ban_minutes,omitempty"`
+	RollbackSeconds      int    `json:"rollback_seconds,omitempty"`      // revert an apply unless confirmed within this time, 0 to not
+	BackupIntervalHours  int    `json:"backup_interval_hours,omitempty"` // hours between scheduled backups, 0 to not back up, see backup.go
+	BackupRetention      int    `json:"backup_retention,omitempty"`      // number of scheduled backups kept, 0 for DefaultBackupRetention
+	InfoRefreshSeconds   int    `json:"info_refresh_seconds,omitempty"`  // seconds between refreshes of Show Info, 0 for defaultInfoRefreshSeconds
+	// Keybindings map keys to the keys they stand for, e.g. "ctrl+n" to
+	// "down", or to "" to turn them off, see keys.go
+	Keybindings map[string]string `json:"keybindings,omitempty"`
+	// RuleListColumns are the optional columns the rule list shows, empty
+	// for the default ones, see columns.go
+	RuleListColumns []string `json:"rule_list_columns,omitempty"`
+	RuleListLayout  string   `json:"rule_list_layout,omitempty"` // "compact" (the default) or "detailed"
+}
+
+// Config holds all firewall, port forwarding and NAT rules, and the tables and macros they reference.
+type Config struct {
+	SchemaVersion       int                  `json:"schema_version"` // see ConfigSchemaVersion
+	Macros              []Macro              `json:"macros"`
+	FirewallRules       []FirewallRule       `json:"filter_rules"`
+	PortForwardingRules []PortForwardingRule `json:"rdr_rules"`
+	NatRules            []NatRule            `json:"nat_rules"`
+	Tables              []PfTable            `json:"tables"`
+	RuleGroups          []RuleGroup          `json:"rule_groups"`
+	Scrub               ScrubOptions         `json:"scrub"`
+	Pipes               []DummynetPipe       `json:"pipes"`
+	Options             PfOptions            `json:"options"`
+	Settings            Settings             `json:"settings"`
+	Archive             []ArchivedRule       `json:"archive,omitempty"` // deleted rules, most recent first, see archive.go
+}
+
+// FirewallManager handles loading, saving, and generating firewall configurations.
+type FirewallManager struct {
+	Config        *Config
+	UnknownFields []string // fields of the rules file the last LoadConfig had no place for
+	saved         []byte   // Config as last loaded or saved, see IsDirty
+	fileData      []byte   // content of the rules file as last loaded or saved, see ChangedOnDisk
+}
+
+// NewFirewallManager creates a new FirewallManager.
+func NewFirewallManager() *FirewallManager {
+	fm := &FirewallManager{Config: emptyConfig()}
+	fm.markSaved()
+	return fm
+}
+
+// emptyConfig returns a configuration without rules.
+func emptyConfig() *Config {
+	return &Config{
+		SchemaVersion:       ConfigSchemaVersion,
+		FirewallRules:       []FirewallRule{},
+		RuleGroups:          []RuleGroup{},
+		Pipes:               []DummynetPipe{},
+		PortForwardingRules: []PortForwardingRule{},
+		NatRules:            []NatRule{},
+		Tables:              []PfTable{},
+		Macros:              []Macro{},
+	}
+}
+
+// markSaved records the current configuration as the one in the file.
+func (fm *FirewallManager) markSaved() {
+	fm.saved, _ = json.Marshal(fm.Config)
+}
+
+// IsDirty reports whether the configuration has changes that are not saved to
+// the configuration file yet, such as a new rule order.
+func (fm *FirewallManager) IsDirty() bool {
+	data, err := json.Marshal(fm.Config)
+	return err != nil || string(data) != string(fm.saved)
+}
+
+// configDirOverride is the directory set by SetConfigDir, see ConfigDir.
+var configDirOverride string
+
+// SetConfigDir makes dir the directory of ConfigDir, e.g. for the
+// --config-dir flag. "" goes back to the default.
+func SetConfigDir(dir string) {
+	configDirOverride = dir
+}
+
+// ConfigDir returns the directory of the rules file, the logs and the backups:
+// the directory of SetConfigDir, the PF_TUI_CONFIG environment variable,
+// $XDG_CONFIG_HOME/pf-tui or ~/.config/pf-tui, whichever is set first.
+func ConfigDir() (string, error) {
+	if configDirOverride != "" {
+		return filepath.Abs(ExpandHome(configDirOverride))
+	}
+	if dir := os.Getenv("PF_TUI_CONFIG"); dir != "" {
+		return filepath.Abs(ExpandHome(dir))
+	}
+	// The XDG spec says to ignore relative paths
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "pf-tui"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "pf-tui"), nil
+}
+
+// GetDefaultConfigPath returns the path of the rules file in the ConfigDir.
+func GetDefaultConfigPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	// rules.json, unless the rules are kept in another format, see ConfigFormatOf
+	for _, name := range ConfigFileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name), nil
+		}
+	}
+	return filepath.Join(dir, ConfigFileNames[0]), nil
+}
+
+// GetConfigPath returns the ConfigDir, creating it if needed.
+func GetConfigPath() (string, error) {
+	configPath, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(configPath, 0755); err != nil {
+		return "", err
+	}
+	return configPath, nil
+}
+
+// LoadConfig loads the firewall configuration from the default rules file.
+func (fm *FirewallManager) LoadConfig() error {
+	path, err := GetDefaultConfigPath()
+	if err != nil {
+		logging.Info(fmt.Sprintf("Error getting default config path: %v", err))
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logging.Warn("Configuration file not found. A new empty configuration will be created on next save.")
+			fm.Config = emptyConfig()
+			fm.markSaved()
+			fm.fileData = nil
+			return nil
+		}
+		logging.Error(fmt.Sprintf("Failed to read configuration file %s: %v", path, err))
+		return WithKind(KindConfig, err)
+	}
+
+	// Decode into a new configuration: decoding into the current one would keep
+	// the fields the file leaves out, e.g. of a rule that moved
+	cfg := emptyConfig()
+	version, unknown, err := UnmarshalConfig(data, ConfigFormatOf(path), cfg)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to parse %s from configuration file %s: %v", ConfigFormatOf(path), path, err))
+		return WithKind(KindConfig, err)
+	}
+	fm.Config = cfg
+	fm.UnknownFields = unknown
+	fm.fileData = data
+	if len(unknown) > 0 {
+		logging.Warn(fmt.Sprintf("%s has fields this version of pf-tui does not know, which the next save drops: %s",
+			path, strings.Join(unknown, ", ")))
+	}
+
+	// Rules created before rule IDs existed get one now; it is persisted on the next save.
+	for i := range fm.Config.FirewallRules {
+		rule := &fm.Config.FirewallRules[i]
+		if rule.ID == "" {
+			rule.ID = NewRuleID()
+		}
+		if rule.SourcePort == "" {
+			rule.SourcePort = "any"
+		}
+		if rule.DestinationPort == "" {
+			rule.DestinationPort = "any"
+		}
+	}
+	for i := range fm.Config.PortForwardingRules {
+		if fm.Config.PortForwardingRules[i].ID == "" {
+			fm.Config.PortForwardingRules[i].ID = NewRuleID()
+		}
+	}
+	for i := range fm.Config.NatRules {
+		if fm.Config.NatRules[i].ID == "" {
+			fm.Config.NatRules[i].ID = NewRuleID()
+		}
+	}
+	fm.normalizeRuleGroups()
+	fm.markSaved()
+
+	logging.Info(fmt.Sprintf("Successfully loaded configuration from %s", path))
+
+	// Re-save a migrated file in the new format, unless that would drop data
+	// the user has not been told about yet; the old file is backed up
+	if version < ConfigSchemaVersion && len(unknown) == 0 {
+		logging.Info(fmt.Sprintf("Migrated %s from schema version %d to %d", path, version, ConfigSchemaVersion))
+		if err := fm.SaveConfig(); err != nil {
+			logging.Warn(fmt.Sprintf("Failed to save the migrated configuration: %v", err))
+		}
+	}
+	return nil
+}
+
+// ImportConfigFile backs up the existing config and replaces it with a new
+// one, converted to the format of the rules file if needed.
+func (fm *FirewallManager) ImportConfigFile(sourcePath string) error {
+	// Read the new config file first, as the backup below can rotate it away
+	// when restoring the oldest backup
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to read import file %s: %v", sourcePath, err))
+		return fmt.Errorf("failed to read import file: %w", err)
+	}
+
+	return fm.ImportConfigData(data, ConfigFormatOf(sourcePath), sourcePath)
+}
+
+// ImportConfigData backs up the existing config and replaces it with data in
+// the given format, read from source, e.g. a file or stdin.
+func (fm *FirewallManager) ImportConfigData(data []byte, format ConfigFormat, source string) error {
+	logging.Info(fmt.Sprintf("Importing configuration from %s", source))
+	if err := fm.replaceConfigFile(data, format); err != nil {
+		return err
+	}
+	logging.Info(fmt.Sprintf("Imported configuration from %s", source))
+	return nil
+}
+
+// ConfigPreview is what a configuration file would import, for checking it
+// before it replaces the configuration.
+type ConfigPreview struct {
+	Path     string
+	Format   ConfigFormat
+	Config   Config
+	Version  int      // schema version of the file, older ones are migrated
+	Unknown  []string // fields of the file the import drops
+	Problems []string // references to macros and tables the file does not define
+	Err      error    // why the file cannot be imported, nil if it can
+}
+
+// PreviewConfigFile reads and checks a configuration file without importing it.
+func PreviewConfigFile(path string) ConfigPreview {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ConfigPreview{Path: path, Format: ConfigFormatOf(path), Err: err}
+	}
+	return PreviewConfigData(path, data, ConfigFormatOf(path))
+}
+
+// PreviewConfigData checks configuration data in the given format, read from
+// path, without importing it.
+func PreviewConfigData(path string, data []byte, format ConfigFormat) ConfigPreview {
+	preview := ConfigPreview{Path: path, Format: format}
+	preview.Version, preview.Unknown, preview.Err = UnmarshalConfig(data, preview.Format, &preview.Config)
+	if preview.Err != nil {
+		return preview
+	}
+
+	imported := &FirewallManager{Config: &preview.Config}
+	check := func(kind string, i int, err error) {
+		if err != nil {
+			preview.Problems = append(preview.Problems, fmt.Sprintf("%s %d: %v", kind, i+1, err))
+		}
+	}
+	for i, rule := range preview.Config.FirewallRules {
+		check("Filter rule", i, imported.CheckMacroReferences(rule.Interface, rule.RouteInterface, rule.RouteGateway, rule.Source, rule.Destination, rule.SourcePort, rule.DestinationPort))
+		check("Filter rule", i, imported.CheckTableReferences(imported.ExpandMacros(rule.Source), imported.ExpandMacros(rule.Destination)))
+		if rule.OverloadTable != "" {
+			check("Filter rule", i, imported.CheckTableReferences("<"+rule.OverloadTable+">"))
+		}
+	}
+	for i, rule := range preview.Config.PortForwardingRules {
+		check("Port forwarding rule", i, imported.CheckMacroReferences(rule.Interface, rule.ExternalIP, rule.ExternalPort, rule.InternalIP, rule.InternalPort))
+	}
+	for i, rule := range preview.Config.NatRules {
+		check("NAT rule", i, checkNatTarget(rule))
+		check("NAT rule", i, imported.CheckMacroReferences(rule.Interface, rule.Source, rule.Destination, rule.Translation))
+		check("NAT rule", i, imported.CheckTableReferences(imported.ExpandMacros(rule.Source), imported.ExpandMacros(rule.Destination)))
+	}
+	return preview
+}
+
+// replaceConfigFile backs up the config file, replaces it with data in the
+// given format and loads the new configuration.
+func (fm *FirewallManager) replaceConfigFile(data []byte, format ConfigFormat) error {
+	defaultPath, err := GetDefaultConfigPath()
+	if err != nil {
+		logging.Info(fmt.Sprintf("Error getting default config path: %v", err))
+		return err
+	}
+	data, err = convertConfig(data, format, ConfigFormatOf(defaultPath))
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to convert the configuration: %v", err))
+		return WithKind(KindConfig, err)
+	}
+
+	// Ensure the config directory exists
+	if err := os.MkdirAll(filepath.Dir(defaultPath), 0755); err != nil {
+		logging.Error(fmt.Sprintf("Error creating config directory: %v", err))
+		return err
+	}
+
+	// Back up the existing config file
+	if err := backupConfigFile(defaultPath, data); err != nil {
+		logging.Error(fmt.Sprintf("Failed to back up %s: %v", defaultPath, err))
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	// Write the new config file to the default path
+	if err := writeFileAtomic(defaultPath, data, 0644); err != nil {
+		logging.Error(fmt.Sprintf("Failed to write new config file %s: %v", defaultPath, err))
+		return fmt.Errorf("failed to write new config file: %w", err)
+	}
+
+	// Load the new config into the manager
+	return fm.LoadConfig()
+}
+
+// SaveConfig saves the firewall configuration to the default rules file, in
+// the format of its extension.
+func (fm *FirewallManager) SaveConfig() error {
+	path, err := GetDefaultConfigPath()
+	if err != nil {
+		logging.Info(fmt.Sprintf("Error getting default config path: %v", err))
+		return err
+	}
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logging.Error(fmt.Sprintf("Error creating config directory: %v", err))
+		return WithKind(KindConfig, err)
+	}
+
+	previous, _ := os.ReadFile(path) // for the comments of a YAML file
+	if len(previous) > 0 {
+		if _, _, err := UnmarshalConfig(previous, ConfigFormatOf(path), &Config{}); errors.Is(err, ErrNewerSchema) {
+			logging.Error(fmt.Sprintf("Not overwriting %s: %v", path, err))
+			return WithKind(KindConfig, fmt.Errorf("not overwriting %s: %w", path, err))
+		}
+	}
+	fm.stampRules(time.Now())
+	data, err := MarshalConfig(fm.Config, ConfigFormatOf(path), previous)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to marshal config to %s: %v", ConfigFormatOf(path), err))
+		return WithKind(KindConfig, err)
+	}
+
+	if err := backupConfigFile(path, data); err != nil {
+		// The save itself is still safe, so only warn
+		logging.Warn(fmt.Sprintf("Failed to back up %s: %v", path, err))
+	}
+
+	logging.Info(fmt.Sprintf("Saving configuration to %s", path))
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		logging.Error(fmt.Sprintf("Failed to write to configuration file %s: %v", path, err))
+		return WithKind(KindConfig, err)
+	}
+
+	fm.markSaved()
+	fm.fileData = data
+	logging.Info(fmt.Sprintf("Saved configuration to %s", path))
+	return nil
+}
+
+// ChangedOnDisk reports whether the rules file was changed by something other
+// than pf-tui since it was last loaded or saved, e.g. edited or synced from
+// another machine. A file that cannot be read has nothing to reload.
+func (fm *FirewallManager) ChangedOnDisk() bool {
+	path, err := GetDefaultConfigPath()
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(data, fm.fileData)
+}
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory that is synced and then renamed over path, so that path holds
+// either the old or the new content even after a crash or power loss.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Sync the directory too, so that the rename survives a power loss
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+const (
+	// backupsDirName is the directory in the config directory with the copies
+	// of rules.json that SaveConfig and ImportConfigFile keep.
+	backupsDirName = "backups"
+	// maxConfigBackups is the number of copies kept; older ones are removed.
+	maxConfigBackups = 20
+	// backupTimeFormat is the time format of the backup file names.
+	backupTimeFormat = "20060102-150405"
+)
+
+// ConfigBackup is a copy of rules.json in the backups directory.
+type ConfigBackup struct {
+	Path string
+	Time time.Time // when the copy was replaced
+}
+
+// ListConfigBackups returns the backups of rules.json, newest first.
+func ListConfigBackups() ([]ConfigBackup, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(configPath, backupsDirName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var backups []ConfigBackup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "rules-") || !IsConfigFile(name) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, "rules-"), filepath.Ext(name))
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, ConfigBackup{Path: filepath.Join(dir, name), Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// pruneConfigBackups removes all but the newest maxConfigBackups backups.
+func pruneConfigBackups() {
+	backups, err := ListConfigBackups()
+	if err != nil || len(backups) <= maxConfigBackups {
+		return
+	}
+	for _, backup := range backups[maxConfigBackups:] {
+		if err := os.Remove(backup.Path); err != nil {
+			logging.Warn(fmt.Sprintf("Failed to remove old backup %s: %v", backup.Path, err))
+		}
+	}
+}
+
+// backupConfigFile copies the configuration file at path to a timestamped
+// file in the backups directory before it is replaced with data, and removes
+// the oldest backups beyond maxConfigBackups. Nothing is copied if the file
+// does not exist yet or does not change.
+func backupConfigFile(path string, data []byte) error {
+	old, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if string(old) == string(data) {
+		return nil
+	}
+	dir := filepath.Join(filepath.Dir(path), backupsDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("rules-%s%s", time.Now().Format(backupTimeFormat), filepath.Ext(path))
+	if err := writeFileAtomic(filepath.Join(dir, name), old, 0644); err != nil {
+		return err
+	}
+	pruneConfigBackups()
+	return nil
+}
+
+// SaveConfigAs saves the current configuration to a different file in the
+// given format.
+func (fm *FirewallManager) SaveConfigAs(path string, format ConfigFormat) error {
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logging.Error(fmt.Sprintf("Error creating config directory: %v", err))
+		return err
+	}
+
+	data, err := MarshalConfig(fm.Config, format, nil)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to marshal config to %s: %v", format, err))
+		return err
+	}
+
+	logging.Info(fmt.Sprintf("Exporting configuration to %s", path))
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		logging.Error(fmt.Sprintf("Failed to write to configuration file %s: %v", path, err))
+		return err
+	}
+
+	logging.Info(fmt.Sprintf("Exported configuration to %s", path))
+	return nil
+}
+
+// ExportPfConf writes the generated rules to path as a standalone pf.conf
+// that can be loaded with pfctl -f on systems without pf-tui. It has the
+// macros, options, tables and rules of the anchor, with comments, and the
+// dnctl commands the pipes need in the header.
+func (fm *FirewallManager) ExportPfConf(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logging.Error(fmt.Sprintf("Error creating export directory: %v", err))
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Generated by pf-tui on %s\n", time.Now().Format("2006-01-02 15:04:05")))
+	b.WriteString("# Load with: pfctl -f " + filepath.Base(path) + "\n")
+	if len(fm.Config.Pipes) > 0 {
+		b.WriteString("#\n# The dummynet rules need these pipes, configured before loading:\n")
+		for _, pipe := range fm.Config.Pipes {
+			b.WriteString("#   " + strings.Join(pipeConfigArgs(pipe), " ") + "\n")
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(fm.GeneratePfConf())
+
+	logging.Info(fmt.Sprintf("Exporting pf.conf to %s", path))
+	if err := writeFileAtomic(path, []byte(b.String()), 0644); err != nil {
+		logging.Error(fmt.Sprintf("Failed to write pf.conf export %s: %v", path, err))
+		return err
+	}
+	logging.Info(fmt.Sprintf("Exported pf.conf to %s", path))
+	return nil
+}
+
+// SelectedRulesConfig returns a configuration with only the filter rules with
+// the given IDs, in their order, and what they need to work on their own: their
+// groups, the macros and tables they reference, and their pipes.
+func (fm *FirewallManager) SelectedRulesConfig(ids map[string]bool) *Config {
+	config := &Config{
+		FirewallRules:       []FirewallRule{},
+		RuleGroups:          []RuleGroup{},
+		Pipes:               []DummynetPipe{},
+		PortForwardingRules: []PortForwardingRule{},
+		NatRules:            []NatRule{},
+		Tables:              []PfTable{},
+		Macros:              []Macro{},
+	}
+	groups := make(map[string]bool)
+	pipes := make(map[int]bool)
+	var fields []string
+	for _, rule := range fm.Config.FirewallRules {
+		if !ids[rule.ID] {
+			continue
+		}
+		config.FirewallRules = append(config.FirewallRules, rule)
+		groups[rule.Group] = true
+		pipes[rule.Pipe] = true
+		fields = append(fields, rule.Interface, rule.RouteInterface, rule.RouteGateway, rule.Source, rule.Destination, rule.SourcePort, rule.DestinationPort)
+		if rule.OverloadTable != "" {
+			fields = append(fields, "<"+rule.OverloadTable+">")
+		}
+	}
+	for _, group := range fm.Config.RuleGroups {
+		if groups[group.Name] {
+			config.RuleGroups = append(config.RuleGroups, group)
+		}
+	}
+	for _, pipe := range fm.Config.Pipes {
+		if pipes[pipe.Number] {
+			config.Pipes = append(config.Pipes, pipe)
+		}
+	}
+
+	// Macro values and table addresses can reference more macros and tables
+	macros := make(map[string]bool)
+	tables := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, macro := range fm.Config.Macros {
+			if !macros[macro.Name] && referencesMacro(fields, macro.Name) {
+				macros[macro.Name] = true
+				fields = append(fields, macro.Value)
+				changed = true
+			}
+		}
+		for _, table := range fm.Config.Tables {
+			if !tables[table.Name] && referencesTable(fields, table.Name) {
+				tables[table.Name] = true
+				fields = append(fields, table.Addresses...)
+				changed = true
+			}
+		}
+	}
+	for _, macro := range fm.Config.Macros {
+		if macros[macro.Name] {
+			config.Macros = append(config.Macros, macro)
+		}
+	}
+	for _, table := range fm.Config.Tables {
+		if tables[table.Name] {
+			config.Tables = append(config.Tables, table)
+		}
+	}
+	return config
+}
+
+// referencesMacro reports whether any of the fields references the macro $name.
+func referencesMacro(fields []string, name string) bool {
+	for _, field := range fields {
+		if containsString(MacroRefPattern.FindAllString(field, -1), "$"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// referencesTable reports whether any of the fields references the table <name>.
+func referencesTable(fields []string, name string) bool {
+	for _, field := range fields {
+		for _, item := range SplitList(field) {
+			if strings.TrimPrefix(item, "!") == "<"+name+">" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExportSelectedRules writes the filter rules with the given IDs, with the
+// definitions they need, to path as a configuration file in one of the
+// configuration formats, or as a pf.conf snippet for format "pf.conf".
+func (fm *FirewallManager) ExportSelectedRules(path, format string, ids map[string]bool) error {
+	selected := &FirewallManager{Config: fm.SelectedRulesConfig(ids)}
+	logging.Info(fmt.Sprintf("Exporting %d selected rules", len(selected.Config.FirewallRules)))
+	if format == "pf.conf" {
+		return selected.ExportPfConf(path)
+	}
+	return selected.SaveConfigAs(path, ConfigFormat(format))
+}
+
+// NewRuleID returns a random (version 4) UUID used to identify a rule across edits and reorders.
+func NewRuleID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		logging.Error(fmt.Sprintf("Failed to generate rule ID: %v", err))
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// stampRules maintains the metadata of the rules before a save: rules without
+// an ID, or with the ID of an earlier rule in the list, get a new one, rules
+// that were not in the configuration as last loaded or saved get created_at
+// and modified_at, and rules that changed since get a new modified_at.
+// Moving a rule does not change it, and neither does restoring it from the
+// archive.
+func (fm *FirewallManager) stampRules(now time.Time) {
+	var saved Config
+	json.Unmarshal(fm.saved, &saved) // empty if nothing was loaded yet
+
+	savedFilter := make(map[string]FirewallRule)
+	for _, rule := range saved.FirewallRules {
+		savedFilter[rule.ID] = rule
+	}
+	// A rule restored from the archive keeps its timestamps
+	for _, archived := range saved.Archive {
+		if archived.FirewallRule == nil {
+			continue
+		}
+		if _, ok := savedFilter[archived.FirewallRule.ID]; !ok {
+			savedFilter[archived.FirewallRule.ID] = *archived.FirewallRule
+		}
+	}
+	seen := make(map[string]bool)
+	for i := range fm.Config.FirewallRules {
+		rule := &fm.Config.FirewallRules[i]
+		if rule.ID == "" || seen[rule.ID] {
+			rule.ID = NewRuleID()
+		}
+		seen[rule.ID] = true
+		old, ok := savedFilter[rule.ID]
+		changed := !ok || rule.withoutTimes() != old.withoutTimes()
+		stampTimes(&rule.CreatedAt, &rule.ModifiedAt, old.CreatedAt, old.ModifiedAt, ok, changed, now)
+	}
+
+	savedRdr := make(map[string]PortForwardingRule)
+	for _, rule := range saved.PortForwardingRules {
+		savedRdr[rule.ID] = rule
+	}
+	for _, archived := range saved.Archive {
+		if archived.PortForwarding == nil {
+			continue
+		}
+		if _, ok := savedRdr[archived.PortForwarding.ID]; !ok {
+			savedRdr[archived.PortForwarding.ID] = *archived.PortForwarding
+		}
+	}
+	seen = make(map[string]bool)
+	for i := range fm.Config.PortForwardingRules {
+		rule := &fm.Config.PortForwardingRules[i]
+		if rule.ID == "" || seen[rule.ID] {
+			rule.ID = NewRuleID()
+		}
+		seen[rule.ID] = true
+		old, ok := savedRdr[rule.ID]
+		changed := !ok || rule.withoutTimes() != old.withoutTimes()
+		stampTimes(&rule.CreatedAt, &rule.ModifiedAt, old.CreatedAt, old.ModifiedAt, ok, changed, now)
+	}
+
+	savedNat := make(map[string]NatRule)
+	for _, rule := range saved.NatRules {
+		savedNat[rule.ID] = rule
+	}
+	for _, archived := range saved.Archive {
+		if archived.Nat == nil {
+			continue
+		}
+		if _, ok := savedNat[archived.Nat.ID]; !ok {
+			savedNat[archived.Nat.ID] = *archived.Nat
+		}
+	}
+	seen = make(map[string]bool)
+	for i := range fm.Config.NatRules {
+		rule := &fm.Config.NatRules[i]
+		if rule.ID == "" || seen[rule.ID] {
+			rule.ID = NewRuleID()
+		}
+		seen[rule.ID] = true
+		old, ok := savedNat[rule.ID]
+		changed := !ok || rule.withoutTimes() != old.withoutTimes()
+		stampTimes(&rule.CreatedAt, &rule.ModifiedAt, old.CreatedAt, old.ModifiedAt, ok, changed, now)
+	}
+}
+
+// stampTimes sets the timestamps of a rule for stampRules. A rule edited in a
+// form has none, so those of its saved version are kept; a rule saved before
+// timestamps existed keeps an unknown creation time.
+func stampTimes(created, modified **time.Time, oldCreated, oldModified *time.Time, existed, changed bool, now time.Time) {
+	now = now.Truncate(time.Second)
+	if !existed {
+		*created = &now
+	} else if *created == nil {
+		*created = oldCreated
+	}
+	if changed {
+		*modified = &now
+	} else if *modified == nil {
+		*modified = oldModified
+	}
+}
+
+// withoutTimes returns the rule without its timestamps, to compare it with another version of it.
+func (rule FirewallRule) withoutTimes() FirewallRule {
+	rule.CreatedAt, rule.ModifiedAt = nil, nil
+	return rule
+}
+
+// withoutTimes returns the rule without its timestamps, to compare it with another version of it.
+func (rule PortForwardingRule) withoutTimes() PortForwardingRule {
+	rule.CreatedAt, rule.ModifiedAt = nil, nil
+	return rule
+}
+
+// withoutTimes returns the rule without its timestamps, to compare it with another version of it.
+func (rule NatRule) withoutTimes() NatRule {
+	rule.CreatedAt, rule.ModifiedAt = nil, nil
+	return rule
+}
+
+// RuleLabel returns the pf label attached to the generated pf rules of a firewall rule.
+func RuleLabel(rule FirewallRule) string {
+	return "pf-tui-" + rule.ID
+}
+
+// RuleError is an error pfctl reported for a line of the generated pf.conf,
+// with the firewall rule the line was generated from.
+type RuleError struct {
+	pfcli.PfctlError
+	Text   string // the generated line
+	RuleID string // "" if the line does not belong to a firewall rule
+}
+
+// ruleLabelPattern matches the label RuleLabel attaches to generated rules.
+var ruleLabelPattern = regexp.MustCompile(`label "pf-tui-([^"]+)"`)
+
+// MapPfctlErrors maps the errors pfctl reported for the pf.conf content conf
+// to the lines and firewall rules they were generated from, using the label
+// of the line.
+func (fm *FirewallManager) MapPfctlErrors(conf string, errs []pfcli.PfctlError) []RuleError {
+	lines := strings.Split(conf, "\n")
+	var mapped []RuleError
+	for _, e := range errs {
+		ruleErr := RuleError{PfctlError: e}
+		if e.Line >= 1 && e.Line <= len(lines) {
+			ruleErr.Text = lines[e.Line-1]
+			if match := ruleLabelPattern.FindStringSubmatch(ruleErr.Text); match != nil {
+				for _, rule := range fm.Config.FirewallRules {
+					if rule.ID == match[1] {
+						ruleErr.RuleID = rule.ID
+						break
+					}
+				}
+			}
+		}
+		mapped = append(mapped, ruleErr)
+	}
+	return mapped
+}
+
+// AddFirewallRule adds a new firewall rule to the configuration file.
+func (fm *FirewallManager) AddFirewallRule(rule FirewallRule) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if rule.ID == "" {
+		rule.ID = NewRuleID()
+	}
+	fm.Config.FirewallRules = append(fm.Config.FirewallRules, rule)
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Added firewall rule: %+v", rule))
+	return fm.SaveConfig()
+}
+
+// UpdateFirewallRule updates an existing firewall rule in the configuration file.
+func (fm *FirewallManager) UpdateFirewallRule(index int, rule FirewallRule) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.FirewallRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	if rule.ID == "" {
+		rule.ID = fm.Config.FirewallRules[index].ID
+	}
+	fm.Config.FirewallRules[index] = rule
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Updated firewall rule at index %d: %+v", index, rule))
+	return fm.SaveConfig()
+}
+
+// DeleteFirewallRule moves a firewall rule from the configuration file to its archive.
+func (fm *FirewallManager) DeleteFirewallRule(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.FirewallRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	logging.Info(fmt.Sprintf("Archived firewall rule at index %d: %+v", index, fm.Config.FirewallRules[index]))
+	rule := fm.Config.FirewallRules[index]
+	fm.archive(ArchivedRule{FirewallRule: &rule})
+	fm.Config.FirewallRules = append(fm.Config.FirewallRules[:index], fm.Config.FirewallRules[index+1:]...)
+	fm.normalizeRuleGroups()
+	return fm.SaveConfig()
+}
+
+// DeleteFirewallRules moves the firewall rules with the given IDs to the
+// archive and returns how many there were.
+func (fm *FirewallManager) DeleteFirewallRules(ids map[string]bool) (int, error) {
+	if err := fm.LoadConfig(); err != nil {
+		return 0, err
+	}
+	kept := fm.Config.FirewallRules[:0]
+	deleted := 0
+	for _, rule := range fm.Config.FirewallRules {
+		if !ids[rule.ID] {
+			kept = append(kept, rule)
+			continue
+		}
+		rule := rule
+		fm.archive(ArchivedRule{FirewallRule: &rule})
+		deleted++
+	}
+	fm.Config.FirewallRules = kept
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Archived %d firewall rules", deleted))
+	return deleted, fm.SaveConfig()
+}
+
+// SetFirewallRulesEnabled enables or disables the firewall rules with the
+// given IDs and returns how many there were.
+func (fm *FirewallManager) SetFirewallRulesEnabled(ids map[string]bool, enabled bool) (int, error) {
+	if err := fm.LoadConfig(); err != nil {
+		return 0, err
+	}
+	changed := 0
+	for i, rule := range fm.Config.FirewallRules {
+		if ids[rule.ID] {
+			fm.Config.FirewallRules[i].Enabled = enabled
+			changed++
+		}
+	}
+	logging.Info(fmt.Sprintf("Set %d firewall rules enabled=%t", changed, enabled))
+	return changed, fm.SaveConfig()
+}
+
+// SetFirewallRulesGroup moves the firewall rules with the given IDs to the
+// end of group, "" for no group, and returns how many there were.
+func (fm *FirewallManager) SetFirewallRulesGroup(ids map[string]bool, group string) (int, error) {
+	if strings.ContainsAny(group, "\"\n") {
+		return 0, WithKind(KindValidation, fmt.Errorf("group name must not contain quotes"))
+	}
+	if err := fm.LoadConfig(); err != nil {
+		return 0, err
+	}
+	// Taking the rules out and appending them keeps their order and puts
+	// them after the rules already in the group
+	var kept, moved []FirewallRule
+	for _, rule := range fm.Config.FirewallRules {
+		if ids[rule.ID] {
+			rule.Group = group
+			moved = append(moved, rule)
+		} else {
+			kept = append(kept, rule)
+		}
+	}
+	fm.Config.FirewallRules = append(kept, moved...)
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Moved %d firewall rules to group %q", len(moved), group))
+	return len(moved), fm.SaveConfig()
+}
+
+// FindFirewallRule returns the index of the firewall rule with the given ID, or -1.
+func (fm *FirewallManager) FindFirewallRule(id string) int {
+	for i, rule := range fm.Config.FirewallRules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindRuleGroup returns the index of the rule group with the given name, or -1.
+func (fm *FirewallManager) FindRuleGroup(name string) int {
+	for i, group := range fm.Config.RuleGroups {
+		if group.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// MoveRuleGroup moves a rule group, together with all of its rules, delta
+// positions up (negative) or down (positive).
+func (fm *FirewallManager) MoveRuleGroup(name string, delta int) {
+	from := fm.FindRuleGroup(name)
+	to := from + delta
+	if from == -1 || to < 0 || to >= len(fm.Config.RuleGroups) {
+		return
+	}
+	fm.Config.RuleGroups[from], fm.Config.RuleGroups[to] = fm.Config.RuleGroups[to], fm.Config.RuleGroups[from]
+	fm.normalizeRuleGroups()
+}
+
+// normalizeRuleGroups keeps Config.RuleGroups in sync with the groups used by the
+// filter rules and sorts the rules so that each group is contiguous: ungrouped
+// rules first, then each group in order. Rules keep their order within a group.
+func (fm *FirewallManager) normalizeRuleGroups() {
+	used := make(map[string]bool)
+	for _, rule := range fm.Config.FirewallRules {
+		if rule.Group != "" {
+			used[rule.Group] = true
+		}
+	}
+	groups := []RuleGroup{}
+	for _, group := range fm.Config.RuleGroups {
+		if used[group.Name] {
+			groups = append(groups, group)
+			delete(used, group.Name)
+		}
+	}
+	// Groups not listed yet (new, or from a hand-edited file) go last, in rule order
+	for _, rule := range fm.Config.FirewallRules {
+		if used[rule.Group] {
+			groups = append(groups, RuleGroup{Name: rule.Group})
+			delete(used, rule.Group)
+		}
+	}
+	fm.Config.RuleGroups = groups
+
+	rank := func(rule FirewallRule) int {
+		if rule.Group == "" {
+			return -1
+		}
+		return fm.FindRuleGroup(rule.Group)
+	}
+	sort.SliceStable(fm.Config.FirewallRules, func(i, j int) bool {
+		return rank(fm.Config.FirewallRules[i]) < rank(fm.Config.FirewallRules[j])
+	})
+}
+
+// SetFirewallRuleEnabled enables or disables a firewall rule without deleting it.
+func (fm *FirewallManager) SetFirewallRuleEnabled(index int, enabled bool) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.FirewallRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	fm.Config.FirewallRules[index].Enabled = enabled
+	logging.Info(fmt.Sprintf("Set firewall rule at index %d enabled=%t", index, enabled))
+	return fm.SaveConfig()
+}
+
+// MoveFirewallRule moves a firewall rule from one index to another.
+func (fm *FirewallManager) MoveFirewallRule(from, to int) {
+	if from < 0 || from >= len(fm.Config.FirewallRules) || to < 0 || to >= len(fm.Config.FirewallRules) {
+		return
+	}
+	if from == to {
+		return
+	}
+	// Rules only move within their group; groups are moved with MoveRuleGroup.
+	if fm.Config.FirewallRules[from].Group != fm.Config.FirewallRules[to].Group {
+		return
+	}
+
+	rule := fm.Config.FirewallRules[from]
+
+	// Remove element
+	tmp := append(fm.Config.FirewallRules[:from], fm.Config.FirewallRules[from+1:]...)
+
+	// Insert element at new position
+	final := make([]FirewallRule, 0, len(fm.Config.FirewallRules))
+	final = append(final, tmp[:to]...)
+	final = append(final, rule)
+	final = append(final, tmp[to:]...)
+
+	fm.Config.FirewallRules = final
+}
+
+// AddPortForwardingRule adds a new port forwarding rule to the configuration file.
+func (fm *FirewallManager) AddPortForwardingRule(rule PortForwardingRule) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.PortForwardingRules = append(fm.Config.PortForwardingRules, rule)
+	logging.Info(fmt.Sprintf("Added port forwarding rule: %+v", rule))
+	return fm.SaveConfig()
+}
+
+// UpdatePortForwardingRule updates an existing port forwarding rule in the configuration file.
+func (fm *FirewallManager) UpdatePortForwardingRule(index int, rule PortForwardingRule) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.PortForwardingRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	if rule.ID == "" {
+		rule.ID = fm.Config.PortForwardingRules[index].ID
+	}
+	fm.Config.PortForwardingRules[index] = rule
+	logging.Info(fmt.Sprintf("Updated port forwarding rule at index %d: %+v", index, rule))
+	return fm.SaveConfig()
+}
+
+// DeletePortForwardingRule moves a port forwarding rule from the configuration file to its archive.
+func (fm *FirewallManager) DeletePortForwardingRule(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.PortForwardingRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	logging.Info(fmt.Sprintf("Archived port forwarding rule at index %d: %+v", index, fm.Config.PortForwardingRules[index]))
+	rule := fm.Config.PortForwardingRules[index]
+	fm.archive(ArchivedRule{PortForwarding: &rule})
+	fm.Config.PortForwardingRules = append(fm.Config.PortForwardingRules[:index], fm.Config.PortForwardingRules[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// SetPortForwardingRuleEnabled enables or disables a port forwarding rule without deleting it.
+func (fm *FirewallManager) SetPortForwardingRuleEnabled(index int, enabled bool) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.PortForwardingRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	fm.Config.PortForwardingRules[index].Enabled = enabled
+	logging.Info(fmt.Sprintf("Set port forwarding rule at index %d enabled=%t", index, enabled))
+	return fm.SaveConfig()
+}
+
+// MovePortForwardingRule moves a port forwarding rule from one index to another.
+func (fm *FirewallManager) MovePortForwardingRule(from, to int) {
+	if from < 0 || from >= len(fm.Config.PortForwardingRules) || to < 0 || to >= len(fm.Config.PortForwardingRules) {
+		return
+	}
+	if from == to {
+		return
+	}
+
+	rule := fm.Config.PortForwardingRules[from]
+
+	// Remove element
+	tmp := append(fm.Config.PortForwardingRules[:from], fm.Config.PortForwardingRules[from+1:]...)
+
+	// Insert element at new position
+	final := make([]PortForwardingRule, 0, len(fm.Config.PortForwardingRules))
+	final = append(final, tmp[:to]...)
+	final = append(final, rule)
+	final = append(final, tmp[to:]...)
+
+	fm.Config.PortForwardingRules = final
+}
+
+// AddNatRule adds a new NAT rule to the configuration file.
+func (fm *FirewallManager) AddNatRule(rule NatRule) error {
+	if err := checkNatTarget(rule); err != nil {
+		return WithKind(KindValidation, err)
+	}
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.NatRules = append(fm.Config.NatRules, rule)
+	logging.Info(fmt.Sprintf("Added NAT rule: %+v", rule))
+	return fm.SaveConfig()
+}
+
+// UpdateNatRule updates an existing NAT rule in the configuration file.
+func (fm *FirewallManager) UpdateNatRule(index int, rule NatRule) error {
+	if err := checkNatTarget(rule); err != nil {
+		return WithKind(KindValidation, err)
+	}
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.NatRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	if rule.ID == "" {
+		rule.ID = fm.Config.NatRules[index].ID
+	}
+	fm.Config.NatRules[index] = rule
+	logging.Info(fmt.Sprintf("Updated NAT rule at index %d: %+v", index, rule))
+	return fm.SaveConfig()
+}
+
+// checkNatTarget checks that a NAT rule has an address to translate to: its
+// translation address, or else the address of its interface.
+func checkNatTarget(rule NatRule) error {
+	if rule.Translation == "" && (rule.Interface == "" || rule.Interface == "any") {
+		return fmt.Errorf("a NAT rule needs an interface or a translation address")
+	}
+	return nil
+}
+
+// DeleteNatRule moves a NAT rule from the configuration file to its archive.
+func (fm *FirewallManager) DeleteNatRule(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.NatRules) {
+		return fmt.Errorf("invalid rule index")
+	}
+	logging.Info(fmt.Sprintf("Archived NAT rule at index %d: %+v", index, fm.Config.NatRules[index]))
+	rule := fm.Config.NatRules[index]
+	fm.archive(ArchivedRule{Nat: &rule})
+	fm.Config.NatRules = append(fm.Config.NatRules[:index], fm.Config.NatRules[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// MoveNatRule moves a NAT rule from one index to another.
+func (fm *FirewallManager) MoveNatRule(from, to int) {
+	if from < 0 || from >= len(fm.Config.NatRules) || to < 0 || to >= len(fm.Config.NatRules) {
+		return
+	}
+	if from == to {
+		return
+	}
+
+	rule := fm.Config.NatRules[from]
+
+	// Remove element
+	tmp := append(fm.Config.NatRules[:from], fm.Config.NatRules[from+1:]...)
+
+	// Insert element at new position
+	final := make([]NatRule, 0, len(fm.Config.NatRules))
+	final = append(final, tmp[:to]...)
+	final = append(final, rule)
+	final = append(final, tmp[to:]...)
+
+	fm.Config.NatRules = final
+}
+
+// InternetSharingGroup is the rule group the Internet Sharing wizard puts its filter rules in.
+const InternetSharingGroup = "Internet Sharing"
+
+// InternetSharingRules returns the rules that share the connection of the wan
+// interface with the network on the lan interface: a NAT rule translating the
+// lan network to the address of wan, and the filter rules passing its traffic.
+func InternetSharingRules(wan, lan string) (NatRule, []FirewallRule) {
+	lanNetwork := lan + ":network"
+	nat := NatRule{
+		Interface:   wan,
+		Protocol:    "any",
+		Source:      lanNetwork,
+		Destination: "any",
+		Description: fmt.Sprintf("Internet Sharing: %s to %s", lan, wan),
+	}
+	rules := []FirewallRule{
+		{
+			Enabled:     true,
+			Group:       InternetSharingGroup,
+			Action:      "pass",
+			Direction:   "in",
+			Quick:       true,
+			Interface:   lan,
+			Protocol:    "any",
+			Source:      lanNetwork,
+			Destination: "any",
+			State:       "keep state",
+			Description: fmt.Sprintf("Internet Sharing: allow clients on %s", lan),
+		},
+		{
+			Enabled:     true,
+			Group:       InternetSharingGroup,
+			Action:      "pass",
+			Direction:   "out",
+			Quick:       true,
+			Interface:   wan,
+			Protocol:    "any",
+			Source:      "any",
+			Destination: "any",
+			State:       "keep state",
+			Description: fmt.Sprintf("Internet Sharing: allow outbound traffic on %s", wan),
+		},
+	}
+	return nat, rules
+}
+
+// AddInternetSharing adds the rules of InternetSharingRules to the configuration file.
+func (fm *FirewallManager) AddInternetSharing(wan, lan string) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if wan == lan {
+		return fmt.Errorf("the WAN and LAN interfaces must differ")
+	}
+	nat, rules := InternetSharingRules(wan, lan)
+	fm.Config.NatRules = append(fm.Config.NatRules, nat)
+	for _, rule := range rules {
+		rule.ID = NewRuleID()
+		fm.Config.FirewallRules = append(fm.Config.FirewallRules, rule)
+	}
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Added Internet Sharing rules: WAN %s, LAN %s", wan, lan))
+	return fm.SaveConfig()
+}
+
+// BlocklistTable is the table quick-block adds addresses to.
+const BlocklistTable = "blocklist"
+
+// blockRule returns a "block drop in quick" rule for all traffic from source.
+func blockRule(source, description string) FirewallRule {
+	return FirewallRule{
+		ID:              NewRuleID(),
+		Enabled:         true,
+		Action:          "block",
+		Direction:       "in",
+		Quick:           true,
+		Interface:       "any",
+		Protocol:        "any",
+		Source:          source,
+		Destination:     "any",
+		SourcePort:      "any",
+		DestinationPort: "any",
+		Description:     description,
+	}
+}
+
+// BlockAddress adds a quick rule blocking all traffic from addr. The rule is
+// put first so that no other quick rule lets the address through.
+func (fm *FirewallManager) BlockAddress(addr string) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	rule := blockRule(addr, fmt.Sprintf("Quick block of %s", addr))
+	fm.Config.FirewallRules = append([]FirewallRule{rule}, fm.Config.FirewallRules...)
+	fm.normalizeRuleGroups()
+	logging.Info(fmt.Sprintf("Added quick block rule: %+v", rule))
+	return fm.SaveConfig()
+}
+
+// ensureBlockTable adds the persist table name and a quick rule blocking it,
+// unless they exist, and returns the index of the table and whether the
+// configuration changed. The configuration is not saved.
+func (fm *FirewallManager) ensureBlockTable(name, description string) (int, bool) {
+	changed := false
+	index := fm.FindTable(name)
+	if index == -1 {
+		fm.Config.Tables = append(fm.Config.Tables, PfTable{
+			Name:        name,
+			Persist:     true,
+			Description: description,
+		})
+		index = len(fm.Config.Tables) - 1
+		changed = true
+	}
+
+	source := "<" + name + ">"
+	for _, rule := range fm.Config.FirewallRules {
+		if rule.Source == source && rule.Action == "block" && rule.Enabled {
+			return index, changed
+		}
+	}
+	rule := blockRule(source, "Quick block of the addresses in "+source)
+	fm.Config.FirewallRules = append([]FirewallRule{rule}, fm.Config.FirewallRules...)
+	fm.normalizeRuleGroups()
+	return index, true
+}
+
+// AddToBlocklist adds addr to the BlocklistTable table. The table and a quick
+// rule blocking it are created the first time.
+func (fm *FirewallManager) AddToBlocklist(addr string) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	index, _ := fm.ensureBlockTable(BlocklistTable, "Addresses blocked with quick-block")
+	table := &fm.Config.Tables[index]
+	for _, existing := range table.Addresses {
+		if existing == addr {
+			return fmt.Errorf("%s is already in <%s>", addr, BlocklistTable)
+		}
+	}
+	table.Addresses = append(table.Addresses, addr)
+	logging.Info(fmt.Sprintf("Added %s to table <%s>", addr, BlocklistTable))
+	return fm.SaveConfig()
+}
+
+// EnsureAutoBanTable adds the AutoBanTable table and a quick rule blocking it
+// to the configuration, unless they exist, and reports whether it changed.
+func (fm *FirewallManager) EnsureAutoBanTable() (bool, error) {
+	if err := fm.LoadConfig(); err != nil {
+		return false, err
+	}
+	if _, changed := fm.ensureBlockTable(AutoBanTable, "Addresses banned automatically after repeated blocked packets"); !changed {
+		return false, nil
+	}
+	logging.Info(fmt.Sprintf("Added table <%s> and its block rule", AutoBanTable))
+	return true, fm.SaveConfig()
+}
+
+// AddTable adds a new table to the configuration file.
+func (fm *FirewallManager) AddTable(table PfTable) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if fm.FindTable(table.Name) != -1 {
+		return fmt.Errorf("table <%s> already exists", table.Name)
+	}
+	fm.Config.Tables = append(fm.Config.Tables, table)
+	logging.Info(fmt.Sprintf("Added table: %+v", table))
+	return fm.SaveConfig()
+}
+
+// UpdateTable updates an existing table in the configuration file.
+func (fm *FirewallManager) UpdateTable(index int, table PfTable) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Tables) {
+		return fmt.Errorf("invalid table index")
+	}
+	if existing := fm.FindTable(table.Name); existing != -1 && existing != index {
+		return fmt.Errorf("table <%s> already exists", table.Name)
+	}
+	fm.Config.Tables[index] = table
+	logging.Info(fmt.Sprintf("Updated table at index %d: %+v", index, table))
+	return fm.SaveConfig()
+}
+
+// DeleteTable deletes a table from the configuration file.
+func (fm *FirewallManager) DeleteTable(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Tables) {
+		return fmt.Errorf("invalid table index")
+	}
+	logging.Info(fmt.Sprintf("Deleted table at index %d: %+v", index, fm.Config.Tables[index]))
+	fm.Config.Tables = append(fm.Config.Tables[:index], fm.Config.Tables[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// FindTable returns the index of the table with the given name, or -1 if there is none.
+// The name may be given with or without the surrounding angle brackets.
+func (fm *FirewallManager) FindTable(name string) int {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "<"), ">")
+	for i, table := range fm.Config.Tables {
+		if table.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// CheckTableReferences returns an error if any of the given rule fields references
+// a table (e.g. "<blocklist>" or "!<blocklist>") that is not defined in the configuration.
+func (fm *FirewallManager) CheckTableReferences(fields ...string) error {
+	for _, field := range fields {
+		field = strings.TrimPrefix(strings.TrimSpace(field), "!")
+		for _, item := range SplitList(field) {
+			if strings.HasPrefix(item, "<") && strings.HasSuffix(item, ">") && fm.FindTable(item) == -1 {
+				return fmt.Errorf("table %s is not defined", item)
+			}
+		}
+	}
+	return nil
+}
+
+// AddMacro adds a new macro to the configuration file.
+func (fm *FirewallManager) AddMacro(macro Macro) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if fm.FindMacro(macro.Name) != -1 {
+		return fmt.Errorf("macro $%s already exists", macro.Name)
+	}
+	fm.Config.Macros = append(fm.Config.Macros, macro)
+	logging.Info(fmt.Sprintf("Added macro: %+v", macro))
+	return fm.SaveConfig()
+}
+
+// UpdateMacro updates an existing macro in the configuration file.
+func (fm *FirewallManager) UpdateMacro(index int, macro Macro) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Macros) {
+		return fmt.Errorf("invalid macro index")
+	}
+	if existing := fm.FindMacro(macro.Name); existing != -1 && existing != index {
+		return fmt.Errorf("macro $%s already exists", macro.Name)
+	}
+	fm.Config.Macros[index] = macro
+	logging.Info(fmt.Sprintf("Updated macro at index %d: %+v", index, macro))
+	return fm.SaveConfig()
+}
+
+// DeleteMacro deletes a macro from the configuration file.
+func (fm *FirewallManager) DeleteMacro(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Macros) {
+		return fmt.Errorf("invalid macro index")
+	}
+	logging.Info(fmt.Sprintf("Deleted macro at index %d: %+v", index, fm.Config.Macros[index]))
+	fm.Config.Macros = append(fm.Config.Macros[:index], fm.Config.Macros[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// AddPipe adds a new dummynet pipe to the configuration file.
+func (fm *FirewallManager) AddPipe(pipe DummynetPipe) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if fm.FindPipe(pipe.Number) != -1 {
+		return fmt.Errorf("pipe %d already exists", pipe.Number)
+	}
+	fm.Config.Pipes = append(fm.Config.Pipes, pipe)
+	logging.Info(fmt.Sprintf("Added pipe: %+v", pipe))
+	return fm.SaveConfig()
+}
+
+// UpdatePipe updates an existing dummynet pipe in the configuration file.
+func (fm *FirewallManager) UpdatePipe(index int, pipe DummynetPipe) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Pipes) {
+		return fmt.Errorf("invalid pipe index")
+	}
+	if existing := fm.FindPipe(pipe.Number); existing != -1 && existing != index {
+		return fmt.Errorf("pipe %d already exists", pipe.Number)
+	}
+	// Rules refer to pipes by number, so follow a renumbered pipe
+	if old := fm.Config.Pipes[index].Number; old != pipe.Number {
+		for i := range fm.Config.FirewallRules {
+			if fm.Config.FirewallRules[i].Pipe == old {
+				fm.Config.FirewallRules[i].Pipe = pipe.Number
+			}
+		}
+	}
+	fm.Config.Pipes[index] = pipe
+	logging.Info(fmt.Sprintf("Updated pipe at index %d: %+v", index, pipe))
+	return fm.SaveConfig()
+}
+
+// DeletePipe deletes a dummynet pipe from the configuration file. A pipe that
+// is still attached to a rule cannot be deleted.
+func (fm *FirewallManager) DeletePipe(index int) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(fm.Config.Pipes) {
+		return fmt.Errorf("invalid pipe index")
+	}
+	number := fm.Config.Pipes[index].Number
+	for i, rule := range fm.Config.FirewallRules {
+		if rule.Pipe == number {
+			return fmt.Errorf("pipe %d is used by rule %d", number, i+1)
+		}
+	}
+	logging.Info(fmt.Sprintf("Deleted pipe at index %d: %+v", index, fm.Config.Pipes[index]))
+	fm.Config.Pipes = append(fm.Config.Pipes[:index], fm.Config.Pipes[index+1:]...)
+	return fm.SaveConfig()
+}
+
+// FindPipe returns the index of the pipe with the given number, or -1 if there is none.
+func (fm *FirewallManager) FindPipe(number int) int {
+	for i, pipe := range fm.Config.Pipes {
+		if pipe.Number == number {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpdateOptions replaces the global pf options in the configuration file.
+func (fm *FirewallManager) UpdateOptions(options PfOptions) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.Options = options
+	logging.Info(fmt.Sprintf("Updated global options: %+v", options))
+	return fm.SaveConfig()
+}
+
+// UpdateSettings replaces the pf-tui settings in the configuration file.
+func (fm *FirewallManager) UpdateSettings(settings Settings) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.Settings = settings
+	logging.Info(fmt.Sprintf("Updated settings: %+v", settings))
+	return fm.SaveConfig()
+}
+
+// SetRuleListView sets the columns and the layout of the rule list in the
+// settings of the configuration file.
+func (fm *FirewallManager) SetRuleListView(columns []string, layout string) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.Settings.RuleListColumns = columns
+	fm.Config.Settings.RuleListLayout = layout
+	logging.Info(fmt.Sprintf("Set the rule list columns to %v, layout %s", columns, layout))
+	return fm.SaveConfig()
+}
+
+// UpdateScrubOptions replaces the scrub settings in the configuration file.
+func (fm *FirewallManager) UpdateScrubOptions(scrub ScrubOptions) error {
+	if err := fm.LoadConfig(); err != nil {
+		return err
+	}
+	fm.Config.Scrub = scrub
+	logging.Info(fmt.Sprintf("Updated scrub options: %+v", scrub))
+	return fm.SaveConfig()
+}
+
+// FindMacro returns the index of the macro with the given name, or -1 if there is none.
+func (fm *FirewallManager) FindMacro(name string) int {
+	name = strings.TrimPrefix(name, "$")
+	for i, macro := range fm.Config.Macros {
+		if macro.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// MacroRefPattern matches a $name macro reference in a rule field.
+var MacroRefPattern = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// ExpandMacros replaces every $name reference in value with the macro's value.
+// Macro values may themselves reference earlier macros. Unknown references are left as is.
+func (fm *FirewallManager) ExpandMacros(value string) string {
+	for depth := 0; depth < 10 && strings.Contains(value, "$"); depth++ {
+		expanded := MacroRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+			if i := fm.FindMacro(ref); i != -1 {
+				return fm.Config.Macros[i].Value
+			}
+			return ref
+		})
+		if expanded == value {
+			break
+		}
+		value = expanded
+	}
+	return value
+}
+
+// expandMacroFields expands macro references in place in each of the given rule fields.
+func (fm *FirewallManager) expandMacroFields(fields ...*string) {
+	for _, field := range fields {
+		*field = fm.ExpandMacros(*field)
+	}
+}
+
+// CheckMacroReferences returns an error if any of the given rule fields references
+// a macro that is not defined in the configuration.
+func (fm *FirewallManager) CheckMacroReferences(fields ...string) error {
+	for _, field := range fields {
+		for _, ref := range MacroRefPattern.FindAllString(field, -1) {
+			if fm.FindMacro(ref) == -1 {
+				return fmt.Errorf("macro %s is not defined", ref)
+			}
+		}
+	}
+	return nil
+}
+
+// SplitList splits a field holding a comma or space separated list (e.g.
+// "10.0.0.1, 10.0.0.2"). A list may already be wrapped in braces (e.g. from a
+// macro value).
+func SplitList(value string) []string {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}")
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// formatList renders list items as a pf set, e.g. "{ a, b, c }".
+func formatList(items []string) string {
+	return fmt.Sprintf("{ %s }", strings.Join(items, ", "))
+}
+
+// FormatHost renders a source or destination for a pf rule. A list of hosts is
+// rendered as a set, and the host is prefixed with "!" when the rule matches
+// everything except that host.
+func FormatHost(host string, negate bool) string {
+	if items := SplitList(host); len(items) > 1 {
+		host = formatList(items)
+	}
+	if negate {
+		return "! " + host
+	}
+	return host
+}
+
+// ParsePortRange parses a port or port range ("6000", "6000-6100" or "6000:6100").
+// ok is false if port is neither.
+func ParsePortRange(port string) (low, high int, ok bool) {
+	bounds := strings.FieldsFunc(port, func(r rune) bool { return r == '-' || r == ':' })
+	if len(bounds) == 1 && !strings.ContainsAny(port, "-:") && validPortNumber(bounds[0]) {
+		low, _ = strconv.Atoi(bounds[0])
+		return low, low, true
+	}
+	if len(bounds) != 2 || !validPortNumber(bounds[0]) || !validPortNumber(bounds[1]) {
+		return 0, 0, false
+	}
+	low, _ = strconv.Atoi(bounds[0])
+	high, _ = strconv.Atoi(bounds[1])
+	return low, high, low <= high
+}
+
+// ValidateRdrPorts checks the external and internal port of a port forwarding
+// rule. The external port may be a range; the internal port is then either a
+// single port all of the range goes to, a range of the same size, or
+// "start:*" to map the range one to one starting at start.
+func ValidateRdrPorts(external, internal string) error {
+	if strings.HasPrefix(external, "$") || strings.HasPrefix(internal, "$") {
+		return nil // checked once the macro is expanded by pfctl
+	}
+	extLow, extHigh, ok := ParsePortRange(external)
+	if !ok && !ServiceNamePattern.MatchString(external) {
+		return fmt.Errorf("invalid external port %q, expected a port or a range like 6000:6100", external)
+	} else if !ok && !KnownService(external) {
+		return fmt.Errorf("unknown service %q, not in %s", external, ServicesFile)
+	}
+	if shift := strings.ReplaceAll(internal, "-", ":"); strings.HasSuffix(shift, ":*") {
+		if !validPortNumber(strings.TrimSuffix(shift, ":*")) {
+			return fmt.Errorf("invalid internal port %q", internal)
+		}
+		return nil
+	}
+	intLow, intHigh, ok := ParsePortRange(internal)
+	if !ok {
+		if !ServiceNamePattern.MatchString(internal) {
+			return fmt.Errorf("invalid internal port %q, expected a port, a range or start:*", internal)
+		} else if !KnownService(internal) {
+			return fmt.Errorf("unknown service %q, not in %s", internal, ServicesFile)
+		}
+		return nil
+	}
+	if intLow != intHigh && intHigh-intLow != extHigh-extLow {
+		return fmt.Errorf("internal port range %q must be the same size as the external range %q", internal, external)
+	}
+	return nil
+}
+
+// formatRdrPorts renders the ports of a port forwarding rule in pf's syntax. A
+// range maps one to one onto an internal range of the same size, written as
+// "start:*" (e.g. "port 6000:6100 -> host port 6000:*").
+func formatRdrPorts(external, internal string) (string, string) {
+	external = strings.ReplaceAll(external, "-", ":")
+	internal = strings.ReplaceAll(internal, "-", ":")
+	if intLow, intHigh, ok := ParsePortRange(internal); ok && intLow != intHigh {
+		internal = fmt.Sprintf("%d:*", intLow)
+	}
+	return external, internal
+}
+
+// FormatRoute renders the route-to/reply-to option of a filter rule, e.g.
+// "route-to (en1 192.168.2.1)", or "" if the rule does not set one.
+func FormatRoute(rule FirewallRule) string {
+	if rule.Route == "" || rule.RouteInterface == "" {
+		return ""
+	}
+	if rule.RouteGateway == "" {
+		return fmt.Sprintf("%s %s", rule.Route, rule.RouteInterface)
+	}
+	return fmt.Sprintf("%s (%s %s)", rule.Route, rule.RouteInterface, rule.RouteGateway)
+}
+
+// formatPort renders a port field for a pf rule. A list ("80,443") or a range
+// ("8000-8080") is wrapped in curly braces, with ranges using pf's colon syntax.
+func formatPort(port string) string {
+	items := SplitList(port)
+	isRange := false
+	for i, item := range items {
+		if strings.Contains(item, "-") || strings.Contains(item, ":") {
+			items[i] = strings.ReplaceAll(item, "-", ":") // Replace hyphen with colon for ranges
+			isRange = true
+		}
+	}
+	if len(items) > 1 || isRange {
+		return formatList(items)
+	}
+	return strings.TrimSpace(port)
+}
+
+// interfaceAddressPattern matches an interface address such as "en0:network"
+// or "en0:peer", or a macro naming the interface. pf also accepts the bare
+// interface name, which HostnamePattern already covers.
+var interfaceAddressPattern = regexp.MustCompile(`^(\$[A-Za-z_][A-Za-z0-9_]*|[A-Za-z][A-Za-z0-9_.]*)(:(network|broadcast|peer|0))*$`)
+
+// HostnamePattern matches a host name in the address fields of rules.
+var HostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+// ServiceNamePattern matches a service name in the port fields of rules, e.g. "https".
+var ServiceNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+var interfaceNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*$`)
+
+// ValidateHostList checks a source or destination field. It accepts "any" or a
+// list of addresses, networks, hostnames, table references (<name>), "self",
+// interface addresses (en0:network, (en0), (en0:network)) and macros.
+func ValidateHostList(value string) error {
+	items := SplitList(value)
+	if len(items) == 0 {
+		return fmt.Errorf("address must not be empty, use \"any\" to match all hosts")
+	}
+	for _, item := range items {
+		switch {
+		case item == "any":
+			if len(items) > 1 {
+				return fmt.Errorf("\"any\" cannot be part of an address list")
+			}
+		case strings.HasPrefix(item, "$"):
+		case strings.HasPrefix(item, "<") && strings.HasSuffix(item, ">"):
+		case item == "self":
+		case strings.HasPrefix(item, "("):
+			// An interface address that pf follows, e.g. "(en0)" or "(en0:network)"
+			inner := strings.TrimSuffix(strings.TrimPrefix(item, "("), ")")
+			if !strings.HasSuffix(item, ")") || !interfaceAddressPattern.MatchString(inner) {
+				return fmt.Errorf("invalid interface address %q, expected e.g. (en0) or (en0:network)", item)
+			}
+		case net.ParseIP(item) != nil:
+		case strings.Contains(item, "/"):
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				return fmt.Errorf("invalid network %q", item)
+			}
+		case HostnamePattern.MatchString(item):
+		case interfaceAddressPattern.MatchString(item):
+		default:
+			return fmt.Errorf("invalid address %q", item)
+		}
+	}
+	return nil
+}
+
+// ValidateInterface checks an interface field. It accepts "any" or a list of
+// interfaces that exist on this host, as listed by SystemInterfaces. If
+// interfaces is nil, only the syntax of the names is checked.
+func ValidateInterface(value string, interfaces []string) error {
+	items := SplitList(value)
+	if len(items) == 0 {
+		return fmt.Errorf("interface must not be empty, use \"any\" to match all interfaces")
+	}
+	for _, item := range items {
+		switch {
+		case item == "any":
+			if len(items) > 1 {
+				return fmt.Errorf("\"any\" cannot be part of an interface list")
+			}
+		case strings.HasPrefix(item, "$"):
+		case !interfaceNamePattern.MatchString(item):
+			return fmt.Errorf("invalid interface %q", item)
+		case interfaces != nil && !containsString(interfaces, item):
+			return fmt.Errorf("interface %s does not exist on this system (%s)", item, strings.Join(interfaces, ", "))
+		}
+	}
+	return nil
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePortList checks a port field. It accepts "any" or a list of port
+// numbers, ranges (8000-8080), service names (ssh) and macros.
+func ValidatePortList(value string) error {
+	items := SplitList(value)
+	if len(items) == 0 {
+		return fmt.Errorf("port must not be empty, use \"any\" to match all ports")
+	}
+	for _, item := range items {
+		switch {
+		case item == "any":
+			if len(items) > 1 {
+				return fmt.Errorf("\"any\" cannot be part of a port list")
+			}
+		case strings.HasPrefix(item, "$"):
+		case validPortNumber(item):
+		case ServiceNamePattern.MatchString(item):
+			if !KnownService(item) {
+				return fmt.Errorf("unknown service %q, not in %s", item, ServicesFile)
+			}
+		case strings.ContainsAny(item, "-:"):
+			bounds := strings.FieldsFunc(item, func(r rune) bool { return r == '-' || r == ':' })
+			if len(bounds) != 2 || !validPortNumber(bounds[0]) || !validPortNumber(bounds[1]) {
+				return fmt.Errorf("invalid port range %q", item)
+			}
+		default:
+			return fmt.Errorf("invalid port %q", item)
+		}
+	}
+	return nil
+}
+
+// CheckRuleField validates a rule field value with its macros expanded, after
+// checking that the macros it references are defined.
+func (fm *FirewallManager) CheckRuleField(value string, validate func(string) error) error {
+	value = strings.TrimSpace(value)
+	if err := fm.CheckMacroReferences(value); err != nil {
+		return err
+	}
+	return validate(fm.ExpandMacros(value))
+}
+
+// CheckHosts validates a source, destination or address field, including
+// that the tables it references exist. A leading "!" is allowed.
+func (fm *FirewallManager) CheckHosts(value string) error {
+	return fm.CheckRuleField(strings.TrimPrefix(strings.TrimSpace(value), "!"), func(value string) error {
+		if err := ValidateHostList(value); err != nil {
+			return err
+		}
+		return fm.CheckTableReferences(value)
+	})
+}
+
+// connRatePattern matches a max-src-conn-rate value such as "15/5".
+var connRatePattern = regexp.MustCompile(`^[0-9]+/[0-9]+$`)
+
+// ValidateFirewallRule checks a firewall rule the way the rule form does
+// before saving it, against the interfaces of this host (see
+// ValidateInterface), and returns it normalized: a leading "!" on an address
+// becomes SourceNot or DestinationNot, and an ICMP type or code "any"
+// becomes "". Its errors are validation errors.
+func (fm *FirewallManager) ValidateFirewallRule(rule FirewallRule, interfaces []string) (FirewallRule, error) {
+	rule, err := fm.validateFirewallRule(rule, interfaces)
+	return rule, WithKind(KindValidation, err)
+}
+
+func (fm *FirewallManager) validateFirewallRule(rule FirewallRule, interfaces []string) (FirewallRule, error) {
+	for _, option := range []struct {
+		name, value string
+		values      []string
+	}{
+		{"action", rule.Action, []string{"block", "pass"}},
+		{"direction", rule.Direction, []string{"in", "out"}},
+		{"log", rule.Log, []string{"", "log", "log (all)"}},
+		{"route", rule.Route, []string{"", "route-to", "reply-to"}},
+		{"protocol", rule.Protocol, []string{"tcp", "udp", "tcp,udp", "icmp", "any"}},
+		{"state", rule.State, []string{"", "no state", "keep state", "modulate state", "synproxy state"}},
+		{"source track", rule.SourceTrack, []string{"", "rule", "global"}},
+		{"overload flush", rule.OverloadFlush, []string{"", "flush", "flush global"}},
+	} {
+		if !containsString(option.values, option.value) {
+			return rule, fmt.Errorf("invalid %s %q, expected one of %s", option.name, option.value, strings.Join(option.values, ", "))
+		}
+	}
+	// "any" of the rule form is stored as no type or code
+	if rule.IcmpType == "any" {
+		rule.IcmpType = ""
+	}
+	if rule.IcmpCode == "any" {
+		rule.IcmpCode = ""
+	}
+	if rule.Protocol == "icmp" {
+		if rule.IcmpType != "" && !containsString(IcmpTypes, rule.IcmpType) {
+			return rule, fmt.Errorf("invalid ICMP type %q, expected one of %s", rule.IcmpType, strings.Join(IcmpTypes, ", "))
+		}
+		if rule.IcmpCode != "" && !containsString(IcmpCodes[rule.IcmpType], rule.IcmpCode) {
+			return rule, fmt.Errorf("invalid ICMP code %q for type %q", rule.IcmpCode, rule.IcmpType)
+		}
+	} else if rule.IcmpType != "" || rule.IcmpCode != "" {
+		return rule, fmt.Errorf("an ICMP type needs protocol icmp")
+	}
+
+	checkInterface := func(value string) error { return ValidateInterface(value, interfaces) }
+	for _, field := range []struct {
+		name, value string
+		check       func(string) error
+	}{
+		{"interface", rule.Interface, func(value string) error { return fm.CheckRuleField(value, checkInterface) }},
+		{"source", rule.Source, fm.CheckHosts},
+		{"destination", rule.Destination, fm.CheckHosts},
+		{"source port", rule.SourcePort, func(value string) error { return fm.CheckRuleField(value, ValidatePortList) }},
+		{"destination port", rule.DestinationPort, func(value string) error { return fm.CheckRuleField(value, ValidatePortList) }},
+	} {
+		if err := field.check(field.value); err != nil {
+			return rule, fmt.Errorf("%s: %w", field.name, err)
+		}
+	}
+
+	if rule.Pipe > 0 && fm.FindPipe(rule.Pipe) == -1 {
+		return rule, fmt.Errorf("pipe %d is not defined", rule.Pipe)
+	}
+	if rule.Probability < 0 || rule.Probability > 100 {
+		return rule, fmt.Errorf("invalid probability %d, expected a percentage from 1 to 100", rule.Probability)
+	}
+	if strings.ContainsAny(rule.Group, "\"\n") {
+		return rule, fmt.Errorf("group name must not contain quotes")
+	}
+	// A leading "!" typed into the address is the same as the negate toggle
+	if strings.HasPrefix(strings.TrimSpace(rule.Source), "!") {
+		rule.Source = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rule.Source), "!"))
+		rule.SourceNot = true
+	}
+	if strings.HasPrefix(strings.TrimSpace(rule.Destination), "!") {
+		rule.Destination = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rule.Destination), "!"))
+		rule.DestinationNot = true
+	}
+	if (rule.SourceNot && (rule.Source == "" || rule.Source == "any")) || (rule.DestinationNot && (rule.Destination == "" || rule.Destination == "any")) {
+		return rule, fmt.Errorf("cannot negate \"any\", enter the address to exclude")
+	}
+	// pf applies "!" to each list item separately, so "! { a, b }" would match everything
+	if (rule.SourceNot && len(SplitList(rule.Source)) > 1) || (rule.DestinationNot && len(SplitList(rule.Destination)) > 1) {
+		return rule, fmt.Errorf("a negated address cannot be a list, use a table instead")
+	}
+	if rule.Route != "" {
+		if rule.RouteInterface == "" || rule.RouteInterface == "any" {
+			return rule, fmt.Errorf("%s needs a route interface", rule.Route)
+		}
+		if err := fm.CheckRuleField(rule.RouteInterface, checkInterface); err != nil {
+			return rule, fmt.Errorf("route interface: %w", err)
+		}
+		if rule.RouteGateway != "" {
+			if err := fm.CheckRuleField(rule.RouteGateway, func(value string) error {
+				if net.ParseIP(value) == nil {
+					return fmt.Errorf("invalid route gateway %q, expected an IP address", value)
+				}
+				return nil
+			}); err != nil {
+				return rule, err
+			}
+		}
+	} else if rule.RouteInterface != "" || rule.RouteGateway != "" {
+		return rule, fmt.Errorf("a route interface needs route-to or reply-to")
+	}
+
+	if rule.StateMax < 0 {
+		return rule, fmt.Errorf("invalid max states %d", rule.StateMax)
+	}
+	if rule.MaxSrcConn < 0 {
+		return rule, fmt.Errorf("invalid max source connections %d", rule.MaxSrcConn)
+	}
+	if rule.MaxSrcConnRate != "" && !connRatePattern.MatchString(rule.MaxSrcConnRate) {
+		return rule, fmt.Errorf("invalid connection rate %q, expected number/seconds (e.g. 15/5)", rule.MaxSrcConnRate)
+	}
+	if rule.State == "no state" && len(StateOptions(rule)) > 0 {
+		return rule, fmt.Errorf("state limits need a state other than \"no state\"")
+	}
+	if rule.OverloadTable != "" {
+		rule.OverloadTable = strings.TrimSuffix(strings.TrimPrefix(rule.OverloadTable, "<"), ">")
+		if rule.MaxSrcConn == 0 && rule.MaxSrcConnRate == "" {
+			return rule, fmt.Errorf("an overload table needs a max source connections or connection rate limit")
+		}
+		if err := fm.CheckTableReferences("<" + rule.OverloadTable + ">"); err != nil {
+			return rule, err
+		}
+	} else if rule.OverloadFlush != "" {
+		return rule, fmt.Errorf("overload flush needs an overload table")
+	}
+	return rule, nil
+}
+
+// validPortNumber reports whether s is a port number between 0 and 65535.
+func validPortNumber(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port >= 0 && port <= 65535
+}
+
+// StateOptions returns the pf state options (e.g. "max 100") configured for a rule.
+func StateOptions(rule FirewallRule) []string {
+	var opts []string
+	if rule.StateMax > 0 {
+		opts = append(opts, fmt.Sprintf("max %d", rule.StateMax))
+	}
+	if rule.SourceTrack != "" {
+		opts = append(opts, "source-track "+rule.SourceTrack)
+	}
+	if rule.MaxSrcConn > 0 {
+		opts = append(opts, fmt.Sprintf("max-src-conn %d", rule.MaxSrcConn))
+	}
+	if rule.MaxSrcConnRate != "" {
+		opts = append(opts, "max-src-conn-rate "+rule.MaxSrcConnRate)
+	}
+	if rule.OverloadTable != "" {
+		overload := fmt.Sprintf("overload <%s>", rule.OverloadTable)
+		if rule.OverloadFlush != "" {
+			overload += " " + rule.OverloadFlush
+		}
+		opts = append(opts, overload)
+	}
+	return opts
+}
+
+// formatScrub renders the scrub rule for the given settings, e.g.
+// "scrub in on en0 all no-df random-id fragment reassemble".
+func formatScrub(scrub ScrubOptions) string {
+	parts := []string{"scrub"}
+	if scrub.Direction != "" {
+		parts = append(parts, scrub.Direction)
+	}
+	if scrub.Interface != "" && scrub.Interface != "any" {
+		parts = append(parts, "on", scrub.Interface)
+	}
+	parts = append(parts, "all")
+	if scrub.NoDF {
+		parts = append(parts, "no-df")
+	}
+	if scrub.RandomID {
+		parts = append(parts, "random-id")
+	}
+	if scrub.Fragment != "" {
+		parts = append(parts, "fragment", scrub.Fragment)
+	}
+	if scrub.ReassembleTCP {
+		parts = append(parts, "reassemble", "tcp")
+	}
+	return strings.Join(parts, " ")
+}
+
+// expandInterfacePatterns expands names ending in "*" (e.g. "utun*") to the
+// matching interfaces of this machine. Other names are kept as they are.
+func expandInterfacePatterns(names []string) []string {
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, "*") {
+			add(name)
+			continue
+		}
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			logging.Error(fmt.Sprintf("Failed to list interfaces for %s: %v", name, err))
+			continue
+		}
+		for _, iface := range ifaces {
+			if strings.HasPrefix(iface.Name, strings.TrimSuffix(name, "*")) {
+				add(iface.Name)
+			}
+		}
+	}
+	return expanded
+}
+
+// GenerateFirewallRule generates the pf lines of a firewall rule as
+// GeneratePfConf emits them, enabled or not: its description as a comment and
+// a filter rule per protocol, and the dummynet rules of its pipe, which
+// GeneratePfConf emits after all filter rules.
+func (fm *FirewallManager) GenerateFirewallRule(rule FirewallRule) (filterRules, dummynetRules []string) {
+	fm.expandMacroFields(&rule.Interface, &rule.RouteInterface, &rule.RouteGateway, &rule.Source, &rule.Destination, &rule.SourcePort, &rule.DestinationPort)
+	if rule.Description != "" {
+		filterRules = append(filterRules, "# "+rule.Description)
+	}
+
+	hasSourcePort := rule.SourcePort != "any" && rule.SourcePort != ""
+	hasDestinationPort := rule.DestinationPort != "any" && rule.DestinationPort != ""
+
+	var protocols []string
+	if rule.Protocol == "any" && (hasSourcePort || hasDestinationPort) {
+		protocols = []string{"tcp", "udp"}
+	} else {
+		protocols = strings.Split(rule.Protocol, ",")
+	}
+
+	for _, proto := range protocols {
+		proto = strings.TrimSpace(proto)
+		var parts []string
+		parts = append(parts, rule.Action)
+		parts = append(parts, rule.Direction)
+		if rule.Log != "" {
+			parts = append(parts, rule.Log)
+		}
+		if rule.Quick {
+			parts = append(parts, "quick")
+		}
+		if rule.Interface != "any" {
+			parts = append(parts, "on", rule.Interface)
+		}
+		if route := FormatRoute(rule); route != "" {
+			parts = append(parts, route)
+		}
+
+		var match []string
+		if proto == "any" && rule.Source == "any" && rule.Destination == "any" && !hasSourcePort && !hasDestinationPort {
+			match = append(match, "all")
+		} else {
+			if proto != "any" {
+				match = append(match, "proto", proto)
+			}
+
+			// Ports only apply to tcp and udp
+			portsApply := proto == "tcp" || proto == "udp"
+			if rule.Source != "any" || rule.Destination != "any" || (portsApply && (hasSourcePort || hasDestinationPort)) {
+				match = append(match, "from", FormatHost(rule.Source, rule.SourceNot))
+				if portsApply && hasSourcePort {
+					match = append(match, "port", formatPort(rule.SourcePort))
+				}
+				match = append(match, "to", FormatHost(rule.Destination, rule.DestinationNot))
+				if portsApply && hasDestinationPort {
+					match = append(match, "port", formatPort(rule.DestinationPort))
+				}
+			}
+
+			// "any" of the rule form is no type, which pfctl rejects
+			if proto == "icmp" && rule.IcmpType != "" && rule.IcmpType != "any" {
+				match = append(match, "icmp-type", rule.IcmpType)
+				if rule.IcmpCode != "" && rule.IcmpCode != "any" {
+					match = append(match, "code", rule.IcmpCode)
+				}
+			}
+		}
+		parts = append(parts, match...)
+
+		if rule.Pipe > 0 {
+			dummynet := []string{"dummynet", rule.Direction}
+			if rule.Interface != "any" {
+				dummynet = append(dummynet, "on", rule.Interface)
+			}
+			dummynet = append(dummynet, match...)
+			dummynet = append(dummynet, "pipe", strconv.Itoa(rule.Pipe))
+			dummynetRules = append(dummynetRules, strings.Join(dummynet, " "))
+		}
+
+		if rule.State != "" {
+			parts = append(parts, rule.State)
+			if opts := StateOptions(rule); rule.State != "no state" && len(opts) > 0 {
+				parts = append(parts, fmt.Sprintf("(%s)", strings.Join(opts, ", ")))
+			}
+		}
+
+		if rule.Probability > 0 && rule.Probability < 100 {
+			parts = append(parts, fmt.Sprintf("probability %d%%", rule.Probability))
+		}
+
+		// The label lets us match pf's per-rule counters back to this rule.
+		if rule.ID != "" {
+			parts = append(parts, "label", fmt.Sprintf("\"%s\"", RuleLabel(rule)))
+		}
+
+		filterRules = append(filterRules, strings.Join(parts, " "))
+	}
+	return filterRules, dummynetRules
+}
+
+// GenerateOptions generates the global "set" options. They only take effect
+// when loaded into the main ruleset, which ApplyOptions does with pfctl -O.
+func (fm *FirewallManager) GenerateOptions() string {
+	var builder strings.Builder
+	skip := make([]string, len(fm.Config.Options.SkipInterfaces))
+	for i, name := range fm.Config.Options.SkipInterfaces {
+		skip[i] = fm.ExpandMacros(name)
+	}
+	if skip = expandInterfacePatterns(skip); len(skip) > 0 {
+		builder.WriteString(fmt.Sprintf("set skip on { %s }\n", strings.Join(skip, " ")))
+	}
+
+	var limits []string
+	if fm.Config.Options.StateLimit > 0 {
+		limits = append(limits, fmt.Sprintf("states %d", fm.Config.Options.StateLimit))
+	}
+	if fm.Config.Options.TableEntriesLimit > 0 {
+		limits = append(limits, fmt.Sprintf("table-entries %d", fm.Config.Options.TableEntriesLimit))
+	}
+	if len(limits) > 0 {
+		builder.WriteString(fmt.Sprintf("set limit { %s }\n", strings.Join(limits, ", ")))
+	}
+	if fm.Config.Options.BlockPolicy != "" {
+		builder.WriteString(fmt.Sprintf("set block-policy %s\n", fm.Config.Options.BlockPolicy))
+	}
+	if fm.Config.Options.Optimization != "" {
+		builder.WriteString(fmt.Sprintf("set optimization %s\n", fm.Config.Options.Optimization))
+	}
+	if len(fm.Config.Options.Timeouts) > 0 {
+		names := make([]string, 0, len(fm.Config.Options.Timeouts))
+		for name := range fm.Config.Options.Timeouts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		timeouts := make([]string, len(names))
+		for i, name := range names {
+			timeouts[i] = fmt.Sprintf("%s %d", name, fm.Config.Options.Timeouts[name])
+		}
+		builder.WriteString(fmt.Sprintf("set timeout { %s }\n", strings.Join(timeouts, ", ")))
+	}
+	return builder.String()
+}
+
+// GeneratePfConf generates the content of the pf.conf file from the current rules.
+func (fm *FirewallManager) GeneratePfConf() string {
+	var builder strings.Builder
+
+	// Macros
+	// Rule fields below are emitted with their macro references already expanded,
+	// so the definitions are mainly there to document the values in the anchor file.
+	for _, macro := range fm.Config.Macros {
+		if macro.Description != "" {
+			builder.WriteString(fmt.Sprintf("# %s\n", macro.Description))
+		}
+		builder.WriteString(fmt.Sprintf("%s = \"%s\"\n", macro.Name, macro.Value))
+	}
+
+	// Options
+	// Options must come before normalization, translation and filter rules.
+	builder.WriteString(fm.GenerateOptions())
+
+	// Tables
+	// Table declarations must come before any rule that references them.
+	for _, table := range fm.Config.Tables {
+		if table.Description != "" {
+			builder.WriteString(fmt.Sprintf("# %s\n", table.Description))
+		}
+
+		tableStr := fmt.Sprintf("table <%s>", table.Name)
+		if table.Persist {
+			tableStr += " persist"
+		}
+		if len(table.Addresses) > 0 {
+			addresses := make([]string, len(table.Addresses))
+			for i, addr := range table.Addresses {
+				addresses[i] = fm.ExpandMacros(addr)
+			}
+			tableStr += fmt.Sprintf(" { %s }", strings.Join(addresses, ", "))
+		}
+		if table.FeedURL != "" {
+			// pfctl fails on a missing file, so the feed is only loaded once it has been downloaded
+			if path, err := FeedPath(table.Name); err == nil {
+				if _, err := os.Stat(path); err == nil {
+					tableStr += fmt.Sprintf(" file \"%s\"", path)
+				}
+			}
+		}
+		builder.WriteString(tableStr + "\n")
+	}
+
+	// Scrub
+	// Normalization must come before translation and filter rules.
+	if fm.Config.Scrub.Enabled {
+		scrub := fm.Config.Scrub
+		scrub.Interface = fm.ExpandMacros(scrub.Interface)
+		builder.WriteString(formatScrub(scrub) + "\n")
+	}
+
+	// NAT Rules
+	// Translation rules must come before filter rules, and pf expects nat before rdr.
+	for _, rule := range fm.Config.NatRules {
+		fm.expandMacroFields(&rule.Interface, &rule.Source, &rule.Destination, &rule.Translation)
+		// A rule with nothing to translate to would fail the whole ruleset
+		if checkNatTarget(rule) != nil {
+			continue
+		}
+		if rule.Description != "" {
+			builder.WriteString(fmt.Sprintf("# %s\n", rule.Description))
+		}
+
+		parts := []string{"nat"}
+		if rule.Interface != "any" && rule.Interface != "" {
+			parts = append(parts, "on", rule.Interface)
+		}
+		if rule.Protocol != "any" && rule.Protocol != "" {
+			parts = append(parts, "proto", rule.Protocol)
+		}
+		source := rule.Source
+		if source == "" {
+			source = "any"
+		}
+		destination := rule.Destination
+		if destination == "" {
+			destination = "any"
+		}
+		parts = append(parts, "from", source, "to", destination)
+
+		// With no explicit translation address, use the address of the outgoing interface.
+		// The parentheses make pf follow the address if it changes (e.g. DHCP).
+		translation := rule.Translation
+		if translation == "" && rule.Interface != "any" && rule.Interface != "" {
+			translation = fmt.Sprintf("(%s)", rule.Interface)
+		}
+		parts = append(parts, "->", translation)
+
+		builder.WriteString(strings.Join(parts, " ") + "\n")
+	}
+
+	// Port Forwarding Rules
+	for _, rule := range fm.Config.PortForwardingRules {
+		if !rule.Enabled {
+			continue
+		}
+		fm.expandMacroFields(&rule.Interface, &rule.ExternalIP, &rule.ExternalPort, &rule.InternalIP, &rule.InternalPort)
+		if rule.Description != "" {
+			builder.WriteString(fmt.Sprintf("# %s\n", rule.Description))
+		}
+
+		rule.ExternalPort, rule.InternalPort = formatRdrPorts(rule.ExternalPort, rule.InternalPort)
+		var rdrStr string
+		if rule.Interface == "any" {
+			rdrStr = fmt.Sprintf("rdr proto %s from any to %s port %s -> %s port %s",
+				rule.Protocol, rule.ExternalIP, rule.ExternalPort, rule.InternalIP, rule.InternalPort)
+		} else {
+			// If ExternalIP is "any", it means the rule applies to any IP on the specified interface.
+			// In pf, "to (interface)" is used for this.
+			toPart := rule.ExternalIP
+			if toPart == "any" {
+				toPart = fmt.Sprintf("(%s)", rule.Interface)
+			}
+			rdrStr = fmt.Sprintf("rdr on %s proto %s from any to %s port %s -> %s port %s",
+				rule.Interface, rule.Protocol, toPart, rule.ExternalPort, rule.InternalIP, rule.InternalPort)
+		}
+		builder.WriteString(rdrStr + "\n")
+	}
+
+	// Firewall Rules
+	// Rules attached to a pipe also get a dummynet rule with the same match,
+	// collected here and emitted before the filter rules.
+	var filter strings.Builder
+	var dummynetRules []string
+	currentGroup := ""
+	for _, rule := range fm.Config.FirewallRules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.Group != currentGroup {
+			currentGroup = rule.Group
+			// Ungrouped rules get no header, normalizeRuleGroups puts them first
+			if currentGroup != "" {
+				filter.WriteString(fmt.Sprintf("\n# --- %s ---\n", currentGroup))
+			}
+		}
+		filterRules, ruleDummynetRules := fm.GenerateFirewallRule(rule)
+		for _, line := range filterRules {
+			filter.WriteString(line + "\n")
+		}
+		dummynetRules = append(dummynetRules, ruleDummynetRules...)
+	}
+
+	// Dummynet Rules
+	// Loaded through the dummynet-anchor; the pipes themselves are configured with dnctl.
+	// pf requires them before the filter rules, see pfConfSections.
+	if len(dummynetRules) > 0 {
+		builder.WriteString("\n# --- Traffic shaping ---\n")
+		for _, rule := range dummynetRules {
+			builder.WriteString(rule + "\n")
+		}
+	}
+
+	builder.WriteString(filter.String())
+	return builder.String()
+}
+
+// EnsureConfigDirExists creates the ConfigDir if it does not exist.
+func EnsureConfigDirExists() error {
+	path, err := GetConfigPath()
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+// IcmpTypes lists the ICMP types offered in the rule form.
+var IcmpTypes = []string{"any", "echoreq", "echorep", "unreach", "squench", "redir", "timex", "paramprob", "timereq", "timerep"}
+
+// IcmpCodes lists the ICMP codes offered for the ICMP types that have them.
+var IcmpCodes = map[string][]string{
+	"unreach":   {"any", "net-unr", "host-unr", "proto-unr", "port-unr", "needfrag", "srcfail", "net-unk", "host-unk", "isolate", "net-prohib", "host-prohib", "net-tos", "host-tos", "filter-prohib"},
+	"redir":     {"any", "redir-net", "redir-host", "redir-tos-net", "redir-tos-host"},
+	"timex":     {"any", "transit", "reassemb"},
+	"paramprob": {"any", "badhead", "optmiss"},
+}
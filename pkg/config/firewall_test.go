@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useConfigDir makes the tests of the package use an empty configuration
+// directory.
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	SetConfigDir(dir)
+	t.Cleanup(func() { SetConfigDir("") })
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	ruleA := FirewallRule{ID: "a", Enabled: true, Action: "pass", Direction: "in", Interface: "any", Protocol: "tcp",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "443", Description: "a"}
+	ruleB := FirewallRule{ID: "b", Enabled: true, Action: "block", Direction: "in", Interface: "any", Protocol: "tcp",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "22", Description: "b"}
+	loggedB := ruleB
+	loggedB.Log = "log"
+	loggedB.Group = "g"
+	const fileA = `{"id": "a", "enabled": true, "action": "pass", "direction": "in", "interface": "any", "protocol": "tcp",
+		"source": "any", "destination": "any", "source_port": "any", "destination_port": "443", "description": "a"}`
+	const fileB = `{"id": "b", "enabled": true, "action": "block", "direction": "in", "interface": "any", "protocol": "tcp",
+		"source": "any", "destination": "any", "source_port": "any", "destination_port": "22", "description": "b"}`
+
+	tests := []struct {
+		name    string
+		current Config // the configuration in memory before the reload
+		file    string
+		want    func(cfg *Config)
+	}{
+		{
+			name: "reordered rules",
+			current: Config{
+				FirewallRules: []FirewallRule{loggedB, ruleA},
+				RuleGroups:    []RuleGroup{{Name: "g"}},
+			},
+			file: `{"schema_version": 1, "filter_rules": [` + fileA + `, ` + fileB + `]}`,
+			want: func(cfg *Config) {
+				cfg.FirewallRules = []FirewallRule{ruleA, ruleB}
+			},
+		},
+		{
+			name: "field removed on disk",
+			current: Config{
+				FirewallRules: []FirewallRule{loggedB},
+				RuleGroups:    []RuleGroup{{Name: "g"}},
+			},
+			file: `{"schema_version": 1, "filter_rules": [` + fileB + `]}`,
+			want: func(cfg *Config) {
+				cfg.FirewallRules = []FirewallRule{ruleB}
+			},
+		},
+		{
+			name: "key removed on disk",
+			current: Config{
+				Macros:        []Macro{{Name: "web", Value: "{ 80 443 }"}},
+				FirewallRules: []FirewallRule{ruleA},
+			},
+			file: `{"schema_version": 1, "filter_rules": [` + fileA + `]}`,
+			want: func(cfg *Config) {
+				cfg.FirewallRules = []FirewallRule{ruleA}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useConfigDir(t)
+			if err := os.WriteFile(filepath.Join(dir, "rules.json"), []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			current := tt.current
+			fm := &FirewallManager{Config: &current}
+			if err := fm.LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			want := emptyConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(fm.Config, want) {
+				t.Errorf("config = %+v, want %+v", fm.Config, want)
+			}
+		})
+	}
+}
+
+func TestGeneratePfConf(t *testing.T) {
+	ssh := FirewallRule{Enabled: true, Action: "pass", Direction: "in", Quick: true, Interface: "en0", Protocol: "tcp",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "22", State: "keep state", Description: "ssh"}
+	icmp := FirewallRule{Enabled: true, Action: "pass", Direction: "in", Interface: "any", Protocol: "icmp",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "any"}
+	tests := []struct {
+		name    string
+		config  func(cfg *Config)
+		want    []string // lines of the output, in this order
+		notWant []string // lines that must not be in the output
+	}{
+		{
+			name: "section order",
+			config: func(cfg *Config) {
+				cfg.Macros = []Macro{{Name: "lan", Value: "192.168.1.0/24"}}
+				cfg.Options.BlockPolicy = "drop"
+				cfg.Tables = []PfTable{{Name: "blocklist", Persist: true, Addresses: []string{"192.0.2.1"}}}
+				cfg.Scrub = ScrubOptions{Enabled: true, Interface: "en0"}
+				cfg.NatRules = []NatRule{{Interface: "en0", Protocol: "any", Source: "$lan", Destination: "any"}}
+				cfg.PortForwardingRules = []PortForwardingRule{{Enabled: true, Interface: "en0", Protocol: "tcp",
+					ExternalIP: "any", ExternalPort: "8080", InternalIP: "192.168.1.10", InternalPort: "80"}}
+				cfg.Pipes = []DummynetPipe{{Number: 1, Bandwidth: "1Mbit/s"}}
+				piped := ssh
+				piped.Pipe = 1
+				cfg.FirewallRules = []FirewallRule{piped}
+			},
+			want: []string{
+				`lan = "192.168.1.0/24"`,
+				"set block-policy drop",
+				"table <blocklist> persist { 192.0.2.1 }",
+				"scrub on en0 all",
+				"nat on en0 from 192.168.1.0/24 to any -> (en0)",
+				"rdr on en0 proto tcp from any to (en0) port 8080 -> 192.168.1.10 port 80",
+				"dummynet in on en0 proto tcp from any to any port 22 pipe 1",
+				"# ssh",
+				"pass in quick on en0 proto tcp from any to any port 22 keep state",
+			},
+		},
+		{
+			name: "disabled rules",
+			config: func(cfg *Config) {
+				disabled := ssh
+				disabled.Enabled = false
+				cfg.FirewallRules = []FirewallRule{disabled}
+				cfg.PortForwardingRules = []PortForwardingRule{{Interface: "en0", Protocol: "tcp",
+					ExternalIP: "any", ExternalPort: "8080", InternalIP: "192.168.1.10", InternalPort: "80"}}
+			},
+			notWant: []string{
+				"pass in quick on en0 proto tcp from any to any port 22 keep state",
+				"rdr on en0 proto tcp from any to (en0) port 8080 -> 192.168.1.10 port 80",
+			},
+		},
+		{
+			name: "icmp type",
+			config: func(cfg *Config) {
+				echo := icmp
+				echo.IcmpType = "unreach"
+				echo.IcmpCode = "port-unr"
+				cfg.FirewallRules = []FirewallRule{echo}
+			},
+			want: []string{"pass in proto icmp icmp-type unreach code port-unr"},
+		},
+		{
+			name: "icmp type any",
+			config: func(cfg *Config) {
+				anyType := icmp
+				anyType.IcmpType = "any"
+				anyType.IcmpCode = "any"
+				cfg.FirewallRules = []FirewallRule{anyType}
+			},
+			want:    []string{"pass in proto icmp"},
+			notWant: []string{"pass in proto icmp icmp-type any"},
+		},
+		{
+			name: "nat without a target",
+			config: func(cfg *Config) {
+				cfg.NatRules = []NatRule{
+					{Interface: "any", Protocol: "any", Source: "10.0.0.0/8", Destination: "any", Description: "broken"},
+					{Interface: "any", Protocol: "any", Source: "10.0.0.0/8", Destination: "any", Translation: "192.0.2.1"},
+				}
+			},
+			want:    []string{"nat from 10.0.0.0/8 to any -> 192.0.2.1"},
+			notWant: []string{"# broken", "nat from 10.0.0.0/8 to any -> "},
+		},
+		{
+			name: "groups",
+			config: func(cfg *Config) {
+				lan := icmp
+				lan.Group = "LAN"
+				cfg.FirewallRules = []FirewallRule{ssh, lan, ssh}
+			},
+			want: []string{
+				"pass in quick on en0 proto tcp from any to any port 22 keep state",
+				"# --- LAN ---",
+				"pass in proto icmp",
+				"pass in quick on en0 proto tcp from any to any port 22 keep state",
+			},
+			notWant: []string{"# ---  ---"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := &FirewallManager{Config: emptyConfig()}
+			tt.config(fm.Config)
+			out := fm.GeneratePfConf()
+			lines := strings.Split(out, "\n")
+			next := 0
+			for _, want := range tt.want {
+				for next < len(lines) && lines[next] != want {
+					next++
+				}
+				if next == len(lines) {
+					t.Fatalf("missing %q, or out of order, in:\n%s", want, out)
+				}
+				next++
+			}
+			for _, line := range lines {
+				for _, notWant := range tt.notWant {
+					if line == notWant {
+						t.Errorf("unexpected %q in:\n%s", notWant, out)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+const (
+	// historyDirName is the directory in the config directory with the
+	// snapshots of successful applies.
+	historyDirName = "history"
+	// maxSnapshots is the number of snapshots kept; older ones are removed.
+	maxSnapshots = 50
+)
+
+// Snapshot is the configuration and the generated rules of a successful
+// Save & Apply.
+type Snapshot struct {
+	Time   time.Time       `json:"time"`
+	Config json.RawMessage `json:"config"`
+	PfConf string          `json:"pf_conf"`
+}
+
+// NewSnapshot returns a snapshot of the current configuration and the rules
+// pfConf generated from it.
+func (fm *FirewallManager) NewSnapshot(pfConf string) (Snapshot, error) {
+	config, err := json.MarshalIndent(fm.Config, "", "  ")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Time: time.Now(), Config: config, PfConf: pfConf}, nil
+}
+
+// historyDir returns the directory with the snapshots.
+func historyDir() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, historyDirName), nil
+}
+
+// RecordSnapshot saves a snapshot to the history and removes the oldest
+// snapshots beyond maxSnapshots.
+func RecordSnapshot(snapshot Snapshot) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, snapshot.Time.Format(backupTimeFormat)+".json")
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	logging.Info(fmt.Sprintf("Recorded snapshot %s", path))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	// The names are timestamps, so they sort oldest first
+	sort.Strings(names)
+	for len(names) > maxSnapshots {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			logging.Warn(fmt.Sprintf("Failed to remove old snapshot %s: %v", names[0], err))
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots of the history, newest first. Files
+// that cannot be read are skipped.
+func ListSnapshots() ([]Snapshot, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logging.Warn(fmt.Sprintf("Failed to read snapshot %s: %v", path, err))
+			continue
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			logging.Warn(fmt.Sprintf("Failed to parse snapshot %s: %v", path, err))
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// ConfigText returns the configuration of a snapshot formatted like the
+// config file, which it is nested in when saved.
+func (s Snapshot) ConfigText() string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, s.Config, "", "  "); err != nil {
+		return string(s.Config)
+	}
+	return b.String()
+}
+
+// RestoreSnapshot replaces the configuration with the one of a snapshot. The
+// current config file is backed up first. The rules are not applied.
+func (fm *FirewallManager) RestoreSnapshot(snapshot Snapshot) error {
+	var config Config
+	if _, _, err := UnmarshalConfig(snapshot.Config, FormatJSON, &config); err != nil {
+		return fmt.Errorf("invalid configuration in snapshot: %w", err)
+	}
+	logging.Info(fmt.Sprintf("Restoring the configuration of the snapshot of %s", snapshot.Time.Format(time.RFC3339)))
+	return fm.replaceConfigFile([]byte(snapshot.ConfigText()), FormatJSON)
+}
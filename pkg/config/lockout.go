@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SSHSession is the SSH connection pf-tui runs in.
+type SSHSession struct {
+	ClientAddr string
+	ClientPort int    // 0 if unknown
+	ServerAddr string // address of this host the client connected to, "" if unknown
+	ServerPort int
+}
+
+// CurrentSSHSession returns the SSH session pf-tui runs in, from
+// SSH_CONNECTION ("client port server port"), or from "who -m" if the
+// variable is not set, e.g. after sudo or su. It returns nil if pf-tui does
+// not run over SSH.
+func CurrentSSHSession() *SSHSession {
+	if fields := strings.Fields(os.Getenv("SSH_CONNECTION")); len(fields) == 4 {
+		clientPort, _ := strconv.Atoi(fields[1])
+		serverPort, err := strconv.Atoi(fields[3])
+		if err == nil && net.ParseIP(fields[0]) != nil {
+			return &SSHSession{ClientAddr: fields[0], ClientPort: clientPort, ServerAddr: fields[2], ServerPort: serverPort}
+		}
+	}
+	// e.g. "alice    ttys001  Oct 16 10:02 (192.0.2.10)"
+	out, err := exec.Command("who", "-m").Output()
+	if err != nil {
+		return nil
+	}
+	line := strings.TrimSpace(string(out))
+	start, end := strings.LastIndex(line, "("), strings.LastIndex(line, ")")
+	if start == -1 || end < start {
+		return nil
+	}
+	host := line[start+1 : end]
+	if net.ParseIP(host) == nil {
+		return nil // a local login, or a host name
+	}
+	return &SSHSession{ClientAddr: host, ServerPort: 22}
+}
+
+// LockoutWarnings returns a warning for each way the enabled filter rules
+// would lock the user out: blocking new connections of the SSH session
+// pf-tui runs in (if session is not nil), and blocking inbound SSH to port 22
+// from any host. Rules are evaluated like pf does, the last matching rule
+// winning unless a quick rule matches first. Where a rule cannot be matched
+// from the configuration alone (host names, interface addresses), a block
+// rule is taken to match and a pass rule not, so that doubtful cases warn.
+// The interface of rules is not checked.
+func (fm *FirewallManager) LockoutWarnings(session *SSHSession) []string {
+	var warnings []string
+	blocked := -1
+	if session != nil {
+		if i := fm.inboundVerdict(session.ClientAddr, session.ClientPort, session.ServerAddr, session.ServerPort); i != -1 && fm.Config.FirewallRules[i].Action == "block" {
+			blocked = i
+			warnings = append(warnings, fmt.Sprintf("%s blocks new SSH connections from your address %s to port %d.",
+				fm.describeRule(i), session.ClientAddr, session.ServerPort))
+		}
+	}
+	if i := fm.inboundVerdict("", 0, "", 22); i != -1 && i != blocked && fm.Config.FirewallRules[i].Action == "block" {
+		warnings = append(warnings, fmt.Sprintf("%s blocks inbound SSH (port 22) from arbitrary hosts.", fm.describeRule(i)))
+	}
+	return warnings
+}
+
+// describeRule names the firewall rule at index for a warning.
+func (fm *FirewallManager) describeRule(index int) string {
+	rule := fm.Config.FirewallRules[index]
+	if rule.Description != "" {
+		return fmt.Sprintf("Rule %d (%s)", index+1, rule.Description)
+	}
+	return fmt.Sprintf("Rule %d", index+1)
+}
+
+// inboundVerdict returns the index of the enabled filter rule that decides on
+// a new inbound TCP connection, or -1 if no rule matches it. An empty source
+// stands for any host and matches only rules from "any"; an empty destination
+// is an unknown address of this host.
+func (fm *FirewallManager) inboundVerdict(src string, srcPort int, dst string, dstPort int) int {
+	verdict := -1
+	for i, rule := range fm.Config.FirewallRules {
+		if !rule.Enabled || rule.Direction != "in" || !matchesTCP(rule.Protocol) {
+			continue
+		}
+		fm.expandMacroFields(&rule.Source, &rule.Destination, &rule.SourcePort, &rule.DestinationPort)
+		doubtful := rule.Action == "block" // what an item that cannot be decided counts as
+		if src == "" {
+			if (rule.Source != "any") != rule.SourceNot {
+				continue
+			}
+		} else if !fm.matchesHost(rule.Source, rule.SourceNot, src, false, doubtful) {
+			continue
+		}
+		if !fm.matchesHost(rule.Destination, rule.DestinationNot, dst, true, doubtful) {
+			continue
+		}
+		if !matchesPort(rule.SourcePort, srcPort, doubtful) || !matchesPort(rule.DestinationPort, dstPort, doubtful) {
+			continue
+		}
+		verdict = i
+		if rule.Quick {
+			break
+		}
+	}
+	return verdict
+}
+
+// matchesTCP reports whether a rule's protocol field includes TCP.
+func matchesTCP(protocol string) bool {
+	for _, proto := range strings.Split(protocol, ",") {
+		if proto = strings.TrimSpace(proto); proto == "tcp" || proto == "any" || proto == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHost reports whether addr matches a source or destination field.
+// Tables match by the addresses listed in the configuration. Items naming
+// this host ("self", interfaces) match a destination; other items that
+// cannot be decided, and an empty addr, count as doubtful, also when the
+// field is negated.
+func (fm *FirewallManager) matchesHost(field string, negate bool, addr string, isDestination, doubtful bool) bool {
+	ip := net.ParseIP(addr)
+	match, undecided := field == "", false
+	for _, item := range SplitList(field) {
+		if fm.matchesHostItem(item, ip, isDestination, false) {
+			match = true
+			break
+		}
+		// An item that only matches when doubtful ones do cannot be decided
+		if fm.matchesHostItem(item, ip, isDestination, true) {
+			undecided = true
+		}
+	}
+	if !match && undecided {
+		return doubtful
+	}
+	return match != negate
+}
+
+// matchesHostItem reports whether ip matches one item of a host field.
+func (fm *FirewallManager) matchesHostItem(item string, ip net.IP, isDestination, doubtful bool) bool {
+	switch {
+	case item == "any":
+		return true
+	case strings.HasPrefix(item, "<") && strings.HasSuffix(item, ">"):
+		i := fm.FindTable(strings.Trim(item, "<>"))
+		if i == -1 || ip == nil {
+			return false
+		}
+		for _, entry := range fm.Config.Tables[i].Addresses {
+			if fm.matchesHostItem(entry, ip, isDestination, false) {
+				return true
+			}
+		}
+		return false
+	case net.ParseIP(item) != nil:
+		if ip == nil {
+			return doubtful
+		}
+		return net.ParseIP(item).Equal(ip)
+	case strings.Contains(item, "/"):
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return false
+		}
+		if ip == nil {
+			return doubtful
+		}
+		return network.Contains(ip)
+	case isDestination && (item == "self" || strings.HasPrefix(item, "(") || interfaceAddressPattern.MatchString(item)):
+		return true // an address of this host
+	}
+	return doubtful
+}
+
+// matchesPort reports whether port matches a port field. A port of 0 is
+// unknown and counts as doubtful, as do service names that cannot be looked up.
+func matchesPort(field string, port int, doubtful bool) bool {
+	items := SplitList(field)
+	if len(items) == 0 || (len(items) == 1 && items[0] == "any") {
+		return true
+	}
+	if port == 0 {
+		return doubtful
+	}
+	for _, item := range items {
+		if low, high, ok := ParsePortRange(item); ok {
+			if port >= low && port <= high {
+				return true
+			}
+			continue
+		}
+		if number, err := net.LookupPort("tcp", item); err == nil {
+			if number == port {
+				return true
+			}
+			continue
+		}
+		if doubtful {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestInboundVerdict(t *testing.T) {
+	block := func(source string, not bool) FirewallRule {
+		return FirewallRule{Enabled: true, Action: "block", Direction: "in", Quick: true, Interface: "any", Protocol: "tcp",
+			Source: source, SourceNot: not, Destination: "any", SourcePort: "any", DestinationPort: "22"}
+	}
+	tests := []struct {
+		name  string
+		rules []FirewallRule
+		src   string
+		want  int
+	}{
+		{name: "any", rules: []FirewallRule{block("any", false)}, src: "192.0.2.1", want: 0},
+		{name: "other address", rules: []FirewallRule{block("198.51.100.1", false)}, src: "192.0.2.1", want: -1},
+		{name: "negated other address", rules: []FirewallRule{block("198.51.100.1", true)}, src: "192.0.2.1", want: 0},
+		{name: "negated own address", rules: []FirewallRule{block("192.0.2.1", true)}, src: "192.0.2.1", want: -1},
+		{name: "hostname", rules: []FirewallRule{block("example.com", false)}, src: "192.0.2.1", want: 0},
+		{name: "negated hostname", rules: []FirewallRule{block("example.com", true)}, src: "192.0.2.1", want: 0},
+		{name: "negated own address or hostname", rules: []FirewallRule{block("{ 192.0.2.1 example.com }", true)}, src: "192.0.2.1", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := &FirewallManager{Config: emptyConfig()}
+			fm.Config.FirewallRules = tt.rules
+			if got := fm.inboundVerdict(tt.src, 50000, "192.0.2.10", 22); got != tt.want {
+				t.Errorf("verdict = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
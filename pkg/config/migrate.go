@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigSchemaVersion is the schema version of the configuration this version
+// of pf-tui writes. When a field is renamed or changes meaning, bump it and add
+// the migration from the previous version to configMigrations.
+const ConfigSchemaVersion = 1
+
+// configMigrations upgrade a configuration, decoded as generic JSON, by one
+// schema version each: configMigrations[0] from version 0 to 1, and so on.
+var configMigrations = []func(config map[string]interface{}){
+	migrateUnversioned,
+}
+
+// ErrNewerSchema is returned, wrapped, for a configuration written by a newer
+// pf-tui, which this one cannot load without losing data.
+var ErrNewerSchema = errors.New("configuration has a newer schema version")
+
+// configVersion returns the schema version of a configuration; files saved
+// before the version was recorded are version 0.
+func configVersion(config map[string]interface{}) int {
+	version, _ := config["schema_version"].(float64)
+	return int(version)
+}
+
+// migrateConfig upgrades a configuration to ConfigSchemaVersion in place and
+// returns the version it had.
+func migrateConfig(config map[string]interface{}) (int, error) {
+	version := configVersion(config)
+	if version > ConfigSchemaVersion {
+		return version, fmt.Errorf("%w: %d, this pf-tui supports up to %d; update pf-tui to load it",
+			ErrNewerSchema, version, ConfigSchemaVersion)
+	}
+	for v := version; v < ConfigSchemaVersion; v++ {
+		configMigrations[v](config)
+	}
+	config["schema_version"] = float64(ConfigSchemaVersion)
+	return version, nil
+}
+
+// configItems returns the objects in the list at key.
+func configItems(config map[string]interface{}, key string) []map[string]interface{} {
+	list, _ := config[key].([]interface{})
+	var items []map[string]interface{}
+	for _, item := range list {
+		if object, ok := item.(map[string]interface{}); ok {
+			items = append(items, object)
+		}
+	}
+	return items
+}
+
+// migrateUnversioned upgrades configurations saved before schema_version
+// existed: rules saved before the enabled field are enabled, the keep_state
+// flag became the state mode, and the single port field the destination port.
+func migrateUnversioned(config map[string]interface{}) {
+	for _, rule := range configItems(config, "filter_rules") {
+		if _, ok := rule["enabled"]; !ok {
+			rule["enabled"] = true
+		}
+		if keepState, _ := rule["keep_state"].(bool); keepState {
+			if state, _ := rule["state"].(string); state == "" {
+				rule["state"] = "keep state"
+			}
+		}
+		delete(rule, "keep_state")
+		if port, _ := rule["port"].(string); port != "" {
+			if destinationPort, _ := rule["destination_port"].(string); destinationPort == "" {
+				rule["destination_port"] = port
+			}
+		}
+		delete(rule, "port")
+	}
+	for _, rule := range configItems(config, "rdr_rules") {
+		if _, ok := rule["enabled"]; !ok {
+			rule["enabled"] = true
+		}
+	}
+}
+
+// unknownConfigFields returns the paths of the fields in a configuration,
+// decoded as generic JSON, that the Config struct has no place for, e.g.
+// "filter_rules[2].comment". They would be dropped by the next save.
+func unknownConfigFields(config map[string]interface{}) []string {
+	unknown := unknownFields(config, reflect.TypeOf(Config{}), "")
+	sort.Strings(unknown)
+	return unknown
+}
+
+// unknownFields compares a generic JSON value with the type it is decoded into.
+func unknownFields(value interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() == reflect.Map {
+			for key, item := range v {
+				unknown = append(unknown, unknownFields(item, t.Elem(), joinFieldPath(path, key))...)
+			}
+			return unknown
+		}
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field.Type
+		}
+		for key, item := range v {
+			fieldType, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, joinFieldPath(path, key))
+				continue
+			}
+			unknown = append(unknown, unknownFields(item, fieldType, joinFieldPath(path, key))...)
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, item := range v {
+				unknown = append(unknown, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return unknown
+}
+
+// joinFieldPath appends a field name to the path of its parent.
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalConfigMigration(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		wantRules   []FirewallRule
+		wantRdr     []PortForwardingRule
+		wantUnknown []string
+		wantErr     error
+	}{
+		{
+			name:        "unversioned rule without enabled",
+			data:        `{"filter_rules": [{"action": "pass", "direction": "in"}]}`,
+			wantVersion: 0,
+			wantRules:   []FirewallRule{{Enabled: true, Action: "pass", Direction: "in"}},
+		},
+		{
+			name:        "unversioned keep_state and port",
+			data:        `{"filter_rules": [{"enabled": false, "action": "pass", "keep_state": true, "port": "443"}]}`,
+			wantVersion: 0,
+			wantRules:   []FirewallRule{{Action: "pass", State: "keep state", DestinationPort: "443"}},
+		},
+		{
+			name:        "unversioned state and destination port kept",
+			data:        `{"filter_rules": [{"action": "pass", "keep_state": true, "state": "modulate state", "port": "80", "destination_port": "443"}]}`,
+			wantVersion: 0,
+			wantRules:   []FirewallRule{{Enabled: true, Action: "pass", State: "modulate state", DestinationPort: "443"}},
+		},
+		{
+			name:        "unversioned rdr rule",
+			data:        `{"rdr_rules": [{"interface": "en0", "protocol": "tcp"}]}`,
+			wantVersion: 0,
+			wantRdr:     []PortForwardingRule{{Enabled: true, Interface: "en0", Protocol: "tcp"}},
+		},
+		{
+			name:        "current version not migrated",
+			data:        `{"schema_version": 1, "filter_rules": [{"action": "pass"}]}`,
+			wantVersion: 1,
+			wantRules:   []FirewallRule{{Action: "pass"}},
+		},
+		{
+			name:        "unknown fields",
+			data:        `{"schema_version": 1, "filter_rules": [{"action": "pass", "comment": "x"}], "extra": 1}`,
+			wantVersion: 1,
+			wantRules:   []FirewallRule{{Action: "pass"}},
+			wantUnknown: []string{"extra", "filter_rules[0].comment"},
+		},
+		{
+			name:        "newer version",
+			data:        `{"schema_version": 99}`,
+			wantVersion: 99,
+			wantErr:     ErrNewerSchema,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			version, unknown, err := UnmarshalConfig([]byte(tt.data), FormatJSON, &cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", version, tt.wantVersion)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.SchemaVersion != ConfigSchemaVersion {
+				t.Errorf("schema version = %d, want %d", cfg.SchemaVersion, ConfigSchemaVersion)
+			}
+			if !reflect.DeepEqual(cfg.FirewallRules, tt.wantRules) {
+				t.Errorf("filter rules = %+v, want %+v", cfg.FirewallRules, tt.wantRules)
+			}
+			if !reflect.DeepEqual(cfg.PortForwardingRules, tt.wantRdr) {
+				t.Errorf("rdr rules = %+v, want %+v", cfg.PortForwardingRules, tt.wantRdr)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %q, want %q", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
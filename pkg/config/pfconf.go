@@ -0,0 +1,948 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// PfConfImport is the configuration ParsePfConf recognized in a pf.conf, and
+// the statements it imported or skipped.
+type PfConfImport struct {
+	Macros              []Macro
+	Tables              []PfTable
+	NatRules            []NatRule
+	PortForwardingRules []PortForwardingRule
+	FirewallRules       []FirewallRule
+
+	Recognized []PfConfStatement
+	Skipped    []PfConfStatement
+}
+
+// PfConfStatement is a statement of a parsed pf.conf.
+type PfConfStatement struct {
+	Line int    // line the statement starts on
+	Text string // the statement, with continuation lines joined
+	Note string // why it was skipped, or the options dropped when importing it
+}
+
+// macroPattern matches a macro definition, e.g. ext_if = "en0".
+var macroPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// groupHeaderPattern matches the comment GeneratePfConf starts a rule group
+// with. Older versions wrote an empty one for ungrouped rules.
+var groupHeaderPattern = regexp.MustCompile(`^--- (.*) ---$`)
+
+// ParsePfConf converts the macros, tables, nat, rdr and filter rules of a
+// pf.conf or anchor file into configuration entries. A comment right above a
+// statement becomes its description. Statements pf-tui cannot represent are
+// skipped with the reason, and options it does not support are dropped from
+// the rules they appear in.
+func ParsePfConf(content string) PfConfImport {
+	var imp PfConfImport
+	description, group := "", ""
+	lines := strings.Split(content, "\n")
+	for n := 0; n < len(lines); n++ {
+		number := n + 1
+		line := lines[n]
+		for strings.HasSuffix(strings.TrimRight(line, " \t"), `\`) && n+1 < len(lines) {
+			n++
+			line = strings.TrimSuffix(strings.TrimRight(line, " \t"), `\`) + " " + lines[n]
+		}
+
+		text, comment := splitPfConfComment(line)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			if match := groupHeaderPattern.FindStringSubmatch(comment); match != nil {
+				group = strings.TrimSpace(match[1])
+				description = ""
+			} else {
+				// A blank line ends the comment a description is taken from
+				description = comment
+			}
+			continue
+		}
+
+		imp.addStatement(number, text, description, group)
+		description = ""
+	}
+	imp.mergeProtocols()
+	return imp
+}
+
+// addStatement imports the statement on a line and records it as recognized
+// or skipped.
+func (imp *PfConfImport) addStatement(line int, text, description, group string) {
+	statement := PfConfStatement{Line: line, Text: strings.Join(strings.Fields(text), " ")}
+	dropped, err := imp.add(text, description, group)
+	if err != nil {
+		statement.Note = err.Error()
+		imp.Skipped = append(imp.Skipped, statement)
+		return
+	}
+	if len(dropped) > 0 {
+		statement.Note = "without " + strings.Join(dropped, " ")
+	}
+	imp.Recognized = append(imp.Recognized, statement)
+}
+
+// ImportLiveRules converts the filter and translation rules loaded in pf's
+// main ruleset, as pfctl -s rules and -s nat show them, for MergeImport.
+// Rules the configuration has already, such as pf-tui's own, are skipped.
+func (fm *FirewallManager) ImportLiveRules(runner pfcli.CommandRunner) (PfConfImport, error) {
+	var imp PfConfImport
+	nat, err := pfcli.RunSudoCmd(runner, "pfctl", "-s", "nat")
+	if err != nil {
+		return imp, fmt.Errorf("failed to show the NAT rules: %w, output: %s", err, nat)
+	}
+	rules, err := pfcli.RunSudoCmd(runner, "pfctl", "-s", "rules")
+	if err != nil {
+		return imp, fmt.Errorf("failed to show the rules: %w, output: %s", err, rules)
+	}
+
+	for i, line := range pfcli.PfctlRuleLines(nat + "\n" + rules) {
+		var one PfConfImport
+		if keyword := strings.Fields(line)[0]; keyword == "pass" || keyword == "block" {
+			one.FirewallRules, _ = ParseLiveRules(line)
+			one.Recognized = []PfConfStatement{{Line: i + 1, Text: line}}
+		} else {
+			one.addStatement(i+1, line, "", "")
+		}
+		if fm.hasImported(one) {
+			imp.Skipped = append(imp.Skipped, PfConfStatement{Line: i + 1, Text: line, Note: "already in the configuration"})
+			continue
+		}
+		imp.Macros = append(imp.Macros, one.Macros...)
+		imp.Tables = append(imp.Tables, one.Tables...)
+		imp.NatRules = append(imp.NatRules, one.NatRules...)
+		imp.PortForwardingRules = append(imp.PortForwardingRules, one.PortForwardingRules...)
+		imp.FirewallRules = append(imp.FirewallRules, one.FirewallRules...)
+		imp.Recognized = append(imp.Recognized, one.Recognized...)
+		imp.Skipped = append(imp.Skipped, one.Skipped...)
+	}
+	imp.mergeProtocols()
+	logging.Info(fmt.Sprintf("Read the live rules: %d recognized, %d skipped", len(imp.Recognized), len(imp.Skipped)))
+	return imp, nil
+}
+
+// hasImported reports whether the configuration has the rules of imp
+// already: filter rules by their label, translation rules by their fields.
+func (fm *FirewallManager) hasImported(imp PfConfImport) bool {
+	for _, rule := range imp.FirewallRules {
+		for _, existing := range fm.Config.FirewallRules {
+			if rule.ID != "" && existing.ID == rule.ID {
+				return true
+			}
+		}
+	}
+	for _, rule := range imp.NatRules {
+		for _, existing := range fm.Config.NatRules {
+			existing = existing.withoutTimes()
+			existing.ID, existing.Description = "", ""
+			if existing == rule {
+				return true
+			}
+		}
+	}
+	for _, rule := range imp.PortForwardingRules {
+		for _, existing := range fm.Config.PortForwardingRules {
+			existing = existing.withoutTimes()
+			existing.ID, existing.Description = "", ""
+			if existing == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitPfConfComment splits a line at the # that starts its comment, outside
+// of quotes, and returns the text before it and the comment text.
+func splitPfConfComment(line string) (string, string) {
+	inQuote := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == '#' && !inQuote:
+			return line[:i], strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, ""
+}
+
+// tokenizePfConf splits a statement into words. Braces and ! are words of
+// their own, commas separate like spaces, and quotes are removed.
+func tokenizePfConf(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	inQuote := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+			word.WriteRune(r)
+		case r == ' ' || r == '\t' || r == ',':
+			flush()
+		case r == '{' || r == '}' || r == '!':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// pfConfList reads a single word or a { ... } list at tokens[i]. It returns
+// the items and the index of the last word read.
+func pfConfList(tokens []string, i int) ([]string, int, error) {
+	if i >= len(tokens) {
+		return nil, i, fmt.Errorf("unexpected end of rule")
+	}
+	if tokens[i] != "{" {
+		return []string{tokens[i]}, i, nil
+	}
+	var items []string
+	for i++; i < len(tokens); i++ {
+		if tokens[i] == "}" {
+			return items, i, nil
+		}
+		if tokens[i] == "{" || tokens[i] == "!" {
+			return nil, i, fmt.Errorf("nested or negated list items are not supported")
+		}
+		items = append(items, tokens[i])
+	}
+	return nil, i, fmt.Errorf("unterminated list")
+}
+
+// pfConfSkipReasons explains why statements starting with a keyword are not imported.
+var pfConfSkipReasons = map[string]string{
+	"set":             "options are not imported, see Edit Global Options",
+	"scrub":           "normalization is not imported, see Edit Scrub Options",
+	"anchor":          "anchors are not imported",
+	"load":            "anchors are not imported",
+	"nat-anchor":      "anchors are not imported",
+	"rdr-anchor":      "anchors are not imported",
+	"binat-anchor":    "anchors are not imported",
+	"scrub-anchor":    "anchors are not imported",
+	"dummynet-anchor": "anchors are not imported",
+	"dummynet":        "traffic shaping is not imported, see Edit Pipes",
+	"altq":            "queueing is not supported",
+	"queue":           "queueing is not supported",
+	"binat":           "binat rules are not supported",
+	"antispoof":       "antispoof rules are not supported",
+	"match":           "match rules are not supported",
+	"include":         "include is not supported",
+}
+
+// add imports a statement. It returns the options it dropped, or an error
+// saying why the statement was skipped.
+func (imp *PfConfImport) add(text, description, group string) ([]string, error) {
+	if match := macroPattern.FindStringSubmatch(text); match != nil {
+		value := strings.Trim(strings.TrimSpace(match[2]), `"`)
+		imp.Macros = append(imp.Macros, Macro{Name: match[1], Value: value, Description: description})
+		return nil, nil
+	}
+	tokens := tokenizePfConf(text)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty statement")
+	}
+	switch tokens[0] {
+	case "table":
+		table, dropped, err := parsePfConfTable(tokens)
+		if err != nil {
+			return nil, err
+		}
+		table.Description = description
+		imp.Tables = append(imp.Tables, table)
+		return dropped, nil
+	case "nat":
+		rule, dropped, err := parsePfConfNat(tokens)
+		if err != nil {
+			return nil, err
+		}
+		rule.Description = description
+		imp.NatRules = append(imp.NatRules, rule)
+		return dropped, nil
+	case "rdr":
+		rule, dropped, err := parsePfConfRdr(tokens)
+		if err != nil {
+			return nil, err
+		}
+		rule.Description = description
+		imp.PortForwardingRules = append(imp.PortForwardingRules, rule)
+		return dropped, nil
+	case "pass", "block":
+		rules, dropped, err := parsePfConfFilter(tokens)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			if rule.Description == "" {
+				rule.Description = description
+			}
+			rule.Group = group
+			imp.FirewallRules = append(imp.FirewallRules, rule)
+		}
+		return dropped, nil
+	}
+	if reason, ok := pfConfSkipReasons[tokens[0]]; ok {
+		return nil, fmt.Errorf("%s", reason)
+	}
+	return nil, fmt.Errorf("unknown statement %q", tokens[0])
+}
+
+// parsePfConfTable parses "table <name> [persist] [const] [counters] [{ ... }]".
+func parsePfConfTable(tokens []string) (PfTable, []string, error) {
+	if len(tokens) < 2 || !strings.HasPrefix(tokens[1], "<") || !strings.HasSuffix(tokens[1], ">") {
+		return PfTable{}, nil, fmt.Errorf("table name missing")
+	}
+	table := PfTable{Name: strings.Trim(tokens[1], "<>"), Addresses: []string{}}
+	var dropped []string
+	for i := 2; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "persist":
+			table.Persist = true
+		case "{":
+			addresses, end, err := pfConfList(tokens, i)
+			if err != nil {
+				return PfTable{}, nil, err
+			}
+			table.Addresses = append(table.Addresses, addresses...)
+			i = end
+		case "file":
+			i++
+			if i < len(tokens) {
+				dropped = append(dropped, "file "+tokens[i])
+			}
+		default:
+			dropped = append(dropped, tokens[i])
+		}
+	}
+	return table, dropped, nil
+}
+
+// parsePfConfNat parses "nat [pass] [on if] [proto p] from src to dst -> translation".
+func parsePfConfNat(tokens []string) (NatRule, []string, error) {
+	rule := NatRule{Interface: "any", Protocol: "any", Source: "any", Destination: "any"}
+	var dropped []string
+	translated := false
+	for i := 1; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "on":
+			i++
+			if i >= len(tokens) || tokens[i] == "{" || tokens[i] == "!" {
+				return rule, nil, fmt.Errorf("interface lists are not supported")
+			}
+			rule.Interface = tokens[i]
+		case "proto":
+			protocols, end, err := pfConfList(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			rule.Protocol = strings.Join(protocols, ",")
+			i = end
+		case "all":
+		case "from", "to":
+			negated, hosts, end, err := pfConfHost(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			if negated {
+				return rule, nil, fmt.Errorf("negated addresses are not supported in NAT rules")
+			}
+			if tokens[i] == "from" {
+				rule.Source = hosts
+			} else {
+				rule.Destination = hosts
+			}
+			i = end
+			if i+1 < len(tokens) && tokens[i+1] == "port" {
+				ports, end, err := pfConfPort(tokens, i+2)
+				if err != nil {
+					return rule, nil, err
+				}
+				dropped = append(dropped, "port "+ports)
+				i = end
+			}
+		case "->":
+			translation, end, err := pfConfList(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			rule.Translation = strings.Join(translation, " ")
+			if len(translation) > 1 {
+				rule.Translation = formatList(translation)
+			}
+			// The interface address is what an empty translation stands for
+			if rule.Translation == fmt.Sprintf("(%s)", rule.Interface) {
+				rule.Translation = ""
+			}
+			translated = true
+			i = end
+			dropped = append(dropped, tokens[i+1:]...)
+			i = len(tokens)
+		default:
+			dropped = append(dropped, tokens[i])
+		}
+	}
+	if !translated {
+		return rule, nil, fmt.Errorf("translation address missing")
+	}
+	return rule, dropped, nil
+}
+
+// parsePfConfRdr parses "rdr [pass] [on if] proto p from any to ext port p -> int [port p]".
+func parsePfConfRdr(tokens []string) (PortForwardingRule, []string, error) {
+	rule := PortForwardingRule{Enabled: true, Interface: "any", ExternalIP: "any"}
+	var dropped []string
+	toInterface := false
+	for i := 1; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "on":
+			i++
+			if i >= len(tokens) || tokens[i] == "{" || tokens[i] == "!" {
+				return rule, nil, fmt.Errorf("interface lists are not supported")
+			}
+			rule.Interface = tokens[i]
+		case "proto":
+			protocols, end, err := pfConfList(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			if len(protocols) != 1 || (protocols[0] != "tcp" && protocols[0] != "udp") {
+				return rule, nil, fmt.Errorf("only tcp or udp redirections are supported")
+			}
+			rule.Protocol = protocols[0]
+			i = end
+		case "from":
+			negated, hosts, end, err := pfConfHost(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			if negated || hosts != "any" {
+				dropped = append(dropped, "from "+hosts)
+			}
+			i = end
+		case "to":
+			negated, hosts, end, err := pfConfHost(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			if negated {
+				return rule, nil, fmt.Errorf("negated addresses are not supported in redirections")
+			}
+			// "to (if)" is how GeneratePfConf writes any address of the interface
+			if hosts == fmt.Sprintf("(%s)", rule.Interface) {
+				toInterface = true
+			} else {
+				rule.ExternalIP = hosts
+			}
+			i = end
+		case "port":
+			ports, end, err := pfConfPort(tokens, i+1)
+			if err != nil {
+				return rule, nil, err
+			}
+			if rule.InternalIP == "" {
+				rule.ExternalPort = strings.Replace(ports, "-", ":", 1)
+			} else {
+				rule.InternalPort = strings.Replace(ports, "-", ":", 1)
+			}
+			i = end
+		case "->":
+			i++
+			if i >= len(tokens) || tokens[i] == "{" {
+				return rule, nil, fmt.Errorf("redirection pools are not supported")
+			}
+			rule.InternalIP = tokens[i]
+		case "all":
+			return rule, nil, fmt.Errorf("redirections need a port")
+		default:
+			dropped = append(dropped, tokens[i])
+		}
+	}
+	if rule.Protocol == "" || rule.ExternalPort == "" || rule.InternalIP == "" {
+		return rule, nil, fmt.Errorf("only redirections of a tcp or udp port to an address are supported")
+	}
+	if rule.InternalPort == "" {
+		rule.InternalPort = rule.ExternalPort
+	}
+	// An external address "any" on an interface is the address of the
+	// interface, see GeneratePfConf, so the rule narrows to it
+	if rule.Interface != "any" && rule.ExternalIP == "any" && !toInterface {
+		dropped = append(dropped, fmt.Sprintf("to any (to (%s) instead)", rule.Interface))
+	}
+	return rule, dropped, nil
+}
+
+// parsePfConfFilter parses a pass or block rule. A rule without a direction
+// matches both, and is imported as an in and an out rule.
+func parsePfConfFilter(tokens []string) ([]FirewallRule, []string, error) {
+	rule := FirewallRule{Enabled: true, Action: tokens[0], Interface: "any", Protocol: "any",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "any"}
+	directions := []string{"in", "out"}
+	var dropped []string
+	hostPart := "" // "from" or "to", whichever host a following "port" belongs to
+	for i := 1; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "in", "out":
+			directions = []string{tokens[i]}
+		case "drop":
+			// The default block policy
+		case "log":
+			rule.Log = "log"
+			if i+1 < len(tokens) && tokens[i+1] == "(all)" {
+				i++
+				rule.Log = "log (all)"
+			}
+		case "quick":
+			rule.Quick = true
+		case "on":
+			i++
+			if i >= len(tokens) || tokens[i] == "{" || tokens[i] == "!" {
+				return nil, nil, fmt.Errorf("interface lists are not supported")
+			}
+			rule.Interface = tokens[i]
+		case "route-to", "reply-to":
+			// "route-to (en1 192.168.2.1)" or "route-to en1"
+			rule.Route = tokens[i]
+			i++
+			if i >= len(tokens) {
+				return nil, nil, fmt.Errorf("%s without an interface", rule.Route)
+			}
+			rule.RouteInterface = strings.TrimRight(strings.TrimPrefix(tokens[i], "("), ")")
+			if strings.HasPrefix(tokens[i], "(") && !strings.HasSuffix(tokens[i], ")") && i+1 < len(tokens) {
+				i++
+				rule.RouteGateway = strings.TrimSuffix(tokens[i], ")")
+			}
+		case "proto":
+			protocols, end, err := pfConfList(tokens, i+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			rule.Protocol = strings.Join(protocols, ",")
+			i = end
+		case "all":
+		case "from", "to":
+			negated, hosts, end, err := pfConfHost(tokens, i+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if tokens[i] == "from" {
+				rule.Source, rule.SourceNot = hosts, negated
+			} else {
+				rule.Destination, rule.DestinationNot = hosts, negated
+			}
+			hostPart = tokens[i]
+			i = end
+		case "port":
+			ports, end, err := pfConfPort(tokens, i+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if hostPart == "from" {
+				rule.SourcePort = ports
+			} else {
+				rule.DestinationPort = ports
+			}
+			i = end
+		case "flags":
+			i++
+			// S/SA is the default for stateful tcp rules
+			if i < len(tokens) && tokens[i] != "S/SA" {
+				dropped = append(dropped, "flags "+tokens[i])
+			}
+		case "icmp-type":
+			i++
+			if i < len(tokens) {
+				rule.IcmpType = tokens[i]
+			}
+		case "code":
+			i++
+			if i < len(tokens) {
+				rule.IcmpCode = tokens[i]
+			}
+		case "keep", "modulate", "synproxy", "no":
+			if i+1 < len(tokens) && tokens[i+1] == "state" {
+				rule.State = tokens[i] + " state"
+				i++
+			} else {
+				dropped = append(dropped, tokens[i])
+			}
+		case "(max", "max":
+			i++
+			if i < len(tokens) {
+				rule.StateMax, _ = strconv.Atoi(strings.TrimRight(tokens[i], ")"))
+			}
+		case "(source-track", "source-track", "(source-track)", "source-track)":
+			rule.SourceTrack = "rule"
+			if !strings.HasSuffix(tokens[i], ")") && i+1 < len(tokens) {
+				if track := strings.TrimRight(tokens[i+1], ")"); track == "rule" || track == "global" {
+					i++
+					rule.SourceTrack = track
+				}
+			}
+		case "(max-src-conn", "max-src-conn":
+			i++
+			if i < len(tokens) {
+				rule.MaxSrcConn, _ = strconv.Atoi(strings.TrimRight(tokens[i], ")"))
+			}
+		case "(max-src-conn-rate", "max-src-conn-rate":
+			i++
+			if i < len(tokens) {
+				rule.MaxSrcConnRate = strings.TrimRight(tokens[i], ")")
+			}
+		case "(overload", "overload":
+			i++
+			if i < len(tokens) {
+				rule.OverloadTable = strings.Trim(tokens[i], "<>)")
+			}
+		case "flush", "flush)":
+			rule.OverloadFlush = "flush"
+			if i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], "global") {
+				i++
+				rule.OverloadFlush = "flush global"
+			}
+		case "probability":
+			i++
+			if i < len(tokens) {
+				if probability, err := strconv.ParseFloat(strings.TrimSuffix(tokens[i], "%"), 64); err == nil {
+					rule.Probability = int(probability + 0.5)
+				}
+			}
+		case "label":
+			i++
+			if i < len(tokens) {
+				if strings.HasPrefix(tokens[i], "pf-tui-") {
+					rule.ID = strings.TrimPrefix(tokens[i], "pf-tui-")
+				} else {
+					rule.Description = tokens[i]
+				}
+			}
+		default:
+			dropped = append(dropped, tokens[i])
+		}
+	}
+
+	rules := make([]FirewallRule, len(directions))
+	for i, direction := range directions {
+		rules[i] = rule
+		rules[i].Direction = direction
+		if len(directions) > 1 {
+			rules[i].ID = ""
+		}
+	}
+	return rules, dropped, nil
+}
+
+// pfConfHost reads the address of a from or to at tokens[i]: an optional !
+// and an address, table, interface or list. Lists are returned comma separated.
+func pfConfHost(tokens []string, i int) (bool, string, int, error) {
+	negated := false
+	if i < len(tokens) && tokens[i] == "!" {
+		negated = true
+		i++
+	}
+	hosts, end, err := pfConfList(tokens, i)
+	if err != nil {
+		return false, "", end, err
+	}
+	for _, host := range hosts {
+		if host == "port" || host == "->" {
+			return false, "", end, fmt.Errorf("address missing")
+		}
+	}
+	return negated, strings.Join(hosts, ", "), end, nil
+}
+
+// pfConfPort reads the ports at tokens[i]: a port, range or list, with an
+// optional "=" operator. Ranges are returned as "low-high", lists comma separated.
+func pfConfPort(tokens []string, i int) (string, int, error) {
+	if i < len(tokens) && tokens[i] == "=" {
+		i++
+	}
+	if i < len(tokens) && (tokens[i] == "!" || strings.ContainsAny(tokens[i], "<>")) {
+		return "", i, fmt.Errorf("port operators other than = are not supported")
+	}
+	ports, end, err := pfConfList(tokens, i)
+	if err != nil {
+		return "", end, err
+	}
+	if end+1 < len(tokens) && (tokens[end+1] == "><" || tokens[end+1] == "<>" || tokens[end+1] == ":") {
+		return "", end, fmt.Errorf("port operators other than = are not supported")
+	}
+	for j, port := range ports {
+		ports[j] = strings.Replace(port, ":", "-", 1)
+	}
+	return strings.Join(ports, ", "), end, nil
+}
+
+// mergeProtocols joins the filter rules GeneratePfConf wrote one line per
+// protocol for, recognized by their label, back into one rule. Only the first
+// line has the description comment.
+func (imp *PfConfImport) mergeProtocols() {
+	var merged []FirewallRule
+	for _, rule := range imp.FirewallRules {
+		if n := len(merged); n > 0 && rule.ID != "" {
+			previous := merged[n-1]
+			previous.Protocol, previous.Description = rule.Protocol, rule.Description
+			if previous == rule {
+				merged[n-1].Protocol += "," + rule.Protocol
+				continue
+			}
+		}
+		merged = append(merged, rule)
+	}
+	imp.FirewallRules = merged
+}
+
+// Summary describes the counts of what was recognized, e.g. "2 macros, 5 filter rules".
+func (imp PfConfImport) Summary() string {
+	var parts []string
+	for _, count := range []struct {
+		n    int
+		name string
+	}{
+		{len(imp.Macros), "macros"},
+		{len(imp.Tables), "tables"},
+		{len(imp.NatRules), "NAT rules"},
+		{len(imp.PortForwardingRules), "port forwarding rules"},
+		{len(imp.FirewallRules), "filter rules"},
+	} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.name))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MergeImport adds the imported entries to the configuration and saves it.
+// Macros and tables that exist already are kept, and rules get new IDs where
+// theirs are taken. It returns what was added.
+func (fm *FirewallManager) MergeImport(imp PfConfImport) (string, error) {
+	if err := fm.LoadConfig(); err != nil {
+		return "", err
+	}
+	var kept []string
+	for _, macro := range imp.Macros {
+		if fm.FindMacro(macro.Name) != -1 {
+			kept = append(kept, "$"+macro.Name)
+			continue
+		}
+		fm.Config.Macros = append(fm.Config.Macros, macro)
+	}
+	for _, table := range imp.Tables {
+		if fm.FindTable(table.Name) != -1 {
+			kept = append(kept, "<"+table.Name+">")
+			continue
+		}
+		fm.Config.Tables = append(fm.Config.Tables, table)
+	}
+	fm.Config.NatRules = append(fm.Config.NatRules, imp.NatRules...)
+	fm.Config.PortForwardingRules = append(fm.Config.PortForwardingRules, imp.PortForwardingRules...)
+
+	ids := make(map[string]bool)
+	for _, rule := range fm.Config.FirewallRules {
+		ids[rule.ID] = true
+	}
+	for _, rule := range imp.FirewallRules {
+		if rule.ID == "" || ids[rule.ID] {
+			rule.ID = NewRuleID()
+		}
+		ids[rule.ID] = true
+		fm.Config.FirewallRules = append(fm.Config.FirewallRules, rule)
+	}
+	fm.normalizeRuleGroups()
+
+	if err := fm.SaveConfig(); err != nil {
+		return "", err
+	}
+	summary := fmt.Sprintf("Imported %s.", imp.Summary())
+	if len(kept) > 0 {
+		summary += fmt.Sprintf(" Kept the existing %s.", strings.Join(kept, ", "))
+	}
+	logging.Info(summary)
+	return summary, nil
+}
+
+// ReplaceWithImport replaces the configuration with the imported entries, e.g.
+// to deploy a pf.conf. The options, scrub and settings of the current
+// configuration are kept, as a pf.conf import has none. The rules file is
+// backed up first, like ImportConfigFile does.
+func (fm *FirewallManager) ReplaceWithImport(imp PfConfImport, source string) error {
+	config := &Config{
+		SchemaVersion:       ConfigSchemaVersion,
+		Macros:              append([]Macro{}, imp.Macros...),
+		FirewallRules:       append([]FirewallRule{}, imp.FirewallRules...),
+		PortForwardingRules: append([]PortForwardingRule{}, imp.PortForwardingRules...),
+		NatRules:            append([]NatRule{}, imp.NatRules...),
+		Tables:              append([]PfTable{}, imp.Tables...),
+		RuleGroups:          []RuleGroup{},
+		Scrub:               fm.Config.Scrub,
+		Pipes:               []DummynetPipe{},
+		Options:             fm.Config.Options,
+		Settings:            fm.Config.Settings,
+	}
+	for i := range config.FirewallRules {
+		if config.FirewallRules[i].ID == "" {
+			config.FirewallRules[i].ID = NewRuleID()
+		}
+	}
+	(&FirewallManager{Config: config}).normalizeRuleGroups()
+
+	data, err := MarshalConfig(config, FormatJSON, nil)
+	if err != nil {
+		return err
+	}
+	return fm.ImportConfigData(data, FormatJSON, source)
+}
+
+// ParseLiveRules parses the output of `pfctl -s rules` and returns a slice of FirewallRule structs.
+func ParseLiveRules(output string) ([]FirewallRule, error) {
+	var rules []FirewallRule
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 4 {
+			continue // Not a valid rule
+		}
+
+		rule := FirewallRule{Enabled: true, Interface: "any", Protocol: "any", Source: "any", Destination: "any",
+			SourcePort: "any", DestinationPort: "any"}
+		hostPart := "" // "from" or "to", whichever host a following "port" belongs to
+
+		// Basic rule components
+		rule.Action = parts[0]
+
+		// Extract other parts of the rule
+		for i := 1; i < len(parts); i++ {
+			switch parts[i] {
+			case "in", "out":
+				rule.Direction = parts[i]
+			case "log":
+				rule.Log = "log"
+				if i+1 < len(parts) && parts[i+1] == "(all)" {
+					i++
+					rule.Log = "log (all)"
+				}
+			case "quick":
+				rule.Quick = true
+			case "on":
+				i++
+				rule.Interface = parts[i]
+			case "route-to", "reply-to":
+				// Printed as "route-to (en1 192.168.2.1)" or "route-to en1"
+				rule.Route = parts[i]
+				i++
+				rule.RouteInterface = strings.TrimRight(strings.TrimPrefix(parts[i], "("), ")")
+				if strings.HasPrefix(parts[i], "(") && !strings.HasSuffix(parts[i], ")") && i+1 < len(parts) {
+					i++
+					rule.RouteGateway = strings.TrimSuffix(parts[i], ")")
+				}
+			case "proto":
+				i++
+				rule.Protocol = parts[i]
+			case "from":
+				i++
+				if parts[i] == "!" && i+1 < len(parts) {
+					rule.SourceNot = true
+					i++
+				}
+				rule.Source = parts[i]
+				hostPart = "from"
+			case "to":
+				i++
+				if parts[i] == "!" && i+1 < len(parts) {
+					rule.DestinationNot = true
+					i++
+				}
+				rule.Destination = parts[i]
+				hostPart = "to"
+			case "port":
+				i++
+				// pfctl prints ports with an operator, e.g. "port = 22"
+				if i+1 < len(parts) && strings.ContainsAny(parts[i], "=<>") {
+					i++
+				}
+				if hostPart == "from" {
+					rule.SourcePort = parts[i]
+				} else {
+					rule.DestinationPort = parts[i]
+				}
+			case "icmp-type":
+				i++
+				rule.IcmpType = parts[i]
+			case "code":
+				i++
+				rule.IcmpCode = parts[i]
+			case "keep", "modulate", "synproxy", "no":
+				if i+1 < len(parts) && parts[i+1] == "state" {
+					rule.State = parts[i] + " state"
+					i++
+				}
+			case "max", "(max":
+				i++
+				rule.StateMax, _ = strconv.Atoi(strings.TrimRight(parts[i], ",)"))
+			case "source-track", "(source-track":
+				i++
+				rule.SourceTrack = strings.TrimRight(parts[i], ",)")
+			case "max-src-conn", "(max-src-conn":
+				i++
+				rule.MaxSrcConn, _ = strconv.Atoi(strings.TrimRight(parts[i], ",)"))
+			case "max-src-conn-rate", "(max-src-conn-rate":
+				i++
+				rule.MaxSrcConnRate = strings.TrimRight(parts[i], ",)")
+			case "overload", "(overload":
+				i++
+				rule.OverloadTable = strings.Trim(parts[i], "<>,)")
+			case "flush":
+				rule.OverloadFlush = "flush"
+				if i+1 < len(parts) && strings.HasPrefix(parts[i+1], "global") {
+					i++
+					rule.OverloadFlush = "flush global"
+				}
+			case "probability":
+				i++
+				// pfctl may print a fraction, e.g. "probability 33.3%"
+				if probability, err := strconv.ParseFloat(strings.TrimSuffix(parts[i], "%"), 64); err == nil {
+					rule.Probability = int(probability + 0.5)
+				}
+			case "label":
+				i++
+				if label := strings.Trim(parts[i], "\""); strings.HasPrefix(label, "pf-tui-") {
+					rule.ID = strings.TrimPrefix(label, "pf-tui-")
+				}
+			}
+		}
+
+		// Rules without a direction, e.g. "pass all flags S/SA keep state", match both
+		if rule.Direction == "" {
+			in, out := rule, rule
+			in.Direction, out.Direction = "in", "out"
+			rules = append(rules, in, out)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePfConf(t *testing.T) {
+	pass := FirewallRule{Enabled: true, Action: "pass", Direction: "out", Interface: "any", Protocol: "any",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "any"}
+	ssh := FirewallRule{Enabled: true, Action: "pass", Direction: "in", Quick: true, Interface: "en0", Protocol: "tcp",
+		Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "22", State: "keep state"}
+	rdr := PortForwardingRule{Enabled: true, Interface: "en0", Protocol: "tcp", ExternalIP: "any",
+		ExternalPort: "8080", InternalIP: "192.168.1.10", InternalPort: "80"}
+	tests := []struct {
+		name    string
+		content string
+		want    PfConfImport
+	}{
+		{
+			name:    "macro and table",
+			content: "ext_if = \"en0\"\ntable <blocklist> persist { 192.0.2.1, 198.51.100.0/24 }\n",
+			want: PfConfImport{
+				Macros: []Macro{{Name: "ext_if", Value: "en0"}},
+				Tables: []PfTable{{Name: "blocklist", Persist: true, Addresses: []string{"192.0.2.1", "198.51.100.0/24"}}},
+				Recognized: []PfConfStatement{
+					{Line: 1, Text: `ext_if = "en0"`},
+					{Line: 2, Text: "table <blocklist> persist { 192.0.2.1, 198.51.100.0/24 }"},
+				},
+			},
+		},
+		{
+			name:    "nat to the interface address",
+			content: "nat on en0 from 192.168.1.0/24 to any -> (en0)",
+			want: PfConfImport{
+				NatRules:   []NatRule{{Interface: "en0", Protocol: "any", Source: "192.168.1.0/24", Destination: "any"}},
+				Recognized: []PfConfStatement{{Line: 1, Text: "nat on en0 from 192.168.1.0/24 to any -> (en0)"}},
+			},
+		},
+		{
+			name:    "rdr to the interface address",
+			content: "rdr on en0 proto tcp from any to (en0) port 8080 -> 192.168.1.10 port 80",
+			want: PfConfImport{
+				PortForwardingRules: []PortForwardingRule{rdr},
+				Recognized:          []PfConfStatement{{Line: 1, Text: "rdr on en0 proto tcp from any to (en0) port 8080 -> 192.168.1.10 port 80"}},
+			},
+		},
+		{
+			name:    "rdr to any address narrows",
+			content: "rdr on en0 proto tcp from any to any port 8080 -> 192.168.1.10 port 80",
+			want: PfConfImport{
+				PortForwardingRules: []PortForwardingRule{rdr},
+				Recognized: []PfConfStatement{{Line: 1, Text: "rdr on en0 proto tcp from any to any port 8080 -> 192.168.1.10 port 80",
+					Note: "without to any (to (en0) instead)"}},
+			},
+		},
+		{
+			name:    "descriptions and groups",
+			content: "# ssh\npass in quick on en0 proto tcp from any to any port 22 keep state\n\n# --- LAN ---\npass out all\n# ---  ---\npass out all\n",
+			want: PfConfImport{
+				FirewallRules: []FirewallRule{
+					func() FirewallRule { rule := ssh; rule.Description = "ssh"; return rule }(),
+					func() FirewallRule { rule := pass; rule.Group = "LAN"; return rule }(),
+					pass,
+				},
+				Recognized: []PfConfStatement{
+					{Line: 2, Text: "pass in quick on en0 proto tcp from any to any port 22 keep state"},
+					{Line: 5, Text: "pass out all"},
+					{Line: 7, Text: "pass out all"},
+				},
+			},
+		},
+		{
+			name:    "icmp type",
+			content: "block in log proto icmp from any to any icmp-type unreach code port-unr",
+			want: PfConfImport{
+				FirewallRules: []FirewallRule{{Enabled: true, Action: "block", Direction: "in", Log: "log", Interface: "any",
+					Protocol: "icmp", Source: "any", Destination: "any", SourcePort: "any", DestinationPort: "any",
+					IcmpType: "unreach", IcmpCode: "port-unr"}},
+				Recognized: []PfConfStatement{{Line: 1, Text: "block in log proto icmp from any to any icmp-type unreach code port-unr"}},
+			},
+		},
+		{
+			name:    "unsupported statement",
+			content: "altq on en0 cbq bandwidth 10Mb queue { std }",
+			want: PfConfImport{
+				Skipped: []PfConfStatement{{Line: 1, Text: "altq on en0 cbq bandwidth 10Mb queue { std }", Note: "queueing is not supported"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParsePfConf(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePfConf =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParsePfConfRoundTrip checks that ParsePfConf reads the rules
+// GeneratePfConf writes back as they were.
+func TestParsePfConfRoundTrip(t *testing.T) {
+	cfg := emptyConfig()
+	cfg.Tables = []PfTable{{Name: "blocklist", Persist: true, Addresses: []string{"192.0.2.1"}}}
+	cfg.NatRules = []NatRule{{Interface: "en0", Protocol: "any", Source: "192.168.1.0/24", Destination: "any", Description: "sharing"}}
+	cfg.PortForwardingRules = []PortForwardingRule{{Enabled: true, Interface: "en0", Protocol: "tcp", ExternalIP: "any",
+		ExternalPort: "8080", InternalIP: "192.168.1.10", InternalPort: "80"}}
+	cfg.FirewallRules = []FirewallRule{
+		{Enabled: true, Action: "block", Direction: "in", Interface: "any", Protocol: "any", Source: "<blocklist>",
+			Destination: "any", SourcePort: "any", DestinationPort: "any", Description: "blocklist"},
+		{Enabled: true, Group: "LAN", Action: "pass", Direction: "in", Quick: true, Interface: "en0", Protocol: "tcp",
+			Source: "192.168.1.0/24", Destination: "any", SourcePort: "any", DestinationPort: "22", State: "keep state"},
+		{Enabled: true, Group: "LAN", Action: "pass", Direction: "in", Interface: "en0", Protocol: "icmp",
+			Source: "192.168.1.0/24", Destination: "any", SourcePort: "any", DestinationPort: "any", IcmpType: "echoreq"},
+	}
+	fm := &FirewallManager{Config: cfg}
+
+	imp := ParsePfConf(fm.GeneratePfConf())
+	if len(imp.Skipped) > 0 {
+		t.Errorf("skipped %+v", imp.Skipped)
+	}
+	for _, statement := range imp.Recognized {
+		if statement.Note != "" {
+			t.Errorf("line %d %q: %s", statement.Line, statement.Text, statement.Note)
+		}
+	}
+	if !reflect.DeepEqual(imp.Tables, cfg.Tables) {
+		t.Errorf("tables = %+v, want %+v", imp.Tables, cfg.Tables)
+	}
+	if !reflect.DeepEqual(imp.NatRules, cfg.NatRules) {
+		t.Errorf("nat rules = %+v, want %+v", imp.NatRules, cfg.NatRules)
+	}
+	if !reflect.DeepEqual(imp.PortForwardingRules, cfg.PortForwardingRules) {
+		t.Errorf("rdr rules = %+v, want %+v", imp.PortForwardingRules, cfg.PortForwardingRules)
+	}
+	if !reflect.DeepEqual(imp.FirewallRules, cfg.FirewallRules) {
+		t.Errorf("filter rules =\n%+v\nwant\n%+v", imp.FirewallRules, cfg.FirewallRules)
+	}
+}
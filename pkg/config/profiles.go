@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// profilesDirName is the directory in the config directory with the
+// profiles: named configurations to switch between, e.g. "home" and "office".
+const profilesDirName = "profiles"
+
+// profileNamePattern is what profile names may contain, as they are file names.
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Profile is a configuration file in the profiles directory.
+type Profile struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Format   ConfigFormat `json:"format"`
+	Modified time.Time    `json:"modified"`
+	Current  bool         `json:"current"` // the configuration is the same as the profile
+}
+
+// profilesDir returns the directory with the profiles.
+func profilesDir() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, profilesDirName), nil
+}
+
+// ListProfiles returns the profiles sorted by name, with Current set for the
+// one the configuration of fm is the same as. A profile can be a JSON, YAML
+// or TOML file; for names with several, the first of ConfigFileNames counts.
+func (fm *FirewallManager) ListProfiles() ([]Profile, error) {
+	dir, err := profilesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Profile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]Profile{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || !IsConfigFile(entry.Name()) || !profileNamePattern.MatchString(name) {
+			continue
+		}
+		if existing, ok := byName[name]; ok && configFileRank(existing.Path) < configFileRank(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, entry.Name())
+		byName[name] = Profile{Name: name, Path: path, Format: ConfigFormatOf(path), Modified: info.ModTime()}
+	}
+
+	current, err := json.Marshal(fm.Config)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]Profile, 0, len(byName))
+	for _, profile := range byName {
+		if preview := PreviewConfigFile(profile.Path); preview.Err == nil {
+			data, err := json.Marshal(preview.Config)
+			profile.Current = err == nil && bytes.Equal(data, current)
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+// FindProfile returns the profile with the given name.
+func (fm *FirewallManager) FindProfile(name string) (Profile, error) {
+	profiles, err := fm.ListProfiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, WithKind(KindUsage, fmt.Errorf("no profile %q", name))
+}
+
+// SaveProfile saves the configuration as the profile with the given name,
+// replacing it if it exists. A new profile is a JSON file; an existing one
+// keeps its format.
+func (fm *FirewallManager) SaveProfile(name string) (Profile, error) {
+	if !profileNamePattern.MatchString(name) {
+		return Profile{}, WithKind(KindUsage, fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name))
+	}
+	dir, err := profilesDir()
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, err := fm.FindProfile(name)
+	if KindOf(err) == KindUsage {
+		profile = Profile{Name: name, Path: filepath.Join(dir, name+FormatJSON.Extension()), Format: FormatJSON}
+	} else if err != nil {
+		return Profile{}, err
+	}
+	if err := fm.SaveConfigAs(profile.Path, profile.Format); err != nil {
+		return Profile{}, WithKind(KindConfig, err)
+	}
+	logging.Info(fmt.Sprintf("Saved the configuration as profile %s", profile.Path))
+	profile.Modified = time.Now()
+	profile.Current = true
+	return profile, nil
+}
+
+// ProfileRule is the copy a profile has of a firewall rule, see
+// ProfilesWithRule.
+type ProfileRule struct {
+	Profile Profile
+	Changed bool // the profile's copy differs from the rule
+}
+
+// ProfilesWithRule returns the profiles that have the firewall rule, by its
+// ID, sorted by name. Profiles that cannot be read are left out.
+func (fm *FirewallManager) ProfilesWithRule(rule FirewallRule) ([]ProfileRule, error) {
+	if rule.ID == "" {
+		return nil, nil
+	}
+	profiles, err := fm.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+	want, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	var found []ProfileRule
+	for _, profile := range profiles {
+		preview := PreviewConfigFile(profile.Path)
+		if preview.Err != nil {
+			continue
+		}
+		for _, other := range preview.Config.FirewallRules {
+			if other.ID == rule.ID {
+				data, err := json.Marshal(other)
+				found = append(found, ProfileRule{Profile: profile, Changed: err != nil || !bytes.Equal(data, want)})
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// configFileRank returns the preference of the extension of path in
+// ConfigFileNames, len(ConfigFileNames) for other extensions.
+func configFileRank(path string) int {
+	ext := strings.ToLower(filepath.Ext(path))
+	for i, name := range ConfigFileNames {
+		if filepath.Ext(name) == ext {
+			return i
+		}
+	}
+	return len(ConfigFileNames)
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// ServicesFile is the services database pf resolves port names such as
+// "ssh" with.
+var ServicesFile = "/etc/services"
+
+// Service is a named port, e.g. "ssh" for port 22.
+type Service struct {
+	Name        string
+	Port        int
+	Description string
+}
+
+// commonServices are the services the rule form offers to pick from. Only
+// the ones in ServicesFile are offered, with the port listed there.
+var commonServices = []Service{
+	{"ssh", 22, "Secure Shell"},
+	{"http", 80, "Web"},
+	{"https", 443, "Web over TLS"},
+	{"http-alt", 8080, "Web, alternate port"},
+	{"domain", 53, "DNS"},
+	{"mdns", 5353, "Bonjour"},
+	{"ntp", 123, "Time"},
+	{"bootps", 67, "DHCP server"},
+	{"bootpc", 68, "DHCP client"},
+	{"smtp", 25, "Mail transfer"},
+	{"submission", 587, "Mail submission"},
+	{"imap", 143, "Mail access"},
+	{"imaps", 993, "Mail access over TLS"},
+	{"pop3", 110, "Mail download"},
+	{"pop3s", 995, "Mail download over TLS"},
+	{"ftp", 21, "File transfer"},
+	{"tftp", 69, "Trivial file transfer"},
+	{"rsync", 873, "File sync"},
+	{"nfs", 2049, "Network file system"},
+	{"afpovertcp", 548, "Apple file sharing"},
+	{"microsoft-ds", 445, "SMB file sharing"},
+	{"netbios-ssn", 139, "NetBIOS file sharing"},
+	{"ipp", 631, "Printing"},
+	{"rfb", 5900, "Screen Sharing (VNC)"},
+	{"ms-wbt-server", 3389, "Remote Desktop"},
+	{"telnet", 23, "Telnet"},
+	{"kerberos", 88, "Kerberos"},
+	{"ldap", 389, "Directory"},
+	{"ldaps", 636, "Directory over TLS"},
+	{"snmp", 161, "Network management"},
+	{"syslog", 514, "Remote logging"},
+	{"isakmp", 500, "IPsec key exchange"},
+	{"openvpn", 1194, "OpenVPN"},
+	{"l2f", 1701, "L2TP VPN"},
+	{"pptp", 1723, "PPTP VPN"},
+	{"mysql", 3306, "MySQL"},
+	{"postgresql", 5432, "PostgreSQL"},
+	{"git", 9418, "Git"},
+}
+
+// serviceDB is the content of ServicesFile.
+type serviceDB struct {
+	ports map[string]int // by service name and alias
+	names map[int]string // the first service listed for a port
+}
+
+var (
+	servicesOnce sync.Once
+	services     *serviceDB // nil if ServicesFile cannot be read
+)
+
+// loadServices reads ServicesFile the first time it is called.
+func loadServices() *serviceDB {
+	servicesOnce.Do(func() {
+		data, err := os.ReadFile(ServicesFile)
+		if err != nil {
+			logging.Warn(fmt.Sprintf("Failed to read %s, service names are not checked: %v", ServicesFile, err))
+			return
+		}
+		services = parseServices(string(data))
+	})
+	return services
+}
+
+// parseServices parses a services database: lines of a name, port/protocol
+// and aliases, with "#" comments. Only tcp and udp ports are kept.
+func parseServices(data string) *serviceDB {
+	db := &serviceDB{ports: map[string]int{}, names: map[int]string{}}
+	for _, line := range strings.Split(data, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portNumber, protocol, _ := strings.Cut(fields[1], "/")
+		port, err := strconv.Atoi(portNumber)
+		if err != nil || (protocol != "tcp" && protocol != "udp") {
+			continue
+		}
+		for _, name := range append([]string{fields[0]}, fields[2:]...) {
+			if _, ok := db.ports[name]; !ok {
+				db.ports[name] = port
+			}
+		}
+		if _, ok := db.names[port]; !ok {
+			db.names[port] = fields[0]
+		}
+	}
+	return db
+}
+
+// LookupService returns the port of a service name or alias, and false if it
+// is not in ServicesFile.
+func LookupService(name string) (int, bool) {
+	db := loadServices()
+	if db == nil {
+		return 0, false
+	}
+	port, ok := db.ports[name]
+	return port, ok
+}
+
+// ServiceName returns the name of the service on a port, or "" if there is
+// none in ServicesFile.
+func ServiceName(port int) string {
+	if db := loadServices(); db != nil {
+		return db.names[port]
+	}
+	return ""
+}
+
+// KnownService reports whether pf can resolve a service name: whether it is
+// in ServicesFile, or true for any name if the file cannot be read.
+func KnownService(name string) bool {
+	if loadServices() == nil {
+		return true
+	}
+	_, ok := LookupService(name)
+	return ok
+}
+
+// PickableServices returns the commonServices that are in ServicesFile, with
+// the port listed there.
+func PickableServices() []Service {
+	var pickable []Service
+	for _, service := range commonServices {
+		if port, ok := LookupService(service.Name); ok {
+			service.Port = port
+			pickable = append(pickable, service)
+		} else if loadServices() == nil {
+			pickable = append(pickable, service)
+		}
+	}
+	return pickable
+}
+
+// FormatPortServices renders a port field with the service name after each
+// port number that has one, e.g. "22 (ssh)" or "80 (http), 443 (https)".
+func FormatPortServices(port string) string {
+	items := SplitList(port)
+	named := false
+	for i, item := range items {
+		if number, err := strconv.Atoi(item); err == nil {
+			if name := ServiceName(number); name != "" {
+				items[i] = fmt.Sprintf("%s (%s)", item, name)
+				named = true
+			}
+		}
+	}
+	if !named {
+		return port
+	}
+	return strings.Join(items, ", ")
+}
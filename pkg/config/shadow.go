@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ShadowedRules returns, by index, a warning for each enabled filter rule
+// that can never match because an earlier enabled quick rule matches every
+// packet it matches. A rule is only reported when the configuration proves
+// it; matches that depend on tables, host names or interface addresses are
+// not compared beyond identical values.
+func (fm *FirewallManager) ShadowedRules() map[int]string {
+	rules := make([]FirewallRule, len(fm.Config.FirewallRules))
+	for i, rule := range fm.Config.FirewallRules {
+		fm.expandMacroFields(&rule.Interface, &rule.Source, &rule.Destination, &rule.SourcePort, &rule.DestinationPort)
+		rules[i] = rule
+	}
+
+	shadowed := make(map[int]string)
+	for j, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		for i, earlier := range rules[:j] {
+			if earlier.Enabled && earlier.Quick && ruleCovers(earlier, rule) {
+				shadowed[j] = fmt.Sprintf("never matches: rule %d (quick %s) matches first", i+1, earlier.Action)
+				break
+			}
+		}
+	}
+	return shadowed
+}
+
+// ruleCovers reports whether rule a matches every packet rule b matches.
+func ruleCovers(a, b FirewallRule) bool {
+	if a.Direction != b.Direction || a.Probability > 0 {
+		return false
+	}
+	if a.Interface != "any" && a.Interface != b.Interface {
+		return false
+	}
+	aProtocols, bProtocols := ruleProtocols(a), ruleProtocols(b)
+	if !protocolsCover(aProtocols, bProtocols) {
+		return false
+	}
+	if aProtocols["icmp"] && a.IcmpType != "" {
+		if b.IcmpType != a.IcmpType || (a.IcmpCode != "" && b.IcmpCode != a.IcmpCode) {
+			return false
+		}
+	}
+	return hostsCover(a.Source, a.SourceNot, b.Source, b.SourceNot) &&
+		hostsCover(a.Destination, a.DestinationNot, b.Destination, b.DestinationNot) &&
+		portsCover(a.SourcePort, b.SourcePort) &&
+		portsCover(a.DestinationPort, b.DestinationPort)
+}
+
+// ruleProtocols returns the protocols a rule matches, as GeneratePfConf
+// emits them: "any" with a port matches tcp and udp.
+func ruleProtocols(rule FirewallRule) map[string]bool {
+	hasPort := (rule.SourcePort != "any" && rule.SourcePort != "") || (rule.DestinationPort != "any" && rule.DestinationPort != "")
+	protocols := make(map[string]bool)
+	if rule.Protocol == "any" && hasPort {
+		protocols["tcp"], protocols["udp"] = true, true
+		return protocols
+	}
+	for _, proto := range strings.Split(rule.Protocol, ",") {
+		protocols[strings.TrimSpace(proto)] = true
+	}
+	return protocols
+}
+
+// protocolsCover reports whether the protocols a include all of b.
+func protocolsCover(a, b map[string]bool) bool {
+	if a["any"] {
+		return true
+	}
+	if b["any"] {
+		return false
+	}
+	for proto := range b {
+		if !a[proto] {
+			return false
+		}
+	}
+	return true
+}
+
+// hostsCover reports whether the host field a includes every address of b.
+func hostsCover(a string, aNot bool, b string, bNot bool) bool {
+	if a == "any" && !aNot {
+		return true
+	}
+	aItems, bItems := SplitList(a), SplitList(b)
+	if aNot || bNot {
+		if aNot != bNot {
+			return false
+		}
+		// Both negated: the same excluded hosts
+		sort.Strings(aItems)
+		sort.Strings(bItems)
+		return strings.Join(aItems, " ") == strings.Join(bItems, " ")
+	}
+	if b == "any" {
+		return false
+	}
+	for _, bItem := range bItems {
+		covered := false
+		for _, aItem := range aItems {
+			if hostItemCovers(aItem, bItem) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// hostItemCovers reports whether the address or network a includes b.
+func hostItemCovers(a, b string) bool {
+	if a == b {
+		return true
+	}
+	_, aNet, err := net.ParseCIDR(a)
+	if err != nil {
+		return false // a single address only covers itself
+	}
+	if ip := net.ParseIP(b); ip != nil {
+		return aNet.Contains(ip)
+	}
+	_, bNet, err := net.ParseCIDR(b)
+	if err != nil {
+		return false
+	}
+	aOnes, _ := aNet.Mask.Size()
+	bOnes, _ := bNet.Mask.Size()
+	return aNet.Contains(bNet.IP) && aOnes <= bOnes
+}
+
+// portsCover reports whether the port field a includes every port of b.
+func portsCover(a, b string) bool {
+	if a == "any" || a == "" {
+		return true
+	}
+	if b == "any" || b == "" {
+		return false
+	}
+	aItems := SplitList(a)
+	for _, bItem := range SplitList(b) {
+		bLow, bHigh, ok := portItemRange(bItem)
+		if !ok {
+			return false
+		}
+		covered := false
+		for _, aItem := range aItems {
+			if aLow, aHigh, ok := portItemRange(aItem); ok && aLow <= bLow && bHigh <= aHigh {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// portItemRange returns the range of a port, port range or service name.
+func portItemRange(item string) (low, high int, ok bool) {
+	if low, high, ok := ParsePortRange(item); ok {
+		return low, high, true
+	}
+	if port, err := net.LookupPort("tcp", item); err == nil {
+		return port, port, true
+	}
+	return 0, 0, false
+}
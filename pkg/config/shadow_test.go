@@ -0,0 +1,41 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShadowedRules(t *testing.T) {
+	rule := func(quick bool, source, port string) FirewallRule {
+		return FirewallRule{Enabled: true, Action: "pass", Direction: "in", Quick: quick, Interface: "any", Protocol: "tcp",
+			Source: source, Destination: "any", SourcePort: "any", DestinationPort: port}
+	}
+	disabled := rule(true, "any", "any")
+	disabled.Enabled = false
+	tests := []struct {
+		name  string
+		rules []FirewallRule
+		want  []int
+	}{
+		{name: "quick any before narrower", rules: []FirewallRule{rule(true, "any", "any"), rule(false, "192.168.1.0/24", "22")}, want: []int{1}},
+		{name: "quick network before address", rules: []FirewallRule{rule(true, "192.168.1.0/24", "20:30"), rule(false, "192.168.1.5", "22")}, want: []int{1}},
+		{name: "not quick", rules: []FirewallRule{rule(false, "any", "any"), rule(false, "192.168.1.0/24", "22")}},
+		{name: "narrower first", rules: []FirewallRule{rule(true, "192.168.1.0/24", "22"), rule(false, "any", "any")}},
+		{name: "other port", rules: []FirewallRule{rule(true, "any", "80"), rule(false, "any", "22")}},
+		{name: "disabled", rules: []FirewallRule{disabled, rule(false, "any", "22")}},
+		{name: "table", rules: []FirewallRule{rule(true, "<lan>", "any"), rule(false, "192.168.1.5", "22")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := &FirewallManager{Config: emptyConfig()}
+			fm.Config.FirewallRules = tt.rules
+			var got []int
+			for i := range fm.ShadowedRules() {
+				got = append(got, i)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shadowed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
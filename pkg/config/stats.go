@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+const (
+	statsFileName   = "stats.json"
+	StatsInterval   = 30 * time.Second // how often RecordStatsSample is meant to run
+	maxStatsSamples = 120              // one hour at StatsInterval
+)
+
+// StatsSample is one periodic sample of pf's activity. Passed and Blocked are
+// the cumulative packet counters of the rules in the pf-tui anchor.
+type StatsSample struct {
+	Time    time.Time `json:"time"`
+	States  int       `json:"states"`
+	Passed  uint64    `json:"passed"`
+	Blocked uint64    `json:"blocked"`
+}
+
+func getStatsPath() (string, error) {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, statsFileName), nil
+}
+
+// LoadStats loads the samples saved by RecordStatsSample, oldest first.
+func LoadStats() ([]StatsSample, error) {
+	path, err := getStatsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var samples []StatsSample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return samples, nil
+}
+
+// RecordStatsSample takes a sample of the current number of states and the
+// passed and blocked packets of the rules, appends it to the samples on disk,
+// keeping the last maxStatsSamples, and returns them.
+func (fm *FirewallManager) RecordStatsSample(runner pfcli.CommandRunner) ([]StatsSample, error) {
+	info, err := pfcli.GetPfInfo(runner)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := pfcli.GetRuleCounters(runner)
+	if err != nil {
+		return nil, err
+	}
+	sample := StatsSample{Time: time.Now(), States: pfcli.ParseCurrentEntries(info)["State Table"]}
+	for _, rule := range fm.Config.FirewallRules {
+		packets := counters[RuleLabel(rule)].Packets
+		if strings.HasPrefix(rule.Action, "pass") {
+			sample.Passed += packets
+		} else {
+			sample.Blocked += packets
+		}
+	}
+
+	samples, err := LoadStats()
+	if err != nil {
+		logging.Warn(fmt.Sprintf("Discarding statistics history: %v", err))
+	}
+	samples = append(samples, sample)
+	if len(samples) > maxStatsSamples {
+		samples = samples[len(samples)-maxStatsSamples:]
+	}
+
+	path, err := getStatsPath()
+	if err != nil {
+		return samples, err
+	}
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return samples, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return samples, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return samples, nil
+}
+
+// CounterDeltas returns the increase of a cumulative counter between
+// consecutive samples. A decrease means the rules were reloaded and the
+// counters restarted from zero.
+func CounterDeltas(samples []StatsSample, counter func(StatsSample) uint64) []uint64 {
+	var deltas []uint64
+	for i := 1; i < len(samples); i++ {
+		previous, current := counter(samples[i-1]), counter(samples[i])
+		if current >= previous {
+			deltas = append(deltas, current-previous)
+		} else {
+			deltas = append(deltas, current)
+		}
+	}
+	return deltas
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// configWatchDebounce is how long a rules file has to be quiet after a change
+// before it is reported, as editors and sync tools write it in several steps.
+const configWatchDebounce = 500 * time.Millisecond
+
+// ConfigWatcher reports changes of the rules file in the config directory,
+// by pf-tui itself or by something else, e.g. an editor or a sync tool.
+type ConfigWatcher struct {
+	Changed <-chan struct{} // receives after the rules file changed, closed when stopped
+
+	watcher *fsnotify.Watcher
+}
+
+// WatchConfig starts watching the config directory for changes of the rules
+// file. The directory is watched rather than the file, so that files replaced
+// by a rename, as SaveConfig and most editors do, are still followed.
+func WatchConfig() (*ConfigWatcher, error) {
+	dir, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	changed := make(chan struct{}, 1)
+	w := &ConfigWatcher{Changed: changed, watcher: watcher}
+	logging.Info(fmt.Sprintf("Watching %s for changes of the rules file", dir))
+
+	go func() {
+		defer close(changed)
+		var pending <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if containsString(ConfigFileNames, filepath.Base(event.Name)) {
+					pending = time.After(configWatchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logging.Warn(fmt.Sprintf("Error watching the config directory: %v", err))
+			case <-pending:
+				pending = nil
+				// Only one pending notice; the TUI checks the file once for it
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Stop stops watching. It can be called more than once.
+func (w *ConfigWatcher) Stop() {
+	w.watcher.Close()
+}
@@ -0,0 +1,31 @@
+// Package logging is the log of the pf-tui packages. It logs nothing until
+// SetLogger gives it a logger; pf-tui gives it the one of pf-tui.log.
+package logging
+
+import (
+	"io"
+	"log"
+)
+
+// logger is discarded until SetLogger is called.
+var logger = log.New(io.Discard, "", 0)
+
+// SetLogger makes the pf-tui packages log to l.
+func SetLogger(l *log.Logger) {
+	logger = l
+}
+
+// Info logs an event, e.g. a command that runs or a file that was saved.
+func Info(format string, v ...interface{}) {
+	logger.Printf("INFO: "+format, v...)
+}
+
+// Warn logs a problem pf-tui carries on after.
+func Warn(format string, v ...interface{}) {
+	logger.Printf("WARN: "+format, v...)
+}
+
+// Error logs a failure.
+func Error(format string, v ...interface{}) {
+	logger.Printf("ERROR: "+format, v...)
+}
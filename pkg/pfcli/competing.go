@@ -0,0 +1,209 @@
+package pfcli
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// CompetingRuleset is a set of pf rules loaded by something other than
+// pf-tui, which can decide on packets before or instead of the pf-tui rules.
+type CompetingRuleset struct {
+	Anchor string   // anchor path, "" for the rules of the main ruleset
+	Tool   string   // what probably loaded the rules
+	Rules  []string // filter and translation rules, as pfctl shows them
+	Quick  int      // number of quick filter rules
+	After  bool     // evaluated after the pf-tui anchor
+}
+
+// knownRulesets names the tools that load rules into pf by a part of their
+// anchor path, matched case-insensitively.
+var knownRulesets = []struct {
+	match string
+	tool  string
+}{
+	{"com.apple/internet-sharing", "macOS Internet Sharing"},
+	{"com.apple/250.ApplicationFirewall", "macOS Application Firewall"},
+	{"obdev", "Little Snitch"},
+	{"littlesnitch", "Little Snitch"},
+	{"murus", "Murus"},
+	{"vallum", "Vallum"},
+	{"mullvad", "Mullvad VPN"},
+	{"nordvpn", "NordVPN"},
+	{"protonvpn", "Proton VPN"},
+	{"com.cisco", "Cisco Secure Client"},
+	{"docker", "Docker Desktop"},
+}
+
+// rulesetTool returns the tool that probably loaded the anchor at path, and
+// whether it is worth a warning: Apple's own anchors other than the known ones
+// come with macOS and are not.
+func rulesetTool(path string) (string, bool) {
+	lower := strings.ToLower(path)
+	for _, known := range knownRulesets {
+		if strings.Contains(lower, strings.ToLower(known.match)) {
+			return known.tool, true
+		}
+	}
+	if strings.HasPrefix(path, "com.apple") {
+		return "macOS", false
+	}
+	return "unknown tool", true
+}
+
+// anchorRuleKeywords start the rules of a ruleset that only evaluate an anchor.
+var anchorRuleKeywords = map[string]bool{
+	"anchor": true, "scrub-anchor": true, "nat-anchor": true, "rdr-anchor": true,
+	"binat-anchor": true, "dummynet-anchor": true,
+}
+
+// DetectCompetingRules returns the rulesets loaded into pf by other tools,
+// such as the anchors of Internet Sharing or a VPN kill switch, and rules
+// loaded into the main ruleset from something else than pf-tui. It also
+// reports whether the main ruleset evaluates the pf-tui anchor or has its
+// rules; if not, another tool probably replaced it.
+func DetectCompetingRules(runner CommandRunner) ([]CompetingRuleset, bool, error) {
+	mainRules, err := RunSudoCmd(runner, "pfctl", "-s", "rules")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to show the rules: %w, output: %s", err, mainRules)
+	}
+	mainNat, err := RunSudoCmd(runner, "pfctl", "-s", "nat")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to show the NAT rules: %w, output: %s", err, mainNat)
+	}
+
+	// The anchors the main ruleset evaluates, in order, and its own rules
+	var anchors []string
+	pfTuiAt := -1
+	var own CompetingRuleset
+	for i, line := range PfctlRuleLines(mainRules + "\n" + mainNat) {
+		fields := strings.Fields(line)
+		if anchorRuleKeywords[fields[0]] && len(fields) > 1 {
+			name := strings.TrimSuffix(strings.Trim(fields[1], `"`), "/*")
+			if name == "pf-tui" && pfTuiAt == -1 {
+				pfTuiAt = i
+			}
+			anchors = append(anchors, name)
+			continue
+		}
+		if strings.Contains(line, `label "pf-tui`) {
+			// Rules of pf-tui loaded into the main ruleset
+			if pfTuiAt == -1 {
+				pfTuiAt = i
+			}
+			continue
+		}
+		if fields[0] == "scrub" {
+			continue // normalization does not decide on packets
+		}
+		own.Rules = append(own.Rules, line)
+		if slices.Contains(fields, "quick") {
+			own.Quick++
+		}
+		own.After = pfTuiAt != -1
+	}
+	if len(own.Rules) > 0 {
+		own.Tool = "/etc/pf.conf or a tool that loaded its own pf.conf"
+	}
+
+	// All anchors, including the nested ones, e.g. "  com.apple/internet-sharing"
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "Anchors", "-v")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list the anchors: %w, output: %s", err, out)
+	}
+	var competing []CompetingRuleset
+	for _, path := range strings.Fields(out) {
+		if path == "pf-tui" || strings.HasPrefix(path, "pf-tui/") {
+			continue
+		}
+		tool, warn := rulesetTool(path)
+		if !warn {
+			continue
+		}
+		ruleset := CompetingRuleset{Anchor: path, Tool: tool}
+		for _, modifier := range []string{"rules", "nat"} {
+			rules, err := RunSudoCmd(runner, "pfctl", "-a", path, "-s", modifier)
+			if err != nil {
+				logging.Warn(fmt.Sprintf("Failed to show the %s of anchor %s: %v", modifier, path, err))
+				continue
+			}
+			for _, line := range PfctlRuleLines(rules) {
+				fields := strings.Fields(line)
+				if anchorRuleKeywords[fields[0]] {
+					continue // the nested anchor is listed itself
+				}
+				ruleset.Rules = append(ruleset.Rules, line)
+				if slices.Contains(fields, "quick") {
+					ruleset.Quick++
+				}
+			}
+		}
+		if len(ruleset.Rules) == 0 {
+			continue
+		}
+		// The anchor is evaluated where the main ruleset refers to its top level
+		top := strings.SplitN(path, "/", 2)[0]
+		for i, name := range anchors {
+			if name == top || name == path {
+				ruleset.After = pfTuiAt != -1 && i > indexOf(anchors, "pf-tui")
+				break
+			}
+		}
+		competing = append(competing, ruleset)
+	}
+	if len(own.Rules) > 0 {
+		competing = append(competing, own)
+	}
+	return competing, pfTuiAt != -1, nil
+}
+
+// PfctlRuleLines returns the rule lines of pfctl -s output, without the
+// empty lines and the messages about ALTQ.
+func PfctlRuleLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, "ALTQ") || strings.HasPrefix(line, "No ") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// indexOf returns the index of the first s in list, or -1.
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Explanation says how the rules of a competing ruleset can override the
+// pf-tui rules, one sentence per line.
+func (c CompetingRuleset) Explanation() string {
+	var parts []string
+	if c.After {
+		parts = append(parts, "Evaluated after the pf-tui anchor: where its rules match, they override pf-tui's non-quick rules, as the last matching rule wins.")
+	} else {
+		parts = append(parts, "Evaluated before the pf-tui anchor: pf-tui's rules override its non-quick rules where both match.")
+	}
+	if c.Quick > 0 {
+		if c.After {
+			parts = append(parts, fmt.Sprintf("Its %d quick rules decide on the packets they match unless a quick pf-tui rule matches first.", c.Quick))
+		} else {
+			parts = append(parts, fmt.Sprintf("Its %d quick rules decide on the packets they match before any pf-tui rule.", c.Quick))
+		}
+	}
+	for _, rule := range c.Rules {
+		if keyword := strings.Fields(rule)[0]; keyword == "nat" || keyword == "rdr" || keyword == "binat" {
+			parts = append(parts, "For translation the first matching rule wins, so its NAT and redirection rules take precedence over pf-tui's if they are evaluated first.")
+			break
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,1094 @@
+// Package pfcli runs pfctl and the other commands pf-tui needs root for,
+// through a CommandRunner, and parses their output: the status and counters
+// of pf, the loaded rules, tables and states, and the pflog stream. The rules
+// are loaded into the pf-tui Anchor, which SetupPfConf makes /etc/pf.conf
+// evaluate.
+package pfcli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// RunSudoCmd executes a command with root privileges, with runner.
+func RunSudoCmd(runner CommandRunner, args ...string) (string, error) {
+	return runSudoInput(runner, nil, args...)
+}
+
+// runSudoInput is RunSudoCmd with stdin as the input of the command.
+func runSudoInput(runner CommandRunner, stdin io.Reader, args ...string) (string, error) {
+	logging.Info(fmt.Sprintf("Executing sudo command: %s", strings.Join(args, " ")))
+	out, err := runner.Run(stdin, args...)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Sudo command failed: %s - %v - %s", strings.Join(args, " "), err, out))
+		return out, &CommandError{Args: args, Err: err}
+	}
+	return out, nil
+}
+
+// CommandError is the error of a command run by RunSudoCmd, e.g. pfctl
+// rejecting the rules. ErrSudoExpired is wrapped in it if sudo needed the
+// password.
+type CommandError struct {
+	Args []string
+	Err  error
+}
+
+func (e *CommandError) Error() string { return e.Err.Error() }
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// Anchor is the anchor the generated rules are loaded into, which the
+// main ruleset of /etc/pf.conf evaluates.
+const Anchor = "pf-tui"
+
+// AnchorPath is the anchor file Save & Apply writes and pf loads.
+const AnchorPath = "/etc/pf.anchors/pf-tui"
+
+// pfTuiAnchorLines are the lines pf.conf needs to load the pf-tui anchor.
+var pfTuiAnchorLines = []string{
+	`scrub-anchor "pf-tui"`,
+	`nat-anchor "pf-tui"`,
+	`rdr-anchor "pf-tui"`,
+	`dummynet-anchor "pf-tui"`,
+	`anchor "pf-tui"`,
+	`load anchor "pf-tui" from "` + AnchorPath + `"`,
+}
+
+// pfConfSections ranks the statements of pf.conf by the section pf requires
+// them in: options, normalization, queueing, translation (nat before rdr, as
+// in Apple's pf.conf), dummynet and filtering. Statements that are not listed,
+// such as macros, tables and load anchor, may appear anywhere.
+var pfConfSections = map[string]int{
+	"set":   1,
+	"scrub": 2, "scrub-anchor": 2,
+	"altq": 3, "queue": 3,
+	"nat": 4, "nat-anchor": 4, "binat": 4, "binat-anchor": 4,
+	"rdr": 5, "rdr-anchor": 5,
+	"dummynet": 6, "dummynet-anchor": 6,
+	"anchor": 7, "pass": 7, "block": 7, "antispoof": 7, "match": 7,
+}
+
+// SetupPfConf ensures that /etc/pf.conf loads the pf-tui anchor. Missing or
+// misplaced anchor lines are put where pf's section ordering requires them,
+// and the new pf.conf is checked with pfctl -n before it replaces the old one,
+// which is kept as /etc/pf.conf.pf-tui.bak.
+func SetupPfConf(runner CommandRunner) error {
+	const pfConfPath = "/etc/pf.conf"
+
+	// Read the current pf.conf
+	logging.Info(fmt.Sprintf("Checking pf.conf for anchor rules at %s", pfConfPath))
+	content, err := RunSudoCmd(runner, "cat", pfConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", pfConfPath, err)
+	}
+	updated := placePfTuiAnchors(content)
+	if updated == content {
+		// Everything is already set up
+		return nil
+	}
+
+	// Check the new pf.conf before installing it. The load anchor line needs
+	// the anchor file, which is only written when the rules are applied; touch
+	// -a creates it without changing the time of an existing one.
+	if output, err := RunSudoCmd(runner, "touch", "-a", AnchorPath); err != nil {
+		return fmt.Errorf("failed to create %s: %w, output: %s", AnchorPath, err, output)
+	}
+	if output, err := CheckRules(runner, updated); err != nil {
+		return fmt.Errorf("pfctl rejected %s with the pf-tui anchor lines, so it was not changed: %w, output: %s", pfConfPath, err, output)
+	}
+
+	logging.Info(fmt.Sprintf("Updating %s with new anchor rules", pfConfPath))
+	if output, err := RunSudoCmd(runner, "cp", pfConfPath, pfConfPath+".pf-tui.bak"); err != nil {
+		return fmt.Errorf("failed to back up %s: %w, output: %s", pfConfPath, err, output)
+	}
+	if out, err := runSudoInput(runner, strings.NewReader(updated), "tee", pfConfPath); err != nil {
+		return fmt.Errorf("failed to write %s: %w, output: %s", pfConfPath, err, out)
+	}
+	return nil
+}
+
+// placePfTuiAnchors returns pf.conf content with each of the pf-tui anchor
+// lines in its section: after the last statement of the same kind (e.g. after
+// Apple's nat-anchor), or else before the first statement of a later section.
+// Anchor lines that are out of order, e.g. appended by earlier versions after
+// the filter rules, are moved. Content that is already in order is returned
+// unchanged.
+func placePfTuiAnchors(content string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+
+	// Keep the anchor lines that are in order, and take out the others
+	var kept []string
+	maxSection := 0
+	present := make(map[string]bool)
+	for _, line := range lines {
+		statement := strings.Join(strings.Fields(line), " ")
+		if statement == "# pf-tui anchor point" {
+			continue
+		}
+		section := pfConfSection(statement)
+		if isPfTuiAnchorLine(statement) {
+			if present[statement] || (section != 0 && section < maxSection) {
+				continue
+			}
+			present[statement] = true
+		}
+		if section > maxSection {
+			maxSection = section
+		}
+		kept = append(kept, line)
+	}
+
+	for _, anchorLine := range pfTuiAnchorLines {
+		if present[anchorLine] {
+			continue
+		}
+		kept = insertPfConfLine(kept, anchorLine)
+	}
+	result := strings.Join(kept, "\n") + "\n"
+	if strings.TrimRight(result, "\n") == strings.TrimRight(content, "\n") {
+		return content
+	}
+	return result
+}
+
+// insertPfConfLine inserts a statement into the lines of pf.conf at the
+// position its section requires.
+func insertPfConfLine(lines []string, statement string) []string {
+	keyword := strings.Fields(statement)[0]
+	section := pfConfSection(statement)
+	insertAt := len(lines)
+	if section == 0 {
+		// After the last statement of the same kind, or at the end
+		for i, line := range lines {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == keyword {
+				insertAt = i + 1
+			}
+		}
+	} else {
+		sameSection, laterSection := -1, -1
+		for i, line := range lines {
+			lineSection := pfConfSection(line)
+			if lineSection == section {
+				sameSection = i
+			} else if lineSection > section && laterSection == -1 {
+				laterSection = i
+			}
+		}
+		if sameSection != -1 && (laterSection == -1 || sameSection < laterSection) {
+			insertAt = sameSection + 1
+		} else if laterSection != -1 {
+			insertAt = laterSection
+		}
+	}
+	// Not between a statement and its continuation lines
+	for insertAt > 0 && insertAt < len(lines) && strings.HasSuffix(strings.TrimSpace(lines[insertAt-1]), "\\") {
+		insertAt++
+	}
+
+	result := append([]string{}, lines[:insertAt]...)
+	result = append(result, statement)
+	return append(result, lines[insertAt:]...)
+}
+
+// pfConfSection returns the rank of a pf.conf line in pfConfSections, or 0 if
+// it has none.
+func pfConfSection(line string) int {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0
+	}
+	return pfConfSections[fields[0]]
+}
+
+// isPfTuiAnchorLine reports whether a pf.conf statement is one of
+// pfTuiAnchorLines.
+func isPfTuiAnchorLine(statement string) bool {
+	for _, line := range pfTuiAnchorLines {
+		if statement == line {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyRules applies the given rules string to pf.
+func ApplyRules(runner CommandRunner, rules string) (string, error) {
+	// Write rules to the anchor file
+	logging.Info(fmt.Sprintf("Applying rules to %s", AnchorPath))
+	if out, err := runSudoInput(runner, strings.NewReader(rules), "tee", AnchorPath); err != nil {
+		return "", fmt.Errorf("failed to write to anchor file: %w, output: %s", err, out)
+	}
+
+	// Load the rules into the anchor, which replaces all of its rules at once
+	out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-f", AnchorPath)
+	if err != nil {
+		return out, err
+	}
+	// The anchor is only evaluated once the main ruleset was loaded from a
+	// pf.conf with the anchor lines, which SetupPfConf may just have added
+	evaluated, err := evaluatesPfTuiAnchor(runner)
+	if err != nil {
+		return out, err
+	}
+	if !evaluated {
+		logging.Info("The main ruleset does not evaluate the pf-tui anchor, reloading /etc/pf.conf")
+		reload, err := RunSudoCmd(runner, "pfctl", "-f", "/etc/pf.conf")
+		return out + reload, err
+	}
+	return out, nil
+}
+
+// evaluatesPfTuiAnchor reports whether the main ruleset evaluates the pf-tui
+// anchor.
+func evaluatesPfTuiAnchor(runner CommandRunner) (bool, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "rules")
+	if err != nil {
+		return false, fmt.Errorf("failed to show the rules: %w, output: %s", err, out)
+	}
+	for _, line := range PfctlRuleLines(out) {
+		fields := strings.Fields(line)
+		if fields[0] == "anchor" && len(fields) > 1 && strings.Trim(fields[1], `"`) == Anchor {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetAppliedAnchor returns the content of the anchor file written by the last
+// ApplyRules, or "" if the rules have never been applied.
+func GetAppliedAnchor(runner CommandRunner) (string, error) {
+	out, err := RunSudoCmd(runner, "cat", AnchorPath)
+	if err != nil && strings.Contains(out, "No such file or directory") {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w, output: %s", AnchorPath, err, out)
+	}
+	return out, nil
+}
+
+// AppliedAnchorTime returns when the pf-tui anchor file was last written, by
+// an apply or a rollback, or the zero time if the rules were never applied.
+func AppliedAnchorTime(runner CommandRunner) (time.Time, error) {
+	// The modification time in seconds since the epoch, with BSD stat
+	out, err := RunSudoCmd(runner, "stat", "-f", "%m", AnchorPath)
+	if err != nil && strings.Contains(out, "No such file or directory") {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check %s: %w, output: %s", AnchorPath, err, out)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check %s: unexpected output %q", AnchorPath, out)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// CheckRules parses the given rules string with pfctl -n, which reports
+// errors without loading anything, and returns the output of pfctl.
+func CheckRules(runner CommandRunner, rules string) (string, error) {
+	logging.Info("Checking the syntax of the rules")
+	return runSudoInput(runner, strings.NewReader(rules), "pfctl", "-n", "-f", "-")
+}
+
+// PfctlError is an error pfctl reported for a line of a ruleset.
+type PfctlError struct {
+	Line    int // 1-based
+	Message string
+}
+
+// pfctlErrorPattern matches an error of pfctl about a line of a file, such as
+// "stdin:12: syntax error" for the rules CheckRules passes on stdin.
+var pfctlErrorPattern = regexp.MustCompile(`^\S*:(\d+): (.+)$`)
+
+// ParsePfctlErrors returns the errors about lines in the output of pfctl.
+func ParsePfctlErrors(output string) []PfctlError {
+	var errs []PfctlError
+	for _, line := range strings.Split(output, "\n") {
+		match := pfctlErrorPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		errs = append(errs, PfctlError{Line: n, Message: match[2]})
+	}
+	return errs
+}
+
+// ApplyOptions loads the given global "set" options into pf. pfctl ignores
+// options in anchors, so they are loaded on their own with pfctl -O, which
+// leaves the loaded rules alone.
+func ApplyOptions(runner CommandRunner, options string) (string, error) {
+	if options == "" {
+		return "", nil
+	}
+	logging.Info("Applying global options")
+	return runSudoInput(runner, strings.NewReader(options), "pfctl", "-O", "-f", "-")
+}
+
+// GetCurrentRules returns the currently loaded pf rules.
+func GetCurrentRules(runner CommandRunner) (string, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "rules")
+	if err != nil {
+		return "", err
+	}
+	var filteredRules []string
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "ALTQ") {
+			filteredRules = append(filteredRules, line)
+		}
+	}
+	return strings.Join(filteredRules, "\n"), nil
+}
+
+// SystemInterfaces returns the names of the network interfaces of this host,
+// or nil if they cannot be listed.
+func SystemInterfaces() []string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		logging.Warn(fmt.Sprintf("Failed to list the network interfaces: %v", err))
+		return nil
+	}
+	names := make([]string, len(interfaces))
+	for i, iface := range interfaces {
+		names[i] = iface.Name
+	}
+	return names
+}
+
+// GetIPForwarding reports whether the kernel forwards IPv4 packets
+// (net.inet.ip.forwarding), which NAT and Internet Sharing need.
+func GetIPForwarding(runner CommandRunner) (bool, error) {
+	out, err := RunSudoCmd(runner, "sysctl", "-n", "net.inet.ip.forwarding")
+	if err != nil {
+		return false, fmt.Errorf("failed to read net.inet.ip.forwarding: %w, output: %s", err, out)
+	}
+	return strings.TrimSpace(out) == "1", nil
+}
+
+// PflogStream is a running `tcpdump -i pflog0` started by StartPflog.
+type PflogStream struct {
+	Lines  <-chan string // one line per logged packet, closed when tcpdump exits
+	output io.ReadCloser
+	done   chan struct{}
+}
+
+// StartPflog starts tcpdump on pflog0 under sudo and streams its output. pf
+// copies the packets matched by rules with "log" to pflog0.
+func StartPflog(runner CommandRunner) (*PflogStream, error) {
+	logging.Info("Starting tcpdump on pflog0")
+	output, err := runner.Start("tcpdump", "-n", "-e", "-ttt", "-l", "-i", "pflog0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start tcpdump: %w", err)
+	}
+	lines := make(chan string, 100)
+	stream := &PflogStream{Lines: lines, output: output, done: make(chan struct{})}
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stream.done: // stopped, discard the rest until tcpdump exits
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// PflogSourceAddress returns the source address of a pflog line printed by
+// tcpdump, e.g. "203.0.113.7" for "... block in on en0: 203.0.113.7.40000 >
+// 192.168.1.10.3389: ...", or "" if the line has none.
+func PflogSourceAddress(line string) string {
+	end := strings.Index(line, " > ")
+	if end == -1 {
+		return ""
+	}
+	fields := strings.Fields(line[:end])
+	if len(fields) == 0 {
+		return ""
+	}
+	return pflogAddress(fields[len(fields)-1])
+}
+
+// PflogDestinationAddress returns the destination address of a pflog line,
+// e.g. "192.168.1.10" for the line above, or "" if the line has none.
+func PflogDestinationAddress(line string) string {
+	start := strings.Index(line, " > ")
+	if start == -1 {
+		return ""
+	}
+	fields := strings.Fields(line[start+3:])
+	if len(fields) == 0 {
+		return ""
+	}
+	return pflogAddress(strings.TrimSuffix(fields[0], ":"))
+}
+
+// pflogAddress returns the address of an address field of tcpdump, with or
+// without a port, or "" if it is not an address.
+func pflogAddress(field string) string {
+	if net.ParseIP(field) != nil {
+		return field
+	}
+	// Strip the port, which tcpdump appends with a dot
+	if i := strings.LastIndex(field, "."); i != -1 && net.ParseIP(field[:i]) != nil {
+		return field[:i]
+	}
+	return ""
+}
+
+// Stop stops tcpdump. sudo passes the interrupt on to it.
+func (s *PflogStream) Stop() {
+	select {
+	case <-s.done:
+		return // already stopped
+	default:
+		close(s.done)
+	}
+	if err := s.output.Close(); err != nil {
+		logging.Error(fmt.Sprintf("Failed to stop tcpdump: %v", err))
+	}
+}
+
+// Done is closed by Stop, so that readers of Lines can stop waiting on them.
+func (s *PflogStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// GetTableEntries returns the addresses in the loaded table <name> of the
+// pf-tui anchor, where ApplyRules loads the tables with the rules. They may
+// differ from the configuration when rules (e.g. overload) or pfctl added to
+// it.
+func GetTableEntries(runner CommandRunner, name string) ([]string, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-t", name, "-T", "show")
+	if err != nil {
+		return nil, fmt.Errorf("failed to show table <%s>: %w, output: %s", name, err, out)
+	}
+	var entries []string
+	for _, line := range strings.Split(out, "\n") {
+		// pfctl prints warnings such as "No ALTQ support in kernel" along with the entries
+		if line = strings.TrimSpace(line); line != "" && !strings.Contains(line, " ") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// ModifyTable runs a pfctl table command ("add", "delete", "flush" or
+// "replace") on the loaded table <name> of the pf-tui anchor. The
+// configuration is not changed.
+func ModifyTable(runner CommandRunner, name, command string, addresses ...string) (string, error) {
+	args := append([]string{"pfctl", "-a", Anchor, "-t", name, "-T", command}, addresses...)
+	out, err := RunSudoCmd(runner, args...)
+	if err != nil {
+		return out, fmt.Errorf("failed to %s table <%s>: %w, output: %s", command, name, err, out)
+	}
+	return out, nil
+}
+
+// GetAnchorRules returns the translation and filter rules loaded in the
+// pf-tui anchor, i.e. exactly what this tool loaded, without the rules of
+// the main ruleset and Apple's anchors. An empty anchor is pointed out, as
+// the applies of earlier versions loaded the rules into the main ruleset.
+func GetAnchorRules(runner CommandRunner) (string, error) {
+	var b strings.Builder
+	empty := true
+	for _, section := range []struct{ title, modifier string }{
+		{"Translation rules", "nat"},
+		{"Filter rules", "rules"},
+	} {
+		out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-s", section.modifier)
+		if err != nil {
+			return "", fmt.Errorf("failed to show the %s of the pf-tui anchor: %w, output: %s", strings.ToLower(section.title), err, out)
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + section.title + "\n")
+		for _, line := range strings.Split(out, "\n") {
+			if line != "" && !strings.Contains(line, "ALTQ") {
+				b.WriteString(line + "\n")
+				empty = false
+			}
+		}
+	}
+	if empty {
+		return "# The pf-tui anchor is empty. Rules applied by an earlier version are loaded\n# in the main ruleset instead, until the next Save & Apply.\n\n" + b.String(), nil
+	}
+	return b.String(), nil
+}
+
+// HostTraffic is the traffic of the pf states with one remote host.
+type HostTraffic struct {
+	Host    string
+	States  int
+	Packets uint64 // both directions
+	Bytes   uint64 // both directions
+}
+
+// GetTopTalkers returns the traffic of the current states by remote host,
+// the host with the most bytes first.
+func GetTopTalkers(runner CommandRunner) ([]HostTraffic, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "states", "-vv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to show states: %w, output: %s", err, out)
+	}
+	return ParseTopTalkers(out), nil
+}
+
+// ParseTopTalkers parses the output of `pfctl -s states -vv` and sums the
+// packets and bytes of the states by remote host. pfctl prints the local
+// address first, so the remote host is the address after "->" or "<-":
+//
+//	all tcp 192.168.1.10:52345 -> 17.253.144.10:443       ESTABLISHED:ESTABLISHED
+//	   age 00:01:23, expires in 23:59:59, 120:98 pkts, 12345:67890 bytes, rule 0
+func ParseTopTalkers(output string) []HostTraffic {
+	byHost := make(map[string]*HostTraffic)
+	var current *HostTraffic
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			// A state line, e.g. "all tcp a:1 -> b:2 ..."
+			current = nil
+			for i, field := range fields {
+				if (field == "->" || field == "<-") && i+1 < len(fields) {
+					host := StripStatePort(fields[i+1])
+					if byHost[host] == nil {
+						byHost[host] = &HostTraffic{Host: host}
+					}
+					current = byHost[host]
+					current.States++
+					break
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		// A detail line of the state, e.g. "age ..., 120:98 pkts, 12345:67890 bytes, rule 0"
+		for i := 1; i < len(fields); i++ {
+			unit := strings.TrimSuffix(fields[i], ",")
+			if unit != "pkts" && unit != "bytes" {
+				continue
+			}
+			var total uint64
+			for _, n := range strings.Split(fields[i-1], ":") {
+				value, _ := strconv.ParseUint(n, 10, 64)
+				total += value
+			}
+			if unit == "pkts" {
+				current.Packets += total
+			} else {
+				current.Bytes += total
+			}
+		}
+	}
+
+	talkers := make([]HostTraffic, 0, len(byHost))
+	for _, traffic := range byHost {
+		talkers = append(talkers, *traffic)
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Bytes != talkers[j].Bytes {
+			return talkers[i].Bytes > talkers[j].Bytes
+		}
+		return talkers[i].Host < talkers[j].Host
+	})
+	return talkers
+}
+
+// StripStatePort removes the port from an address of `pfctl -s states`,
+// e.g. "1.1.1.1:53" or "2001:db8::1[53]".
+func StripStatePort(addr string) string {
+	if i := strings.Index(addr, "["); i != -1 {
+		return addr[:i]
+	}
+	if strings.Count(addr, ":") == 1 {
+		return addr[:strings.Index(addr, ":")]
+	}
+	return addr
+}
+
+// PfState is a state of `pfctl -s states`.
+type PfState struct {
+	Interface string `json:"interface"`
+	Protocol  string `json:"protocol"`
+	Local     string `json:"local"`
+	Remote    string `json:"remote"`
+	Direction string `json:"direction"` // "out" for "->", "in" for "<-"
+	State     string `json:"state"`     // e.g. ESTABLISHED:ESTABLISHED
+}
+
+// GetStates returns the current pf states.
+func GetStates(runner CommandRunner) ([]PfState, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "states")
+	if err != nil {
+		return nil, fmt.Errorf("failed to show states: %w, output: %s", err, out)
+	}
+	return ParseStates(out), nil
+}
+
+// ParseStates parses the state lines of `pfctl -s states`, e.g.
+// "all tcp 192.168.1.10:52345 -> 17.253.144.10:443 ESTABLISHED:ESTABLISHED".
+// The translated address of a NAT state, in parentheses after the local
+// address, is skipped; the detail lines of -v are too.
+func ParseStates(output string) []PfState {
+	states := []PfState{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || strings.HasPrefix(line, " ") {
+			continue
+		}
+		for i := 3; i+1 < len(fields); i++ {
+			if fields[i] != "->" && fields[i] != "<-" {
+				continue
+			}
+			state := PfState{Interface: fields[0], Protocol: fields[1], Local: fields[2], Remote: fields[i+1], Direction: "out"}
+			if fields[i] == "<-" {
+				state.Direction = "in"
+			}
+			if i+2 < len(fields) {
+				state.State = fields[i+2]
+			}
+			states = append(states, state)
+			break
+		}
+	}
+	return states
+}
+
+// RuleCounters holds the pf counters of a single (labelled) rule.
+type RuleCounters struct {
+	Evaluations uint64
+	Packets     uint64
+	Bytes       uint64
+	States      uint64
+}
+
+// PfTimeout is one pf timeout as listed by `pfctl -s timeouts`.
+type PfTimeout struct {
+	Name  string
+	Value int // seconds, or a number of states for adaptive.start/adaptive.end
+}
+
+// GetTimeouts returns the current pf timeouts, in pfctl's order.
+func GetTimeouts(runner CommandRunner) ([]PfTimeout, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "timeouts")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTimeouts(out), nil
+}
+
+// ParseTimeouts parses the output of `pfctl -s timeouts`, e.g. "tcp.established 86400s"
+// or "adaptive.start 6000 states".
+func ParseTimeouts(output string) []PfTimeout {
+	var timeouts []PfTimeout
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSuffix(fields[1], "s"))
+		if err != nil {
+			continue // e.g. the "No ALTQ support in kernel" warning
+		}
+		timeouts = append(timeouts, PfTimeout{Name: fields[0], Value: value})
+	}
+	return timeouts
+}
+
+// GetRuleCounters returns the counters of the rules loaded in the pf-tui anchor, keyed by rule label.
+// If the anchor is empty, they are read from the main ruleset, which the applies of earlier
+// versions loaded the rules into, until the next apply loads them into the anchor.
+func GetRuleCounters(runner CommandRunner) (map[string]RuleCounters, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-v", "-s", "rules")
+	if err != nil {
+		return nil, err
+	}
+	if counters := ParseRuleCounters(out); len(counters) > 0 {
+		return counters, nil
+	}
+	out, err = RunSudoCmd(runner, "pfctl", "-v", "-s", "rules")
+	if err != nil {
+		return nil, err
+	}
+	return ParseRuleCounters(out), nil
+}
+
+// ParseRuleCounters parses the output of `pfctl -v -s rules`. Each labelled rule line is
+// followed by a "[ Evaluations: ... Packets: ... Bytes: ... States: ... ]" line.
+// Counters of rules sharing a label (e.g. the tcp and udp halves of a rule) are summed.
+func ParseRuleCounters(output string) map[string]RuleCounters {
+	counters := make(map[string]RuleCounters)
+	label := ""
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "[") {
+			label = ""
+			if idx := strings.Index(trimmed, "label \""); idx != -1 {
+				rest := trimmed[idx+len("label \""):]
+				if end := strings.Index(rest, "\""); end != -1 {
+					label = rest[:end]
+				}
+			}
+			continue
+		}
+		if label == "" || !strings.Contains(trimmed, "Evaluations:") {
+			continue
+		}
+
+		c := counters[label]
+		fields := strings.Fields(strings.Trim(trimmed, "[]"))
+		for i := 0; i+1 < len(fields); i++ {
+			value, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch fields[i] {
+			case "Evaluations:":
+				c.Evaluations += value
+			case "Packets:":
+				c.Packets += value
+			case "Bytes:":
+				c.Bytes += value
+			case "States:":
+				c.States += value
+			}
+		}
+		counters[label] = c
+	}
+	return counters
+}
+
+// GetPfStatus returns the status of pf ("Enabled" or "Disabled").
+func GetPfStatus(runner CommandRunner) (string, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-s", "info")
+	if err != nil {
+		// If pfctl returns an error, it might be because PF is disabled.
+		// The output often contains "pf not running".
+		if strings.Contains(out, "pf not running") {
+			return "Disabled", nil
+		}
+		return "", err
+	}
+
+	// Parse the output to find the status line.
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "Status:") {
+			status := strings.TrimSpace(strings.TrimPrefix(line, "Status:"))
+			if strings.HasPrefix(status, "Enabled") {
+				return "Enabled", nil
+			}
+		}
+	}
+
+	return "Disabled", nil
+}
+
+// EnablePf enables the pf firewall.
+//
+// pf is enabled with pfctl -E, which takes a reference on it like Apple's
+// services (AirDrop, Internet Sharing) do, instead of pfctl -e, which they
+// can undo. The token pfctl returns for the reference is added to the file
+// tokensPath for DisablePf.
+func EnablePf(runner CommandRunner, tokensPath string) (string, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-E")
+	if err != nil {
+		return out, err
+	}
+	match := pfTokenPattern.FindStringSubmatch(out)
+	if match == nil {
+		logging.Warn(fmt.Sprintf("pfctl -E returned no token: %s", out))
+		return out, nil
+	}
+	file, err := os.OpenFile(tokensPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return out, fmt.Errorf("failed to save the pf token: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(match[1] + "\n"); err != nil {
+		return out, fmt.Errorf("failed to save the pf token: %w", err)
+	}
+	logging.Info(fmt.Sprintf("pf enabled with token %s", match[1]))
+	return out, nil
+}
+
+// pfTokenPattern matches the token in the output of pfctl -E, e.g.
+// "Token : 12345678901234567890".
+var pfTokenPattern = regexp.MustCompile(`Token\s*:\s*(\d+)`)
+
+// FlushStates removes all entries from the pf state table, so existing
+// connections are matched against the current rules again.
+func FlushStates(runner CommandRunner) (string, error) {
+	return RunSudoCmd(runner, "pfctl", "-F", "states")
+}
+
+// ErrNoPfReference is returned by DisablePf when pf-tui holds no reference on
+// pf that it could release.
+var ErrNoPfReference = errors.New("pf-tui holds no reference on pf, so pf stays enabled for the services that use it; sudo pfctl -d disables it for all of them")
+
+// DisablePf releases the references EnablePf took on pf with pfctl -X, whose
+// tokens are in the file tokensPath. pf
+// stays enabled while other services still hold references. Without saved
+// tokens, e.g. when pf was enabled before pf-tui ran, or if none could be
+// released, it returns ErrNoPfReference: pf is never disabled for the other
+// services. Tokens that pf no longer knows, e.g. after a reboot, are dropped.
+func DisablePf(runner CommandRunner, tokensPath string) (string, error) {
+	data, err := os.ReadFile(tokensPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read the pf tokens: %w", err)
+	}
+	tokens := strings.Fields(string(data))
+	if len(tokens) == 0 {
+		logging.Warn("No pf token saved, pf is left enabled")
+		return "", ErrNoPfReference
+	}
+
+	var output strings.Builder
+	released := 0
+	for _, token := range tokens {
+		out, err := RunSudoCmd(runner, "pfctl", "-X", token)
+		output.WriteString(out)
+		if err != nil {
+			if errors.Is(err, ErrSudoExpired) {
+				return output.String(), err // keep the tokens for the next try
+			}
+			logging.Warn(fmt.Sprintf("Failed to release pf token %s: %v", token, err))
+			continue
+		}
+		released++
+	}
+	if err := os.Remove(tokensPath); err != nil {
+		logging.Warn(fmt.Sprintf("Failed to remove %s: %v", tokensPath, err))
+	}
+	logging.Info(fmt.Sprintf("Released %d of %d pf tokens", released, len(tokens)))
+	if released == 0 {
+		// The references are gone, e.g. after a reboot, and pf was enabled otherwise
+		logging.Warn("No pf token could be released, pf is left enabled")
+		return output.String(), ErrNoPfReference
+	}
+	return output.String(), nil
+}
+
+// panicLabel labels the pass-all rule PanicAllowAll loads, so that the panic
+// mode can be recognized in the loaded rules.
+const panicLabel = "pf-tui-panic"
+
+// PanicAllowAll replaces everything loaded in the pf-tui anchor (filter,
+// translation and dummynet rules) with a single quick rule passing all
+// traffic, for when a bad rule locks the user out. The anchor file is left as
+// it is, so Save & Apply or reloading /etc/pf.conf restores the configured
+// rules. Rules of the main ruleset before the anchor still apply.
+func PanicAllowAll(runner CommandRunner) (string, error) {
+	logging.Warn("Panic: replacing the rules of the pf-tui anchor with pass all")
+	// Loading rules into the anchor replaces all of its rules at once
+	rules := fmt.Sprintf("pass quick all label \"%s\"\n", panicLabel)
+	return runSudoInput(runner, strings.NewReader(rules), "pfctl", "-a", Anchor, "-f", "-")
+}
+
+// PanicActive reports whether the pass-all rule of PanicAllowAll is loaded.
+func PanicActive(runner CommandRunner) (bool, error) {
+	out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-s", "rules")
+	if err != nil {
+		return false, fmt.Errorf("failed to show the rules of the pf-tui anchor: %w, output: %s", err, out)
+	}
+	return strings.Contains(out, panicLabel), nil
+}
+
+// GetPfInfo returns detailed statistics from pf.
+func GetPfInfo(runner CommandRunner) (string, error) {
+	return RunSudoCmd(runner, "pfctl", "-s", "info")
+}
+
+// PfUsage is the current use and the hard limit of one of pf's memory pools.
+type PfUsage struct {
+	Name    string // "states", "src-nodes", "frags", "tables" or "table-entries"
+	Current int    // -1 if unknown
+	Limit   int
+}
+
+// Percent returns how much of the limit is in use, or -1 if unknown.
+func (u PfUsage) Percent() float64 {
+	if u.Current < 0 || u.Limit <= 0 {
+		return -1
+	}
+	return float64(u.Current) * 100 / float64(u.Limit)
+}
+
+// GetPfUsage returns the hard limits of `pfctl -s memory` with the current
+// number of states and source nodes from `pfctl -s info`, and the number of
+// tables and table entries in the pf-tui anchor.
+func GetPfUsage(runner CommandRunner) ([]PfUsage, error) {
+	memory, err := RunSudoCmd(runner, "pfctl", "-s", "memory")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pf memory limits: %w, output: %s", err, memory)
+	}
+	info, err := GetPfInfo(runner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pf info: %w, output: %s", err, info)
+	}
+
+	usages := ParseMemoryLimits(memory)
+	current := ParseCurrentEntries(info)
+	tables, entries := countTables(runner)
+	for i := range usages {
+		switch usages[i].Name {
+		case "states":
+			usages[i].Current = current["State Table"]
+		case "src-nodes":
+			usages[i].Current = current["Source Tracking Table"]
+		case "tables":
+			usages[i].Current = tables
+		case "table-entries":
+			usages[i].Current = entries
+		}
+	}
+	return usages, nil
+}
+
+// ParseMemoryLimits parses the output of `pfctl -s memory`, e.g.
+// "states        hard limit    10000". Current is left unknown.
+func ParseMemoryLimits(output string) []PfUsage {
+	var usages []PfUsage
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[1] != "hard" || fields[2] != "limit" {
+			continue
+		}
+		limit, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		usages = append(usages, PfUsage{Name: fields[0], Current: -1, Limit: limit})
+	}
+	return usages
+}
+
+// ParseCurrentEntries parses the "current entries" of each table section of
+// `pfctl -s info`, keyed by section (e.g. "State Table"). Missing sections
+// are reported as -1.
+func ParseCurrentEntries(output string) map[string]int {
+	current := map[string]int{"State Table": -1, "Source Tracking Table": -1}
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			for name := range current {
+				if strings.HasPrefix(line, name) {
+					section = name
+				}
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if section != "" && len(fields) >= 3 && fields[0] == "current" && fields[1] == "entries" {
+			if n, err := strconv.Atoi(fields[2]); err == nil {
+				current[section] = n
+			}
+		}
+	}
+	return current
+}
+
+// countTables returns the number of tables loaded in the pf-tui anchor and
+// their total number of entries, or -1 for both if they cannot be read.
+func countTables(runner CommandRunner) (int, int) {
+	out, err := RunSudoCmd(runner, "pfctl", "-a", Anchor, "-s", "Tables")
+	if err != nil {
+		return -1, -1
+	}
+	tables, entries := 0, 0
+	for _, name := range strings.Split(out, "\n") {
+		// Skip warnings such as "No ALTQ support in kernel"
+		if name = strings.TrimSpace(name); name == "" || strings.Contains(name, " ") {
+			continue
+		}
+		tableEntries, err := GetTableEntries(runner, name)
+		if err != nil {
+			return -1, -1
+		}
+		tables++
+		entries += len(tableEntries)
+	}
+	return tables, entries
+}
+
+const plistPath = "/Library/LaunchDaemons/com.user.pftui.plist"
+
+// CheckPfStartupStatus checks if the launchd plist exists.
+func CheckPfStartupStatus(runner CommandRunner) (string, error) {
+	out, err := RunSudoCmd(runner, "ls", plistPath)
+	if err == nil {
+		return "Enabled", nil
+	} else if strings.Contains(out, "No such file or directory") {
+		return "Disabled", nil
+	} else {
+		return "Unknown", err
+	}
+}
+
+// EnablePfOnStartup configures pf to start on boot.
+func EnablePfOnStartup(runner CommandRunner) (string, error) {
+	logging.Info(fmt.Sprintf("Enabling pf on startup by creating %s", plistPath))
+	plistContent := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>com.user.pftui</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>/sbin/pfctl</string>
+        <string>-E</string>
+    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>StandardErrorPath</key>
+    <string>/tmp/com.user.pftui.stderr</string>
+    <key>StandardOutPath</key>
+    <string>/tmp/com.user.pftui.stdout</string>
+</dict>
+</plist>`
+
+	// Write the plist file
+	if out, err := runSudoInput(runner, strings.NewReader(plistContent), "tee", plistPath); err != nil {
+		return "", fmt.Errorf("failed to write plist file: %w, output: %s", err, out)
+	}
+
+	// Load the launchd job
+	return RunSudoCmd(runner, "launchctl", "load", "-w", plistPath)
+}
+
+// DisablePfOnStartup prevents pf from starting on boot.
+func DisablePfOnStartup(runner CommandRunner) (string, error) {
+	logging.Info(fmt.Sprintf("Disabling pf on startup by removing %s", plistPath))
+	// Unload the launchd job
+	_, err := RunSudoCmd(runner, "launchctl", "unload", "-w", plistPath)
+	if err != nil {
+		// Ignore errors if the job is not loaded
+	}
+
+	// Remove the plist file
+	return RunSudoCmd(runner, "rm", plistPath)
+}
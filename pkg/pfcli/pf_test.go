@@ -0,0 +1,179 @@
+package pfcli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunSudoCmd(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name    string
+		args    []string
+		outputs map[string]string
+		errors  map[string]error
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "output",
+			args:    []string{"pfctl", "-s", "info"},
+			outputs: map[string]string{"pfctl -s info": "Status: Enabled\n"},
+			want:    "Status: Enabled\n",
+		},
+		{
+			name:    "failure",
+			args:    []string{"pfctl", "-E"},
+			outputs: map[string]string{"pfctl -E": "pfctl: /dev/pf: Permission denied\n"},
+			errors:  map[string]error{"pfctl -E": failed},
+			want:    "pfctl: /dev/pf: Permission denied\n",
+			wantErr: failed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &FakeRunner{Outputs: tt.outputs, Errors: tt.errors}
+			got, err := RunSudoCmd(runner, tt.args...)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			var failed *CommandError
+			if err != nil && !errors.As(err, &failed) {
+				t.Errorf("error = %#v, want a *CommandError", err)
+			}
+			want := []FakeCall{{Args: tt.args}}
+			if calls := runner.Calls(); !reflect.DeepEqual(calls, want) {
+				t.Errorf("calls = %q, want %q", calls, want)
+			}
+		})
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	const rules = "block in all\n"
+	failed := errors.New("exit status 1")
+	tee := FakeCall{Args: []string{"tee", "/etc/pf.anchors/pf-tui"}, Stdin: rules}
+	load := FakeCall{Args: []string{"pfctl", "-a", "pf-tui", "-f", "/etc/pf.anchors/pf-tui"}}
+	showRules := FakeCall{Args: []string{"pfctl", "-s", "rules"}}
+	reloadPfConf := FakeCall{Args: []string{"pfctl", "-f", "/etc/pf.conf"}}
+	tests := []struct {
+		name    string
+		outputs map[string]string
+		errors  map[string]error
+		want    []FakeCall
+		wantErr bool
+	}{
+		{
+			name:    "anchor evaluated",
+			outputs: map[string]string{"pfctl -s rules": "anchor \"com.apple/*\" all\nanchor \"pf-tui\" all\n"},
+			want:    []FakeCall{tee, load, showRules},
+		},
+		{
+			name:    "anchor not evaluated",
+			outputs: map[string]string{"pfctl -s rules": "anchor \"com.apple/*\" all\n"},
+			want:    []FakeCall{tee, load, showRules, reloadPfConf},
+		},
+		{
+			name:    "write fails",
+			errors:  map[string]error{"tee /etc/pf.anchors/pf-tui": failed},
+			want:    []FakeCall{tee},
+			wantErr: true,
+		},
+		{
+			name:    "load fails",
+			errors:  map[string]error{"pfctl -a pf-tui -f /etc/pf.anchors/pf-tui": failed},
+			want:    []FakeCall{tee, load},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &FakeRunner{Outputs: tt.outputs, Errors: tt.errors}
+			_, err := ApplyRules(runner, rules)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+			var failed *CommandError
+			if err != nil && !errors.As(err, &failed) {
+				t.Errorf("error = %#v, want a *CommandError", err)
+			}
+			if calls := runner.Calls(); !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetupPfConf(t *testing.T) {
+	const apple = "scrub-anchor \"com.apple/*\"\n" +
+		"nat-anchor \"com.apple/*\"\n" +
+		"rdr-anchor \"com.apple/*\"\n" +
+		"dummynet-anchor \"com.apple/*\"\n" +
+		"anchor \"com.apple/*\"\n" +
+		"load anchor \"com.apple\" from \"/etc/pf.anchors/com.apple\"\n"
+	const updated = "scrub-anchor \"com.apple/*\"\n" +
+		"scrub-anchor \"pf-tui\"\n" +
+		"nat-anchor \"com.apple/*\"\n" +
+		"nat-anchor \"pf-tui\"\n" +
+		"rdr-anchor \"com.apple/*\"\n" +
+		"rdr-anchor \"pf-tui\"\n" +
+		"dummynet-anchor \"com.apple/*\"\n" +
+		"dummynet-anchor \"pf-tui\"\n" +
+		"anchor \"com.apple/*\"\n" +
+		"anchor \"pf-tui\"\n" +
+		"load anchor \"com.apple\" from \"/etc/pf.anchors/com.apple\"\n" +
+		"load anchor \"pf-tui\" from \"/etc/pf.anchors/pf-tui\"\n"
+	failed := errors.New("exit status 1")
+	cat := FakeCall{Args: []string{"cat", "/etc/pf.conf"}}
+	touch := FakeCall{Args: []string{"touch", "-a", "/etc/pf.anchors/pf-tui"}}
+	check := FakeCall{Args: []string{"pfctl", "-n", "-f", "-"}, Stdin: updated}
+	backup := FakeCall{Args: []string{"cp", "/etc/pf.conf", "/etc/pf.conf.pf-tui.bak"}}
+	write := FakeCall{Args: []string{"tee", "/etc/pf.conf"}, Stdin: updated}
+	tests := []struct {
+		name    string
+		pfConf  string
+		errors  map[string]error
+		want    []FakeCall
+		wantErr bool
+	}{
+		{
+			name:   "set up already",
+			pfConf: updated,
+			want:   []FakeCall{cat},
+		},
+		{
+			name:   "anchor lines missing",
+			pfConf: apple,
+			want:   []FakeCall{cat, touch, check, backup, write},
+		},
+		{
+			name:    "rejected by pfctl",
+			pfConf:  apple,
+			errors:  map[string]error{"pfctl -n -f -": failed},
+			want:    []FakeCall{cat, touch, check},
+			wantErr: true,
+		},
+		{
+			name:    "unreadable",
+			errors:  map[string]error{"cat /etc/pf.conf": failed},
+			want:    []FakeCall{cat},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &FakeRunner{Outputs: map[string]string{"cat /etc/pf.conf": tt.pfConf}, Errors: tt.errors}
+			err := SetupPfConf(runner)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if calls := runner.Calls(); !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
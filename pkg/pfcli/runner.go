@@ -0,0 +1,135 @@
+package pfcli
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// CommandRunner runs commands with root privileges for the pf functions,
+// e.g. pfctl or the tee that writes the anchor file. SudoRunner runs them with
+// sudo; other backends, or FakeRunner, can be passed to the pf functions
+// instead. main creates the runner and hands it to the TUI and the commands.
+type CommandRunner interface {
+	// Run runs args, with stdin as its input if it is not nil, and returns
+	// its output, stdout and stderr.
+	Run(stdin io.Reader, args ...string) (string, error)
+	// Start starts args in the background and returns its output, stdout
+	// and stderr, which ends when the command exits. Close interrupts it.
+	Start(args ...string) (io.ReadCloser, error)
+}
+
+// Authenticator is implemented by the CommandRunners whose credentials
+// expire, e.g. SudoRunner. Runners without it, e.g. FakeRunner, never ask for
+// a password.
+type Authenticator interface {
+	// Renew refreshes the credentials without asking for the password.
+	Renew() error
+	// AuthCommand returns the command that asks for the password in the
+	// terminal with prompt, "" for the default one, and renews the
+	// credentials.
+	AuthCommand(prompt string) *exec.Cmd
+}
+
+// CheckCredentials checks that runner runs commands without asking for a
+// password, by running true with it. It fails with ErrSudoExpired, wrapped,
+// if sudo needs the password.
+func CheckCredentials(runner CommandRunner) error {
+	_, err := RunSudoCmd(runner, "true")
+	return err
+}
+
+// FakeCall is a command FakeRunner was asked to run.
+type FakeCall struct {
+	Args  []string
+	Stdin string
+}
+
+// FakeRunner is a CommandRunner that runs nothing. It records the commands
+// and answers them with Outputs and Errors, keyed by the arguments joined
+// with spaces, e.g. "pfctl -s info"; other commands succeed with no output.
+type FakeRunner struct {
+	Outputs map[string]string
+	Errors  map[string]error
+
+	mu    sync.Mutex
+	calls []FakeCall
+}
+
+// Run records the command and returns its output and error.
+func (f *FakeRunner) Run(stdin io.Reader, args ...string) (string, error) {
+	call := FakeCall{Args: args}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the input: %w", err)
+		}
+		call.Stdin = string(data)
+	}
+	key := strings.Join(args, " ")
+	logging.Info(fmt.Sprintf("Fake runner, not running: %s", key))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	return f.Outputs[key], f.Errors[key]
+}
+
+// Start records the command and returns its output, all of it at once.
+func (f *FakeRunner) Start(args ...string) (io.ReadCloser, error) {
+	out, err := f.Run(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(out)), nil
+}
+
+// Calls returns the commands run so far, in order.
+func (f *FakeRunner) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// testStates is the output of `pfctl -s states -vv` in test mode.
+const testStates = `all tcp 192.168.1.10:52345 -> 17.253.144.10:443       ESTABLISHED:ESTABLISHED
+   age 00:01:23, expires in 23:59:59, 1200:980 pkts, 1234567:678901 bytes, rule 0
+all udp 192.168.1.10:53124 -> 1.1.1.1:53       MULTIPLE:SINGLE
+   age 00:00:02, expires in 00:00:58, 2:2 pkts, 120:240 bytes, rule 2
+all tcp 192.168.1.10:22 <- 192.168.1.50:51234       ESTABLISHED:ESTABLISHED
+   age 00:10:00, expires in 23:59:59, 300:280 pkts, 45000:98000 bytes, rule 1`
+
+// NewTestModeRunner returns the FakeRunner of test mode, which answers the
+// status commands with the output of a Mac with pf enabled and the pf-tui
+// rules loaded.
+func NewTestModeRunner() *FakeRunner {
+	return &FakeRunner{Outputs: map[string]string{
+		"pfctl -s info": "Status: Enabled for 0 days 01:23:45           Debug: Urgent\n\n" +
+			"State Table                          Total             Rate\n  current entries                       42\n" +
+			"Source Tracking Table\n  current entries                        0\n",
+		"pfctl -s memory":                      "states        hard limit    10000\nsrc-nodes     hard limit    10000\nfrags         hard limit     5000\ntables        hard limit     1000\ntable-entries hard limit   200000\n",
+		"pfctl -s rules":                       "scrub-anchor \"com.apple/*\" all fragment reassemble\nanchor \"com.apple/*\" all\nanchor \"pf-tui\" all\n",
+		"pfctl -s timeouts":                    "tcp.first                   120s\ntcp.established           86400s\nudp.first                    60s\nudp.single                   30s\nudp.multiple                 60s\nicmp.first                   20s\nadaptive.start             6000 states\n",
+		"pfctl -s states":                      testStates,
+		"pfctl -s states -vv":                  testStates,
+		"pfctl -E":                             "pf enabled\nToken : 1000000000000000000\n",
+		"pfctl -a pf-tui -s nat":               "nat on en0 inet from 192.168.2.0/24 to any -> (en0) round-robin\n",
+		"pfctl -a pf-tui -s rules":             "block drop in all label \"pf-tui-1\"\n",
+		"pfctl -a pf-tui -s Tables":            "   blocklist\n   autoban\n",
+		"pfctl -a pf-tui -t blocklist -T show": "   192.0.2.1\n   198.51.100.0/24\n",
+		"pfctl -a pf-tui -t autoban -T show":   "   203.0.113.7\n",
+		"cat /etc/pf.conf":                     "scrub-anchor \"com.apple/*\"\nnat-anchor \"com.apple/*\"\nrdr-anchor \"com.apple/*\"\ndummynet-anchor \"com.apple/*\"\nanchor \"com.apple/*\"\nload anchor \"com.apple\" from \"/etc/pf.anchors/com.apple\"\n",
+		"cat " + AnchorPath:                    "table <blocklist> persist\nblock in quick from <blocklist> to any\npass in on en0 proto tcp from any to any port 22 keep state\n",
+		"stat -f %m " + AnchorPath:             strconv.FormatInt(time.Now().Truncate(time.Hour).Unix(), 10) + "\n",
+		"sysctl -n net.inet.ip.forwarding":     "0\n",
+		"tcpdump -n -e -ttt -l -i pflog0": "00:00:00.000000 rule 0/0(match): block in on en0: 192.168.1.50.51234 > 192.168.1.10.22: Flags [S], length 0\n" +
+			"00:00:01.250000 rule 2/0(match): pass out on en0: 192.168.1.10.53124 > 1.1.1.1.443: Flags [S], length 0\n" +
+			"00:00:00.500000 rule 0/0(match): block in on en0: 203.0.113.7.40000 > 192.168.1.10.3389: Flags [S], length 0\n",
+	}}
+}
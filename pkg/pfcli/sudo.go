@@ -0,0 +1,215 @@
+package pfcli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/logging"
+)
+
+// SudoKeepAliveInterval is how often the sudo timestamp is refreshed. macOS
+// lets it expire after 5 minutes by default.
+const SudoKeepAliveInterval = time.Minute
+
+// ErrSudoExpired is returned, wrapped, by sudo commands that failed because
+// sudo needs the password again.
+var ErrSudoExpired = errors.New("sudo credentials expired")
+
+// ErrSudoCancelled is returned, wrapped, by sudo commands that were stopped
+// by CancelSudoCommands.
+var ErrSudoCancelled = errors.New("cancelled")
+
+// sudoCancelGrace is how long a cancelled sudo command has to exit after
+// SIGTERM before it is killed.
+const sudoCancelGrace = 2 * time.Second
+
+// sudoRuns is the context of the sudo commands run by SudoRunner, which
+// CancelSudoCommands cancels.
+var sudoRuns struct {
+	sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// sudoRunContext returns the context to run the next sudo command in.
+func sudoRunContext() context.Context {
+	sudoRuns.Lock()
+	defer sudoRuns.Unlock()
+	if sudoRuns.ctx == nil {
+		sudoRuns.ctx, sudoRuns.cancel = context.WithCancel(context.Background())
+	}
+	return sudoRuns.ctx
+}
+
+// CancelSudoCommands stops the sudo commands SudoRunner is running, e.g. a
+// pfctl that hangs, which then fail with ErrSudoCancelled. Commands started
+// afterwards run as usual.
+func CancelSudoCommands() {
+	sudoRuns.Lock()
+	defer sudoRuns.Unlock()
+	if sudoRuns.cancel != nil {
+		logging.Warn("Cancelling the running sudo commands")
+		sudoRuns.cancel()
+		sudoRuns.ctx, sudoRuns.cancel = nil, nil
+	}
+}
+
+// sudoCommand returns a command that runs args with sudo. It never prompts
+// for a password, which would write over the TUI; it fails instead, see
+// sudoError.
+func sudoCommand(args ...string) *exec.Cmd {
+	return exec.Command("sudo", append([]string{"-n"}, args...)...)
+}
+
+// SudoRunner is the CommandRunner that runs commands with sudo -n.
+type SudoRunner struct{}
+
+// Run runs args with sudo, with stdin as its input if it is not nil, and
+// returns its output, stdout and stderr. It can be cancelled with
+// CancelSudoCommands.
+func (SudoRunner) Run(stdin io.Reader, args ...string) (string, error) {
+	ctx := sudoRunContext()
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{"-n"}, args...)...)
+	// sudo passes SIGTERM on to the command, which SIGKILL would leave running
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = sudoCancelGrace
+	cmd.Stdin = stdin
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return out.String(), fmt.Errorf("%w (%v)", ErrSudoCancelled, err)
+	}
+	return out.String(), sudoError(err, out.String())
+}
+
+// Start starts args with sudo. The output ends once the command exited, and
+// Close interrupts it, which sudo passes on.
+func (SudoRunner) Start(args ...string) (io.ReadCloser, error) {
+	cmd := sudoCommand(args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the output pipe: %w", err)
+	}
+	cmd.Stderr = cmd.Stdout // e.g. tcpdump reports errors on stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sudoProcess{cmd: cmd, stdout: stdout}, nil
+}
+
+// Renew refreshes the sudo credentials with sudo -n -v, without asking for
+// the password.
+func (SudoRunner) Renew() error {
+	out, err := sudoCommand("-v").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w, output: %s", sudoError(err, string(out)), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// AuthCommand returns sudo -v, which asks for the password in the terminal
+// with prompt, or with the prompt of sudo if it is "".
+func (SudoRunner) AuthCommand(prompt string) *exec.Cmd {
+	if prompt == "" {
+		return exec.Command("sudo", "-v")
+	}
+	return exec.Command("sudo", "-v", "-p", prompt)
+}
+
+// sudoProcess is a command started by SudoRunner.Start.
+type sudoProcess struct {
+	cmd    *exec.Cmd
+	stdout io.Reader
+	waited bool
+}
+
+// Read reads the output of the command, and waits for it once it ended.
+func (p *sudoProcess) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	if err == io.EOF && !p.waited {
+		p.waited = true
+		if err := p.cmd.Wait(); err != nil {
+			logging.Info(fmt.Sprintf("%s exited: %v", strings.Join(p.cmd.Args[2:], " "), err))
+		}
+	}
+	return n, err
+}
+
+// Close interrupts the command.
+func (p *sudoProcess) Close() error {
+	return p.cmd.Process.Signal(os.Interrupt)
+}
+
+// sudoError returns ErrSudoExpired, wrapped, if a sudo command failed because
+// a password is required, and err otherwise.
+func sudoError(err error, output string) error {
+	if err != nil && strings.Contains(output, "a password is required") {
+		return fmt.Errorf("%w: %v", ErrSudoExpired, err)
+	}
+	return err
+}
+
+// SudoKeepAlive refreshes the sudo timestamp in the background, so that long
+// sessions keep their credentials.
+type SudoKeepAlive struct {
+	Expired <-chan struct{} // receives when the credentials could not be refreshed
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// StartSudoKeepAlive renews the credentials of auth every interval until Stop
+// is called.
+func StartSudoKeepAlive(auth Authenticator, interval time.Duration) *SudoKeepAlive {
+	expired := make(chan struct{}, 1)
+	k := &SudoKeepAlive{
+		Expired: expired,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	logging.Info(fmt.Sprintf("Sudo keep-alive started, every %s", interval))
+
+	go func() {
+		defer close(k.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-k.stop:
+				logging.Info("Sudo keep-alive stopped")
+				return
+			case <-ticker.C:
+				if err := auth.Renew(); err != nil {
+					logging.Warn(fmt.Sprintf("Failed to refresh the sudo credentials: %v", err))
+					// Only one pending notice; the TUI prompts once for it
+					select {
+					case expired <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return k
+}
+
+// Stop stops the keep-alive and waits for it to finish. It can be called
+// more than once.
+func (k *SudoKeepAlive) Stop() {
+	k.stopOnce.Do(func() {
+		close(k.stop)
+		<-k.done
+	})
+}
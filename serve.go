@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kh813/pf-tui-go/pkg/config"
+	"github.com/kh813/pf-tui-go/pkg/pfcli"
+)
+
+// serveSocketName is the unix socket of pf-tui serve in the config directory.
+const serveSocketName = "pf-tui.sock"
+
+// apiServer serves the configuration and pf over HTTP for pf-tui serve. The
+// configuration is reloaded for every request, so that changes of the TUI or
+// of commands are seen, and requests are handled one at a time.
+type apiServer struct {
+	mu       sync.Mutex
+	fm       *config.FirewallManager
+	runner   pfcli.CommandRunner
+	rollback *config.PendingRollback // apply waiting for /apply/confirm or /apply/revert
+}
+
+// runServe serves the API on a unix socket until SIGINT or SIGTERM.
+func runServe(fm *config.FirewallManager, runner pfcli.CommandRunner, args []string, o commandOutput) int {
+	flags := o.flagSet("serve")
+	socket := flags.String("socket", "", "unix socket to listen on (default "+serveSocketName+" in the config directory)")
+	if err := flags.Parse(args); err != nil {
+		return o.fail(config.KindUsage, "%v", err)
+	}
+	if flags.NArg() > 0 {
+		return o.fail(config.KindUsage, "Unexpected argument %q", flags.Arg(0))
+	}
+	if *socket == "" {
+		configPath, err := config.GetConfigPath()
+		if err != nil {
+			return o.failErr(err, "Failed to find the config directory")
+		}
+		*socket = filepath.Join(configPath, serveSocketName)
+	}
+	if !requireSudo(o, runner) {
+		return config.KindPermission.ExitCode()
+	}
+
+	// A socket left behind by a server that died is removed, one in use is not
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return o.fail(config.KindFailure, "%s is in use by another pf-tui serve", *socket)
+	}
+	os.Remove(*socket)
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		return o.failErr(err, "Failed to listen on "+*socket)
+	}
+	// Only the user running pf-tui may change the rules through the socket
+	if err := os.Chmod(*socket, 0600); err != nil {
+		listener.Close()
+		return o.failErr(err, "Failed to restrict "+*socket)
+	}
+
+	var keepAlive *pfcli.SudoKeepAlive
+	if auth, ok := runner.(pfcli.Authenticator); ok {
+		keepAlive = pfcli.StartSudoKeepAlive(auth, pfcli.SudoKeepAliveInterval)
+		defer keepAlive.Stop()
+	}
+
+	server := &http.Server{Handler: &apiServer{fm: fm, runner: runner}}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	LogInfo(fmt.Sprintf("Serving the API on %s", *socket))
+	listening := struct {
+		Socket string `json:"socket"`
+	}{*socket}
+	o.result(listening, func(out io.Writer) { fmt.Fprintf(out, "Listening on %s\n", *socket) })
+	err = server.Serve(listener)
+	os.Remove(*socket)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return o.failErr(err, "Failed to serve")
+	}
+	LogInfo("Stopped serving the API")
+	return 0
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		LogWarn(fmt.Sprintf("Failed to write the API response: %v", err))
+	}
+}
+
+// writeError writes an error of the given kind as the body of the response.
+func writeError(w http.ResponseWriter, code int, kind config.ErrorKind, format string, args ...interface{}) {
+	writeJSON(w, code, errorBody{fmt.Sprintf(format, args...), kind.String()})
+}
+
+// writeErr writes err after what failed, with the HTTP status of its kind.
+func writeErr(w http.ResponseWriter, err error, what string) {
+	code := http.StatusInternalServerError
+	switch config.KindOf(err) {
+	case config.KindUsage, config.KindValidation:
+		code = http.StatusBadRequest
+	case config.KindPermission:
+		code = http.StatusForbidden
+	}
+	writeError(w, code, config.KindOf(err), "%s: %v", what, err)
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	LogInfo(fmt.Sprintf("API request: %s %s", r.Method, r.URL.Path))
+
+	path := strings.Trim(r.URL.Path, "/")
+	route := r.Method + " " + path
+	if strings.HasPrefix(path, "rules/") {
+		route = r.Method + " rules/{id}"
+	}
+	switch route {
+	case "GET status", "GET rules", "GET rdr", "GET nat", "GET tables", "GET macros", "POST rules", "DELETE rules/{id}", "POST apply":
+		if err := s.fm.LoadConfig(); err != nil {
+			writeErr(w, err, "Failed to load the configuration")
+			return
+		}
+	}
+
+	switch route {
+	case "GET status":
+		status, err := getCommandStatus(s.fm, s.runner)
+		if err != nil {
+			writeErr(w, err, "Failed to get the status")
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case "GET rules", "GET rdr", "GET nat", "GET tables", "GET macros":
+		writeJSON(w, http.StatusOK, listEntries(s.fm.Config, path))
+	case "POST rules":
+		s.addRule(w, r)
+	case "DELETE rules/{id}":
+		id := strings.TrimPrefix(path, "rules/")
+		kind, err := deleteRuleByID(s.fm, id)
+		if err != nil {
+			writeErr(w, err, "Failed to delete the rule")
+			return
+		}
+		if kind == "" {
+			writeError(w, http.StatusNotFound, config.KindValidation, "No rule with ID %q", id)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			ID   string `json:"id"`
+			Kind string `json:"kind"`
+		}{id, kind})
+	case "POST apply":
+		s.apply(w, r)
+	case "POST apply/confirm", "POST apply/revert":
+		s.finishApply(w, path == "apply/confirm")
+	case "GET states":
+		states, err := pfcli.GetStates(s.runner)
+		if err != nil {
+			writeErr(w, err, "Failed to get the states")
+			return
+		}
+		writeJSON(w, http.StatusOK, states)
+	default:
+		writeError(w, http.StatusNotFound, config.KindUsage, "No such endpoint: %s /%s", r.Method, path)
+	}
+}
+
+// addRule adds the firewall rule in the body, in the format of the rules
+// file. Missing fields have the defaults of the rule form.
+func (s *apiServer) addRule(w http.ResponseWriter, r *http.Request) {
+	rule := config.FirewallRule{
+		Enabled:         true,
+		Action:          "block",
+		Direction:       "in",
+		Interface:       "any",
+		Protocol:        "any",
+		Source:          "any",
+		Destination:     "any",
+		SourcePort:      "any",
+		DestinationPort: "any",
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		writeError(w, http.StatusBadRequest, config.KindValidation, "Invalid rule: %v", err)
+		return
+	}
+	rule, err := s.fm.ValidateFirewallRule(rule, pfcli.SystemInterfaces())
+	if err != nil {
+		writeErr(w, err, "Invalid rule")
+		return
+	}
+	rule.ID = config.NewRuleID()
+	if err := s.fm.AddFirewallRule(rule); err != nil {
+		writeErr(w, err, "Failed to add the rule")
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// apply saves and applies the configuration. With a Rollback time, the rules
+// are reverted at the deadline unless /apply/confirm is called before.
+func (s *apiServer) apply(w http.ResponseWriter, r *http.Request) {
+	if s.rollback != nil && time.Now().Before(s.rollback.Deadline) {
+		writeError(w, http.StatusConflict, config.KindFailure, "The last apply is waiting for /apply/confirm or /apply/revert")
+		return
+	}
+	s.rollback = nil
+	result, rollback, err := applyConfig(s.fm, s.runner, r.URL.Query().Get("force") == "true")
+	if err != nil {
+		writeErr(w, err, "Apply failed")
+		return
+	}
+	if !result.Applied {
+		if len(result.Rejected) == 0 {
+			result.Status = "Not applied. Retry with ?force=true to apply anyway."
+		}
+		writeJSON(w, http.StatusConflict, result)
+		return
+	}
+	s.rollback = rollback
+	writeJSON(w, http.StatusOK, result)
+}
+
+// finishApply keeps or reverts the rules of the apply waiting for confirmation.
+func (s *apiServer) finishApply(w http.ResponseWriter, keep bool) {
+	rollback := s.rollback
+	if rollback == nil {
+		writeError(w, http.StatusNotFound, config.KindFailure, "No apply is waiting for confirmation")
+		return
+	}
+	s.rollback = nil
+	result := commandApplyResult{Applied: keep, Rollback: &commandRollback{Deadline: rollback.Deadline, Confirmed: keep}}
+	if keep {
+		if err := keepRollback(rollback); err != nil {
+			writeError(w, http.StatusConflict, config.KindOf(err), "%v", err)
+			return
+		}
+		result.Status = "New rules confirmed and kept."
+	} else {
+		if output, err := rollback.Revert(); err != nil {
+			writeError(w, http.StatusConflict, config.KindOf(err), "Failed to revert the rules: %v, output: %s", err, output)
+			return
+		}
+		result.Status = "Reverted to the previous rules."
+	}
+	writeJSON(w, http.StatusOK, result)
+}